@@ -0,0 +1,126 @@
+package gomachine
+
+// readUint is used by the typed read helpers to read a little endian value of the width specified from memory.
+func (v *VM) readUint(Offset, Width uint64) (uint64, error) {
+	if v.paged == nil && v.rom == nil {
+		if err := v.checkBounds(Offset, Width, false); err != nil {
+			return 0, err
+		}
+		switch Width {
+		case 1:
+			return uint64(v.Memory[Offset]), nil
+		case 2:
+			return uint64(getUint16(v.Memory, Offset)), nil
+		case 4:
+			return uint64(getUint32(v.Memory, Offset)), nil
+		default:
+			return getUint64(v.Memory, Offset), nil
+		}
+	}
+	b, err := v.ReadBytes(Offset, Width)
+	if err != nil {
+		return 0, err
+	}
+	x := uint64(0)
+	for i, c := range b {
+		x |= uint64(c) << (8 * uint(i))
+	}
+	return x, nil
+}
+
+// writeUint is used by the typed write helpers to write the low bytes of the value to memory as a little endian value
+// of the width specified.
+func (v *VM) writeUint(Offset, Width, Value uint64) error {
+	var b [8]byte
+	for i := uint64(0); i < Width; i++ {
+		b[i] = uint8(Value >> (8 * i))
+	}
+	return v.writeMemory(Offset, b[:Width])
+}
+
+// ReadUint8At is used to read a uint8 from memory at the offset specified.
+func (v *VM) ReadUint8At(Offset uint64) (uint8, error) {
+	x, err := v.readUint(Offset, 1)
+	return uint8(x), err
+}
+
+// WriteUint8At is used to write a uint8 to memory at the offset specified.
+func (v *VM) WriteUint8At(Offset uint64, Value uint8) error {
+	return v.writeUint(Offset, 1, uint64(Value))
+}
+
+// ReadInt8At is used to read an int8 from memory at the offset specified.
+func (v *VM) ReadInt8At(Offset uint64) (int8, error) {
+	x, err := v.readUint(Offset, 1)
+	return int8(x), err
+}
+
+// WriteInt8At is used to write an int8 to memory at the offset specified.
+func (v *VM) WriteInt8At(Offset uint64, Value int8) error {
+	return v.writeUint(Offset, 1, uint64(Value))
+}
+
+// ReadUint16At is used to read a little endian uint16 from memory at the offset specified.
+func (v *VM) ReadUint16At(Offset uint64) (uint16, error) {
+	x, err := v.readUint(Offset, 2)
+	return uint16(x), err
+}
+
+// WriteUint16At is used to write a little endian uint16 to memory at the offset specified.
+func (v *VM) WriteUint16At(Offset uint64, Value uint16) error {
+	return v.writeUint(Offset, 2, uint64(Value))
+}
+
+// ReadInt16At is used to read a little endian int16 from memory at the offset specified.
+func (v *VM) ReadInt16At(Offset uint64) (int16, error) {
+	x, err := v.readUint(Offset, 2)
+	return int16(x), err
+}
+
+// WriteInt16At is used to write a little endian int16 to memory at the offset specified.
+func (v *VM) WriteInt16At(Offset uint64, Value int16) error {
+	return v.writeUint(Offset, 2, uint64(Value))
+}
+
+// ReadUint32At is used to read a little endian uint32 from memory at the offset specified.
+func (v *VM) ReadUint32At(Offset uint64) (uint32, error) {
+	x, err := v.readUint(Offset, 4)
+	return uint32(x), err
+}
+
+// WriteUint32At is used to write a little endian uint32 to memory at the offset specified.
+func (v *VM) WriteUint32At(Offset uint64, Value uint32) error {
+	return v.writeUint(Offset, 4, uint64(Value))
+}
+
+// ReadInt32At is used to read a little endian int32 from memory at the offset specified.
+func (v *VM) ReadInt32At(Offset uint64) (int32, error) {
+	x, err := v.readUint(Offset, 4)
+	return int32(x), err
+}
+
+// WriteInt32At is used to write a little endian int32 to memory at the offset specified.
+func (v *VM) WriteInt32At(Offset uint64, Value int32) error {
+	return v.writeUint(Offset, 4, uint64(Value))
+}
+
+// ReadUint64At is used to read a little endian uint64 from memory at the offset specified.
+func (v *VM) ReadUint64At(Offset uint64) (uint64, error) {
+	return v.readUint(Offset, 8)
+}
+
+// WriteUint64At is used to write a little endian uint64 to memory at the offset specified.
+func (v *VM) WriteUint64At(Offset uint64, Value uint64) error {
+	return v.writeUint(Offset, 8, Value)
+}
+
+// ReadInt64At is used to read a little endian int64 from memory at the offset specified.
+func (v *VM) ReadInt64At(Offset uint64) (int64, error) {
+	x, err := v.readUint(Offset, 8)
+	return int64(x), err
+}
+
+// WriteInt64At is used to write a little endian int64 to memory at the offset specified.
+func (v *VM) WriteInt64At(Offset uint64, Value int64) error {
+	return v.writeUint(Offset, 8, uint64(Value))
+}
@@ -0,0 +1,88 @@
+package gomachine
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVM_TypedAccessors(t *testing.T) {
+	vm := NewVM(16, 0)
+	tests := []struct {
+		width uint64
+		write func(uint64) error
+		read  func(uint64) (uint64, error)
+	}{
+		{
+			1,
+			func(o uint64) error { return vm.WriteUint8At(o, 0xF1) },
+			func(o uint64) (uint64, error) { x, err := vm.ReadUint8At(o); return uint64(x), err },
+		},
+		{
+			2,
+			func(o uint64) error { return vm.WriteUint16At(o, 0xF1F2) },
+			func(o uint64) (uint64, error) { x, err := vm.ReadUint16At(o); return uint64(x), err },
+		},
+		{
+			4,
+			func(o uint64) error { return vm.WriteUint32At(o, 0xF1F2F3F4) },
+			func(o uint64) (uint64, error) { x, err := vm.ReadUint32At(o); return uint64(x), err },
+		},
+		{
+			8,
+			func(o uint64) error { return vm.WriteUint64At(o, 0xF1F2F3F4F5F6F7F8) },
+			func(o uint64) (uint64, error) { x, err := vm.ReadUint64At(o); return x, err },
+		},
+		{
+			1,
+			func(o uint64) error { return vm.WriteInt8At(o, -2) },
+			func(o uint64) (uint64, error) { x, err := vm.ReadInt8At(o); return uint64(x + 2), err },
+		},
+		{
+			2,
+			func(o uint64) error { return vm.WriteInt16At(o, -2) },
+			func(o uint64) (uint64, error) { x, err := vm.ReadInt16At(o); return uint64(x + 2), err },
+		},
+		{
+			4,
+			func(o uint64) error { return vm.WriteInt32At(o, -2) },
+			func(o uint64) (uint64, error) { x, err := vm.ReadInt32At(o); return uint64(x + 2), err },
+		},
+		{
+			8,
+			func(o uint64) error { return vm.WriteInt64At(o, -2) },
+			func(o uint64) (uint64, error) { x, err := vm.ReadInt64At(o); return uint64(x + 2), err },
+		},
+	}
+	expected := []uint64{0xF1, 0xF1F2, 0xF1F2F3F4, 0xF1F2F3F4F5F6F7F8, 0, 0, 0, 0}
+	for i, tt := range tests {
+		for _, offset := range []uint64{0, 16 - tt.width} {
+			vm.ClearMemory()
+			if err := tt.write(offset); err != nil {
+				t.Fatal(err)
+			}
+			x, err := tt.read(offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if x != expected[i] {
+				t.Fatalf("test %d at %d: expected 0x%x, got 0x%x", i, offset, expected[i], x)
+			}
+		}
+
+		// Check one past the last valid offset.
+		if err := tt.write(17 - tt.width); !errors.Is(err, InvalidMemoryLocation) {
+			t.Fatal("expected invalid memory location, got:", err)
+		}
+		if _, err := tt.read(17 - tt.width); !errors.Is(err, InvalidMemoryLocation) {
+			t.Fatal("expected invalid memory location, got:", err)
+		}
+	}
+
+	// Check the byte order is little endian.
+	if err := vm.WriteUint32At(0, 0x01020304); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Memory[0] != 0x04 || vm.Memory[3] != 0x01 {
+		t.Fatal("not little endian:", vm.Memory[:4])
+	}
+}
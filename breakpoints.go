@@ -0,0 +1,27 @@
+package gomachine
+
+// SetBreakpoint is used to make execution return BreakpointHit before running the instruction at the bytecode index
+// specified. The PC is left pointing at the instruction, and resuming with Continue, ExecuteN or Step runs it without
+// stopping on the same breakpoint again.
+func (v *VM) SetBreakpoint(Offset uint64) {
+	v.SetConditionalBreakpoint(Offset, nil)
+}
+
+// SetConditionalBreakpoint is used to set a breakpoint which only stops execution when the condition returns true. The
+// condition is called each time the bytecode index is reached with the registers and PC as they are before the
+// instruction runs. A nil condition always stops. This replaces any breakpoint already at the bytecode index.
+func (v *VM) SetConditionalBreakpoint(Offset uint64, Condition func(*VM) bool) {
+	if v.breakpoints == nil {
+		v.breakpoints = map[uint64]func(*VM) bool{}
+	}
+	v.breakpoints[Offset] = Condition
+}
+
+// ClearBreakpoint is used to remove the breakpoint at the bytecode index specified.
+func (v *VM) ClearBreakpoint(Offset uint64) {
+	delete(v.breakpoints, Offset)
+	if len(v.breakpoints) == 0 {
+		// Go back to the fast path with no map lookups.
+		v.breakpoints = nil
+	}
+}
@@ -0,0 +1,108 @@
+package gomachine
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVM_SetBreakpoint(t *testing.T) {
+	// Count R1 up to 5 with a breakpoint on the add.
+	bytecode := []byte{
+		InstructionUint8LoadR3Direct, 0x05,
+		InstructionUint8LoadR2Direct, 0x01,
+		InstructionUnsignedAdd,
+		InstructionJmpIfLt, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	vm := NewVM(0, 0)
+	vm.SetBreakpoint(4)
+
+	// The breakpoint is hit before each add, and continuing runs the add.
+	err := vm.Execute(bytecode)
+	for i := uint64(0); i < 5; i++ {
+		var vmErr *VMError
+		if !errors.Is(err, BreakpointHit) || !errors.As(err, &vmErr) || vmErr.Offset != 4 {
+			t.Fatal("expected breakpoint at 4, got:", err)
+		}
+		if vm.PC != 4 || vm.Registers[0] != i {
+			t.Fatal("wrong state:", vm.PC, vm.Registers[0])
+		}
+		err = vm.Continue(bytecode)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 5 {
+		t.Fatal("not 5:", vm.Registers[0])
+	}
+
+	// Stepping from a breakpoint runs the instruction.
+	vm.ClearRegisters()
+	if err := vm.Execute(bytecode); !errors.Is(err, BreakpointHit) {
+		t.Fatal("expected breakpoint, got:", err)
+	}
+	if err := vm.Step(bytecode); err != nil || vm.PC != 5 || vm.Registers[0] != 1 {
+		t.Fatal("wrong state:", err, vm.PC, vm.Registers[0])
+	}
+
+	// Clearing the breakpoint runs to the end.
+	vm.ClearBreakpoint(4)
+	if vm.breakpoints != nil {
+		t.Fatal("breakpoints not nil")
+	}
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVM_SetConditionalBreakpoint(t *testing.T) {
+	// Count R1 up to 1,000,000 and break on the add when R1 is 0xDEAD.
+	bytecode := []byte{
+		InstructionUint64LoadR3Direct, 0x40, 0x42, 0x0F, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint8LoadR2Direct, 0x01,
+		InstructionUnsignedAdd,
+		InstructionJmpIfLt, 0x0B, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	vm := NewVM(0, 0)
+	calls := 0
+	vm.SetConditionalBreakpoint(11, func(vm *VM) bool {
+		calls++
+		if vm.PC != 11 {
+			t.Fatal("wrong pc:", vm.PC)
+		}
+		return vm.Registers[0] == 0xDEAD
+	})
+
+	// It stops on exactly the matching iteration.
+	if err := vm.Execute(bytecode); !errors.Is(err, BreakpointHit) {
+		t.Fatal("expected breakpoint, got:", err)
+	}
+	if vm.Registers[0] != 0xDEAD || calls != 0xDEAD+1 {
+		t.Fatal("wrong state:", vm.Registers[0], calls)
+	}
+
+	// Continuing runs to the end as the condition is never true again.
+	if err := vm.Continue(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 1000000 {
+		t.Fatal("not 1000000:", vm.Registers[0])
+	}
+
+	// Memory grown by a condition is used by the instructions after it.
+	vm = NewVM(8, 0)
+	vm.SetConditionalBreakpoint(2, func(vm *VM) bool {
+		if _, err := vm.Grow(1 << 20); err != nil {
+			t.Fatal(err)
+		}
+		return false
+	})
+	if err := vm.Execute([]byte{
+		InstructionUint8Load, 0x05,
+		InstructionUint8Dump, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(vm.Memory) != 8+1<<20 || vm.Memory[0] != 5 {
+		t.Fatal("write was lost:", len(vm.Memory), vm.Memory[0])
+	}
+}
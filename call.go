@@ -0,0 +1,44 @@
+package gomachine
+
+import (
+	"errors"
+	"fmt"
+)
+
+// TooManyArguments is returned when Call is given more arguments than there are argument registers.
+var TooManyArguments = errors.New("too many call arguments")
+
+// maxCallArguments is the number of arguments Call can put in registers.
+const maxCallArguments = 3
+
+// Call is used to call the routine at the entry point specified in the loaded program and get its result. This uses the
+// calling convention below:
+//
+//   - The arguments are put in R1, R2 and R3 in order. The other registers are left as they are.
+//   - The routine returns by running InstructionYield or by running off the end of the program.
+//   - The result is in R1.
+//
+// Any error other than the yield is returned as it would be by RunFrom.
+func (v *VM) Call(Entry uint64, Args ...uint64) (uint64, error) {
+	if len(Args) > maxCallArguments {
+		return 0, fmt.Errorf("%w: %d arguments were given but the most is %d", TooManyArguments, len(Args),
+			maxCallArguments)
+	}
+	if v.program == nil {
+		return 0, NoProgramLoaded
+	}
+	copy(v.Registers[:], Args)
+	if err := v.RunFrom(Entry); err != nil && !errors.Is(err, Yielded) {
+		return 0, err
+	}
+	return v.Registers[0], nil
+}
+
+// CallWithMemoryArgs is used to write the data specified to memory at the offset specified and call the routine at the
+// entry point with the offset in R1 and the length of the data in R2. It works like Call otherwise.
+func (v *VM) CallWithMemoryArgs(Entry, Offset uint64, Data []byte) (uint64, error) {
+	if err := v.WriteBytes(Offset, Data); err != nil {
+		return 0, err
+	}
+	return v.Call(Entry, Offset, uint64(len(Data)))
+}
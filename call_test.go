@@ -0,0 +1,49 @@
+package gomachine
+
+import (
+	"errors"
+	"hash/crc32"
+	"testing"
+)
+
+func TestVM_Call(t *testing.T) {
+	vm := NewVM(16, 0)
+	if _, err := vm.Call(0); !errors.Is(err, NoProgramLoaded) {
+		t.Fatal("expected no program loaded, got:", err)
+	}
+
+	// add returns R1 + R2 with a yield and crc returns the CRC32 of the bytes by running off the end.
+	program := []byte{
+		InstructionUnsignedAdd,
+		InstructionYield,
+		InstructionMoveR2ToR3,
+		InstructionMoveR1ToR2,
+		InstructionMemoryCRC32,
+	}
+	if err := vm.LoadProgram(program); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := vm.Call(0, 40, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != 42 {
+		t.Fatal("not 42:", sum)
+	}
+
+	data := []byte("gomachine")
+	crc, err := vm.CallWithMemoryArgs(2, 4, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crc != uint64(crc32.ChecksumIEEE(data)) {
+		t.Fatal("wrong crc:", crc)
+	}
+
+	if _, err := vm.CallWithMemoryArgs(2, 12, data); !errors.Is(err, InvalidMemoryLocation) {
+		t.Fatal("expected invalid memory location, got:", err)
+	}
+	if _, err := vm.Call(0, 1, 2, 3, 4); !errors.Is(err, TooManyArguments) {
+		t.Fatal("expected too many arguments, got:", err)
+	}
+}
@@ -0,0 +1,70 @@
+package gomachine
+
+// Coverage is used to represent the bytecode offsets where instructions were executed as a bitset.
+type Coverage []uint64
+
+// Covered is used to check if a instruction starting at the offset specified was executed.
+func (c Coverage) Covered(Offset uint64) bool {
+	i := Offset / 64
+	return i < uint64(len(c)) && c[i]&(1<<(Offset%64)) != 0
+}
+
+// Count is used to get the number of instructions executed.
+func (c Coverage) Count() int {
+	n := 0
+	for _, word := range c {
+		for ; word != 0; word &= word - 1 {
+			n++
+		}
+	}
+	return n
+}
+
+// OffsetRange is used to represent the bytecode from Start up to but not including End.
+type OffsetRange struct {
+	Start, End uint64
+}
+
+// Uncovered is used to get the ranges of bytecode with instructions which weren't executed. Offsets is the offset of
+// every instruction in order, as returned by InstructionOffsets, and Length is the length of the bytecode. Instructions
+// next to each other are joined into one range.
+func (c Coverage) Uncovered(Offsets []uint64, Length uint64) []OffsetRange {
+	var ranges []OffsetRange
+	for i, offset := range Offsets {
+		if c.Covered(offset) {
+			continue
+		}
+		end := Length
+		if i+1 < len(Offsets) {
+			end = Offsets[i+1]
+		}
+		if n := len(ranges); n != 0 && ranges[n-1].End == offset {
+			ranges[n-1].End = end
+		} else {
+			ranges = append(ranges, OffsetRange{Start: offset, End: end})
+		}
+	}
+	return ranges
+}
+
+// growCoverage is used to make sure the coverage bitset has a bit for every offset in bytecode of the length
+// specified.
+func (v *VM) growCoverage(Length uint64) Coverage {
+	if words := (Length + 63) / 64; uint64(len(v.coverage)) < words {
+		grown := make(Coverage, words)
+		copy(grown, v.coverage)
+		v.coverage = grown
+	}
+	return v.coverage
+}
+
+// Coverage is used to get a copy of the offsets of the instructions executed while CollectCoverage was set, since the
+// virtual machine was created or ResetCoverage was last called.
+func (v *VM) Coverage() Coverage {
+	return append(Coverage(nil), v.coverage...)
+}
+
+// ResetCoverage is used to clear the coverage returned by Coverage.
+func (v *VM) ResetCoverage() {
+	v.coverage = nil
+}
@@ -0,0 +1,72 @@
+package gomachine
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestVM_Coverage(t *testing.T) {
+	// The branch to 22 is never taken and the jump to 24 skips the loads at 20 and 22.
+	bytecode := []byte{
+		InstructionUint8Load, 0x01,
+		InstructionJmpIfZero, 0x16, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionJmp, 0x18, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint8Load, 0x05,
+		InstructionUint8Load, 0x06,
+		InstructionUint8Load, 0x07,
+	}
+
+	// Nothing is collected by default.
+	vm := NewVM(0, 0)
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Coverage() != nil {
+		t.Fatal("coverage was collected:", vm.Coverage())
+	}
+
+	vm.CollectCoverage = true
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	coverage := vm.Coverage()
+	for offset := uint64(0); offset < uint64(len(bytecode)); offset++ {
+		expected := offset == 0 || offset == 2 || offset == 11 || offset == 24
+		if coverage.Covered(offset) != expected {
+			t.Fatal("wrong coverage at", offset)
+		}
+	}
+	if coverage.Count() != 4 {
+		t.Fatal("wrong count:", coverage.Count())
+	}
+
+	// The skipped instructions are reported as one range.
+	offsets, err := InstructionOffsets(bytecode, Mode64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(offsets, []uint64{0, 2, 11, 20, 22, 24}) {
+		t.Fatal("wrong offsets:", offsets)
+	}
+	if ranges := coverage.Uncovered(offsets, uint64(len(bytecode))); !reflect.DeepEqual(ranges, []OffsetRange{{20, 24}}) {
+		t.Fatal("wrong ranges:", ranges)
+	}
+
+	// Coverage adds up until it is reset.
+	if err := vm.Execute([]byte{InstructionUint8Load, 0x01, InstructionUint8Load, 0x02}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Coverage().Count() != 4 || vm.Coverage().Covered(1) || !vm.Coverage().Covered(2) {
+		t.Fatal("coverage didn't add up:", vm.Coverage())
+	}
+	vm.ResetCoverage()
+	if vm.Coverage() != nil {
+		t.Fatal("coverage wasn't reset")
+	}
+
+	// Invalid bytecode has no instruction offsets.
+	if _, err := InstructionOffsets([]byte{InstructionUint16Load, 0x01}, Mode64); !errors.Is(err, InvalidInstructionArgument) {
+		t.Fatal("expected invalid instruction argument, got:", err)
+	}
+}
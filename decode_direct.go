@@ -0,0 +1,40 @@
+//go:build (386 || amd64 || arm64 || ppc64le || wasm) && !gomachine_safe
+// +build 386 amd64 arm64 ppc64le wasm
+// +build !gomachine_safe
+
+package gomachine
+
+import "unsafe"
+
+// This host is little endian and supports unaligned loads and stores, so bytecode arguments and memory can be read and
+// written directly at any offset.
+
+// getUint16 is used to get a little endian uint16 from the bytes at the index specified.
+func getUint16(b []byte, i uint64) uint16 {
+	return *(*uint16)(unsafe.Pointer(&b[i]))
+}
+
+// getUint32 is used to get a little endian uint32 from the bytes at the index specified.
+func getUint32(b []byte, i uint64) uint32 {
+	return *(*uint32)(unsafe.Pointer(&b[i]))
+}
+
+// getUint64 is used to get a little endian uint64 from the bytes at the index specified.
+func getUint64(b []byte, i uint64) uint64 {
+	return *(*uint64)(unsafe.Pointer(&b[i]))
+}
+
+// putUint16 is used to put a little endian uint16 into the bytes at the index specified.
+func putUint16(b []byte, i uint64, x uint16) {
+	*(*uint16)(unsafe.Pointer(&b[i])) = x
+}
+
+// putUint32 is used to put a little endian uint32 into the bytes at the index specified.
+func putUint32(b []byte, i uint64, x uint32) {
+	*(*uint32)(unsafe.Pointer(&b[i])) = x
+}
+
+// putUint64 is used to put a little endian uint64 into the bytes at the index specified.
+func putUint64(b []byte, i uint64, x uint64) {
+	*(*uint64)(unsafe.Pointer(&b[i])) = x
+}
@@ -0,0 +1,40 @@
+//go:build (!386 && !amd64 && !arm64 && !ppc64le && !wasm) || gomachine_safe
+// +build !386,!amd64,!arm64,!ppc64le,!wasm gomachine_safe
+
+package gomachine
+
+import "encoding/binary"
+
+// This host is either big endian or may fault (or silently misbehave) on unaligned loads and stores, or the
+// gomachine_safe build tag is set, so bytecode arguments and memory are assembled from individual bytes as little
+// endian without using unsafe.
+
+// getUint16 is used to get a little endian uint16 from the bytes at the index specified.
+func getUint16(b []byte, i uint64) uint16 {
+	return binary.LittleEndian.Uint16(b[i:])
+}
+
+// getUint32 is used to get a little endian uint32 from the bytes at the index specified.
+func getUint32(b []byte, i uint64) uint32 {
+	return binary.LittleEndian.Uint32(b[i:])
+}
+
+// getUint64 is used to get a little endian uint64 from the bytes at the index specified.
+func getUint64(b []byte, i uint64) uint64 {
+	return binary.LittleEndian.Uint64(b[i:])
+}
+
+// putUint16 is used to put a little endian uint16 into the bytes at the index specified.
+func putUint16(b []byte, i uint64, x uint16) {
+	binary.LittleEndian.PutUint16(b[i:], x)
+}
+
+// putUint32 is used to put a little endian uint32 into the bytes at the index specified.
+func putUint32(b []byte, i uint64, x uint32) {
+	binary.LittleEndian.PutUint32(b[i:], x)
+}
+
+// putUint64 is used to put a little endian uint64 into the bytes at the index specified.
+func putUint64(b []byte, i uint64, x uint64) {
+	binary.LittleEndian.PutUint64(b[i:], x)
+}
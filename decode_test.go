@@ -0,0 +1,35 @@
+package gomachine
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	b := []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09}
+	for i := uint64(0); i < 2; i++ {
+		if getUint16(b, i) != binary.LittleEndian.Uint16(b[i:]) {
+			t.Fatal("getUint16 is not little endian at", i)
+		}
+		if getUint32(b, i) != binary.LittleEndian.Uint32(b[i:]) {
+			t.Fatal("getUint32 is not little endian at", i)
+		}
+		if getUint64(b, i) != binary.LittleEndian.Uint64(b[i:]) {
+			t.Fatal("getUint64 is not little endian at", i)
+		}
+	}
+
+	x := make([]byte, 9)
+	putUint16(x, 1, 0x0102)
+	if x[1] != 0x02 || x[2] != 0x01 {
+		t.Fatal("putUint16 is not little endian:", x)
+	}
+	putUint32(x, 1, 0x01020304)
+	if binary.LittleEndian.Uint32(x[1:]) != 0x01020304 {
+		t.Fatal("putUint32 is not little endian:", x)
+	}
+	putUint64(x, 1, 0x0102030405060708)
+	if binary.LittleEndian.Uint64(x[1:]) != 0x0102030405060708 {
+		t.Fatal("putUint64 is not little endian:", x)
+	}
+}
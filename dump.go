@@ -0,0 +1,126 @@
+package gomachine
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// MemRange is used to define a range of memory for DumpState.
+type MemRange struct {
+	// Offset is the memory location the range starts at.
+	Offset uint64
+
+	// Length is the number of bytes in the range.
+	Length uint64
+}
+
+// hexDump is used to write the data as a hexdump with 16 bytes per line. Each line has the memory location of its first
+// byte, the bytes in hex and the printable bytes as ASCII with a dot for everything else.
+func hexDump(w io.Writer, Offset uint64, Data []byte) error {
+	for i := 0; i < len(Data); i += 16 {
+		line := Data[i:]
+		if len(line) > 16 {
+			line = line[:16]
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "%08x ", Offset+uint64(i))
+		for j := 0; j < 16; j++ {
+			if j == 8 {
+				b.WriteByte(' ')
+			}
+			if j < len(line) {
+				fmt.Fprintf(&b, " %02x", line[j])
+			} else {
+				b.WriteString("   ")
+			}
+		}
+		b.WriteString("  |")
+		for _, c := range line {
+			if c < 0x20 || c > 0x7E {
+				c = '.'
+			}
+			b.WriteByte(c)
+		}
+		b.WriteString("|\n")
+		if _, err := io.WriteString(w, b.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DumpState is used to write the registers in hex and decimal, the PC and a hexdump of each memory range specified.
+// The format is stable so it can be used in golden tests.
+func (v *VM) DumpState(w io.Writer, MemoryWindows ...MemRange) error {
+	var b strings.Builder
+	for i, x := range v.Registers {
+		fmt.Fprintf(&b, "R%d: 0x%016x (%d)\n", i+1, x, x)
+	}
+	fmt.Fprintf(&b, "PC: 0x%016x (%d)\n", v.PC, v.PC)
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return err
+	}
+	for _, window := range MemoryWindows {
+		data, err := v.ReadBytes(window.Offset, window.Length)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "Memory 0x%x-0x%x:\n", window.Offset, window.Offset+window.Length); err != nil {
+			return err
+		}
+		if err := hexDump(w, window.Offset, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// String implements fmt.Stringer with a one line summary of the registers and PC.
+func (v *VM) String() string {
+	var b strings.Builder
+	for i, x := range v.Registers {
+		fmt.Fprintf(&b, "R%d=0x%x ", i+1, x)
+	}
+	fmt.Fprintf(&b, "PC=0x%x", v.PC)
+	return b.String()
+}
+
+// HexDump is used to get a hexdump of the range of memory specified with 16 bytes per line, the memory location of each
+// line and a ASCII gutter. InvalidMemoryLocation is returned if the range doesn't fit.
+func (v *VM) HexDump(Offset, Length uint64) (string, error) {
+	var b strings.Builder
+	if err := v.HexDumpTo(&b, Offset, Length); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// HexDumpTo is used to write a hexdump like HexDump to the writer. The memory is read in chunks so large ranges are
+// not copied all at once.
+func (v *VM) HexDumpTo(w io.Writer, Offset, Length uint64) error {
+	if Offset+Length < Offset {
+		return &MemoryFault{Address: Offset, Width: Length, Size: v.memorySize()}
+	}
+	if v.paged == nil && v.rom == nil {
+		// Check the whole range first so nothing is written for a range which doesn't fit.
+		if err := v.checkBounds(Offset, Length, false); err != nil {
+			return err
+		}
+	}
+	const chunkSize = 4096
+	for done := uint64(0); done < Length; done += chunkSize {
+		n := Length - done
+		if n > chunkSize {
+			n = chunkSize
+		}
+		data, err := v.ReadBytes(Offset+done, n)
+		if err != nil {
+			return err
+		}
+		if err := hexDump(w, Offset+done, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
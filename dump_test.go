@@ -0,0 +1,91 @@
+package gomachine
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestVM_DumpState(t *testing.T) {
+	vm := NewVM(32, 0)
+	vm.Registers = [8]uint64{1, 0xFF, 0, 1, 0xFFFFFFFFFFFFFFFF, 0, 0, 0x1234}
+	vm.PC = 10
+	copy(vm.Memory[4:], "Hello, world!\x00\x01\x7F\x80")
+
+	var b bytes.Buffer
+	if err := vm.DumpState(&b, MemRange{Offset: 0, Length: 20}, MemRange{Offset: 30, Length: 2}); err != nil {
+		t.Fatal(err)
+	}
+	expected := `R1: 0x0000000000000001 (1)
+R2: 0x00000000000000ff (255)
+R3: 0x0000000000000000 (0)
+R4: 0x0000000000000001 (1)
+R5: 0xffffffffffffffff (18446744073709551615)
+R6: 0x0000000000000000 (0)
+R7: 0x0000000000000000 (0)
+R8: 0x0000000000001234 (4660)
+PC: 0x000000000000000a (10)
+Memory 0x0-0x14:
+00000000  00 00 00 00 48 65 6c 6c  6f 2c 20 77 6f 72 6c 64  |....Hello, world|
+00000010  21 00 01 7f                                       |!...|
+Memory 0x1e-0x20:
+0000001e  00 00                                             |..|
+`
+	if b.String() != expected {
+		t.Fatalf("unexpected dump:\n%s", b.String())
+	}
+
+	// Invalid ranges error.
+	if err := vm.DumpState(&b, MemRange{Offset: 30, Length: 3}); !errors.Is(err, InvalidMemoryLocation) {
+		t.Fatal("expected invalid memory location, got:", err)
+	}
+}
+
+func TestVM_String(t *testing.T) {
+	vm := NewVM(0, 0)
+	vm.Registers[0] = 0x10
+	vm.Registers[7] = 1
+	vm.PC = 3
+	expected := "R1=0x10 R2=0x0 R3=0x0 R4=0x0 R5=0x0 R6=0x0 R7=0x0 R8=0x1 PC=0x3"
+	if vm.String() != expected {
+		t.Fatal("unexpected string:", vm.String())
+	}
+}
+
+func TestVM_HexDump(t *testing.T) {
+	vm := NewVM(8192, 0)
+	for i := 0; i < 34; i++ {
+		vm.Memory[0x10+i] = uint8(0x70 + i)
+	}
+	s, err := vm.HexDump(0x10, 34)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `00000010  70 71 72 73 74 75 76 77  78 79 7a 7b 7c 7d 7e 7f  |pqrstuvwxyz{|}~.|
+00000020  80 81 82 83 84 85 86 87  88 89 8a 8b 8c 8d 8e 8f  |................|
+00000030  90 91                                             |..|
+`
+	if s != expected {
+		t.Fatalf("unexpected hexdump:\n%s", s)
+	}
+
+	// Large regions are streamed in chunks but give the same output.
+	var b bytes.Buffer
+	if err := vm.HexDumpTo(&b, 0, 8192); err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() != 512*79 {
+		t.Fatal("unexpected length:", b.Len())
+	}
+
+	// Invalid ranges write nothing and error.
+	b.Reset()
+	for _, tt := range []struct{ offset, length uint64 }{{8190, 3}, {^uint64(0), 2}} {
+		if err := vm.HexDumpTo(&b, tt.offset, tt.length); !errors.Is(err, InvalidMemoryLocation) {
+			t.Fatal("expected invalid memory location, got:", err)
+		}
+	}
+	if b.Len() != 0 {
+		t.Fatal("wrote a partial dump")
+	}
+}
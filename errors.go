@@ -0,0 +1,319 @@
+package gomachine
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// VMError is returned from Execute when the bytecode fails. It wraps the underlying error, so errors.Is still matches
+// variables such as InvalidMemoryLocation.
+type VMError struct {
+	// Offset is the bytecode index of the instruction which failed.
+	Offset uint64
+
+	// Opcode is the instruction which failed.
+	Opcode uint8
+
+	// Operand is the argument of the instruction which failed (memory location, jump target or syscall) if it has one.
+	Operand uint64
+
+	// Err is the underlying error.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *VMError) Error() string {
+	return fmt.Sprintf("%s (opcode 0x%02x at offset %d, operand 0x%x)", e.Err.Error(), e.Opcode, e.Offset, e.Operand)
+}
+
+// Unwrap is used to get the underlying error.
+func (e *VMError) Unwrap() error {
+	return e.Err
+}
+
+// fail is used to create a VMError for the instruction at the offset specified and leave the PC pointing at it.
+func (v *VM) fail(Bytecode []byte, Offset, Operand uint64, Err error) error {
+	v.PC = Offset
+	return &VMError{Offset: Offset, Opcode: Bytecode[Offset], Operand: Operand, Err: Err}
+}
+
+// CPUTimeError is used when the CPU time runs out. It wraps CPUTimeExhausted, so errors.Is still matches it.
+type CPUTimeError struct {
+	// Limit is the CPU time which ran out. This is CumulativeCPUBudget if the cumulative budget ran out.
+	Limit time.Duration
+
+	// Elapsed is the CPU time measured when execution was stopped. It is at least Limit, and includes earlier
+	// executions if the cumulative budget ran out.
+	Elapsed time.Duration
+
+	// Instructions is the number of instructions the execution ran.
+	Instructions uint64
+
+	// PC is the bytecode index of the instruction which would have run next.
+	PC uint64
+}
+
+// Error implements the error interface.
+func (e *CPUTimeError) Error() string {
+	return fmt.Sprintf("%s: used %s of %s after %d instructions at pc %d", CPUTimeExhausted.Error(), e.Elapsed,
+		e.Limit, e.Instructions, e.PC)
+}
+
+// Unwrap is used to get CPUTimeExhausted.
+func (e *CPUTimeError) Unwrap() error {
+	return CPUTimeExhausted
+}
+
+// MemoryFault is used when a memory access is outside of the virtual machines memory. It wraps InvalidMemoryLocation,
+// so errors.Is still matches it.
+type MemoryFault struct {
+	// Address is the memory location which was accessed.
+	Address uint64
+
+	// Width is the number of bytes which were accessed.
+	Width uint64
+
+	// Write is true if the access was a write.
+	Write bool
+
+	// Size is the length of the memory at the time of the access.
+	Size uint64
+}
+
+// Error implements the error interface.
+func (e *MemoryFault) Error() string {
+	access := "read"
+	if e.Write {
+		access = "write"
+	}
+	return fmt.Sprintf("%s of %d bytes at 0x%x exceeds memory size %d", access, e.Width, e.Address, e.Size)
+}
+
+// Unwrap is used to get InvalidMemoryLocation.
+func (e *MemoryFault) Unwrap() error {
+	return InvalidMemoryLocation
+}
+
+// NullPageFault is used when a memory access starts below the guard size. It wraps InvalidMemoryLocation, so errors.Is
+// still matches it.
+type NullPageFault struct {
+	// Address is the memory location which was accessed.
+	Address uint64
+
+	// Width is the number of bytes which were accessed.
+	Width uint64
+
+	// Write is true if the access was a write.
+	Write bool
+}
+
+// Error implements the error interface.
+func (e *NullPageFault) Error() string {
+	access := "read"
+	if e.Write {
+		access = "write"
+	}
+	return fmt.Sprintf("%s of %d bytes at 0x%x is inside the guard page", access, e.Width, e.Address)
+}
+
+// Unwrap is used to get InvalidMemoryLocation.
+func (e *NullPageFault) Unwrap() error {
+	return InvalidMemoryLocation
+}
+
+// UnalignedAccess is used when RequireAlignment is set and a memory access is not aligned to its width.
+type UnalignedAccess struct {
+	// Address is the memory location which was accessed.
+	Address uint64
+
+	// Width is the number of bytes which were accessed.
+	Width uint64
+
+	// Write is true if the access was a write.
+	Write bool
+}
+
+// Error implements the error interface.
+func (e *UnalignedAccess) Error() string {
+	access := "read"
+	if e.Write {
+		access = "write"
+	}
+	return fmt.Sprintf("%s of %d bytes at 0x%x is not aligned", access, e.Width, e.Address)
+}
+
+// UninitializedRead is used when TrackInitialization is set and a load reads memory which was never written.
+type UninitializedRead struct {
+	// Address is the memory location which was accessed.
+	Address uint64
+
+	// Width is the number of bytes which were accessed.
+	Width uint64
+}
+
+// Error implements the error interface.
+func (e *UninitializedRead) Error() string {
+	return fmt.Sprintf("read of %d bytes at 0x%x includes uninitialized memory", e.Width, e.Address)
+}
+
+// DefaultSyscallErrorCode is the error code put in R3 when a system call returns a error which wasn't made with
+// ErrnoError.
+const DefaultSyscallErrorCode uint64 = 1
+
+// SyscallError is used when a system call fails in a way the bytecode can handle. It is made by ErrnoError.
+type SyscallError struct {
+	// Code is the error code put in R3.
+	Code uint64
+
+	// Err is the underlying error.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *SyscallError) Error() string {
+	return fmt.Sprintf("system call error %d: %s", e.Code, e.Err.Error())
+}
+
+// Unwrap is used to get the underlying error.
+func (e *SyscallError) Unwrap() error {
+	return e.Err
+}
+
+// ErrnoError is used to create a error for a system call to return which puts the code specified in R3 instead of
+// DefaultSyscallErrorCode.
+func ErrnoError(Code uint64, Err error) error {
+	return &SyscallError{Code: Code, Err: Err}
+}
+
+// FatalSyscallError is used to wrap a error returned by a system call which should stop execution rather than be
+// handled by the bytecode. Execute returns a VMError wrapping it.
+type FatalSyscallError struct {
+	// Err is the underlying error.
+	Err error
+}
+
+// Error implements the error interface. It is the message of the underlying error.
+func (e *FatalSyscallError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap is used to get the underlying error.
+func (e *FatalSyscallError) Unwrap() error {
+	return e.Err
+}
+
+// syscallResult is used to handle the error returned by a system call. Fatal errors and the errors the virtual machine
+// returns for system calls are returned, so a replayed system call can fail the same way. Any other error puts its code
+// in R3 and sets R4 to 1, and nil is returned so execution carries on.
+func (v *VM) syscallResult(Err error) error {
+	if Err == nil {
+		return nil
+	}
+	var fatal *FatalSyscallError
+	var panicErr *SyscallPanicError
+	var invalidErr *InvalidSyscallError
+	var deniedErr *SyscallDeniedError
+	var budgetErr *SyscallBudgetError
+	if errors.As(Err, &fatal) || errors.As(Err, &panicErr) || errors.As(Err, &invalidErr) || errors.As(Err, &deniedErr) ||
+		errors.As(Err, &budgetErr) {
+		return Err
+	}
+	code := DefaultSyscallErrorCode
+	var syscallErr *SyscallError
+	if errors.As(Err, &syscallErr) {
+		code = syscallErr.Code
+	}
+	v.Registers[2] = code
+	v.Registers[3] = 1
+	return nil
+}
+
+// InvalidSyscallError is used when the bytecode makes a system call which doesn't exist. It wraps InvalidSyscall, so
+// errors.Is still matches it.
+type InvalidSyscallError struct {
+	// Number is the system call which was made.
+	Number uint64
+
+	// PC is the bytecode index of the system call instruction.
+	PC uint64
+}
+
+// Error implements the error interface.
+func (e *InvalidSyscallError) Error() string {
+	return fmt.Sprintf("%s: system call %d at pc %d", InvalidSyscall.Error(), e.Number, e.PC)
+}
+
+// Unwrap is used to get InvalidSyscall.
+func (e *InvalidSyscallError) Unwrap() error {
+	return InvalidSyscall
+}
+
+// SyscallPanicError is used when a system call panics. It stops execution like a FatalSyscallError.
+type SyscallPanicError struct {
+	// Number is the system call which panicked.
+	Number uint64
+
+	// Value is the value passed to panic.
+	Value interface{}
+
+	// Stack is the stack trace of the goroutine when it panicked.
+	Stack []byte
+}
+
+// Error implements the error interface.
+func (e *SyscallPanicError) Error() string {
+	return fmt.Sprintf("system call %d panicked: %v", e.Number, e.Value)
+}
+
+// Unwrap is used to get the panic value if it is a error.
+func (e *SyscallPanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// SyscallDeniedError is used when the bytecode makes a system call which a filter added by PushSyscallFilter doesn't
+// allow. It wraps SyscallDenied, so errors.Is still matches it.
+type SyscallDeniedError struct {
+	// Number is the system call which was made.
+	Number uint64
+
+	// PC is the bytecode index of the system call instruction.
+	PC uint64
+}
+
+// Error implements the error interface.
+func (e *SyscallDeniedError) Error() string {
+	return fmt.Sprintf("%s: system call %d at pc %d", SyscallDenied.Error(), e.Number, e.PC)
+}
+
+// Unwrap is used to get SyscallDenied.
+func (e *SyscallDeniedError) Unwrap() error {
+	return SyscallDenied
+}
+
+// SyscallBudgetError is used when a system call budget runs out. It wraps SyscallBudgetExhausted, so errors.Is still
+// matches it.
+type SyscallBudgetError struct {
+	// Number is the system call which was made.
+	Number uint64
+
+	// Count is the number of calls the budget had already allowed.
+	Count uint64
+
+	// Global is true if MaxSyscalls ran out rather than the budget of the system call.
+	Global bool
+}
+
+// Error implements the error interface.
+func (e *SyscallBudgetError) Error() string {
+	if e.Global {
+		return fmt.Sprintf("%s: system call %d after %d system calls", SyscallBudgetExhausted.Error(), e.Number, e.Count)
+	}
+	return fmt.Sprintf("%s: system call %d after %d calls", SyscallBudgetExhausted.Error(), e.Number, e.Count)
+}
+
+// Unwrap is used to get SyscallBudgetExhausted.
+func (e *SyscallBudgetError) Unwrap() error {
+	return SyscallBudgetExhausted
+}
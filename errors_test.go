@@ -0,0 +1,188 @@
+package gomachine
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVMError(t *testing.T) {
+	tests := []struct {
+		name    string
+		program []byte
+		offset  uint64
+		opcode  uint8
+		operand uint64
+		err     error
+	}{
+		{
+			"invalid memory location",
+			[]byte{
+				InstructionUint8Load, 0x01, InstructionMoveR1ToR2,
+				InstructionMemoryUint8Load, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			},
+			3, InstructionMemoryUint8Load, 0x10, InvalidMemoryLocation,
+		},
+		{
+			"invalid jump target",
+			[]byte{InstructionMoveR1ToR2, InstructionJmp, 0xFF, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+			1, InstructionJmp, 0xFF, InvalidMemoryLocation,
+		},
+		{
+			"missing argument",
+			[]byte{InstructionMoveR1ToR2, InstructionMoveR1ToR3, InstructionUint32Load, 0x00},
+			2, InstructionUint32Load, 0, InvalidInstructionArgument,
+		},
+		{
+			"unknown instruction",
+			[]byte{InstructionMoveR1ToR2, 0xFF},
+			1, 0xFF, 0, UnknownInstruction,
+		},
+		{
+			"invalid syscall",
+			[]byte{InstructionMoveR1ToR2, InstructionSyscall, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+			1, InstructionSyscall, 5, InvalidSyscall,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vm := NewVM(4, 0)
+			err := vm.Execute(tt.program)
+			if !errors.Is(err, tt.err) {
+				t.Fatal("expected", tt.err, "got:", err)
+			}
+			var vmErr *VMError
+			if !errors.As(err, &vmErr) {
+				t.Fatal("expected a VMError, got:", err)
+			}
+			if vmErr.Offset != tt.offset || vmErr.Opcode != tt.opcode || vmErr.Operand != tt.operand {
+				t.Fatal("unexpected error fields:", vmErr.Offset, vmErr.Opcode, vmErr.Operand)
+			}
+		})
+	}
+}
+
+func TestVMError_Syscall(t *testing.T) {
+	vm := NewVM(0, 0)
+	syscallErr := errors.New("syscall failed")
+	vm.Syscalls[2] = func(*VM) error {
+		return &FatalSyscallError{Err: syscallErr}
+	}
+	err := vm.Execute([]byte{InstructionMoveR1ToR2, InstructionSyscall, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	if !errors.Is(err, syscallErr) {
+		t.Fatal("expected the syscall error, got:", err)
+	}
+	var vmErr *VMError
+	if !errors.As(err, &vmErr) || vmErr.Offset != 1 || vmErr.Operand != 2 {
+		t.Fatal("unexpected error:", err)
+	}
+}
+
+func TestVMError_CPUTimeExhausted(t *testing.T) {
+	vm := NewVM(0, time.Millisecond)
+	err := vm.Execute([]byte{InstructionMoveR1ToR2, InstructionJmp, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	var vmErr *VMError
+	if !errors.As(err, &vmErr) || !errors.Is(err, CPUTimeExhausted) {
+		t.Fatal("expected cpu time exhausted error, got:", err)
+	}
+	if vmErr.Offset != 1 || vmErr.Opcode != InstructionJmp {
+		t.Fatal("unexpected error fields:", vmErr.Offset, vmErr.Opcode)
+	}
+}
+
+func TestVMError_Error(t *testing.T) {
+	err := &VMError{Offset: 12, Opcode: InstructionJmp, Operand: 0x100, Err: InvalidMemoryLocation}
+	if err.Error() != "memory location is outside of the maximum array size (opcode 0x27 at offset 12, operand 0x100)" {
+		t.Fatal("unexpected error string:", err.Error())
+	}
+}
+
+func TestMemoryFault(t *testing.T) {
+	tests := []struct {
+		load    uint8
+		dump    uint8
+		width   uint64
+		compact bool
+	}{
+		{InstructionMemoryUint8Load, InstructionUint8Dump, 1, false},
+		{InstructionMemoryUint16Load, InstructionUint16Dump, 2, false},
+		{InstructionMemoryUint32Load, InstructionUint32Dump, 4, false},
+		{InstructionMemoryUint64Load, InstructionUint64Dump, 8, false},
+		{InstructionMemoryUint8Load32, InstructionUint8Dump32, 1, true},
+		{InstructionMemoryUint64Load32, InstructionUint64Dump32, 8, true},
+		{InstructionMemoryFloat32Load, InstructionFloat32Dump, 4, false},
+	}
+	for _, tt := range tests {
+		for _, instruction := range []uint8{tt.load, tt.dump} {
+			vm := NewVM(256, 0)
+			program := []byte{instruction, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+			if tt.compact {
+				program = program[:5]
+			}
+			err := vm.Execute(program)
+			if !errors.Is(err, InvalidMemoryLocation) {
+				t.Fatal("expected invalid memory location, got:", err)
+			}
+			var fault *MemoryFault
+			if !errors.As(err, &fault) {
+				t.Fatal("expected a memory fault, got:", err)
+			}
+			expected := MemoryFault{Address: 0x100, Width: tt.width, Write: instruction == tt.dump, Size: 256}
+			if *fault != expected {
+				t.Fatal("unexpected memory fault:", *fault)
+			}
+		}
+	}
+}
+
+func TestMemoryFault_Error(t *testing.T) {
+	err := &MemoryFault{Address: 0x1000, Width: 8, Write: true, Size: 256}
+	if err.Error() != "write of 8 bytes at 0x1000 exceeds memory size 256" {
+		t.Fatal("unexpected error string:", err.Error())
+	}
+	err = &MemoryFault{Address: 0x10, Width: 1, Size: 0}
+	if err.Error() != "read of 1 bytes at 0x10 exceeds memory size 0" {
+		t.Fatal("unexpected error string:", err.Error())
+	}
+}
+
+func TestNullPageFault_Error(t *testing.T) {
+	err := &NullPageFault{Address: 0x8, Width: 4, Write: true}
+	if err.Error() != "write of 4 bytes at 0x8 is inside the guard page" {
+		t.Fatal("unexpected error string:", err.Error())
+	}
+}
+
+func TestUnalignedAccess_Error(t *testing.T) {
+	err := &UnalignedAccess{Address: 0x3, Width: 4}
+	if err.Error() != "read of 4 bytes at 0x3 is not aligned" {
+		t.Fatal("unexpected error string:", err.Error())
+	}
+}
+
+func TestUninitializedRead_Error(t *testing.T) {
+	err := &UninitializedRead{Address: 0x20, Width: 8}
+	if err.Error() != "read of 8 bytes at 0x20 includes uninitialized memory" {
+		t.Fatal("unexpected error string:", err.Error())
+	}
+}
+
+func TestCPUTimeError(t *testing.T) {
+	vm := NewVM(0, 5*time.Millisecond)
+	err := vm.Execute([]byte{InstructionMoveR1ToR2, InstructionJmp, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	var cpuErr *CPUTimeError
+	if !errors.As(err, &cpuErr) || !errors.Is(err, CPUTimeExhausted) {
+		t.Fatal("expected cpu time error, got:", err)
+	}
+	if cpuErr.Limit != 5*time.Millisecond || cpuErr.Elapsed < cpuErr.Limit {
+		t.Fatal("wrong times:", cpuErr.Limit, cpuErr.Elapsed)
+	}
+	if cpuErr.Instructions == 0 || cpuErr.Instructions != vm.InstructionsExecuted || cpuErr.PC != vm.PC {
+		t.Fatal("wrong position:", cpuErr.Instructions, cpuErr.PC)
+	}
+
+	e := &CPUTimeError{Limit: time.Second, Elapsed: 1500 * time.Millisecond, Instructions: 1024, PC: 9}
+	if e.Error() != "cpu time is exhausted: used 1.5s of 1s after 1024 instructions at pc 9" {
+		t.Fatal("unexpected error string:", e.Error())
+	}
+}
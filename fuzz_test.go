@@ -0,0 +1,59 @@
+package gomachine
+
+import (
+	"testing"
+	"time"
+)
+
+// fuzzVM is used to create the virtual machine bytecode is fuzzed in. The bits of Config turn on the memory options
+// and Mode32 so the slow path and the 32-bit decoding are fuzzed too.
+func fuzzVM(Config uint8) *VM {
+	var options []Option
+	if Config&1 != 0 {
+		options = append(options, WithMode(Mode32))
+	}
+	var vm *VM
+	if Config&2 != 0 {
+		vm = NewPagedVM(1<<16, 256, 4, 10*time.Millisecond, options...)
+	} else {
+		vm = NewVM(64, 10*time.Millisecond, options...)
+	}
+	vm.MaxMemory = 256
+	vm.GuardSize = uint64(Config>>2&1) * 8
+	vm.WrapAddresses = Config&8 != 0
+	vm.RequireAlignment = Config&16 != 0
+	vm.TrackInitialization = Config&32 != 0
+	vm.StrictArithmetic = Config&64 != 0
+	vm.PreserveFlags = Config&128 != 0
+	vm.Syscalls = map[uint64]func(*VM) error{
+		0: SbrkSyscall,
+	}
+	return vm
+}
+
+func FuzzExecute(f *testing.F) {
+	// Seed the corpus with every instruction followed by enough zeros for any argument, and followed by 0xFF bytes so
+	// the largest memory locations (which overflow when the width is added) are tried.
+	for opcode := 1; opcode <= int(InstructionSyscallR1); opcode++ {
+		for _, fill := range []uint8{0x00, 0xFF} {
+			bytecode := make([]byte, 17)
+			bytecode[0] = uint8(opcode)
+			for i := 1; i < len(bytecode); i++ {
+				bytecode[i] = fill
+			}
+			f.Add(uint8(0), bytecode)
+			f.Add(uint8(0xFF), bytecode)
+		}
+	}
+	f.Fuzz(func(t *testing.T, config uint8, bytecode []byte) {
+		// Any error is fine, but it must not panic.
+		_ = fuzzVM(config).Execute(bytecode)
+
+		// Run it from memory too, which follows the memory if it is replaced.
+		vm := fuzzVM(config)
+		if vm.paged == nil {
+			copy(vm.Memory, bytecode)
+			_ = vm.ExecuteFromMemory(0)
+		}
+	})
+}
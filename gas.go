@@ -0,0 +1,36 @@
+package gomachine
+
+import "errors"
+
+// OutOfGas is returned when there isn't enough gas left to run the next instruction or for a system call to charge.
+var OutOfGas = errors.New("out of gas")
+
+// CostTable is used to define how much gas each opcode costs. Opcodes which aren't in the table cost 1.
+type CostTable map[uint8]uint64
+
+// costs is used to get the cost of every opcode.
+func (c CostTable) costs() *[256]uint64 {
+	var costs [256]uint64
+	for i := range costs {
+		costs[i] = 1
+	}
+	for opcode, cost := range c {
+		costs[opcode] = cost
+	}
+	return &costs
+}
+
+// ChargeGas is used by system calls to charge gas on top of the cost of InstructionSyscall. If there isn't enough gas
+// left, GasRemaining is set to 0 and a FatalSyscallError wrapping OutOfGas is returned, which the system call should
+// return. Nothing is charged if CostTable is nil.
+func (v *VM) ChargeGas(Amount uint64) error {
+	if v.CostTable == nil {
+		return nil
+	}
+	if Amount > v.GasRemaining {
+		v.GasRemaining = 0
+		return &FatalSyscallError{Err: OutOfGas}
+	}
+	v.GasRemaining -= Amount
+	return nil
+}
@@ -0,0 +1,63 @@
+package gomachine
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVM_Execute_Gas(t *testing.T) {
+	costs := CostTable{
+		InstructionUnsignedAdd: 3,
+		InstructionSyscall:     10,
+	}
+	bytecode := []byte{
+		InstructionUint8Load, 0x05,
+		InstructionMoveR1ToR2,
+		InstructionUnsignedAdd,
+		InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	syscalls := map[uint64]func(*VM) error{
+		1: func(vm *VM) error {
+			return vm.ChargeGas(7)
+		},
+	}
+
+	// The loads cost 1, the add 3, the syscall 10 and the syscall charges another 7.
+	vm := NewVM(0, 0, WithGas(costs, 100))
+	vm.Syscalls = syscalls
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	if vm.GasRemaining != 78 {
+		t.Fatal("not 78:", vm.GasRemaining)
+	}
+
+	// Running out of gas stops before the add and leaves the gas it couldn't pay for.
+	vm = NewVM(0, 0, WithGas(costs, 4))
+	vm.Syscalls = syscalls
+	err := vm.Execute(bytecode)
+	if !errors.Is(err, OutOfGas) {
+		t.Fatal("expected out of gas, got:", err)
+	}
+	if vm.PC != 3 || vm.GasRemaining != 2 || vm.Registers[0] != 5 {
+		t.Fatal("wrong state:", vm.PC, vm.GasRemaining, vm.Registers[0])
+	}
+
+	// The syscall charging more than is left empties the gas.
+	vm = NewVM(0, 0, WithGas(costs, 20))
+	vm.Syscalls = syscalls
+	err = vm.Execute(bytecode)
+	if !errors.Is(err, OutOfGas) {
+		t.Fatal("expected out of gas, got:", err)
+	}
+	if vm.PC != 4 || vm.GasRemaining != 0 {
+		t.Fatal("wrong state:", vm.PC, vm.GasRemaining)
+	}
+
+	// Without a cost table gas isn't metered.
+	vm = NewVM(0, 0)
+	vm.Syscalls = syscalls
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+}
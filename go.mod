@@ -1,3 +1,3 @@
 module gomachine
 
-go 1.15
+go 1.18
@@ -0,0 +1,135 @@
+// Package gomachinetest is used to provide helpers for testing bytecode run by gomachine.
+package gomachinetest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gomachine"
+)
+
+// maxDiffLines is the most lines from each side shown in a failure diff.
+const maxDiffLines = 40
+
+// diffContext is the number of matching lines shown before the first difference.
+const diffContext = 3
+
+// RunGolden is used to execute the program and compare its trace, error, final registers and the memory windows
+// specified with the golden file at the path specified. If Update is true, the golden file is written instead, so it is
+// usually passed a flag like:
+//
+//	var update = flag.Bool("update", false, "update golden files")
+//
+// The trace is written with a gomachine.JSONTraceEncoder, which is removed from the virtual machine before RunGolden
+// returns. Line endings and trailing whitespace are normalized before comparing.
+func RunGolden(t testing.TB, VM *gomachine.VM, Program []byte, GoldenPath string, Update bool, MemoryWindows ...gomachine.MemRange) {
+	t.Helper()
+	got, err := goldenOutput(VM, Program, MemoryWindows)
+	if err != nil {
+		t.Fatalf("gomachinetest: %v", err)
+		return
+	}
+
+	if Update {
+		if err := os.MkdirAll(filepath.Dir(GoldenPath), 0o755); err != nil {
+			t.Fatalf("gomachinetest: %v", err)
+			return
+		}
+		if err := os.WriteFile(GoldenPath, got, 0o644); err != nil {
+			t.Fatalf("gomachinetest: %v", err)
+			return
+		}
+		t.Logf("gomachinetest: updated %s", GoldenPath)
+		return
+	}
+
+	want, err := os.ReadFile(GoldenPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("gomachinetest: golden file %s does not exist, run with -update to create it", GoldenPath)
+			return
+		}
+		t.Fatalf("gomachinetest: %v", err)
+		return
+	}
+	want = normalize(want)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("gomachinetest: output does not match %s (run with -update to accept it):\n%s", GoldenPath, diff(want, got))
+	}
+}
+
+// goldenOutput is used to execute the program and get the normalized output compared with the golden file.
+func goldenOutput(VM *gomachine.VM, Program []byte, MemoryWindows []gomachine.MemRange) ([]byte, error) {
+	var buf bytes.Buffer
+	VM.SetTraceEncoder(gomachine.NewJSONTraceEncoder(&buf))
+	execErr := VM.Execute(Program)
+	VM.SetTraceEncoder(nil)
+
+	if execErr == nil {
+		buf.WriteString("Error: none\n")
+	} else {
+		fmt.Fprintf(&buf, "Error: %v\n", execErr)
+	}
+	if err := VM.DumpState(&buf, MemoryWindows...); err != nil {
+		return nil, err
+	}
+	return normalize(buf.Bytes()), nil
+}
+
+// normalize is used to convert line endings to \n, remove trailing whitespace from each line and make sure the output
+// ends with a single newline.
+func normalize(b []byte) []byte {
+	lines := strings.Split(strings.ReplaceAll(string(b), "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	s := strings.TrimRight(strings.Join(lines, "\n"), "\n")
+	if s == "" {
+		return nil
+	}
+	return []byte(s + "\n")
+}
+
+// diff is used to show where the output differs from the golden file. The lines both have in common at the start and
+// end are skipped, apart from a few lines of context before the difference.
+func diff(Want, Got []byte) string {
+	want := strings.Split(strings.TrimSuffix(string(Want), "\n"), "\n")
+	got := strings.Split(strings.TrimSuffix(string(Got), "\n"), "\n")
+	start := 0
+	for start < len(want) && start < len(got) && want[start] == got[start] {
+		start++
+	}
+	wantEnd, gotEnd := len(want), len(got)
+	for wantEnd > start && gotEnd > start && want[wantEnd-1] == got[gotEnd-1] {
+		wantEnd--
+		gotEnd--
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "@@ line %d @@\n", start+1)
+	context := start - diffContext
+	if context < 0 {
+		context = 0
+	}
+	for _, line := range want[context:start] {
+		b.WriteString("  " + line + "\n")
+	}
+	writeLines := func(Prefix string, Lines []string) {
+		for i, line := range Lines {
+			if i == maxDiffLines {
+				fmt.Fprintf(&b, "%s... %d more lines\n", Prefix, len(Lines)-i)
+				break
+			}
+			b.WriteString(Prefix + " " + line + "\n")
+		}
+	}
+	writeLines("-", want[start:wantEnd])
+	writeLines("+", got[start:gotEnd])
+	return b.String()
+}
@@ -0,0 +1,97 @@
+package gomachinetest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gomachine"
+)
+
+// recordingTB is used to catch failures from RunGolden instead of failing the test.
+type recordingTB struct {
+	testing.TB
+	failed  bool
+	message string
+}
+
+func (r *recordingTB) Helper() {}
+
+func (r *recordingTB) Logf(string, ...interface{}) {}
+
+func (r *recordingTB) Fatalf(Format string, Args ...interface{}) {
+	r.failed = true
+	r.message = fmt.Sprintf(Format, Args...)
+}
+
+func TestRunGolden(t *testing.T) {
+	program := []byte{
+		gomachine.InstructionUint8Load, 0x05,
+		gomachine.InstructionUint8LoadR2Direct, 0x03,
+		gomachine.InstructionUnsignedAdd,
+		gomachine.InstructionUint8Dump, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	path := filepath.Join(t.TempDir(), "testdata", "add.golden")
+	window := gomachine.MemRange{Offset: 0, Length: 16}
+
+	// A missing golden file fails with a hint.
+	r := &recordingTB{TB: t}
+	RunGolden(r, gomachine.NewVM(16, 0), program, path, false, window)
+	if !r.failed || !strings.Contains(r.message, "-update") {
+		t.Fatal("missing golden file did not fail:", r.message)
+	}
+
+	// Updating writes the trace, error, registers and memory.
+	RunGolden(t, gomachine.NewVM(16, 0), program, path, true, window)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden := string(b)
+	for _, s := range []string{`"mnemonic":"UnsignedAdd"`, "Error: none", "R1: 0x0000000000000008 (8)", "Memory 0x0-0x10:", "00000000  08"} {
+		if !strings.Contains(golden, s) {
+			t.Fatalf("golden file is missing %q:\n%s", s, golden)
+		}
+	}
+
+	// The same program matches, even with different line endings and trailing whitespace in the golden file.
+	RunGolden(t, gomachine.NewVM(16, 0), program, path, false, window)
+	if err := os.WriteFile(path, []byte(strings.ReplaceAll(golden, "\n", " \r\n")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	RunGolden(t, gomachine.NewVM(16, 0), program, path, false, window)
+
+	// A different program fails with a diff of the lines which changed.
+	program[1] = 0x06
+	r = &recordingTB{TB: t}
+	RunGolden(r, gomachine.NewVM(16, 0), program, path, false, window)
+	if !r.failed {
+		t.Fatal("different program matched")
+	}
+	if !strings.Contains(r.message, "@@ line 1 @@") || !strings.Contains(r.message, `- {"offset":0,"opcode":1,"mnemonic":"Uint8Load","operand":5`) ||
+		!strings.Contains(r.message, `+ {"offset":0,"opcode":1,"mnemonic":"Uint8Load","operand":6`) || !strings.Contains(r.message, "+ R1: 0x0000000000000009 (9)") {
+		t.Fatal("wrong diff:", r.message)
+	}
+
+	// Errors are part of the output.
+	errPath := filepath.Join(t.TempDir(), "error.golden")
+	bad := []byte{gomachine.InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	RunGolden(t, gomachine.NewVM(0, 0), bad, errPath, true)
+	if b, err = os.ReadFile(errPath); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "Error: none") {
+		t.Fatal("error is missing:", string(b))
+	}
+}
+
+func TestDiff(t *testing.T) {
+	want := []byte("a\nb\nc\nd\ne\nf\n")
+	got := []byte("a\nb\nc\nd\nx\nf\n")
+	expected := "@@ line 5 @@\n  b\n  c\n  d\n- e\n+ x\n"
+	if s := diff(want, got); s != expected {
+		t.Fatalf("wrong diff:\n%s", s)
+	}
+}
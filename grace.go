@@ -0,0 +1,21 @@
+package gomachine
+
+import "time"
+
+// GraceDecision is used to say what happens when a CPU time limit is hit. It is returned by OnCPUExhausted.
+type GraceDecision struct {
+	duration     time.Duration
+	instructions uint64
+}
+
+// Kill is used to end execution with the CPU time error straight away, which is what happens without OnCPUExhausted.
+var Kill = GraceDecision{}
+
+// GrantGrace is used to let the bytecode run the cleanup code at CleanupOffset for up to the CPU time and number of
+// instructions specified. 0 means there is no limit of that kind, but if both are 0 it is the same as Kill.
+func GrantGrace(Duration time.Duration, Instructions uint64) GraceDecision {
+	if Duration < 0 {
+		Duration = 0
+	}
+	return GraceDecision{duration: Duration, instructions: Instructions}
+}
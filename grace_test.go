@@ -0,0 +1,93 @@
+package gomachine
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVM_Execute_OnCPUExhausted(t *testing.T) {
+	// Spin until the CPU time runs out. The cleanup code at 9 saves 42 to memory.
+	bytecode := []byte{
+		InstructionJmp, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint8Load, 0x2A,
+		InstructionUint8Dump, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	run := func(Bytecode []byte, Decision GraceDecision) (*VM, int, error) {
+		vm := NewVM(8, 10*time.Millisecond)
+		vm.CleanupOffset = 9
+		calls := 0
+		vm.OnCPUExhausted = func(vm *VM) GraceDecision {
+			calls++
+			if vm.PC != 0 {
+				t.Error("wrong pc:", vm.PC)
+			}
+			return Decision
+		}
+		err := vm.Execute(Bytecode)
+		return vm, calls, err
+	}
+
+	// The epilogue runs and its writes land in memory.
+	vm, calls, err := run(bytecode, GrantGrace(time.Second, 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 || vm.Memory[0] != 42 {
+		t.Fatal("epilogue didn't run:", calls, vm.Memory[0])
+	}
+
+	// Memory grown by the handler is what the epilogue writes to.
+	vm = NewVM(8, 10*time.Millisecond)
+	vm.CleanupOffset = 9
+	vm.OnCPUExhausted = func(vm *VM) GraceDecision {
+		if _, err := vm.Grow(1 << 20); err != nil {
+			t.Fatal(err)
+		}
+		return GrantGrace(time.Second, 100)
+	}
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	if len(vm.Memory) != 8+1<<20 || vm.Memory[0] != 42 {
+		t.Fatal("epilogue write was lost:", len(vm.Memory), vm.Memory[0])
+	}
+
+	// Killing returns the CPU time error without running the epilogue.
+	vm, calls, err = run(bytecode, Kill)
+	if !errors.Is(err, CPUTimeExhausted) {
+		t.Fatal("expected cpu time exhausted, got:", err)
+	}
+	if calls != 1 || vm.Memory[0] != 0 {
+		t.Fatal("epilogue ran:", calls, vm.Memory[0])
+	}
+
+	// A epilogue which spins forever is stopped when the grace instructions run out, and grace is only granted once.
+	spin := append(bytecode[:9:9], InstructionJmp, 0x09, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+	vm, calls, err = run(spin, GrantGrace(0, 50))
+	var cpuErr *CPUTimeError
+	if !errors.As(err, &cpuErr) {
+		t.Fatal("expected cpu time error, got:", err)
+	}
+	if calls != 1 || vm.InstructionsExecuted != cpuErr.Instructions+50 {
+		t.Fatal("wrong grace:", calls, vm.InstructionsExecuted, cpuErr.Instructions)
+	}
+
+	// The same goes for the grace CPU time.
+	start := time.Now()
+	_, calls, err = run(spin, GrantGrace(20*time.Millisecond, 0))
+	if !errors.Is(err, CPUTimeExhausted) {
+		t.Fatal("expected cpu time exhausted, got:", err)
+	}
+	if calls != 1 || time.Since(start) < 30*time.Millisecond {
+		t.Fatal("wrong grace:", calls, time.Since(start))
+	}
+
+	// A cleanup offset outside of the bytecode is an error.
+	vm = NewVM(8, time.Millisecond)
+	vm.CleanupOffset = 100
+	vm.OnCPUExhausted = func(*VM) GraceDecision { return GrantGrace(time.Second, 0) }
+	if err := vm.Execute(spin); !errors.Is(err, InvalidMemoryLocation) {
+		t.Fatal("expected invalid memory location, got:", err)
+	}
+}
@@ -0,0 +1,46 @@
+package gomachine
+
+import (
+	"hash/fnv"
+	"sort"
+)
+
+// StateHash is used to get a 64-bit FNV-1a fingerprint of the state of the virtual machine. The hash covers, in order,
+// the 8 registers, the PC, RandomState and the memory size as little endian uint64s followed by the memory. For virtual
+// machines created with NewPagedVM, the memory is hashed as the page number (as a little endian uint64) and contents of
+// every page which isn't all zeros in ascending order. The encoding doesn't depend on the host, so the same logical
+// state gives the same hash on every architecture.
+func (v *VM) StateHash() uint64 {
+	h := fnv.New64a()
+	var b [8]byte
+	writeUint64 := func(x uint64) {
+		putUint64(b[:], 0, x)
+		_, _ = h.Write(b[:])
+	}
+	for _, r := range v.Registers {
+		writeUint64(r)
+	}
+	writeUint64(v.PC)
+	writeUint64(v.RandomState)
+	writeUint64(v.memorySize())
+
+	if v.paged == nil {
+		_, _ = h.Write(v.Memory)
+		return h.Sum64()
+	}
+	pages := make([]uint64, 0, len(v.paged.pages))
+	for n, page := range v.paged.pages {
+		for _, x := range page {
+			if x != 0 {
+				pages = append(pages, n)
+				break
+			}
+		}
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i] < pages[j] })
+	for _, n := range pages {
+		writeUint64(n)
+		_, _ = h.Write(v.paged.pages[n])
+	}
+	return h.Sum64()
+}
@@ -0,0 +1,62 @@
+package gomachine
+
+import (
+	"testing"
+)
+
+func TestVM_StateHash(t *testing.T) {
+	// Fill memory with 8 random numbers and sum them into R1. The hash is fixed, so a change to the semantics of any of
+	// the instructions used or to the encoding of the hash fails this test.
+	vm := NewVM(64, 0)
+	vm.SeedRandom(42)
+	bytecode := []byte{
+		InstructionRand,
+		InstructionUint64Dump, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionRand,
+		InstructionUint64Dump, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionRand,
+		InstructionUint64Dump, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionRand,
+		InstructionUint64Dump, 0x18, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint8LoadR3Direct, 0x04,
+		InstructionMemorySum64,
+	}
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	const expected = 0x83883f15840202bf
+	if h := vm.StateHash(); h != expected {
+		t.Fatalf("hash is 0x%016x, not 0x%016x", h, uint64(expected))
+	}
+
+	// A clone has the same hash and changing a byte of memory or a register changes it.
+	clone := vm.Clone()
+	if clone.StateHash() != vm.StateHash() {
+		t.Fatal("clone hash differs")
+	}
+	clone.Memory[63] = 1
+	if clone.StateHash() == vm.StateHash() {
+		t.Fatal("memory change didn't change the hash")
+	}
+	clone = vm.Clone()
+	clone.Registers[7]++
+	if clone.StateHash() == vm.StateHash() {
+		t.Fatal("register change didn't change the hash")
+	}
+
+	// Paged memory ignores pages which are all zeros.
+	paged := NewPagedVM(1<<20, 0, 0, 0)
+	before := paged.StateHash()
+	if _, err := paged.ReadUint64At(8192); err != nil {
+		t.Fatal(err)
+	}
+	if paged.ResidentPages() != 1 || paged.StateHash() != before {
+		t.Fatal("zero page changed the hash")
+	}
+	if err := paged.WriteUint64At(8192, 1); err != nil {
+		t.Fatal(err)
+	}
+	if paged.StateHash() == before {
+		t.Fatal("page write didn't change the hash")
+	}
+}
@@ -0,0 +1,75 @@
+package gomachine
+
+import (
+	"errors"
+	"math/bits"
+	"sync/atomic"
+)
+
+// NotInInterrupt is returned when InstructionInterruptReturn runs outside of a interrupt handler.
+var NotInInterrupt = errors.New("not in a interrupt handler")
+
+// SetInterruptHandler is used to set the bytecode index execution jumps to when the interrupt vector specified is
+// raised. The handler should end with InstructionInterruptReturn and has to save and restore any registers it uses.
+func (v *VM) SetInterruptHandler(Vector uint8, Offset uint64) {
+	if v.interruptHandlers == nil {
+		v.interruptHandlers = map[uint8]uint64{}
+	}
+	v.interruptHandlers[Vector] = Offset
+}
+
+// ClearInterruptHandler is used to remove the handler for the interrupt vector specified. Interrupts raised for a
+// vector with no handler are dropped.
+func (v *VM) ClearInterruptHandler(Vector uint8) {
+	delete(v.interruptHandlers, Vector)
+}
+
+// RaiseInterrupt is used to raise the interrupt vector specified. It can be called from any goroutine. Within
+// CheckInterval instructions (or before the next instruction if it is called from a system call), the PC is saved and
+// execution jumps to the handler for the vector. Interrupts raised while a handler is running stay pending until it
+// returns, and are then handled lowest vector first. Pending vectors are a set rather than a queue: raising a vector
+// which is already pending does nothing, so the handler runs once however many times the vector was raised before it
+// was taken. Hosts which need to count events should keep the count themselves and read it from the handler.
+func (v *VM) RaiseInterrupt(Vector uint8) {
+	word, bit := &v.interruptsPending[Vector/32], uint32(1)<<(Vector%32)
+	for {
+		old := atomic.LoadUint32(word)
+		if atomic.CompareAndSwapUint32(word, old, old|bit) {
+			break
+		}
+	}
+	atomic.StoreUint32(&v.interruptRaised, 1)
+}
+
+// takeInterrupt is used to take the lowest pending interrupt vector which has a handler and get the handler's bytecode
+// index. Pending vectors with no handler are dropped.
+func (v *VM) takeInterrupt() (uint64, bool) {
+	atomic.StoreUint32(&v.interruptRaised, 0)
+	for i := range v.interruptsPending {
+		word := &v.interruptsPending[i]
+		for {
+			old := atomic.LoadUint32(word)
+			if old == 0 {
+				break
+			}
+			bit := old & -old
+			if !atomic.CompareAndSwapUint32(word, old, old&^bit) {
+				continue
+			}
+			if offset, ok := v.interruptHandlers[uint8(i*32+bits.TrailingZeros32(bit))]; ok {
+				// Check for other pending vectors again once the handler returns.
+				atomic.StoreUint32(&v.interruptRaised, 1)
+				return offset, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// clearInterrupts is used to drop every pending interrupt vector.
+func (v *VM) clearInterrupts() {
+	for i := range v.interruptsPending {
+		atomic.StoreUint32(&v.interruptsPending[i], 0)
+	}
+	atomic.StoreUint32(&v.interruptRaised, 0)
+}
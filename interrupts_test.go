@@ -0,0 +1,59 @@
+package gomachine
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVM_RaiseInterrupt(t *testing.T) {
+	// Count R1 up to 1000 calling a syscall each time round the loop. The handler adds 1 to R8 and the syscall raises
+	// the interrupt when R1 is 500, raises two at once when R1 is 600 and raises the same one twice when R1 is 700.
+	bytecode := []byte{
+		InstructionJmp, 0x15, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+
+		// Handler at 0x09: R8 += 1 using R5 so R1 and R2 are preserved.
+		InstructionUint64LoadR5Direct, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionAdd2, RegisterOperand(7, 4),
+		InstructionInterruptReturn,
+
+		// Loop at 0x15.
+		InstructionUint64LoadR3Direct, 0xE8, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint8LoadR2Direct, 0x01,
+		InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUnsignedAdd,
+		InstructionJmpIfLt, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	vm := NewVM(0, 0)
+	vm.SetInterruptHandler(3, 0x09)
+	vm.SetInterruptHandler(4, 0x09)
+	vm.Syscalls = map[uint64]func(*VM) error{
+		1: func(vm *VM) error {
+			switch vm.Registers[0] {
+			case 500:
+				vm.RaiseInterrupt(3)
+			case 600:
+				vm.RaiseInterrupt(4)
+				vm.RaiseInterrupt(3)
+
+				// Vectors with no handler are dropped.
+				vm.RaiseInterrupt(200)
+			case 700:
+				// Raising a vector which is already pending merges with it, so the handler runs once.
+				vm.RaiseInterrupt(3)
+				vm.RaiseInterrupt(3)
+			}
+			return nil
+		},
+	}
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 1000 || vm.Registers[7] != 4 {
+		t.Fatal("wrong state:", vm.Registers[0], vm.Registers[7])
+	}
+
+	// Returning outside of a handler errors.
+	if err := vm.Execute([]byte{InstructionInterruptReturn}); !errors.Is(err, NotInInterrupt) {
+		t.Fatal("expected not in interrupt, got:", err)
+	}
+}
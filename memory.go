@@ -0,0 +1,558 @@
+package gomachine
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// OutOfMemory is returned when a access to a paged virtual machine needs a new page but the resident page limit is
+// reached.
+var OutOfMemory = errors.New("resident page limit exceeded")
+
+// MemoryQuotaExceeded is returned when growing the memory would take it past MaxMemory.
+var MemoryQuotaExceeded = errors.New("memory quota exceeded")
+
+// DefaultMaxMemory is the size Grow can't grow memory which isn't paged past when MaxMemory is 0, so a huge request
+// fails instead of crashing the host.
+const DefaultMaxMemory uint64 = 1 << 32
+
+// MemoryWriteProtected is returned when a dump writes to the ROM.
+var MemoryWriteProtected = errors.New("memory is write protected")
+
+// IORangeOverlap is returned by MapIO when the range overlaps a range which is already mapped.
+var IORangeOverlap = errors.New("memory mapped io range overlaps a existing range")
+
+// IORangeStraddled is returned when a memory access is partly inside a memory mapped io range.
+var IORangeStraddled = errors.New("memory access straddles a memory mapped io range boundary")
+
+// MMIOHandler is used to back a range of addresses with Go code instead of the memory. The address passed is the
+// memory location accessed (not the offset into the range) and the width is the number of bytes accessed. Errors
+// returned abort the execution and are wrapped in the VMError.
+type MMIOHandler interface {
+	// Read is used to handle a load from the range. Only the low width bytes of the value are used.
+	Read(Address uint64, Width int) (uint64, error)
+
+	// Write is used to handle a dump into the range. Only the low width bytes of the value are set.
+	Write(Address uint64, Width int, Value uint64) error
+}
+
+// ioRange is a range of addresses mapped to a MMIOHandler.
+type ioRange struct {
+	start, last uint64
+	handler     MMIOHandler
+}
+
+// MapIO is used to map the range of addresses specified to the handler. Loads and dumps inside the range call the
+// handler instead of touching the memory, and the range does not need to be inside the memory. Ranges can't overlap
+// and accesses which are partly inside a range return IORangeStraddled.
+func (v *VM) MapIO(Start, Length uint64, Handler MMIOHandler) error {
+	if Length == 0 || Start+Length-1 < Start {
+		return &MemoryFault{Address: Start, Width: Length, Size: uint64(len(v.Memory))}
+	}
+	last := Start + Length - 1
+	for _, r := range v.io {
+		if Start <= r.last && last >= r.start {
+			return IORangeOverlap
+		}
+	}
+	v.io = append(v.io, ioRange{start: Start, last: last, handler: Handler})
+	return nil
+}
+
+// findIO is used to get the memory mapped io range an access is inside. Nil is returned if the access doesn't touch
+// any range.
+func (v *VM) findIO(Address, Width uint64) (*ioRange, error) {
+	last := Address + Width - 1
+	for i := range v.io {
+		r := &v.io[i]
+		if Address <= r.last && last >= r.start {
+			if Address < r.start || last > r.last || last < Address {
+				return nil, IORangeStraddled
+			}
+			return r, nil
+		}
+	}
+	return nil, nil
+}
+
+// slowMemory is used to check if memory accesses have to go through load, store and loadRange instead of the inline
+// fast path in Execute.
+func (v *VM) slowMemory() bool {
+	return v.GuardSize != 0 || len(v.io) != 0 || v.rom != nil || v.paged != nil || v.WrapAddresses ||
+		v.RequireAlignment || v.TrackInitialization || v.transaction != nil || v.MemoryProfileBucketSize != 0 ||
+		v.OnMemoryWrite != nil || v.reverse != nil
+}
+
+// wrap is used to apply WrapAddresses to a access. The address is returned modulo the memory size and split is true if
+// the access runs off the end of the memory, in which case it must be done a byte at a time.
+func (v *VM) wrap(Address, Width uint64) (uint64, bool) {
+	size := v.memorySize()
+	if !v.WrapAddresses || size == 0 {
+		return Address, false
+	}
+	Address %= size
+	return Address, Width > size-Address
+}
+
+// memorySize is used to get the size of the address space backed by memory.
+func (v *VM) memorySize() uint64 {
+	if v.paged != nil {
+		return v.paged.size
+	}
+	return uint64(len(v.Memory))
+}
+
+// checkGuard is used to check a memory access against the guard size.
+func (v *VM) checkGuard(Address, Width uint64, Write bool) error {
+	if Width != 0 && Address < v.GuardSize {
+		return &NullPageFault{Address: Address, Width: Width, Write: Write}
+	}
+	return nil
+}
+
+// checkBounds is used to check a memory access is inside the memory.
+func (v *VM) checkBounds(Address, Width uint64, Write bool) error {
+	size := v.memorySize()
+	if Width > size || Address > size-Width {
+		return &MemoryFault{Address: Address, Width: Width, Write: Write, Size: size}
+	}
+	return nil
+}
+
+// load is used to read a little endian value of the width specified from memory on the slow path.
+func (v *VM) load(Address, Width uint64) (uint64, error) {
+	if v.MemoryProfileBucketSize != 0 {
+		v.profileMemory(Address, false)
+	}
+	return v.loadValue(Address, Width)
+}
+
+// loadValue is used to do the read for load without counting it in the memory profile.
+func (v *VM) loadValue(Address, Width uint64) (uint64, error) {
+	if v.RequireAlignment && Address%Width != 0 {
+		return 0, &UnalignedAccess{Address: Address, Width: Width}
+	}
+	Address, split := v.wrap(Address, Width)
+	if split {
+		x := uint64(0)
+		for i := uint64(0); i < Width; i++ {
+			b, err := v.loadValue(Address+i, 1)
+			if err != nil {
+				return 0, err
+			}
+			x |= b << (8 * i)
+		}
+		return x, nil
+	}
+	if err := v.checkGuard(Address, Width, false); err != nil {
+		return 0, err
+	}
+	if len(v.io) != 0 {
+		r, err := v.findIO(Address, Width)
+		if err != nil {
+			return 0, err
+		}
+		if r != nil {
+			x, err := r.handler.Read(Address, int(Width))
+			if err != nil {
+				return 0, err
+			}
+			if Width < 8 {
+				x &= 1<<(Width*8) - 1
+			}
+			return x, nil
+		}
+	}
+	if v.paged != nil || (v.rom != nil && v.touchesROM(Address, Width)) {
+		// Put the value together a byte at a time since it may be partly in the ROM and partly in the memory, or split
+		// across pages.
+		x := uint64(0)
+		for i := uint64(0); i < Width; i++ {
+			b, err := v.readByte(Address+i, Address, Width)
+			if err != nil {
+				return 0, err
+			}
+			x |= uint64(b) << (8 * i)
+		}
+		if v.TrackInitialization {
+			if err := v.checkInitialized(Address, Width); err != nil {
+				return 0, err
+			}
+		}
+		return x, nil
+	}
+	if err := v.checkBounds(Address, Width, false); err != nil {
+		return 0, err
+	}
+	if v.TrackInitialization {
+		if err := v.checkInitialized(Address, Width); err != nil {
+			return 0, err
+		}
+	}
+	switch Width {
+	case 1:
+		return uint64(v.Memory[Address]), nil
+	case 2:
+		return uint64(getUint16(v.Memory, Address)), nil
+	case 4:
+		return uint64(getUint32(v.Memory, Address)), nil
+	default:
+		return getUint64(v.Memory, Address), nil
+	}
+}
+
+// store is used to write the low bytes of the value to memory as a little endian value of the width specified on the
+// slow path.
+func (v *VM) store(Address, Width, Value uint64) error {
+	if v.MemoryProfileBucketSize != 0 {
+		v.profileMemory(Address, true)
+	}
+	return v.storeValue(Address, Width, Value)
+}
+
+// storeValue is used to do the write for store without counting it in the memory profile.
+func (v *VM) storeValue(Address, Width, Value uint64) error {
+	if v.RequireAlignment && Address%Width != 0 {
+		return &UnalignedAccess{Address: Address, Width: Width, Write: true}
+	}
+	Address, split := v.wrap(Address, Width)
+	if split {
+		for i := uint64(0); i < Width; i++ {
+			if err := v.storeValue(Address+i, 1, Value>>(8*i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := v.checkGuard(Address, Width, true); err != nil {
+		return err
+	}
+	if len(v.io) != 0 {
+		r, err := v.findIO(Address, Width)
+		if err != nil {
+			return err
+		}
+		if r != nil {
+			if Width < 8 {
+				Value &= 1<<(Width*8) - 1
+			}
+			return r.handler.Write(Address, int(Width), Value)
+		}
+	}
+	if v.rom != nil && v.touchesROM(Address, Width) {
+		return MemoryWriteProtected
+	}
+	if err := v.checkBounds(Address, Width, true); err != nil {
+		return err
+	}
+	if v.transaction != nil {
+		v.record(Address, Width)
+	}
+	if v.reverse != nil {
+		v.reverse.record(v, Address, Width)
+	}
+	if v.paged != nil {
+		for i := uint64(0); i < Width; i++ {
+			page, err := v.paged.page(Address + i)
+			if err != nil {
+				return err
+			}
+			page[(Address+i)%v.paged.pageSize] = uint8(Value >> (8 * i))
+		}
+		if v.TrackInitialization {
+			v.MarkInitialized(Address, Width)
+		}
+		v.notifyWrite(Address, Width, Value)
+		return nil
+	}
+	if v.TrackInitialization {
+		v.MarkInitialized(Address, Width)
+	}
+	switch Width {
+	case 1:
+		v.Memory[Address] = uint8(Value)
+	case 2:
+		putUint16(v.Memory, Address, uint16(Value))
+	case 4:
+		putUint32(v.Memory, Address, uint32(Value))
+	default:
+		putUint64(v.Memory, Address, Value)
+	}
+	v.notifyWrite(Address, Width, Value)
+	return nil
+}
+
+// notifyWrite is used to call OnMemoryWrite, if it is set, with the low bytes of the value written.
+func (v *VM) notifyWrite(Address, Width, Value uint64) {
+	if v.OnMemoryWrite == nil {
+		return
+	}
+	if Width < 8 {
+		Value &= 1<<(Width*8) - 1
+	}
+	v.OnMemoryWrite(Address, int(Width), Value)
+}
+
+// loadRange is used to get the bytes in a range of memory on the slow path. The bytes must not be written to.
+func (v *VM) loadRange(Address, Length uint64) ([]byte, error) {
+	if v.MemoryProfileBucketSize != 0 {
+		v.profileMemory(Address, false)
+	}
+	Address, split := v.wrap(Address, Length)
+	if err := v.checkGuard(Address, Length, false); err != nil {
+		return nil, err
+	}
+	if Length != 0 && (split || v.paged != nil || v.touchesIO(Address, Length) || (v.rom != nil && v.touchesROM(Address, Length))) {
+		// Build a copy a byte at a time so the handlers and ROM are used for the bytes inside them. The copy is grown as
+		// it goes so a huge length faults before it is allocated.
+		var region []byte
+		for i := uint64(0); i < Length; i++ {
+			x, err := v.loadValue(Address+i, 1)
+			if err != nil {
+				return nil, err
+			}
+			region = append(region, uint8(x))
+		}
+		return region, nil
+	}
+	if err := v.checkBounds(Address, Length, false); err != nil {
+		return nil, err
+	}
+	if Length == 0 {
+		// Paged virtual machines have no memory slice to take a empty range of.
+		return nil, nil
+	}
+	if v.TrackInitialization {
+		if err := v.checkInitialized(Address, Length); err != nil {
+			return nil, err
+		}
+	}
+	return v.Memory[Address : Address+Length], nil
+}
+
+// touchesIO is used to check if a range of addresses touches any memory mapped io range.
+func (v *VM) touchesIO(Address, Length uint64) bool {
+	if Length == 0 {
+		return false
+	}
+	last := Address + Length - 1
+	if last < Address {
+		last = ^uint64(0)
+	}
+	for _, r := range v.io {
+		if Address <= r.last && last >= r.start {
+			return true
+		}
+	}
+	return false
+}
+
+// AttachROM is used to map read-only data at the base address specified. Loads inside the window read from the data
+// and dumps into it return MemoryWriteProtected. The ROM shadows any memory below it and the data is never written to,
+// so one slice can be shared between many virtual machines. Attaching a ROM replaces the last one and nil detaches it.
+func (v *VM) AttachROM(Base uint64, Data []byte) error {
+	if len(Data) != 0 && Base+uint64(len(Data))-1 < Base {
+		return &MemoryFault{Address: Base, Width: uint64(len(Data)), Size: uint64(len(v.Memory))}
+	}
+	v.romBase = Base
+	v.rom = Data
+	if len(Data) == 0 {
+		v.rom = nil
+	}
+	return nil
+}
+
+// touchesROM is used to check if a range of addresses touches the ROM.
+func (v *VM) touchesROM(Address, Length uint64) bool {
+	last := Address + Length - 1
+	if last < Address {
+		last = ^uint64(0)
+	}
+	return Length != 0 && Address <= v.romBase+uint64(len(v.rom))-1 && last >= v.romBase
+}
+
+// readByte is used to read a byte from the ROM, pages or memory for a access of the width specified at the address
+// specified.
+func (v *VM) readByte(Address, AccessAddress, AccessWidth uint64) (uint8, error) {
+	if Address >= v.romBase && Address-v.romBase < uint64(len(v.rom)) {
+		return v.rom[Address-v.romBase], nil
+	}
+	if Address < AccessAddress || Address >= v.memorySize() {
+		// The access overflowed or is past the end of the memory.
+		return 0, &MemoryFault{Address: AccessAddress, Width: AccessWidth, Size: v.memorySize()}
+	}
+	if v.paged != nil {
+		page, err := v.paged.page(Address)
+		if err != nil {
+			return 0, err
+		}
+		return page[Address%v.paged.pageSize], nil
+	}
+	return v.Memory[Address], nil
+}
+
+// withinMemoryLimit is used to check if the memory can be the size specified. The size can't be past MaxMemory, or
+// DefaultMaxMemory for memory which isn't paged if MaxMemory is 0, or too large for a int.
+func (v *VM) withinMemoryLimit(Size uint64) bool {
+	limit := v.MaxMemory
+	if limit == 0 && v.paged == nil {
+		limit = DefaultMaxMemory
+	}
+	return int(Size) >= 0 && (limit == 0 || Size <= limit)
+}
+
+// Grow is used to add the number of zeroed bytes specified to the end of the memory. The new size is returned. If the
+// new size would be past MaxMemory, or DefaultMaxMemory for memory which isn't paged if MaxMemory is 0, the memory is
+// left as it is and MemoryQuotaExceeded is returned. It is safe to call from a system call and the new memory can be
+// used by the next instruction. MaxMemory should be set when running untrusted bytecode so it can't exhaust the memory
+// of the host.
+func (v *VM) Grow(Bytes uint64) (uint64, error) {
+	size := v.memorySize()
+	newSize := size + Bytes
+	if newSize < size || !v.withinMemoryLimit(newSize) {
+		return size, MemoryQuotaExceeded
+	}
+	if v.paged != nil {
+		v.paged.size = newSize
+	} else {
+		v.Memory = append(v.Memory, make([]byte, Bytes)...)
+	}
+	return newSize, nil
+}
+
+// SbrkSyscall is a system call which grows the memory by the number of bytes in R2 and puts the old size in R1, so the
+// new region starts at R1. If the memory can't be grown because of MaxMemory or DefaultMaxMemory, R1 is set to the
+// maximum uint64 and R4 is set to 1.
+func SbrkSyscall(v *VM) error {
+	oldSize := v.memorySize()
+	if _, err := v.Grow(v.Registers[R2]); err != nil {
+		v.Registers[R1] = ^uint64(0)
+		v.Registers[R4] = 1
+		return nil
+	}
+	v.Registers[R1] = oldSize
+	return nil
+}
+
+// MemoryUsage is used to get the number of bytes of memory the virtual machine is using. This is the length of the
+// memory, or the size of the allocated pages for virtual machines created with NewPagedVM.
+func (v *VM) MemoryUsage() uint64 {
+	if v.paged != nil {
+		return uint64(len(v.paged.pages)) * v.paged.pageSize
+	}
+	return uint64(len(v.Memory))
+}
+
+// MarkInitialized is used to mark a range of memory as initialized when TrackInitialization is set. Dumps mark the
+// bytes they write, so this is only needed when the host writes to the memory directly.
+func (v *VM) MarkInitialized(Offset, Length uint64) {
+	if v.shadow == nil {
+		v.shadow = map[uint64]uint64{}
+	}
+	for i := uint64(0); i < Length; i++ {
+		address := Offset + i
+		v.shadow[address>>6] |= 1 << (address & 63)
+	}
+}
+
+// checkInitialized is used to check every byte of a access outside the ROM was initialized. If one wasn't,
+// UninitializedReadHandler is called or UninitializedRead is returned.
+func (v *VM) checkInitialized(Address, Width uint64) error {
+	for i := uint64(0); i < Width; i++ {
+		address := Address + i
+		if v.rom != nil && address >= v.romBase && address-v.romBase < uint64(len(v.rom)) {
+			continue
+		}
+		if v.shadow[address>>6]&(1<<(address&63)) == 0 {
+			if v.UninitializedReadHandler != nil {
+				return v.UninitializedReadHandler(Address, Width)
+			}
+			return &UninitializedRead{Address: Address, Width: Width}
+		}
+	}
+	return nil
+}
+
+// writeMemory is used by the host write helpers to copy the data into memory at the offset specified. The range is
+// checked with overflow safe math and the write is recorded like a dump, but it never goes to memory mapped io.
+func (v *VM) writeMemory(Offset uint64, Data []byte) error {
+	length := uint64(len(Data))
+	if err := v.checkBounds(Offset, length, true); err != nil {
+		return err
+	}
+	if v.rom != nil && v.touchesROM(Offset, length) {
+		return MemoryWriteProtected
+	}
+	if v.transaction != nil {
+		v.record(Offset, length)
+	}
+	if v.reverse != nil && atomic.LoadUint32(&v.running) != 0 {
+		// Only writes made by system calls belong to a instruction.
+		v.reverse.record(v, Offset, length)
+	}
+	if v.TrackInitialization {
+		v.MarkInitialized(Offset, length)
+	}
+	if v.paged == nil {
+		copy(v.Memory[Offset:], Data)
+	} else {
+		for i, b := range Data {
+			page, err := v.paged.page(Offset + uint64(i))
+			if err != nil {
+				return err
+			}
+			page[(Offset+uint64(i))%v.paged.pageSize] = b
+		}
+	}
+
+	// Tell OnMemoryWrite about the data up to 8 bytes at a time.
+	if v.OnMemoryWrite != nil {
+		for i := 0; i < len(Data); i += 8 {
+			chunk := Data[i:]
+			if len(chunk) > 8 {
+				chunk = chunk[:8]
+			}
+			x := uint64(0)
+			for j, b := range chunk {
+				x |= uint64(b) << (8 * uint(j))
+			}
+			v.OnMemoryWrite(Offset+uint64(i), len(chunk), x)
+		}
+	}
+	return nil
+}
+
+// LoadMemory is used to copy the data into memory at the offset specified. InvalidMemoryLocation is returned if it
+// doesn't fit.
+func (v *VM) LoadMemory(Offset uint64, Data []byte) error {
+	return v.writeMemory(Offset, Data)
+}
+
+// WriteBytes is used to copy the data into memory at the offset specified. InvalidMemoryLocation is returned if the
+// range doesn't fit, including when the offset and length overflow.
+func (v *VM) WriteBytes(Offset uint64, Data []byte) error {
+	return v.writeMemory(Offset, Data)
+}
+
+// ReadBytes is used to get a copy of the range of memory specified, so the caller can't alias the memory.
+// InvalidMemoryLocation is returned if the range doesn't fit, including when the offset and length overflow.
+func (v *VM) ReadBytes(Offset, Length uint64) ([]byte, error) {
+	if v.paged == nil && (v.rom == nil || !v.touchesROM(Offset, Length)) {
+		if err := v.checkBounds(Offset, Length, false); err != nil {
+			return nil, err
+		}
+		return append([]byte{}, v.Memory[Offset:Offset+Length]...), nil
+	}
+
+	// Read a byte at a time from the ROM or pages. The copy is grown as it goes so a huge length faults before it is
+	// allocated.
+	data := []byte{}
+	for i := uint64(0); i < Length; i++ {
+		b, err := v.readByte(Offset+i, Offset, Length)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, b)
+	}
+	return data, nil
+}
@@ -0,0 +1,543 @@
+package gomachine
+
+import (
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"reflect"
+	"testing"
+)
+
+// counterDevice is a memory mapped io device which counts up every time it is read.
+type counterDevice struct {
+	count  uint64
+	writes int
+}
+
+func (d *counterDevice) Read(Address uint64, Width int) (uint64, error) {
+	x := d.count
+	d.count++
+	return x, nil
+}
+
+func (d *counterDevice) Write(Address uint64, Width int, Value uint64) error {
+	if Address != 0x1000 {
+		return errors.New("write to the wrong register")
+	}
+	d.count = Value
+	d.writes++
+	return nil
+}
+
+func TestVM_MapIO(t *testing.T) {
+	vm := NewVM(16, 0)
+	device := &counterDevice{}
+	if err := vm.MapIO(0x1000, 8, device); err != nil {
+		t.Fatal(err)
+	}
+
+	// Set the counter to 5, read it twice and store the second read in memory.
+	err := vm.Execute([]byte{
+		InstructionUint8Load, 0x05,
+		InstructionUint64Dump, 0x00, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionMemoryUint64Load, 0x00, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionMemoryUint8Load32, 0x00, 0x10, 0x00, 0x00,
+		InstructionUint64Dump, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device.writes != 1 || device.count != 7 {
+		t.Fatal("unexpected device state:", device.writes, device.count)
+	}
+	if getUint64(vm.Memory, 8) != 6 || getUint64(vm.Memory, 0) != 0 {
+		t.Fatal("unexpected memory:", vm.Memory)
+	}
+
+	// Bulk memory instructions read through the handler a byte at a time.
+	vm.Registers = [8]uint64{0, 0x1000, 1}
+	if err := vm.Execute([]byte{InstructionMemorySum64}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != getUint64([]byte{7, 8, 9, 10, 11, 12, 13, 14}, 0) {
+		t.Fatal("unexpected sum:", vm.Registers[0])
+	}
+
+	// Accesses straddling the range are rejected.
+	err = vm.Execute([]byte{InstructionMemoryUint64Load, 0xFC, 0x0F, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	if !errors.Is(err, IORangeStraddled) {
+		t.Fatal("expected io range straddled, got:", err)
+	}
+
+	// Handler errors abort the execution.
+	err = vm.Execute([]byte{InstructionUint8Dump, 0x01, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	var vmErr *VMError
+	if !errors.As(err, &vmErr) || vmErr.Err.Error() != "write to the wrong register" {
+		t.Fatal("expected the handler error, got:", err)
+	}
+
+	// Ranges can't overlap.
+	if err := vm.MapIO(0x1007, 2, device); !errors.Is(err, IORangeOverlap) {
+		t.Fatal("expected io range overlap, got:", err)
+	}
+	if err := vm.MapIO(0x1008, 2, device); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.MapIO(0, 0, device); !errors.Is(err, InvalidMemoryLocation) {
+		t.Fatal("expected invalid memory location, got:", err)
+	}
+}
+
+func TestVM_AttachROM(t *testing.T) {
+	rom := []byte{0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17}
+	vms := []*VM{NewVM(8, 0), NewVM(8, 0)}
+	for _, vm := range vms {
+		copy(vm.Memory, []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07})
+		if err := vm.AttachROM(8, rom); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Read across the memory and ROM boundary.
+	vm := vms[0]
+	if err := vm.Execute([]byte{InstructionMemoryUint64Load, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 0x1312111007060504 {
+		t.Fatalf("unexpected value: 0x%x", vm.Registers[0])
+	}
+
+	// Read only from the ROM on the other virtual machine.
+	if err := vms[1].Execute([]byte{InstructionMemoryUint16Load32, 0x0E, 0x00, 0x00, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	if vms[1].Registers[0] != 0x1716 {
+		t.Fatalf("unexpected value: 0x%x", vms[1].Registers[0])
+	}
+
+	// Writes into the ROM fail and leave it untouched.
+	err := vm.Execute([]byte{InstructionUint16Dump, 0x07, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	if !errors.Is(err, MemoryWriteProtected) {
+		t.Fatal("expected memory write protected, got:", err)
+	}
+	if rom[0] != 0x10 || vm.Memory[7] != 0x07 {
+		t.Fatal("memory was written to")
+	}
+
+	// Reads past the end of the ROM are still invalid.
+	err = vm.Execute([]byte{InstructionMemoryUint16Load, 0x0F, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	if !errors.Is(err, InvalidMemoryLocation) {
+		t.Fatal("expected invalid memory location, got:", err)
+	}
+
+	// Bulk memory instructions see the ROM.
+	vm.Registers = [8]uint64{0, 6, 4}
+	if err := vm.Execute([]byte{InstructionMemoryCRC32}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != uint64(crc32.ChecksumIEEE([]byte{0x06, 0x07, 0x10, 0x11})) {
+		t.Fatal("unexpected crc32:", vm.Registers[0])
+	}
+}
+
+func TestVM_Grow(t *testing.T) {
+	vm := NewVM(8, 0)
+	vm.MaxMemory = 64
+	vm.Syscalls[1] = SbrkSyscall
+
+	// Grow by 16 bytes and write to the new region straight away.
+	err := vm.Execute([]byte{
+		InstructionUint8LoadR2Direct, 0x10,
+		InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionMoveR1ToR5,
+		InstructionUint8Load, 0xAB,
+		InstructionUint8Dump, 0x17, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionMoveR5ToR1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 8 || len(vm.Memory) != 24 || vm.Memory[23] != 0xAB {
+		t.Fatal("unexpected state:", vm.Registers[0], len(vm.Memory))
+	}
+
+	// Growing past the maximum fails.
+	vm.Registers[1] = 41
+	if err := vm.Execute([]byte{InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != ^uint64(0) || vm.Registers[3] != 1 || len(vm.Memory) != 24 {
+		t.Fatal("unexpected state:", vm.Registers[0], vm.Registers[3], len(vm.Memory))
+	}
+	if size, err := vm.Grow(40); err != nil || size != 64 {
+		t.Fatal("unexpected grow result:", size, err)
+	}
+	if _, err := vm.Grow(^uint64(0)); !errors.Is(err, MemoryQuotaExceeded) {
+		t.Fatal("expected memory quota exceeded, got:", err)
+	}
+}
+
+func TestVM_MaxMemory(t *testing.T) {
+	vm := NewVM(16, 0, WithMaxMemory(100))
+	if vm.MemoryUsage() != 16 {
+		t.Fatal("not 16:", vm.MemoryUsage())
+	}
+
+	// Growing up to exactly the limit works and one more byte fails.
+	if size, err := vm.Grow(84); err != nil || size != 100 {
+		t.Fatal("unexpected grow result:", size, err)
+	}
+	if size, err := vm.Grow(1); !errors.Is(err, MemoryQuotaExceeded) || size != 100 {
+		t.Fatal("unexpected grow result:", size, err)
+	}
+	if vm.MemoryUsage() != 100 {
+		t.Fatal("not 100:", vm.MemoryUsage())
+	}
+
+	// The same applies to the sbrk system call.
+	vm = NewVM(16, 0, WithMaxMemory(100))
+	vm.Syscalls[1] = SbrkSyscall
+	for _, tt := range []struct {
+		bytes, r1, r4 uint64
+	}{{84, 16, 0}, {1, ^uint64(0), 1}, {0, 100, 0}} {
+		vm.Registers[1] = tt.bytes
+		if err := vm.Execute([]byte{InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}); err != nil {
+			t.Fatal(err)
+		}
+		if vm.Registers[0] != tt.r1 || vm.Registers[3] != tt.r4 {
+			t.Fatal("unexpected registers:", vm.Registers)
+		}
+	}
+
+	// Huge sizes fail without MaxMemory instead of crashing the host.
+	vm = NewVM(16, 0)
+	vm.Syscalls[1] = SbrkSyscall
+	for _, bytes := range []uint64{1 << 62, 1 << 40, DefaultMaxMemory, ^uint64(0)} {
+		vm.Registers[1] = bytes
+		if err := vm.Execute([]byte{InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}); err != nil {
+			t.Fatal(err)
+		}
+		if vm.Registers[0] != ^uint64(0) || vm.Registers[3] != 1 || len(vm.Memory) != 16 {
+			t.Fatal("unexpected registers:", vm.Registers, len(vm.Memory))
+		}
+	}
+
+	// Paged virtual machines report the allocated pages.
+	vm = NewPagedVM(1<<20, 16, 0, 0)
+	vm.Registers[0] = 1
+	if err := vm.Execute([]byte{InstructionUint8Dump32, 0x00, 0x01, 0x00, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.MemoryUsage() != 16 {
+		t.Fatal("not 16:", vm.MemoryUsage())
+	}
+}
+
+func TestVM_WrapAddresses(t *testing.T) {
+	vm := NewVM(16, 0)
+	vm.WrapAddresses = true
+	vm.Memory[15] = 0x34
+	vm.Memory[0] = 0x12
+
+	// Read a uint16 which straddles the last and first byte of memory.
+	if err := vm.Execute([]byte{InstructionMemoryUint16Load, 0x0F, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 0x1234 {
+		t.Fatalf("not 0x1234: 0x%x", vm.Registers[0])
+	}
+
+	// Addresses past the end wrap around.
+	if err := vm.Execute(append([]byte{InstructionMemoryUint8Load}, uint64Operand(16*1000+15)...)); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 0x34 {
+		t.Fatalf("not 0x34: 0x%x", vm.Registers[0])
+	}
+
+	// Writes wrap a byte at a time.
+	vm.Registers[0] = 0x0807060504030201
+	if err := vm.Execute(append([]byte{InstructionUint64Dump}, uint64Operand(^uint64(0))...)); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Memory[15] != 0x01 || vm.Memory[0] != 0x02 || vm.Memory[6] != 0x08 {
+		t.Fatal("unexpected memory:", vm.Memory)
+	}
+
+	// Bulk memory instructions wrap as well.
+	vm.Registers = [8]uint64{0, 15, 2}
+	if err := vm.Execute([]byte{InstructionMemoryCRC32}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != uint64(crc32.ChecksumIEEE([]byte{0x01, 0x02})) {
+		t.Fatal("unexpected crc32:", vm.Registers[0])
+	}
+}
+
+func TestVM_RequireAlignment(t *testing.T) {
+	tests := []struct {
+		load  uint8
+		dump  uint8
+		width uint64
+	}{
+		{InstructionMemoryUint16Load, InstructionUint16Dump, 2},
+		{InstructionMemoryUint32Load, InstructionUint32Dump, 4},
+		{InstructionMemoryUint64Load, InstructionUint64Dump, 8},
+		{InstructionMemoryFloat32Load, InstructionFloat32Dump, 4},
+	}
+	for _, tt := range tests {
+		for _, instruction := range []uint8{tt.load, tt.dump} {
+			for _, requireAlignment := range []bool{false, true} {
+				// Check a aligned access always works.
+				vm := NewVM(32, 0)
+				vm.RequireAlignment = requireAlignment
+				if err := vm.Execute(append([]byte{instruction}, uint64Operand(tt.width*2)...)); err != nil {
+					t.Fatal(err)
+				}
+
+				// Check a misaligned access only works when alignment isn't required.
+				err := vm.Execute(append([]byte{instruction}, uint64Operand(tt.width*2+1)...))
+				if !requireAlignment {
+					if err != nil {
+						t.Fatal(err)
+					}
+					continue
+				}
+				var unaligned *UnalignedAccess
+				if !errors.As(err, &unaligned) {
+					t.Fatalf("instruction 0x%02x: expected unaligned access, got %v", instruction, err)
+				}
+				expected := UnalignedAccess{Address: tt.width*2 + 1, Width: tt.width, Write: instruction == tt.dump}
+				if *unaligned != expected {
+					t.Fatalf("instruction 0x%02x: unexpected error %+v", instruction, *unaligned)
+				}
+			}
+		}
+	}
+
+	// Single bytes are always aligned.
+	vm := NewVM(32, 0)
+	vm.RequireAlignment = true
+	if err := vm.Execute([]byte{InstructionUint8Dump32, 0x03, 0x00, 0x00, 0x00, InstructionMemoryUint8Load32, 0x03, 0x00, 0x00, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVM_TrackInitialization(t *testing.T) {
+	// A read before a write is caught.
+	vm := NewVM(16, 0)
+	vm.TrackInitialization = true
+	err := vm.Execute([]byte{
+		InstructionUint8Load, 0x01,
+		InstructionUint8Dump, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionMemoryUint16Load, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	})
+	var uninitialized *UninitializedRead
+	if !errors.As(err, &uninitialized) || *uninitialized != (UninitializedRead{Address: 4, Width: 2}) {
+		t.Fatal("expected uninitialized read, got:", err)
+	}
+
+	// A correct program works, including with memory the host marked.
+	vm = NewVM(16, 0)
+	vm.TrackInitialization = true
+	vm.MarkInitialized(8, 8)
+	err = vm.Execute([]byte{
+		InstructionUint8Load, 0x01,
+		InstructionUint16Dump, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionMemoryUint16Load, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionMemoryUint64Load, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The handler is called instead of returning a error.
+	var reads []uint64
+	vm.UninitializedReadHandler = func(Address, Width uint64) error {
+		reads = append(reads, Address)
+		return nil
+	}
+	vm.Registers = [8]uint64{0, 0, 8}
+	if err := vm.Execute([]byte{InstructionMemoryUint8Load, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, InstructionMemoryCRC32}); err != nil {
+		t.Fatal(err)
+	}
+	if len(reads) != 2 || reads[0] != 0 || reads[1] != 0 {
+		t.Fatal("unexpected reads:", reads)
+	}
+
+	// Clearing the memory makes it uninitialized again.
+	vm.ClearMemory()
+	vm.UninitializedReadHandler = nil
+	err = vm.Execute([]byte{InstructionMemoryUint8Load, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	if !errors.As(err, &uninitialized) {
+		t.Fatal("expected uninitialized read, got:", err)
+	}
+}
+
+func TestNewVMWithMemory(t *testing.T) {
+	initial := []byte{1, 2, 3}
+	vm := NewVMWithMemory(initial, 2, 0)
+	if !bytes.Equal(vm.Memory, []byte{1, 2, 3, 0, 0}) {
+		t.Fatal("unexpected memory:", vm.Memory)
+	}
+
+	// The initial bytes are copied.
+	vm.Memory[0] = 9
+	if initial[0] != 1 {
+		t.Fatal("initial bytes were aliased")
+	}
+
+	// Adopted memory is shared.
+	vm = NewVMFromMemory(initial, 0)
+	if err := vm.Execute([]byte{InstructionUint8Load, 0x07, InstructionUint8Dump32, 0x02, 0x00, 0x00, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	if initial[2] != 7 {
+		t.Fatal("memory was not adopted")
+	}
+}
+
+func TestVM_LoadMemory(t *testing.T) {
+	vm := NewVM(8, 0)
+	if err := vm.LoadMemory(6, []byte{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(vm.Memory, []byte{0, 0, 0, 0, 0, 0, 1, 2}) {
+		t.Fatal("unexpected memory:", vm.Memory)
+	}
+	for _, offset := range []uint64{7, ^uint64(0)} {
+		if err := vm.LoadMemory(offset, []byte{1, 2}); !errors.Is(err, InvalidMemoryLocation) {
+			t.Fatal("expected invalid memory location, got:", err)
+		}
+	}
+}
+
+func TestVM_ReadBytes_WriteBytes(t *testing.T) {
+	vm := NewVM(8, 0)
+
+	// Empty slices work anywhere inside or at the end of the memory.
+	if err := vm.WriteBytes(8, nil); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := vm.ReadBytes(8, 0); err != nil || b == nil || len(b) != 0 {
+		t.Fatal("unexpected read:", b, err)
+	}
+
+	// Exact fit ranges work.
+	if err := vm.WriteBytes(0, []byte{1, 2, 3, 4, 5, 6, 7, 8}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := vm.ReadBytes(0, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, []byte{1, 2, 3, 4, 5, 6, 7, 8}) {
+		t.Fatal("unexpected read:", b)
+	}
+
+	// The read is a copy.
+	b[0] = 9
+	if vm.Memory[0] != 1 {
+		t.Fatal("read aliases the memory")
+	}
+
+	// Ranges past the end and overflowing offsets fail.
+	for _, tt := range []struct{ offset, length uint64 }{
+		{9, 0}, {4, 5}, {^uint64(0), 2}, {^uint64(0) - 1, 4}, {1, ^uint64(0)},
+	} {
+		if _, err := vm.ReadBytes(tt.offset, tt.length); !errors.Is(err, InvalidMemoryLocation) {
+			t.Fatal("expected invalid memory location, got:", err)
+		}
+		if tt.length < 8 {
+			if err := vm.WriteBytes(tt.offset, make([]byte, tt.length)); !errors.Is(err, InvalidMemoryLocation) {
+				t.Fatal("expected invalid memory location, got:", err)
+			}
+		}
+	}
+
+	// Reads see the ROM and pages.
+	if err := vm.AttachROM(8, []byte{9, 10}); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := vm.ReadBytes(7, 3); err != nil || !bytes.Equal(b, []byte{8, 9, 10}) {
+		t.Fatal("unexpected read:", b, err)
+	}
+	if _, err := vm.ReadBytes(^uint64(0), 2); !errors.Is(err, InvalidMemoryLocation) {
+		t.Fatal("expected invalid memory location, got:", err)
+	}
+	vm = NewPagedVM(1<<32, 16, 0, 0)
+	if err := vm.WriteBytes(1<<31-1, []byte{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := vm.ReadBytes(1<<31-1, 2); err != nil || !bytes.Equal(b, []byte{1, 2}) {
+		t.Fatal("unexpected read:", b, err)
+	}
+}
+
+func TestVM_OnMemoryWrite(t *testing.T) {
+	type write struct {
+		address uint64
+		width   int
+		value   uint64
+	}
+	var writes []write
+	vm := NewVM(32, 0)
+	vm.OnMemoryWrite = func(Address uint64, Width int, Value uint64) {
+		writes = append(writes, write{Address, Width, Value})
+	}
+
+	// Every dump is seen with only the bytes it wrote, and failed dumps aren't seen.
+	err := vm.Execute([]byte{
+		InstructionUint64Load, 0x08, 0x07, 0x06, 0x05, 0x04, 0x03, 0x02, 0x01,
+		InstructionUint8Dump, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint16Dump, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint32Dump, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint64Dump, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint64Dump, 0x1C, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	})
+	if !errors.Is(err, InvalidMemoryLocation) {
+		t.Fatal("expected invalid memory location, got:", err)
+	}
+	expected := []write{
+		{0x00, 1, 0x08},
+		{0x02, 2, 0x0708},
+		{0x04, 4, 0x05060708},
+		{0x08, 8, 0x0102030405060708},
+	}
+	if !reflect.DeepEqual(writes, expected) {
+		t.Fatal("wrong writes:", writes)
+	}
+
+	// The host write helpers are seen up to 8 bytes at a time.
+	writes = nil
+	if err := vm.WriteBytes(0x10, []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.WriteUint16At(0x1E, 0xBEEF); err != nil {
+		t.Fatal(err)
+	}
+	expected = []write{
+		{0x10, 8, 0x0807060504030201},
+		{0x18, 2, 0x0A09},
+		{0x1E, 2, 0xBEEF},
+	}
+	if !reflect.DeepEqual(writes, expected) {
+		t.Fatal("wrong writes:", writes)
+	}
+
+	// Rolling back is seen a byte at a time in reverse order.
+	if err := vm.BeginTransaction(); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.WriteUint16At(0x00, 0xFFFF); err != nil {
+		t.Fatal(err)
+	}
+	writes = nil
+	if err := vm.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	expected = []write{{0x01, 1, 0x00}, {0x00, 1, 0x08}}
+	if !reflect.DeepEqual(writes, expected) {
+		t.Fatal("wrong writes:", writes)
+	}
+}
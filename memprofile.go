@@ -0,0 +1,105 @@
+package gomachine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MemoryBucket is used to represent the number of accesses made to a bucket of memory.
+type MemoryBucket struct {
+	// Address is the address of the first byte in the bucket.
+	Address uint64
+
+	// Reads is the number of loads which started in the bucket.
+	Reads uint64
+
+	// Writes is the number of dumps which started in the bucket.
+	Writes uint64
+}
+
+// memoryProfile is used to hold the counts for MemoryProfile. The slices are indexed by the bucket number and grown
+// as buckets are accessed.
+type memoryProfile struct {
+	bucketSize    uint64
+	reads, writes []uint64
+}
+
+// profileMemory is used to count a access starting at the address specified in the memory profile. Accesses outside
+// of the memory aren't counted.
+func (v *VM) profileMemory(Address uint64, Write bool) {
+	Address, _ = v.wrap(Address, 1)
+	if Address >= v.memorySize() {
+		return
+	}
+	p := v.memoryProfile
+	if p == nil || p.bucketSize != v.MemoryProfileBucketSize {
+		// The counts can't be kept if the bucket size changed.
+		p = &memoryProfile{bucketSize: v.MemoryProfileBucketSize}
+		v.memoryProfile = p
+	}
+	counts := &p.reads
+	if Write {
+		counts = &p.writes
+	}
+	bucket := Address / p.bucketSize
+	if bucket >= uint64(len(*counts)) {
+		grown := make([]uint64, bucket+1)
+		copy(grown, *counts)
+		*counts = grown
+	}
+	(*counts)[bucket]++
+}
+
+// MemoryProfile is used to get the number of loads and dumps made to each bucket of memory while
+// MemoryProfileBucketSize was set, since the virtual machine was created or ResetMemoryProfile was last called. Bulk
+// memory instructions count as one read of the bucket their range starts in. The buckets are sorted by address and
+// buckets which haven't been accessed are left out.
+func (v *VM) MemoryProfile() []MemoryBucket {
+	p := v.memoryProfile
+	if p == nil {
+		return nil
+	}
+	n := len(p.reads)
+	if len(p.writes) > n {
+		n = len(p.writes)
+	}
+	var buckets []MemoryBucket
+	for i := 0; i < n; i++ {
+		b := MemoryBucket{Address: uint64(i) * p.bucketSize}
+		if i < len(p.reads) {
+			b.Reads = p.reads[i]
+		}
+		if i < len(p.writes) {
+			b.Writes = p.writes[i]
+		}
+		if b.Reads != 0 || b.Writes != 0 {
+			buckets = append(buckets, b)
+		}
+	}
+	return buckets
+}
+
+// ResetMemoryProfile is used to set the counts returned by MemoryProfile back to 0.
+func (v *VM) ResetMemoryProfile() {
+	v.memoryProfile = nil
+}
+
+// FormatMemoryProfile is used to render the hottest buckets from MemoryProfile as a table, the most accessed first.
+// Each line has the address the bucket starts at, the reads and the writes. Top is the number of buckets to include,
+// and 0 includes all of them.
+func FormatMemoryProfile(Buckets []MemoryBucket, Top int) string {
+	sorted := append([]MemoryBucket(nil), Buckets...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Reads+sorted[i].Writes > sorted[j].Reads+sorted[j].Writes
+	})
+	if Top != 0 && Top < len(sorted) {
+		sorted = sorted[:Top]
+	}
+
+	var b strings.Builder
+	for _, bucket := range sorted {
+		_, _ = fmt.Fprintf(&b, "0x%08x reads=%d writes=%d\n", bucket.Address, bucket.Reads, bucket.Writes)
+	}
+	return b.String()
+}
@@ -0,0 +1,72 @@
+package gomachine
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVM_MemoryProfile(t *testing.T) {
+	bytecode := []byte{
+		InstructionUint8Dump, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint8Dump, 0x3F, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint64Dump, 0x40, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionMemoryUint8Load, 0x41, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionMemoryUint8Load, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionMemoryUint16Load, 0xBE, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint8LoadR2Direct, 0x80,
+		InstructionUint8LoadR3Direct, 0x10,
+		InstructionMemoryCRC32,
+	}
+
+	// Nothing is counted by default.
+	vm := NewVM(256, 0)
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	if vm.MemoryProfile() != nil {
+		t.Fatal("memory was profiled:", vm.MemoryProfile())
+	}
+
+	// Accesses are counted in the bucket they start in.
+	vm.MemoryProfileBucketSize = 64
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	expected := []MemoryBucket{
+		{Address: 0x00, Writes: 2},
+		{Address: 0x40, Reads: 1, Writes: 1},
+		{Address: 0x80, Reads: 3},
+	}
+	if profile := vm.MemoryProfile(); !reflect.DeepEqual(profile, expected) {
+		t.Fatal("wrong profile:", profile)
+	}
+	if formatted := FormatMemoryProfile(vm.MemoryProfile(), 2); formatted != ""+
+		"0x00000080 reads=3 writes=0\n"+
+		"0x00000000 reads=0 writes=2\n" {
+		t.Fatal("wrong format:\n" + formatted)
+	}
+
+	// The counts add up across executions until the bucket size changes.
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	if profile := vm.MemoryProfile(); profile[2].Reads != 6 {
+		t.Fatal("counts didn't add up:", profile)
+	}
+	vm.MemoryProfileBucketSize = 128
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	expected = []MemoryBucket{
+		{Address: 0x00, Reads: 1, Writes: 3},
+		{Address: 0x80, Reads: 3},
+	}
+	if profile := vm.MemoryProfile(); !reflect.DeepEqual(profile, expected) {
+		t.Fatal("wrong profile:", profile)
+	}
+
+	vm.ResetMemoryProfile()
+	if vm.MemoryProfile() != nil {
+		t.Fatal("profile wasn't reset")
+	}
+}
@@ -0,0 +1,669 @@
+package gomachine
+
+import "fmt"
+
+// ISAVersion is the version of the instruction set supported by this version of the virtual machine. It is bumped
+// whenever instructions are added. Opcode values are never changed or reused, so bytecode built against a older
+// version always runs on a newer one.
+const ISAVersion = uint16(6)
+
+// Defines the CPU instructions. The values are part of the bytecode format and must never change, so new instructions
+// must always be given a new value at the end.
+const (
+	// InstructionUint8Load is used to load a uint8 argument into R1.
+	InstructionUint8Load uint8 = 0x01
+
+	// InstructionUint16Load is used to load a uint16 argument into R1.
+	InstructionUint16Load uint8 = 0x02
+
+	// InstructionUint32Load is used to load a uint32 argument into R1.
+	InstructionUint32Load uint8 = 0x03
+
+	// InstructionUint64Load is used to load a uint64 argument into R1.
+	InstructionUint64Load uint8 = 0x04
+
+	// InstructionUint8Load is used to load a uint8 argument into R1 from the memory location specified.
+	InstructionMemoryUint8Load uint8 = 0x05
+
+	// InstructionUint16Load is used to load a uint16 argument into R1 from the memory location specified.
+	InstructionMemoryUint16Load uint8 = 0x06
+
+	// InstructionUint32Load is used to load a uint32 argument into R1 from the memory location specified.
+	InstructionMemoryUint32Load uint8 = 0x07
+
+	// InstructionUint64Load is used to load a uint64 argument into R1 from the memory location specified.
+	InstructionMemoryUint64Load uint8 = 0x08
+
+	// InstructionMoveR1ToR2 is used to move R1 into R2.
+	InstructionMoveR1ToR2 uint8 = 0x09
+
+	// InstructionMoveR1ToR3 is used to move R1 into R3.
+	InstructionMoveR1ToR3 uint8 = 0x0A
+
+	// InstructionMoveR2ToR1 is used to move R2 into R1.
+	InstructionMoveR2ToR1 uint8 = 0x0B
+
+	// InstructionMoveR2ToR3 is used to move R2 into R3.
+	InstructionMoveR2ToR3 uint8 = 0x0C
+
+	// InstructionFlipR1R2 is used to flip the values of R1 and R2.
+	InstructionFlipR1R2 uint8 = 0x0D
+
+	// InstructionMoveR3ToR1 is used to move R3 into R1.
+	InstructionMoveR3ToR1 uint8 = 0x0E
+
+	// InstructionMoveR3ToR2 is used to move R3 into R2.
+	InstructionMoveR3ToR2 uint8 = 0x0F
+
+	// InstructionFlipR1R3 is used to flip the values of R1 and R3.
+	InstructionFlipR1R3 uint8 = 0x10
+
+	// InstructionMoveR4ToR1 is used to move R4 into R1.
+	InstructionMoveR4ToR1 uint8 = 0x11
+
+	// InstructionMoveR4ToR2 is used to move R4 into R2.
+	InstructionMoveR4ToR2 uint8 = 0x12
+
+	// InstructionMoveR4ToR3 is used to move R4 into R3.
+	InstructionMoveR4ToR3 uint8 = 0x13
+
+	// InstructionUint8Dump is used to dump a uint8 argument from R1 into the memory location specified.
+	InstructionUint8Dump uint8 = 0x14
+
+	// InstructionUint16Dump is used to dump a uint16 argument from R1 into the memory location specified.
+	InstructionUint16Dump uint8 = 0x15
+
+	// InstructionUint32Dump is used to dump a uint32 argument from R1 into the memory location specified.
+	InstructionUint32Dump uint8 = 0x16
+
+	// InstructionUint64Dump is used to dump a uint64 argument from R1 into the memory location specified.
+	InstructionUint64Dump uint8 = 0x17
+
+	// InstructionUnsignedAdd is used to add R2 to R1 and treat them as unsigned integers. The result is stored in R1.
+	InstructionUnsignedAdd uint8 = 0x18
+
+	// InstructionSignedAdd is used to add R2 to R1 and treat them as signed integers. The result is stored in R1.
+	InstructionSignedAdd uint8 = 0x19
+
+	// InstructionUnsignedSub is used to subtract R2 from R1 and treat them as unsigned integers. The result is stored in R1.
+	InstructionUnsignedSub uint8 = 0x1A
+
+	// InstructionSignedSub is used to subtract R2 from R1 and treat them as signed integers. The result is stored in R1.
+	InstructionSignedSub uint8 = 0x1B
+
+	// InstructionUnsignedDiv is used to divide R1 against R2 and treat them as unsigned integers. The result is stored in R1, and if you try and divide by 0 it returns 1 in R4.
+	InstructionUnsignedDiv uint8 = 0x1C
+
+	// InstructionSignedDiv is used to divide R1 against R2 and treat them as signed integers. The result is stored in R1, and if you try and divide by 0 it returns 1 in R4.
+	InstructionSignedDiv uint8 = 0x1D
+
+	// InstructionUnsignedMul is used to multiply R1 with R2 and treat them as unsigned integers. The result is stored in R1.
+	InstructionUnsignedMul uint8 = 0x1E
+
+	// InstructionSignedMul is used to multiply R1 with R2 and treat them as signed integers. The result is stored in R1.
+	InstructionSignedMul uint8 = 0x1F
+
+	// InstructionUnsignedMod is used to mod R1 against R2 and treat them as unsigned integers. The result is stored in R1, and if you try and divide by 0 it returns 1 in R4.
+	InstructionUnsignedMod uint8 = 0x20
+
+	// InstructionSignedMod is used to mod R1 against R2 and treat them as signed integers. The result is stored in R1, and if you try and divide by 0 it returns 1 in R4.
+	InstructionSignedMod uint8 = 0x21
+
+	// InstructionBitwiseAnd is used to perform bitwise and on R1 with R2. The result is stored in R1.
+	InstructionBitwiseAnd uint8 = 0x22
+
+	// InstructionBitwiseOr is used to perform bitwise or on R1 with R2. The result is stored in R1.
+	InstructionBitwiseOr uint8 = 0x23
+
+	// InstructionBitwiseXor is used to perform bitwise xor on R1 with R2. The result is stored in R1.
+	InstructionBitwiseXor uint8 = 0x24
+
+	// InstructionBitwiseLeftShift is used to shift R1 left the number of bits specified in R2. The result is stored in R1.
+	InstructionBitwiseLeftShift uint8 = 0x25
+
+	// InstructionBitwiseRightShift is used to shift R1 right the number of bits specified in R2. The result is stored in R1.
+	InstructionBitwiseRightShift uint8 = 0x26
+
+	// InstructionJmp is used to jump to another place in the bytecode.
+	InstructionJmp uint8 = 0x27
+
+	// InstructionJmpIfZero is used to jump if R3 is not equal to R1.
+	InstructionJmpIfZero uint8 = 0x28
+
+	// InstructionJmpIfEq is used to jump if R3 is equal to R1.
+	InstructionJmpIfEq uint8 = 0x29
+
+	// InstructionJmpIfNe is used to jump if R3 is not equal to R1.
+	InstructionJmpIfNe uint8 = 0x2A
+
+	// InstructionJmpIfGt is used to jump if R1 is greater than R3.
+	InstructionJmpIfGt uint8 = 0x2B
+
+	// InstructionJmpIfLt is used to jump if R1 is less than R3.
+	InstructionJmpIfLt uint8 = 0x2C
+
+	// InstructionJmpIfGtOrEqual is used to jump if R1 is greater than or equal to R3.
+	InstructionJmpIfGtOrEqual uint8 = 0x2D
+
+	// InstructionJmpIfLtOrEqual is used to jump if R1 is less than or equal to R3.
+	InstructionJmpIfLtOrEqual uint8 = 0x2E
+
+	// InstructionSyscall is used to make the system call specified. System calls are expected to throw errors in R3.
+	// If the system call returns a error, its code is put in R3 and R4 is set to 1. Errors wrapped in FatalSyscallError stop
+	// execution instead.
+	InstructionSyscall uint8 = 0x2F
+
+	// InstructionJmpIfR4Set is used to jump if R4 is not zero. R4 is not modified.
+	InstructionJmpIfR4Set uint8 = 0x30
+
+	// InstructionJmpIfR4Clear is used to jump if R4 is zero. R4 is not modified.
+	InstructionJmpIfR4Clear uint8 = 0x31
+
+	// InstructionJmpIfGtSigned is used to jump if R1 is greater than R3 and treat them as signed integers.
+	InstructionJmpIfGtSigned uint8 = 0x32
+
+	// InstructionJmpIfLtSigned is used to jump if R1 is less than R3 and treat them as signed integers.
+	InstructionJmpIfLtSigned uint8 = 0x33
+
+	// InstructionJmpIfGtOrEqualSigned is used to jump if R1 is greater than or equal to R3 and treat them as signed integers.
+	InstructionJmpIfGtOrEqualSigned uint8 = 0x34
+
+	// InstructionJmpIfLtOrEqualSigned is used to jump if R1 is less than or equal to R3 and treat them as signed integers.
+	InstructionJmpIfLtOrEqualSigned uint8 = 0x35
+
+	// InstructionMoveR2ToR1IfEq is used to move R2 into R1 if R3 is equal to R1.
+	InstructionMoveR2ToR1IfEq uint8 = 0x36
+
+	// InstructionMoveR2ToR1IfNe is used to move R2 into R1 if R3 is not equal to R1.
+	InstructionMoveR2ToR1IfNe uint8 = 0x37
+
+	// InstructionSetIfEq is used to set R1 to 1 if R3 is equal to R1 and 0 otherwise.
+	InstructionSetIfEq uint8 = 0x38
+
+	// InstructionSetIfLt is used to set R1 to 1 if R1 is less than R3 and 0 otherwise.
+	InstructionSetIfLt uint8 = 0x39
+
+	// InstructionSetIfGt is used to set R1 to 1 if R1 is greater than R3 and 0 otherwise.
+	InstructionSetIfGt uint8 = 0x3A
+
+	// InstructionSetIfLtSigned is used to set R1 to 1 if R1 is less than R3 and 0 otherwise. R1 and R3 are treated as signed integers.
+	InstructionSetIfLtSigned uint8 = 0x3B
+
+	// InstructionSetIfGtSigned is used to set R1 to 1 if R1 is greater than R3 and 0 otherwise. R1 and R3 are treated as signed integers.
+	InstructionSetIfGtSigned uint8 = 0x3C
+
+	// InstructionFloat64Load is used to load a IEEE-754 float64 argument into R1.
+	InstructionFloat64Load uint8 = 0x3D
+
+	// InstructionFloat64Add is used to add R2 to R1 and treat them as float64 bit patterns. The result is stored in R1.
+	InstructionFloat64Add uint8 = 0x3E
+
+	// InstructionFloat64Sub is used to subtract R2 from R1 and treat them as float64 bit patterns. The result is stored in R1.
+	InstructionFloat64Sub uint8 = 0x3F
+
+	// InstructionFloat64Mul is used to multiply R1 with R2 and treat them as float64 bit patterns. The result is stored in R1.
+	InstructionFloat64Mul uint8 = 0x40
+
+	// InstructionFloat64Div is used to divide R1 against R2 and treat them as float64 bit patterns. The result is stored in R1. Dividing by 0 follows IEEE-754 and returns Inf or NaN rather than setting R4.
+	InstructionFloat64Div uint8 = 0x41
+
+	// InstructionMemoryFloat32Load is used to load a float32 from the memory location specified into R1 as a float64 bit pattern.
+	InstructionMemoryFloat32Load uint8 = 0x42
+
+	// InstructionFloat32Dump is used to dump the float64 bit pattern in R1 into the memory location specified as a float32.
+	InstructionFloat32Dump uint8 = 0x43
+
+	// InstructionFloat64ToFloat32Bits is used to narrow the float64 bit pattern in R1 into a float32 bit pattern. The result is stored in R1.
+	InstructionFloat64ToFloat32Bits uint8 = 0x44
+
+	// InstructionJmpIfFloatGt is used to jump if R1 is greater than R3 and treat them as float64 bit patterns.
+	InstructionJmpIfFloatGt uint8 = 0x45
+
+	// InstructionJmpIfFloatLt is used to jump if R1 is less than R3 and treat them as float64 bit patterns.
+	InstructionJmpIfFloatLt uint8 = 0x46
+
+	// InstructionJmpIfFloatEq is used to jump if R1 is equal to R3 and treat them as float64 bit patterns.
+	InstructionJmpIfFloatEq uint8 = 0x47
+
+	// InstructionJmpIfFloatUnordered is used to jump if either R1 or R3 is NaN when treated as float64 bit patterns.
+	InstructionJmpIfFloatUnordered uint8 = 0x48
+
+	// InstructionFeatures is used to load the bitmask of supported instruction groups into R1. See the Feature constants.
+	InstructionFeatures uint8 = 0x49
+
+	// InstructionMemorySize is used to load the length of the virtual machines memory into R1.
+	InstructionMemorySize uint8 = 0x4A
+
+	// InstructionLoadPC is used to load the bytecode index of this instruction into R1.
+	InstructionLoadPC uint8 = 0x4B
+
+	// InstructionReadCycleCounter is used to load the number of instructions executed so far in this execution (including this one) into R1.
+	InstructionReadCycleCounter uint8 = 0x4C
+
+	// InstructionRand is used to load the next pseudo-random number from the virtual machines generator into R1.
+	InstructionRand uint8 = 0x4D
+
+	// InstructionMemorySum64 is used to add the R3 uint64 values starting at the memory location in R2 to R1. The result is stored in R1.
+	InstructionMemorySum64 uint8 = 0x4E
+
+	// InstructionMemoryCRC32 is used to compute the IEEE CRC32 of the R3 bytes starting at the memory location in R2. The result is stored in R1.
+	InstructionMemoryCRC32 uint8 = 0x4F
+
+	// InstructionJmp32 is used to jump to another place in the bytecode specified by a uint32 argument.
+	InstructionJmp32 uint8 = 0x50
+
+	// InstructionJmpIfZero32 is used to jump if R1 is zero to the place in the bytecode specified by a uint32 argument.
+	InstructionJmpIfZero32 uint8 = 0x51
+
+	// InstructionJmpIfEq32 is used to jump if R3 is equal to R1 to the place in the bytecode specified by a uint32 argument.
+	InstructionJmpIfEq32 uint8 = 0x52
+
+	// InstructionJmpIfNe32 is used to jump if R3 is not equal to R1 to the place in the bytecode specified by a uint32 argument.
+	InstructionJmpIfNe32 uint8 = 0x53
+
+	// InstructionJmpIfGt32 is used to jump if R1 is greater than R3 to the place in the bytecode specified by a uint32 argument.
+	InstructionJmpIfGt32 uint8 = 0x54
+
+	// InstructionJmpIfLt32 is used to jump if R1 is less than R3 to the place in the bytecode specified by a uint32 argument.
+	InstructionJmpIfLt32 uint8 = 0x55
+
+	// InstructionJmpIfGtOrEqual32 is used to jump if R1 is greater than or equal to R3 to the place in the bytecode specified by a uint32 argument.
+	InstructionJmpIfGtOrEqual32 uint8 = 0x56
+
+	// InstructionJmpIfLtOrEqual32 is used to jump if R1 is less than or equal to R3 to the place in the bytecode specified by a uint32 argument.
+	InstructionJmpIfLtOrEqual32 uint8 = 0x57
+
+	// InstructionMemoryUint8Load32 is used to load a uint8 argument into R1 from the memory location specified by a uint32 argument.
+	InstructionMemoryUint8Load32 uint8 = 0x58
+
+	// InstructionMemoryUint16Load32 is used to load a uint16 argument into R1 from the memory location specified by a uint32 argument.
+	InstructionMemoryUint16Load32 uint8 = 0x59
+
+	// InstructionMemoryUint32Load32 is used to load a uint32 argument into R1 from the memory location specified by a uint32 argument.
+	InstructionMemoryUint32Load32 uint8 = 0x5A
+
+	// InstructionMemoryUint64Load32 is used to load a uint64 argument into R1 from the memory location specified by a uint32 argument.
+	InstructionMemoryUint64Load32 uint8 = 0x5B
+
+	// InstructionUint8Dump32 is used to dump a uint8 argument from R1 into the memory location specified by a uint32 argument.
+	InstructionUint8Dump32 uint8 = 0x5C
+
+	// InstructionUint16Dump32 is used to dump a uint16 argument from R1 into the memory location specified by a uint32 argument.
+	InstructionUint16Dump32 uint8 = 0x5D
+
+	// InstructionUint32Dump32 is used to dump a uint32 argument from R1 into the memory location specified by a uint32 argument.
+	InstructionUint32Dump32 uint8 = 0x5E
+
+	// InstructionUint64Dump32 is used to dump a uint64 argument from R1 into the memory location specified by a uint32 argument.
+	InstructionUint64Dump32 uint8 = 0x5F
+
+	// InstructionVarLoad is used to load a unsigned LEB128 argument into R1.
+	InstructionVarLoad uint8 = 0x60
+
+	// InstructionVarJmp is used to jump to another place in the bytecode specified by a unsigned LEB128 argument.
+	InstructionVarJmp uint8 = 0x61
+
+	// InstructionMemoryVarLoad64 is used to load a uint64 argument into R1 from the memory location specified by a unsigned LEB128 argument.
+	InstructionMemoryVarLoad64 uint8 = 0x62
+
+	// InstructionUint8LoadR2Direct is used to load a uint8 argument directly into R2. This replaces a InstructionUint8Load and InstructionMoveR1ToR2 pair but leaves R1 untouched.
+	InstructionUint8LoadR2Direct uint8 = 0x63
+
+	// InstructionUint8LoadR3Direct is used to load a uint8 argument directly into R3. This replaces a InstructionUint8Load and InstructionMoveR1ToR3 pair but leaves R1 untouched.
+	InstructionUint8LoadR3Direct uint8 = 0x64
+
+	// InstructionUint64LoadR2Direct is used to load a uint64 argument directly into R2. This replaces a InstructionUint64Load and InstructionMoveR1ToR2 pair but leaves R1 untouched.
+	InstructionUint64LoadR2Direct uint8 = 0x65
+
+	// InstructionUint64LoadR3Direct is used to load a uint64 argument directly into R3. This replaces a InstructionUint64Load and InstructionMoveR1ToR3 pair but leaves R1 untouched.
+	InstructionUint64LoadR3Direct uint8 = 0x66
+
+	// InstructionRequireISAVersion is used to make sure the virtual machine supports the uint16 ISA version specified.
+	// Programs can start with this as a header so they fail fast with UnsupportedISAVersion on older virtual machines.
+	InstructionRequireISAVersion uint8 = 0x67
+
+	// InstructionMoveR1ToR5 is used to move R1 into R5.
+	InstructionMoveR1ToR5 uint8 = 0x68
+
+	// InstructionMoveR1ToR6 is used to move R1 into R6.
+	InstructionMoveR1ToR6 uint8 = 0x69
+
+	// InstructionMoveR1ToR7 is used to move R1 into R7.
+	InstructionMoveR1ToR7 uint8 = 0x6A
+
+	// InstructionMoveR1ToR8 is used to move R1 into R8.
+	InstructionMoveR1ToR8 uint8 = 0x6B
+
+	// InstructionMoveR2ToR5 is used to move R2 into R5.
+	InstructionMoveR2ToR5 uint8 = 0x6C
+
+	// InstructionMoveR2ToR6 is used to move R2 into R6.
+	InstructionMoveR2ToR6 uint8 = 0x6D
+
+	// InstructionMoveR2ToR7 is used to move R2 into R7.
+	InstructionMoveR2ToR7 uint8 = 0x6E
+
+	// InstructionMoveR2ToR8 is used to move R2 into R8.
+	InstructionMoveR2ToR8 uint8 = 0x6F
+
+	// InstructionMoveR3ToR5 is used to move R3 into R5.
+	InstructionMoveR3ToR5 uint8 = 0x70
+
+	// InstructionMoveR3ToR6 is used to move R3 into R6.
+	InstructionMoveR3ToR6 uint8 = 0x71
+
+	// InstructionMoveR3ToR7 is used to move R3 into R7.
+	InstructionMoveR3ToR7 uint8 = 0x72
+
+	// InstructionMoveR3ToR8 is used to move R3 into R8.
+	InstructionMoveR3ToR8 uint8 = 0x73
+
+	// InstructionMoveR5ToR1 is used to move R5 into R1.
+	InstructionMoveR5ToR1 uint8 = 0x74
+
+	// InstructionMoveR5ToR2 is used to move R5 into R2.
+	InstructionMoveR5ToR2 uint8 = 0x75
+
+	// InstructionMoveR5ToR3 is used to move R5 into R3.
+	InstructionMoveR5ToR3 uint8 = 0x76
+
+	// InstructionMoveR6ToR1 is used to move R6 into R1.
+	InstructionMoveR6ToR1 uint8 = 0x77
+
+	// InstructionMoveR6ToR2 is used to move R6 into R2.
+	InstructionMoveR6ToR2 uint8 = 0x78
+
+	// InstructionMoveR6ToR3 is used to move R6 into R3.
+	InstructionMoveR6ToR3 uint8 = 0x79
+
+	// InstructionMoveR7ToR1 is used to move R7 into R1.
+	InstructionMoveR7ToR1 uint8 = 0x7A
+
+	// InstructionMoveR7ToR2 is used to move R7 into R2.
+	InstructionMoveR7ToR2 uint8 = 0x7B
+
+	// InstructionMoveR7ToR3 is used to move R7 into R3.
+	InstructionMoveR7ToR3 uint8 = 0x7C
+
+	// InstructionMoveR8ToR1 is used to move R8 into R1.
+	InstructionMoveR8ToR1 uint8 = 0x7D
+
+	// InstructionMoveR8ToR2 is used to move R8 into R2.
+	InstructionMoveR8ToR2 uint8 = 0x7E
+
+	// InstructionMoveR8ToR3 is used to move R8 into R3.
+	InstructionMoveR8ToR3 uint8 = 0x7F
+
+	// InstructionUint64LoadR5Direct is used to load a uint64 argument directly into R5.
+	InstructionUint64LoadR5Direct uint8 = 0x80
+
+	// InstructionUint64LoadR6Direct is used to load a uint64 argument directly into R6.
+	InstructionUint64LoadR6Direct uint8 = 0x81
+
+	// InstructionUint64LoadR7Direct is used to load a uint64 argument directly into R7.
+	InstructionUint64LoadR7Direct uint8 = 0x82
+
+	// InstructionUint64LoadR8Direct is used to load a uint64 argument directly into R8.
+	InstructionUint64LoadR8Direct uint8 = 0x83
+
+	// InstructionMove2 is used to move the source register into the destination register. See RegisterOperand.
+	InstructionMove2 uint8 = 0x84
+
+	// InstructionAdd2 is used to add the source register to the destination register and treat them as unsigned integers. The result is stored in the destination register. See RegisterOperand.
+	InstructionAdd2 uint8 = 0x85
+
+	// InstructionSub2 is used to subtract the source register from the destination register and treat them as unsigned integers. The result is stored in the destination register. See RegisterOperand.
+	InstructionSub2 uint8 = 0x86
+
+	// InstructionMul2 is used to multiply the destination register with the source register and treat them as unsigned integers. The result is stored in the destination register. See RegisterOperand.
+	InstructionMul2 uint8 = 0x87
+
+	// InstructionAnd2 is used to perform bitwise and on the destination register with the source register. The result is stored in the destination register. See RegisterOperand.
+	InstructionAnd2 uint8 = 0x88
+
+	// InstructionOr2 is used to perform bitwise or on the destination register with the source register. The result is stored in the destination register. See RegisterOperand.
+	InstructionOr2 uint8 = 0x89
+
+	// InstructionXor2 is used to perform bitwise xor on the destination register with the source register. The result is stored in the destination register. See RegisterOperand.
+	InstructionXor2 uint8 = 0x8A
+
+	// InstructionLeftShift2 is used to shift the destination register left the number of bits specified in the source register. The result is stored in the destination register. See RegisterOperand.
+	InstructionLeftShift2 uint8 = 0x8B
+
+	// InstructionRightShift2 is used to shift the destination register right the number of bits specified in the source register. The result is stored in the destination register. See RegisterOperand.
+	InstructionRightShift2 uint8 = 0x8C
+
+	// InstructionYield is used to give control back to the host. Execution returns Yielded with the PC pointing at the
+	// next instruction, so Continue carries on after the yield. R1 is left as it is so it can hold a reason code or value
+	// for the host.
+	InstructionYield uint8 = 0x8D
+
+	// InstructionInterruptReturn is used to return from a interrupt handler to the instruction which was about to run when
+	// the interrupt was taken. It returns NotInInterrupt outside of a handler. See RaiseInterrupt.
+	InstructionInterruptReturn uint8 = 0x8E
+
+	// InstructionSyscallR1 is used to make the system call in R1, so the system call can be worked out at runtime. It
+	// works like InstructionSyscall otherwise.
+	InstructionSyscallR1 uint8 = 0x8F
+)
+
+// RegisterOperand is used to build the operand byte taken by the register operand instructions such as
+// InstructionMove2. The destination register index (0 for R1 up to 7 for R8) is the high nibble and the source register
+// index is the low nibble. Indexes past the last register make the instruction return InvalidRegister.
+func RegisterOperand(Destination, Source uint8) uint8 {
+	return Destination<<4 | Source&0x0F
+}
+
+// Defines the instruction groups reported by InstructionFeatures.
+const (
+	// FeatureBase is set when the base instruction set is supported.
+	FeatureBase = uint64(1 << iota)
+
+	// FeatureSignedCompare is set when the signed jump and set on compare instructions are supported.
+	FeatureSignedCompare
+
+	// FeatureConditionalMove is set when the conditional move instructions are supported.
+	FeatureConditionalMove
+
+	// FeatureFloat64 is set when the float64 arithmetic and jump instructions are supported.
+	FeatureFloat64
+
+	// FeatureFloat32 is set when the float32 load, dump and narrowing instructions are supported.
+	FeatureFloat32
+
+	// FeatureIntrospection is set when the instructions which query the state of the virtual machine are supported.
+	FeatureIntrospection
+
+	// FeatureRandom is set when the pseudo-random number instruction is supported.
+	FeatureRandom
+
+	// FeatureBulkMemory is set when the instructions which operate over ranges of memory are supported.
+	FeatureBulkMemory
+
+	// FeatureCompactOperands is set when the instructions which take uint32 jump targets and memory locations are
+	// supported.
+	FeatureCompactOperands
+
+	// FeatureVariableOperands is set when the instructions which take unsigned LEB128 arguments are supported.
+	FeatureVariableOperands
+
+	// FeatureDirectLoads is set when the instructions which load arguments directly into R2 and R3 are supported.
+	FeatureDirectLoads
+
+	// FeatureExtendedRegisters is set when R5 to R8 and the instructions which move and load into them are supported.
+	FeatureExtendedRegisters
+
+	// FeatureRegisterOperands is set when the instructions which take a register operand byte are supported.
+	FeatureRegisterOperands
+
+	// FeatureYield is set when InstructionYield is supported.
+	FeatureYield
+
+	// FeatureInterrupts is set when InstructionInterruptReturn is supported.
+	FeatureInterrupts
+
+	// FeatureSyscallR1 is set when InstructionSyscallR1 is supported.
+	FeatureSyscallR1
+)
+
+// SupportedFeatures is the bitmask of instruction groups supported by this version of the virtual machine.
+const SupportedFeatures = FeatureBase | FeatureSignedCompare | FeatureConditionalMove | FeatureFloat64 | FeatureFloat32 |
+	FeatureIntrospection | FeatureRandom | FeatureBulkMemory | FeatureCompactOperands |
+	FeatureVariableOperands | FeatureDirectLoads | FeatureExtendedRegisters |
+	FeatureRegisterOperands | FeatureYield | FeatureInterrupts | FeatureSyscallR1
+
+// opcodeNames is used to define the name of each instruction without the Instruction prefix.
+var opcodeNames = map[uint8]string{
+	InstructionUint8Load:            "Uint8Load",
+	InstructionUint16Load:           "Uint16Load",
+	InstructionUint32Load:           "Uint32Load",
+	InstructionUint64Load:           "Uint64Load",
+	InstructionMemoryUint8Load:      "MemoryUint8Load",
+	InstructionMemoryUint16Load:     "MemoryUint16Load",
+	InstructionMemoryUint32Load:     "MemoryUint32Load",
+	InstructionMemoryUint64Load:     "MemoryUint64Load",
+	InstructionMoveR1ToR2:           "MoveR1ToR2",
+	InstructionMoveR1ToR3:           "MoveR1ToR3",
+	InstructionMoveR2ToR1:           "MoveR2ToR1",
+	InstructionMoveR2ToR3:           "MoveR2ToR3",
+	InstructionFlipR1R2:             "FlipR1R2",
+	InstructionMoveR3ToR1:           "MoveR3ToR1",
+	InstructionMoveR3ToR2:           "MoveR3ToR2",
+	InstructionFlipR1R3:             "FlipR1R3",
+	InstructionMoveR4ToR1:           "MoveR4ToR1",
+	InstructionMoveR4ToR2:           "MoveR4ToR2",
+	InstructionMoveR4ToR3:           "MoveR4ToR3",
+	InstructionUint8Dump:            "Uint8Dump",
+	InstructionUint16Dump:           "Uint16Dump",
+	InstructionUint32Dump:           "Uint32Dump",
+	InstructionUint64Dump:           "Uint64Dump",
+	InstructionUnsignedAdd:          "UnsignedAdd",
+	InstructionSignedAdd:            "SignedAdd",
+	InstructionUnsignedSub:          "UnsignedSub",
+	InstructionSignedSub:            "SignedSub",
+	InstructionUnsignedDiv:          "UnsignedDiv",
+	InstructionSignedDiv:            "SignedDiv",
+	InstructionUnsignedMul:          "UnsignedMul",
+	InstructionSignedMul:            "SignedMul",
+	InstructionUnsignedMod:          "UnsignedMod",
+	InstructionSignedMod:            "SignedMod",
+	InstructionBitwiseAnd:           "BitwiseAnd",
+	InstructionBitwiseOr:            "BitwiseOr",
+	InstructionBitwiseXor:           "BitwiseXor",
+	InstructionBitwiseLeftShift:     "BitwiseLeftShift",
+	InstructionBitwiseRightShift:    "BitwiseRightShift",
+	InstructionJmp:                  "Jmp",
+	InstructionJmpIfZero:            "JmpIfZero",
+	InstructionJmpIfEq:              "JmpIfEq",
+	InstructionJmpIfNe:              "JmpIfNe",
+	InstructionJmpIfGt:              "JmpIfGt",
+	InstructionJmpIfLt:              "JmpIfLt",
+	InstructionJmpIfGtOrEqual:       "JmpIfGtOrEqual",
+	InstructionJmpIfLtOrEqual:       "JmpIfLtOrEqual",
+	InstructionSyscall:              "Syscall",
+	InstructionJmpIfR4Set:           "JmpIfR4Set",
+	InstructionJmpIfR4Clear:         "JmpIfR4Clear",
+	InstructionJmpIfGtSigned:        "JmpIfGtSigned",
+	InstructionJmpIfLtSigned:        "JmpIfLtSigned",
+	InstructionJmpIfGtOrEqualSigned: "JmpIfGtOrEqualSigned",
+	InstructionJmpIfLtOrEqualSigned: "JmpIfLtOrEqualSigned",
+	InstructionMoveR2ToR1IfEq:       "MoveR2ToR1IfEq",
+	InstructionMoveR2ToR1IfNe:       "MoveR2ToR1IfNe",
+	InstructionSetIfEq:              "SetIfEq",
+	InstructionSetIfLt:              "SetIfLt",
+	InstructionSetIfGt:              "SetIfGt",
+	InstructionSetIfLtSigned:        "SetIfLtSigned",
+	InstructionSetIfGtSigned:        "SetIfGtSigned",
+	InstructionFloat64Load:          "Float64Load",
+	InstructionFloat64Add:           "Float64Add",
+	InstructionFloat64Sub:           "Float64Sub",
+	InstructionFloat64Mul:           "Float64Mul",
+	InstructionFloat64Div:           "Float64Div",
+	InstructionMemoryFloat32Load:    "MemoryFloat32Load",
+	InstructionFloat32Dump:          "Float32Dump",
+	InstructionFloat64ToFloat32Bits: "Float64ToFloat32Bits",
+	InstructionJmpIfFloatGt:         "JmpIfFloatGt",
+	InstructionJmpIfFloatLt:         "JmpIfFloatLt",
+	InstructionJmpIfFloatEq:         "JmpIfFloatEq",
+	InstructionJmpIfFloatUnordered:  "JmpIfFloatUnordered",
+	InstructionFeatures:             "Features",
+	InstructionMemorySize:           "MemorySize",
+	InstructionLoadPC:               "LoadPC",
+	InstructionReadCycleCounter:     "ReadCycleCounter",
+	InstructionRand:                 "Rand",
+	InstructionMemorySum64:          "MemorySum64",
+	InstructionMemoryCRC32:          "MemoryCRC32",
+	InstructionJmp32:                "Jmp32",
+	InstructionJmpIfZero32:          "JmpIfZero32",
+	InstructionJmpIfEq32:            "JmpIfEq32",
+	InstructionJmpIfNe32:            "JmpIfNe32",
+	InstructionJmpIfGt32:            "JmpIfGt32",
+	InstructionJmpIfLt32:            "JmpIfLt32",
+	InstructionJmpIfGtOrEqual32:     "JmpIfGtOrEqual32",
+	InstructionJmpIfLtOrEqual32:     "JmpIfLtOrEqual32",
+	InstructionMemoryUint8Load32:    "MemoryUint8Load32",
+	InstructionMemoryUint16Load32:   "MemoryUint16Load32",
+	InstructionMemoryUint32Load32:   "MemoryUint32Load32",
+	InstructionMemoryUint64Load32:   "MemoryUint64Load32",
+	InstructionUint8Dump32:          "Uint8Dump32",
+	InstructionUint16Dump32:         "Uint16Dump32",
+	InstructionUint32Dump32:         "Uint32Dump32",
+	InstructionUint64Dump32:         "Uint64Dump32",
+	InstructionVarLoad:              "VarLoad",
+	InstructionVarJmp:               "VarJmp",
+	InstructionMemoryVarLoad64:      "MemoryVarLoad64",
+	InstructionUint8LoadR2Direct:    "Uint8LoadR2Direct",
+	InstructionUint8LoadR3Direct:    "Uint8LoadR3Direct",
+	InstructionUint64LoadR2Direct:   "Uint64LoadR2Direct",
+	InstructionUint64LoadR3Direct:   "Uint64LoadR3Direct",
+	InstructionRequireISAVersion:    "RequireISAVersion",
+	InstructionMoveR1ToR5:           "MoveR1ToR5",
+	InstructionMoveR1ToR6:           "MoveR1ToR6",
+	InstructionMoveR1ToR7:           "MoveR1ToR7",
+	InstructionMoveR1ToR8:           "MoveR1ToR8",
+	InstructionMoveR2ToR5:           "MoveR2ToR5",
+	InstructionMoveR2ToR6:           "MoveR2ToR6",
+	InstructionMoveR2ToR7:           "MoveR2ToR7",
+	InstructionMoveR2ToR8:           "MoveR2ToR8",
+	InstructionMoveR3ToR5:           "MoveR3ToR5",
+	InstructionMoveR3ToR6:           "MoveR3ToR6",
+	InstructionMoveR3ToR7:           "MoveR3ToR7",
+	InstructionMoveR3ToR8:           "MoveR3ToR8",
+	InstructionMoveR5ToR1:           "MoveR5ToR1",
+	InstructionMoveR5ToR2:           "MoveR5ToR2",
+	InstructionMoveR5ToR3:           "MoveR5ToR3",
+	InstructionMoveR6ToR1:           "MoveR6ToR1",
+	InstructionMoveR6ToR2:           "MoveR6ToR2",
+	InstructionMoveR6ToR3:           "MoveR6ToR3",
+	InstructionMoveR7ToR1:           "MoveR7ToR1",
+	InstructionMoveR7ToR2:           "MoveR7ToR2",
+	InstructionMoveR7ToR3:           "MoveR7ToR3",
+	InstructionMoveR8ToR1:           "MoveR8ToR1",
+	InstructionMoveR8ToR2:           "MoveR8ToR2",
+	InstructionMoveR8ToR3:           "MoveR8ToR3",
+	InstructionUint64LoadR5Direct:   "Uint64LoadR5Direct",
+	InstructionUint64LoadR6Direct:   "Uint64LoadR6Direct",
+	InstructionUint64LoadR7Direct:   "Uint64LoadR7Direct",
+	InstructionUint64LoadR8Direct:   "Uint64LoadR8Direct",
+	InstructionMove2:                "Move2",
+	InstructionAdd2:                 "Add2",
+	InstructionSub2:                 "Sub2",
+	InstructionMul2:                 "Mul2",
+	InstructionAnd2:                 "And2",
+	InstructionOr2:                  "Or2",
+	InstructionXor2:                 "Xor2",
+	InstructionLeftShift2:           "LeftShift2",
+	InstructionRightShift2:          "RightShift2",
+	InstructionYield:                "Yield",
+	InstructionInterruptReturn:      "InterruptReturn",
+	InstructionSyscallR1:            "SyscallR1",
+}
+
+// OpcodeName is used to get the name of the instruction specified without the Instruction prefix, such as "Uint8Load".
+// Opcodes which aren't instructions are named by their hex value.
+func OpcodeName(Opcode uint8) string {
+	if name, ok := opcodeNames[Opcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02X", Opcode)
+}
@@ -0,0 +1,212 @@
+package gomachine
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestOpcodeValues(t *testing.T) {
+	// These values are part of the bytecode format. If this fails, a instruction was renumbered.
+	for instruction, expected := range map[uint8]uint8{
+		InstructionUint8Load:            0x01,
+		InstructionUint16Load:           0x02,
+		InstructionUint32Load:           0x03,
+		InstructionUint64Load:           0x04,
+		InstructionMemoryUint8Load:      0x05,
+		InstructionMemoryUint16Load:     0x06,
+		InstructionMemoryUint32Load:     0x07,
+		InstructionMemoryUint64Load:     0x08,
+		InstructionMoveR1ToR2:           0x09,
+		InstructionMoveR1ToR3:           0x0A,
+		InstructionMoveR2ToR1:           0x0B,
+		InstructionMoveR2ToR3:           0x0C,
+		InstructionFlipR1R2:             0x0D,
+		InstructionMoveR3ToR1:           0x0E,
+		InstructionMoveR3ToR2:           0x0F,
+		InstructionFlipR1R3:             0x10,
+		InstructionMoveR4ToR1:           0x11,
+		InstructionMoveR4ToR2:           0x12,
+		InstructionMoveR4ToR3:           0x13,
+		InstructionUint8Dump:            0x14,
+		InstructionUint16Dump:           0x15,
+		InstructionUint32Dump:           0x16,
+		InstructionUint64Dump:           0x17,
+		InstructionUnsignedAdd:          0x18,
+		InstructionSignedAdd:            0x19,
+		InstructionUnsignedSub:          0x1A,
+		InstructionSignedSub:            0x1B,
+		InstructionUnsignedDiv:          0x1C,
+		InstructionSignedDiv:            0x1D,
+		InstructionUnsignedMul:          0x1E,
+		InstructionSignedMul:            0x1F,
+		InstructionUnsignedMod:          0x20,
+		InstructionSignedMod:            0x21,
+		InstructionBitwiseAnd:           0x22,
+		InstructionBitwiseOr:            0x23,
+		InstructionBitwiseXor:           0x24,
+		InstructionBitwiseLeftShift:     0x25,
+		InstructionBitwiseRightShift:    0x26,
+		InstructionJmp:                  0x27,
+		InstructionJmpIfZero:            0x28,
+		InstructionJmpIfEq:              0x29,
+		InstructionJmpIfNe:              0x2A,
+		InstructionJmpIfGt:              0x2B,
+		InstructionJmpIfLt:              0x2C,
+		InstructionJmpIfGtOrEqual:       0x2D,
+		InstructionJmpIfLtOrEqual:       0x2E,
+		InstructionSyscall:              0x2F,
+		InstructionJmpIfR4Set:           0x30,
+		InstructionJmpIfR4Clear:         0x31,
+		InstructionJmpIfGtSigned:        0x32,
+		InstructionJmpIfLtSigned:        0x33,
+		InstructionJmpIfGtOrEqualSigned: 0x34,
+		InstructionJmpIfLtOrEqualSigned: 0x35,
+		InstructionMoveR2ToR1IfEq:       0x36,
+		InstructionMoveR2ToR1IfNe:       0x37,
+		InstructionSetIfEq:              0x38,
+		InstructionSetIfLt:              0x39,
+		InstructionSetIfGt:              0x3A,
+		InstructionSetIfLtSigned:        0x3B,
+		InstructionSetIfGtSigned:        0x3C,
+		InstructionFloat64Load:          0x3D,
+		InstructionFloat64Add:           0x3E,
+		InstructionFloat64Sub:           0x3F,
+		InstructionFloat64Mul:           0x40,
+		InstructionFloat64Div:           0x41,
+		InstructionMemoryFloat32Load:    0x42,
+		InstructionFloat32Dump:          0x43,
+		InstructionFloat64ToFloat32Bits: 0x44,
+		InstructionJmpIfFloatGt:         0x45,
+		InstructionJmpIfFloatLt:         0x46,
+		InstructionJmpIfFloatEq:         0x47,
+		InstructionJmpIfFloatUnordered:  0x48,
+		InstructionFeatures:             0x49,
+		InstructionMemorySize:           0x4A,
+		InstructionLoadPC:               0x4B,
+		InstructionReadCycleCounter:     0x4C,
+		InstructionRand:                 0x4D,
+		InstructionMemorySum64:          0x4E,
+		InstructionMemoryCRC32:          0x4F,
+		InstructionJmp32:                0x50,
+		InstructionJmpIfZero32:          0x51,
+		InstructionJmpIfEq32:            0x52,
+		InstructionJmpIfNe32:            0x53,
+		InstructionJmpIfGt32:            0x54,
+		InstructionJmpIfLt32:            0x55,
+		InstructionJmpIfGtOrEqual32:     0x56,
+		InstructionJmpIfLtOrEqual32:     0x57,
+		InstructionMemoryUint8Load32:    0x58,
+		InstructionMemoryUint16Load32:   0x59,
+		InstructionMemoryUint32Load32:   0x5A,
+		InstructionMemoryUint64Load32:   0x5B,
+		InstructionUint8Dump32:          0x5C,
+		InstructionUint16Dump32:         0x5D,
+		InstructionUint32Dump32:         0x5E,
+		InstructionUint64Dump32:         0x5F,
+		InstructionVarLoad:              0x60,
+		InstructionVarJmp:               0x61,
+		InstructionMemoryVarLoad64:      0x62,
+		InstructionUint8LoadR2Direct:    0x63,
+		InstructionUint8LoadR3Direct:    0x64,
+		InstructionUint64LoadR2Direct:   0x65,
+		InstructionUint64LoadR3Direct:   0x66,
+		InstructionRequireISAVersion:    0x67,
+		InstructionMoveR1ToR5:           0x68,
+		InstructionMoveR1ToR6:           0x69,
+		InstructionMoveR1ToR7:           0x6A,
+		InstructionMoveR1ToR8:           0x6B,
+		InstructionMoveR2ToR5:           0x6C,
+		InstructionMoveR2ToR6:           0x6D,
+		InstructionMoveR2ToR7:           0x6E,
+		InstructionMoveR2ToR8:           0x6F,
+		InstructionMoveR3ToR5:           0x70,
+		InstructionMoveR3ToR6:           0x71,
+		InstructionMoveR3ToR7:           0x72,
+		InstructionMoveR3ToR8:           0x73,
+		InstructionMoveR5ToR1:           0x74,
+		InstructionMoveR5ToR2:           0x75,
+		InstructionMoveR5ToR3:           0x76,
+		InstructionMoveR6ToR1:           0x77,
+		InstructionMoveR6ToR2:           0x78,
+		InstructionMoveR6ToR3:           0x79,
+		InstructionMoveR7ToR1:           0x7A,
+		InstructionMoveR7ToR2:           0x7B,
+		InstructionMoveR7ToR3:           0x7C,
+		InstructionMoveR8ToR1:           0x7D,
+		InstructionMoveR8ToR2:           0x7E,
+		InstructionMoveR8ToR3:           0x7F,
+		InstructionUint64LoadR5Direct:   0x80,
+		InstructionUint64LoadR6Direct:   0x81,
+		InstructionUint64LoadR7Direct:   0x82,
+		InstructionMove2:                0x84,
+		InstructionAdd2:                 0x85,
+		InstructionSub2:                 0x86,
+		InstructionMul2:                 0x87,
+		InstructionAnd2:                 0x88,
+		InstructionOr2:                  0x89,
+		InstructionXor2:                 0x8A,
+		InstructionLeftShift2:           0x8B,
+		InstructionRightShift2:          0x8C,
+		InstructionYield:                0x8D,
+		InstructionInterruptReturn:      0x8E,
+		InstructionSyscallR1:            0x8F,
+		InstructionUint64LoadR8Direct:   0x83,
+	} {
+		if instruction != expected {
+			t.Fatalf("instruction 0x%02X was renumbered to 0x%02X", expected, instruction)
+		}
+	}
+}
+
+func TestVM_CheckVersion(t *testing.T) {
+	vm := NewVM(0, 0)
+	for version := uint16(0); version <= ISAVersion; version++ {
+		if err := vm.CheckVersion(version); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := vm.CheckVersion(ISAVersion + 1); !errors.Is(err, UnsupportedISAVersion) {
+		t.Fatal("expected unsupported isa version, got:", err)
+	}
+}
+
+func TestVM_Execute_RequireISAVersion(t *testing.T) {
+	vm := NewVM(0, 0)
+	if err := vm.Execute([]byte{InstructionRequireISAVersion, byte(ISAVersion), byte(ISAVersion >> 8), InstructionUint8Load, 0x01}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 1 {
+		t.Fatal("not 1:", vm.Registers[0])
+	}
+
+	// A newer version fails before anything else runs.
+	vm = NewVM(0, 0)
+	next := ISAVersion + 1
+	err := vm.Execute([]byte{InstructionRequireISAVersion, byte(next), byte(next >> 8), InstructionUint8Load, 0x01})
+	if !errors.Is(err, UnsupportedISAVersion) {
+		t.Fatal("expected unsupported isa version, got:", err)
+	}
+	if vm.Registers[0] != 0 {
+		t.Fatal("bytecode ran after the version check:", vm.Registers[0])
+	}
+
+	// The argument is required.
+	if err := vm.Execute([]byte{InstructionRequireISAVersion, 0x01}); !errors.Is(err, InvalidInstructionArgument) {
+		t.Fatal("expected invalid instruction argument, got:", err)
+	}
+}
+
+func TestOpcodeName(t *testing.T) {
+	if name := OpcodeName(InstructionUint8Load); name != "Uint8Load" {
+		t.Fatal("wrong name:", name)
+	}
+	if name := OpcodeName(InstructionInterruptReturn); name != "InterruptReturn" {
+		t.Fatal("wrong name:", name)
+	}
+	if name := OpcodeName(0xFF); name != "0xFF" {
+		t.Fatal("wrong name:", name)
+	}
+	if len(opcodeNames) != int(InstructionSyscallR1) {
+		t.Fatal("not every instruction is named:", len(opcodeNames))
+	}
+}
@@ -0,0 +1,118 @@
+package gomachine
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// InvalidOptions is returned by NewVMWithOptions when the options conflict.
+var InvalidOptions = errors.New("invalid virtual machine options")
+
+// Option is used to configure a virtual machine created by NewVMWithOptions or NewVM.
+type Option func(*VM)
+
+// WithMemory is used to set the length of the memory.
+func WithMemory(MemoryLength uint64) Option {
+	return func(v *VM) {
+		v.Memory = make([]byte, MemoryLength)
+	}
+}
+
+// WithMaxCPUTime is used to set the maximum CPU time for each execution.
+func WithMaxCPUTime(MaxCPUTime time.Duration) Option {
+	return func(v *VM) {
+		v.MaxCPUTime = MaxCPUTime
+	}
+}
+
+// WithMaxInstructions is used to set the maximum number of instructions for each execution.
+func WithMaxInstructions(MaxInstructions uint64) Option {
+	return func(v *VM) {
+		v.MaxInstructions = MaxInstructions
+	}
+}
+
+// WithMaxBackwardJumps is used to set the maximum number of backward jumps for each execution.
+func WithMaxBackwardJumps(MaxBackwardJumps uint64) Option {
+	return func(v *VM) {
+		v.MaxBackwardJumps = MaxBackwardJumps
+	}
+}
+
+// WithGas is used to turn on gas metering with the cost table and amount of gas specified.
+func WithGas(Costs CostTable, Gas uint64) Option {
+	return func(v *VM) {
+		v.CostTable = Costs
+		v.GasRemaining = Gas
+	}
+}
+
+// WithMode is used to set the mode of the virtual machine. The mode can't be changed after the virtual machine is
+// created.
+func WithMode(Mode Mode) Option {
+	return func(v *VM) {
+		v.mode = Mode
+	}
+}
+
+// WithMaxMemory is used to set MaxMemory so the virtual machine can start with a small memory but never grow past the
+// limit.
+func WithMaxMemory(Limit uint64) Option {
+	return func(v *VM) {
+		v.MaxMemory = Limit
+	}
+}
+
+// WithStrictArithmetic is used to set StrictArithmetic.
+func WithStrictArithmetic() Option {
+	return func(v *VM) {
+		v.StrictArithmetic = true
+	}
+}
+
+// WithPropagateSyscallPanics is used to set PropagateSyscallPanics.
+func WithPropagateSyscallPanics() Option {
+	return func(v *VM) {
+		v.PropagateSyscallPanics = true
+	}
+}
+
+// WithGuardSize is used to set GuardSize.
+func WithGuardSize(GuardSize uint64) Option {
+	return func(v *VM) {
+		v.GuardSize = GuardSize
+	}
+}
+
+// WithSyscall is used to add a system call.
+func WithSyscall(Number uint64, Syscall func(*VM) error) Option {
+	return func(v *VM) {
+		v.Syscalls[Number] = Syscall
+	}
+}
+
+// NewVMWithOptions is used to create a new virtual machine from the options. By default it has no memory and no CPU
+// time limit. InvalidOptions is returned if the options conflict.
+func NewVMWithOptions(Options ...Option) (*VM, error) {
+	v := NewVM(0, 0, Options...)
+	if err := v.checkOptions(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// checkOptions is used to check the options set on a new virtual machine don't conflict.
+func (v *VM) checkOptions() error {
+	size := uint64(len(v.Memory))
+	if v.MaxMemory != 0 && size > v.MaxMemory {
+		return fmt.Errorf("%w: memory length %d is more than the maximum memory %d", InvalidOptions, size, v.MaxMemory)
+	}
+	if v.mode == Mode32 && (size > 1<<32 || v.MaxMemory > 1<<32) {
+		return fmt.Errorf("%w: memory past 4GB can't be addressed in 32-bit mode", InvalidOptions)
+	}
+	if v.mode != Mode64 && v.mode != Mode32 {
+		return fmt.Errorf("%w: unknown mode %d", InvalidOptions, v.mode)
+	}
+	return nil
+}
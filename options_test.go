@@ -0,0 +1,57 @@
+package gomachine
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNewVMWithOptions(t *testing.T) {
+	// Check the defaults.
+	vm, err := NewVMWithOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vm.Memory) != 0 || vm.MaxCPUTime != 0 || vm.Mode() != Mode64 || vm.Syscalls == nil || vm.StrictArithmetic {
+		t.Fatal("unexpected defaults:", vm)
+	}
+
+	// Check each option.
+	syscall := func(vm *VM) error {
+		vm.Registers[R1] = 42
+		return nil
+	}
+	vm, err = NewVMWithOptions(
+		WithMemory(64),
+		WithMaxCPUTime(time.Second),
+		WithMode(Mode32),
+		WithMaxMemory(128),
+		WithStrictArithmetic(),
+		WithGuardSize(8),
+		WithSyscall(3, syscall),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vm.Memory) != 64 || vm.MaxCPUTime != time.Second || vm.Mode() != Mode32 || vm.MaxMemory != 128 ||
+		!vm.StrictArithmetic || vm.GuardSize != 8 {
+		t.Fatal("options not applied")
+	}
+	if err := vm.Execute([]byte{InstructionSyscall, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[R1] != 42 {
+		t.Fatal("syscall not added")
+	}
+
+	// Check conflicting options.
+	for _, options := range [][]Option{
+		{WithMemory(64), WithMaxMemory(63)},
+		{WithMode(Mode32), WithMaxMemory(1<<32 + 1)},
+		{WithMode(Mode(2))},
+	} {
+		if _, err := NewVMWithOptions(options...); !errors.Is(err, InvalidOptions) {
+			t.Fatal("expected invalid options, got:", err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package gomachine
+
+import "time"
+
+// pagedMemory is a sparse address space where pages are only allocated when they are first accessed.
+type pagedMemory struct {
+	size        uint64
+	pageSize    uint64
+	maxResident uint64
+	pages       map[uint64][]byte
+}
+
+// page is used to get the page holding the address, allocating it if this is the first access to it.
+func (p *pagedMemory) page(Address uint64) ([]byte, error) {
+	n := Address / p.pageSize
+	page, ok := p.pages[n]
+	if !ok {
+		if p.maxResident != 0 && uint64(len(p.pages)) >= p.maxResident {
+			return nil, OutOfMemory
+		}
+		page = make([]byte, p.pageSize)
+		p.pages[n] = page
+	}
+	return page, nil
+}
+
+// clone is used to deep copy the pages.
+func (p *pagedMemory) clone() *pagedMemory {
+	c := *p
+	c.pages = make(map[uint64][]byte, len(p.pages))
+	for n, page := range p.pages {
+		c.pages[n] = append([]byte(nil), page...)
+	}
+	return &c
+}
+
+// NewPagedVM is used to create a virtual machine with a sparse address space of the size specified. Instead of the
+// Memory slice, pages of PageSize bytes (4096 if 0) are allocated when they are first read or written. Once
+// MaxResidentPages pages are allocated (0 for no limit), accesses which need a new page return OutOfMemory. Every
+// memory access goes through the slow path, so this is slower than NewVM for memory heavy bytecode.
+func NewPagedVM(AddressSpaceSize, PageSize, MaxResidentPages uint64, MaxCPUTime time.Duration, Options ...Option) *VM {
+	if PageSize == 0 {
+		PageSize = 4096
+	}
+	v := NewVM(0, MaxCPUTime, Options...)
+	v.paged = &pagedMemory{
+		size:        AddressSpaceSize,
+		pageSize:    PageSize,
+		maxResident: MaxResidentPages,
+		pages:       map[uint64][]byte{},
+	}
+	return v
+}
+
+// ResidentPages is used to get the number of pages allocated by a virtual machine created with NewPagedVM.
+func (v *VM) ResidentPages() uint64 {
+	if v.paged == nil {
+		return 0
+	}
+	return uint64(len(v.paged.pages))
+}
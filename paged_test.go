@@ -0,0 +1,75 @@
+package gomachine
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewPagedVM(t *testing.T) {
+	vm := NewPagedVM(1<<33, 4096, 3, 0)
+
+	// Write to widely scattered addresses and read them back.
+	addresses := []uint64{0, 1 << 32, 1<<33 - 8}
+	for i, address := range addresses {
+		vm.Registers[0] = uint64(i) + 0x1122334455667700
+		if err := vm.Execute(append([]byte{InstructionUint64Dump}, uint64Operand(address)...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, address := range addresses {
+		if err := vm.Execute(append([]byte{InstructionMemoryUint64Load}, uint64Operand(address)...)); err != nil {
+			t.Fatal(err)
+		}
+		if vm.Registers[0] != uint64(i)+0x1122334455667700 {
+			t.Fatalf("unexpected value at 0x%x: 0x%x", address, vm.Registers[0])
+		}
+	}
+	if vm.ResidentPages() != 3 {
+		t.Fatal("not 3 pages:", vm.ResidentPages())
+	}
+
+	// Check the memory size is the address space size.
+	if err := vm.Execute([]byte{InstructionMemorySize}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 1<<33 {
+		t.Fatal("unexpected memory size:", vm.Registers[0])
+	}
+
+	// Accessing a new page once the limit is reached fails.
+	err := vm.Execute(append([]byte{InstructionMemoryUint8Load}, uint64Operand(1<<20)...))
+	if !errors.Is(err, OutOfMemory) {
+		t.Fatal("expected out of memory, got:", err)
+	}
+
+	// Accesses past the address space are still invalid.
+	err = vm.Execute(append([]byte{InstructionMemoryUint16Load}, uint64Operand(1<<33-1)...))
+	if !errors.Is(err, InvalidMemoryLocation) {
+		t.Fatal("expected invalid memory location, got:", err)
+	}
+}
+
+func TestNewPagedVM_StraddlePages(t *testing.T) {
+	vm := NewPagedVM(1<<20, 16, 0, 0)
+	vm.Registers[0] = 0x0807060504030201
+	err := vm.Execute([]byte{
+		InstructionUint64Dump32, 0x0C, 0x00, 0x00, 0x00,
+		InstructionMemoryUint32Load32, 0x0E, 0x00, 0x00, 0x00,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 0x06050403 {
+		t.Fatalf("unexpected value: 0x%x", vm.Registers[0])
+	}
+	if vm.ResidentPages() != 2 {
+		t.Fatal("not 2 pages:", vm.ResidentPages())
+	}
+
+	// Clones get their own pages and clearing the memory frees them.
+	clone := vm.Clone()
+	vm.ClearMemory()
+	if vm.ResidentPages() != 0 || clone.ResidentPages() != 2 {
+		t.Fatal("unexpected pages:", vm.ResidentPages(), clone.ResidentPages())
+	}
+}
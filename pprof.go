@@ -0,0 +1,229 @@
+package gomachine
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"runtime/pprof"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ExecuteWithLabels is used to execute the bytecode like Execute with the pprof labels specified applied, so CPU
+// profiles of the host show the time spent in the guest under them. While it runs, Context returns the labelled context
+// so system calls can pass it on. VMBusy is returned without changing the context if the virtual machine is already
+// executing.
+func (v *VM) ExecuteWithLabels(ctx context.Context, Labels pprof.LabelSet, Bytecode []byte) error {
+	if atomic.LoadUint32(&v.running) != 0 {
+		return VMBusy
+	}
+	var err error
+	pprof.Do(ctx, Labels, func(ctx context.Context) {
+		previous := v.ctx
+		v.ctx = ctx
+		defer func() {
+			v.ctx = previous
+		}()
+		err = v.Execute(Bytecode)
+	})
+	return err
+}
+
+// Context is used to get the context of the current execution, which has the labels set by ExecuteWithLabels. Outside
+// of ExecuteWithLabels it is context.Background.
+func (v *VM) Context() context.Context {
+	if v.ctx == nil {
+		return context.Background()
+	}
+	return v.ctx
+}
+
+// PCSampler is used to sample the PC of a virtual machine while it runs. It reads the PC published for Snapshot, so
+// samples are at most CheckInterval instructions behind and a lower CheckInterval gives a more precise profile.
+type PCSampler struct {
+	vm       *VM
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+
+	// mu protects samples.
+	mu      sync.Mutex
+	samples map[uint64]uint64
+	start   time.Time
+}
+
+// StartPCSampling is used to start sampling the PC every interval specified on a new goroutine until Stop is called.
+// Samples are only taken while the virtual machine is executing.
+func (v *VM) StartPCSampling(Interval time.Duration) *PCSampler {
+	s := &PCSampler{
+		vm:       v,
+		interval: Interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		samples:  map[uint64]uint64{},
+		start:    time.Now(),
+	}
+	go s.run()
+	return s
+}
+
+// run is used to take samples until Stop is called.
+func (s *PCSampler) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if atomic.LoadUint32(&s.vm.running) == 0 {
+				continue
+			}
+			pc := s.vm.Snapshot().PC
+			s.mu.Lock()
+			s.samples[pc]++
+			s.mu.Unlock()
+		}
+	}
+}
+
+// Stop is used to stop sampling. The samples taken are kept.
+func (s *PCSampler) Stop() {
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	<-s.done
+}
+
+// Samples is used to get a copy of the number of samples taken at each PC.
+func (s *PCSampler) Samples() map[uint64]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	samples := make(map[uint64]uint64, len(s.samples))
+	for pc, n := range s.samples {
+		samples[pc] = n
+	}
+	return samples
+}
+
+// GuestFunction is used to name the bytecode from Start up to but not including End in a profile.
+type GuestFunction struct {
+	Name       string
+	Start, End uint64
+}
+
+// WriteProfile is used to write the samples as a gzipped pprof profile. Each PC is a location whose line number is the
+// bytecode offset, in the function whose range contains it. PCs outside of every function are put in a function named
+// "bytecode".
+func (s *PCSampler) WriteProfile(W io.Writer, Functions []GuestFunction) error {
+	samples := s.Samples()
+	pcs := make([]uint64, 0, len(samples))
+	for pc := range samples {
+		pcs = append(pcs, pc)
+	}
+	sort.Slice(pcs, func(i, j int) bool {
+		return pcs[i] < pcs[j]
+	})
+
+	p := &profileBuilder{strings: map[string]int64{"": 0}, stringTable: []string{""}}
+
+	// The sample value is a count of samples, with the interval as the period.
+	var b []byte
+	b = appendMessageField(b, 1, p.valueType("samples", "count"))
+	functionIDs := map[int]uint64{}
+	for i, pc := range pcs {
+		fn := -1
+		for j, f := range Functions {
+			if pc >= f.Start && pc < f.End {
+				fn = j
+				break
+			}
+		}
+		id, ok := functionIDs[fn]
+		if !ok {
+			id = uint64(len(functionIDs) + 1)
+			functionIDs[fn] = id
+			name := "bytecode"
+			if fn != -1 {
+				name = Functions[fn].Name
+			}
+			var f []byte
+			f = appendVarintField(f, 1, id)
+			f = appendVarintField(f, 2, uint64(p.str(name)))
+			f = appendVarintField(f, 3, uint64(p.str(name)))
+			b = appendMessageField(b, 5, f)
+		}
+		var line []byte
+		line = appendVarintField(line, 1, id)
+		line = appendVarintField(line, 2, pc)
+		var loc []byte
+		loc = appendVarintField(loc, 1, uint64(i+1))
+		loc = appendVarintField(loc, 3, pc)
+		loc = appendMessageField(loc, 4, line)
+		b = appendMessageField(b, 4, loc)
+
+		var sample []byte
+		sample = appendVarintField(sample, 1, uint64(i+1))
+		sample = appendVarintField(sample, 2, samples[pc])
+		b = appendMessageField(b, 2, sample)
+	}
+	b = appendVarintField(b, 9, uint64(s.start.UnixNano()))
+	b = appendVarintField(b, 10, uint64(time.Since(s.start)))
+	b = appendMessageField(b, 11, p.valueType("wall", "nanoseconds"))
+	b = appendVarintField(b, 12, uint64(s.interval))
+	for _, str := range p.stringTable {
+		b = appendBytesField(b, 6, []byte(str))
+	}
+
+	zw := gzip.NewWriter(W)
+	if _, err := zw.Write(b); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// profileBuilder is used to hold the string table of a profile being written.
+type profileBuilder struct {
+	strings     map[string]int64
+	stringTable []string
+}
+
+// str is used to get the index of a string in the string table, adding it if needed.
+func (p *profileBuilder) str(s string) int64 {
+	if i, ok := p.strings[s]; ok {
+		return i
+	}
+	i := int64(len(p.stringTable))
+	p.strings[s] = i
+	p.stringTable = append(p.stringTable, s)
+	return i
+}
+
+// valueType is used to encode a pprof ValueType message.
+func (p *profileBuilder) valueType(Type, Unit string) []byte {
+	var b []byte
+	b = appendVarintField(b, 1, uint64(p.str(Type)))
+	return appendVarintField(b, 2, uint64(p.str(Unit)))
+}
+
+// appendVarintField is used to append a protobuf varint field.
+func appendVarintField(b []byte, Field int, X uint64) []byte {
+	b = appendUvarint(b, uint64(Field)<<3)
+	return appendUvarint(b, X)
+}
+
+// appendBytesField is used to append a protobuf length delimited field.
+func appendBytesField(b []byte, Field int, Data []byte) []byte {
+	b = appendUvarint(b, uint64(Field)<<3|2)
+	return appendBytes(b, Data)
+}
+
+// appendMessageField is used to append a embedded protobuf message.
+func appendMessageField(b []byte, Field int, Message []byte) []byte {
+	return appendBytesField(b, Field, Message)
+}
@@ -0,0 +1,98 @@
+package gomachine
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"runtime/pprof"
+	"testing"
+	"time"
+)
+
+func TestVM_ExecuteWithLabels(t *testing.T) {
+	vm := NewVM(0, 0)
+	var label string
+	vm.Syscalls[1] = func(vm *VM) error {
+		label, _ = pprof.Label(vm.Context(), "guest")
+		return nil
+	}
+	bytecode := []byte{InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	if err := vm.ExecuteWithLabels(context.Background(), pprof.Labels("guest", "test"), bytecode); err != nil {
+		t.Fatal(err)
+	}
+	if label != "test" {
+		t.Fatal("label was not applied:", label)
+	}
+
+	// The labels are gone once it returns.
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	if label != "" {
+		t.Fatal("label was kept:", label)
+	}
+
+	// Calling it while executing returns VMBusy and leaves the context of the running execution alone.
+	var nestedErr error
+	vm.Syscalls[1] = func(vm *VM) error {
+		nestedErr = vm.ExecuteWithLabels(context.Background(), pprof.Labels("guest", "nested"), bytecode)
+		label, _ = pprof.Label(vm.Context(), "guest")
+		return nil
+	}
+	if err := vm.ExecuteWithLabels(context.Background(), pprof.Labels("guest", "test"), bytecode); err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(nestedErr, VMBusy) || label != "test" {
+		t.Fatal("running context was changed:", nestedErr, label)
+	}
+}
+
+func TestVM_StartPCSampling(t *testing.T) {
+	// Loop forever until stopped.
+	bytecode := []byte{
+		InstructionUint8Load, 0x01,
+		InstructionJmp, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	vm := NewVM(0, 0)
+	vm.CheckInterval = 1
+	sampler := vm.StartPCSampling(time.Millisecond)
+	timer := time.AfterFunc(100*time.Millisecond, vm.Stop)
+	defer timer.Stop()
+	if err := vm.Execute(bytecode); !errors.Is(err, Stopped) {
+		t.Fatal("expected stopped, got:", err)
+	}
+	sampler.Stop()
+	sampler.Stop()
+
+	samples := sampler.Samples()
+	if len(samples) == 0 {
+		t.Fatal("no samples were taken")
+	}
+	for pc := range samples {
+		if pc != 0 && pc != 2 {
+			t.Fatal("sample at wrong pc:", pc)
+		}
+	}
+
+	// The profile names the functions the samples are in.
+	var buf bytes.Buffer
+	if err := sampler.WriteProfile(&buf, []GuestFunction{{Name: "guest_loop", Start: 0, End: 11}}); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte("guest_loop")) || !bytes.Contains(b, []byte("samples")) {
+		t.Fatal("profile is missing strings")
+	}
+	if bytes.Contains(b, []byte("bytecode")) {
+		t.Fatal("sample outside of the functions")
+	}
+}
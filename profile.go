@@ -0,0 +1,55 @@
+package gomachine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// OpcodeProfile is used to get the number of times each opcode has been executed while ProfileOpcodes was set, since
+// the virtual machine was created or ResetOpcodeProfile was last called. Opcodes which haven't run are left out.
+func (v *VM) OpcodeProfile() map[uint8]uint64 {
+	profile := map[uint8]uint64{}
+	if v.opcodeProfile != nil {
+		for opcode, count := range v.opcodeProfile {
+			if count != 0 {
+				profile[uint8(opcode)] = count
+			}
+		}
+	}
+	return profile
+}
+
+// ResetOpcodeProfile is used to set the counts returned by OpcodeProfile back to 0.
+func (v *VM) ResetOpcodeProfile() {
+	v.opcodeProfile = nil
+}
+
+// FormatOpcodeProfile is used to render a profile from OpcodeProfile as a table with a line for each opcode, the most
+// executed first. Each line has the instruction name, the count and the percentage of every instruction executed.
+// Profiles from more than one virtual machine can be added together before they are formatted.
+func FormatOpcodeProfile(Profile map[uint8]uint64) string {
+	opcodes := make([]uint8, 0, len(Profile))
+	total := uint64(0)
+	width := 0
+	for opcode, count := range Profile {
+		opcodes = append(opcodes, opcode)
+		total += count
+		if l := len(OpcodeName(opcode)); l > width {
+			width = l
+		}
+	}
+	sort.Slice(opcodes, func(i, j int) bool {
+		if Profile[opcodes[i]] != Profile[opcodes[j]] {
+			return Profile[opcodes[i]] > Profile[opcodes[j]]
+		}
+		return opcodes[i] < opcodes[j]
+	})
+
+	var b strings.Builder
+	for _, opcode := range opcodes {
+		count := Profile[opcode]
+		_, _ = fmt.Fprintf(&b, "%-*s %d (%.1f%%)\n", width, OpcodeName(opcode), count, float64(count)*100/float64(total))
+	}
+	return b.String()
+}
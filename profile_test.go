@@ -0,0 +1,81 @@
+package gomachine
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVM_OpcodeProfile(t *testing.T) {
+	// Count R1 up to 3.
+	bytecode := []byte{
+		InstructionUint8LoadR3Direct, 0x03,
+		InstructionUint8LoadR2Direct, 0x01,
+		InstructionUnsignedAdd,
+		InstructionJmpIfLt, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+
+	// Nothing is counted by default.
+	vm := NewVM(0, 0)
+	run := func() {
+		vm.ClearRegisters()
+		if err := vm.Execute(bytecode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	allocs := testing.AllocsPerRun(100, run)
+	if vm.opcodeProfile != nil || len(vm.OpcodeProfile()) != 0 {
+		t.Fatal("opcodes were counted:", vm.OpcodeProfile())
+	}
+
+	// Once the counts are allocated, counting doesn't allocate.
+	vm.ProfileOpcodes = true
+	run()
+	if profiled := testing.AllocsPerRun(100, run); profiled != allocs {
+		t.Fatal("profiling allocates:", profiled, allocs)
+	}
+
+	// The counts add up across executions.
+	vm.ResetOpcodeProfile()
+	for i := 0; i < 2; i++ {
+		vm.ClearRegisters()
+		if err := vm.Execute(bytecode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := map[uint8]uint64{
+		InstructionUint8LoadR3Direct: 2,
+		InstructionUint8LoadR2Direct: 2,
+		InstructionUnsignedAdd:       6,
+		InstructionJmpIfLt:           6,
+	}
+	if profile := vm.OpcodeProfile(); !reflect.DeepEqual(profile, expected) {
+		t.Fatal("wrong profile:", profile)
+	}
+
+	// Collecting stats at the same time doesn't count anything twice.
+	vm.ResetOpcodeProfile()
+	vm.CollectStats = true
+	vm.ClearRegisters()
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	expected = map[uint8]uint64{
+		InstructionUint8LoadR3Direct: 1,
+		InstructionUint8LoadR2Direct: 1,
+		InstructionUnsignedAdd:       3,
+		InstructionJmpIfLt:           3,
+	}
+	if profile := vm.OpcodeProfile(); !reflect.DeepEqual(profile, expected) {
+		t.Fatal("wrong profile:", profile)
+	}
+
+	// The most executed opcodes are first.
+	formatted := FormatOpcodeProfile(vm.OpcodeProfile())
+	if formatted != ""+
+		"UnsignedAdd       3 (37.5%)\n"+
+		"JmpIfLt           3 (37.5%)\n"+
+		"Uint8LoadR2Direct 1 (12.5%)\n"+
+		"Uint8LoadR3Direct 1 (12.5%)\n" {
+		t.Fatal("wrong format:\n" + formatted)
+	}
+}
@@ -0,0 +1,194 @@
+package gomachine
+
+import (
+	"errors"
+	"fmt"
+)
+
+// NoProgramLoaded is returned when Run or RunFrom is called before a program is loaded.
+var NoProgramLoaded = errors.New("no program is loaded")
+
+// Defines the special operand lengths returned by operandLength.
+const (
+	// operandUnknown is returned for opcodes which are not instructions.
+	operandUnknown = -1
+
+	// operandVarint is returned for instructions which take a unsigned LEB128 argument.
+	operandVarint = -2
+
+	// operandAddress is returned for instructions which take a memory location or jump target. The argument is a uint64
+	// in Mode64 and a uint32 in Mode32.
+	operandAddress = -3
+)
+
+// operandLength is used to get the length of the argument an instruction takes and if the argument is a jump target.
+func operandLength(Opcode uint8) (int, bool) {
+	switch Opcode {
+	case InstructionMoveR1ToR2, InstructionMoveR1ToR3, InstructionMoveR2ToR1, InstructionMoveR2ToR3,
+		InstructionFlipR1R2, InstructionMoveR3ToR1, InstructionMoveR3ToR2, InstructionFlipR1R3,
+		InstructionMoveR4ToR1, InstructionMoveR4ToR2, InstructionMoveR4ToR3,
+		InstructionUnsignedAdd, InstructionSignedAdd, InstructionUnsignedSub, InstructionSignedSub,
+		InstructionUnsignedDiv, InstructionSignedDiv, InstructionUnsignedMul, InstructionSignedMul,
+		InstructionUnsignedMod, InstructionSignedMod, InstructionBitwiseAnd, InstructionBitwiseOr,
+		InstructionBitwiseXor, InstructionBitwiseLeftShift, InstructionBitwiseRightShift,
+		InstructionMoveR2ToR1IfEq, InstructionMoveR2ToR1IfNe, InstructionSetIfEq, InstructionSetIfLt,
+		InstructionSetIfGt, InstructionSetIfLtSigned, InstructionSetIfGtSigned,
+		InstructionFloat64Add, InstructionFloat64Sub, InstructionFloat64Mul, InstructionFloat64Div,
+		InstructionFloat64ToFloat32Bits, InstructionFeatures, InstructionMemorySize, InstructionLoadPC,
+		InstructionReadCycleCounter, InstructionRand, InstructionMemorySum64, InstructionMemoryCRC32,
+		InstructionMoveR1ToR5, InstructionMoveR1ToR6, InstructionMoveR1ToR7, InstructionMoveR1ToR8,
+		InstructionMoveR2ToR5, InstructionMoveR2ToR6, InstructionMoveR2ToR7, InstructionMoveR2ToR8,
+		InstructionMoveR3ToR5, InstructionMoveR3ToR6, InstructionMoveR3ToR7, InstructionMoveR3ToR8,
+		InstructionMoveR5ToR1, InstructionMoveR5ToR2, InstructionMoveR5ToR3, InstructionMoveR6ToR1,
+		InstructionMoveR6ToR2, InstructionMoveR6ToR3, InstructionMoveR7ToR1, InstructionMoveR7ToR2,
+		InstructionMoveR7ToR3, InstructionMoveR8ToR1, InstructionMoveR8ToR2, InstructionMoveR8ToR3,
+		InstructionYield, InstructionInterruptReturn, InstructionSyscallR1:
+		return 0, false
+	case InstructionUint8Load, InstructionUint8LoadR2Direct, InstructionUint8LoadR3Direct,
+		InstructionMove2, InstructionAdd2, InstructionSub2, InstructionMul2, InstructionAnd2,
+		InstructionOr2, InstructionXor2, InstructionLeftShift2, InstructionRightShift2:
+		return 1, false
+	case InstructionUint16Load, InstructionRequireISAVersion:
+		return 2, false
+	case InstructionUint32Load,
+		InstructionMemoryUint8Load32, InstructionMemoryUint16Load32, InstructionMemoryUint32Load32,
+		InstructionMemoryUint64Load32, InstructionUint8Dump32, InstructionUint16Dump32, InstructionUint32Dump32,
+		InstructionUint64Dump32:
+		return 4, false
+	case InstructionJmp32, InstructionJmpIfZero32, InstructionJmpIfEq32, InstructionJmpIfNe32, InstructionJmpIfGt32,
+		InstructionJmpIfLt32, InstructionJmpIfGtOrEqual32, InstructionJmpIfLtOrEqual32:
+		return 4, true
+	case InstructionUint64Load, InstructionUint64LoadR2Direct, InstructionUint64LoadR3Direct, InstructionFloat64Load,
+		InstructionSyscall, InstructionUint64LoadR5Direct, InstructionUint64LoadR6Direct,
+		InstructionUint64LoadR7Direct, InstructionUint64LoadR8Direct:
+		return 8, false
+	case InstructionMemoryUint8Load, InstructionMemoryUint16Load, InstructionMemoryUint32Load,
+		InstructionMemoryUint64Load, InstructionUint8Dump, InstructionUint16Dump, InstructionUint32Dump,
+		InstructionUint64Dump, InstructionMemoryFloat32Load, InstructionFloat32Dump:
+		return operandAddress, false
+	case InstructionJmp, InstructionJmpIfZero, InstructionJmpIfEq, InstructionJmpIfNe, InstructionJmpIfGt,
+		InstructionJmpIfLt, InstructionJmpIfGtOrEqual, InstructionJmpIfLtOrEqual, InstructionJmpIfR4Set,
+		InstructionJmpIfR4Clear, InstructionJmpIfGtSigned, InstructionJmpIfLtSigned,
+		InstructionJmpIfGtOrEqualSigned, InstructionJmpIfLtOrEqualSigned, InstructionJmpIfFloatGt,
+		InstructionJmpIfFloatLt, InstructionJmpIfFloatEq, InstructionJmpIfFloatUnordered:
+		return operandAddress, true
+	case InstructionVarLoad, InstructionMemoryVarLoad64:
+		return operandVarint, false
+	case InstructionVarJmp:
+		return operandVarint, true
+	default:
+		return operandUnknown, false
+	}
+}
+
+// decodeInstruction is used to get the argument of the instruction at the index specified when run in the mode
+// specified, the number of bytes the argument takes up and if it is a jump target.
+func decodeInstruction(Bytecode []byte, Index uint64, Mode Mode) (uint64, int, bool, error) {
+	length, jump := operandLength(Bytecode[Index])
+	if length == operandAddress {
+		length = 8
+		if Mode == Mode32 {
+			length = 4
+		}
+	}
+	switch length {
+	case operandUnknown:
+		return 0, 0, false, UnknownInstruction
+	case operandVarint:
+		value, n := decodeVarint(Bytecode[Index+1:])
+		if n == 0 {
+			return 0, 0, false, InvalidInstructionArgument
+		}
+		return value, n, jump, nil
+	}
+	if uint64(len(Bytecode))-Index-1 < uint64(length) {
+		return 0, 0, false, InvalidInstructionArgument
+	}
+	var operand uint64
+	switch length {
+	case 1:
+		operand = uint64(Bytecode[Index+1])
+	case 2:
+		operand = uint64(getUint16(Bytecode, Index+1))
+	case 4:
+		operand = uint64(getUint32(Bytecode, Index+1))
+	case 8:
+		operand = getUint64(Bytecode, Index+1)
+	}
+	return operand, length, jump, nil
+}
+
+// floatInstruction is used to check if the opcode is a float instruction, which can't be used in Mode32.
+func floatInstruction(Opcode uint8) bool {
+	return Opcode >= InstructionFloat64Load && Opcode <= InstructionJmpIfFloatUnordered
+}
+
+// validate is used to check every instruction in the bytecode is known, has a complete argument and jumps inside the
+// bytecode when run in the mode specified.
+func validate(Bytecode []byte, Mode Mode) error {
+	bytecodeLen := uint64(len(Bytecode))
+	for i := uint64(0); i < bytecodeLen; {
+		operand, length, jump, err := decodeInstruction(Bytecode, i, Mode)
+		if err == nil && Mode == Mode32 && floatInstruction(Bytecode[i]) {
+			err = FloatUnsupported
+		}
+		if err != nil {
+			return &VMError{Offset: i, Opcode: Bytecode[i], Err: err}
+		}
+		if jump && operand >= bytecodeLen {
+			return &VMError{Offset: i, Opcode: Bytecode[i], Operand: operand, Err: InvalidMemoryLocation}
+		}
+		i += uint64(length) + 1
+	}
+	return nil
+}
+
+// InstructionOffsets is used to get the offset of every instruction in the bytecode when run in the mode specified. The
+// bytecode is checked like LoadProgram, and the error from the first instruction which isn't valid is returned.
+func InstructionOffsets(Bytecode []byte, Mode Mode) ([]uint64, error) {
+	if err := validate(Bytecode, Mode); err != nil {
+		return nil, err
+	}
+	var offsets []uint64
+	for i := uint64(0); i < uint64(len(Bytecode)); {
+		offsets = append(offsets, i)
+		_, length, _, _ := decodeInstruction(Bytecode, i, Mode)
+		i += uint64(length) + 1
+	}
+	return offsets, nil
+}
+
+// LoadProgram is used to validate the bytecode and store it as the program run by Run and RunFrom. The PC is set to 0.
+func (v *VM) LoadProgram(Bytecode []byte) error {
+	if err := validate(Bytecode, v.mode); err != nil {
+		return err
+	}
+	v.program = Bytecode
+	v.PC = 0
+	return nil
+}
+
+// Run is used to execute the loaded program from the start.
+func (v *VM) Run() error {
+	return v.RunFrom(0)
+}
+
+// RunFrom is used to execute the loaded program from the bytecode index specified. The entry point should be the start
+// of an instruction.
+func (v *VM) RunFrom(Entry uint64) error {
+	if v.program == nil {
+		return NoProgramLoaded
+	}
+	if Entry > uint64(len(v.program)) {
+		return fmt.Errorf("%w: entry point %d is past the end of the program", InvalidMemoryLocation, Entry)
+	}
+	return v.run(v.program, Entry, 0, false)
+}
+
+// StepProgram is used to execute the instruction of the loaded program at the PC. It works like Step.
+func (v *VM) StepProgram() error {
+	if v.program == nil {
+		return NoProgramLoaded
+	}
+	return v.Step(v.program)
+}
@@ -0,0 +1,116 @@
+package gomachine
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestOperandLength(t *testing.T) {
+	// Every opcode up to the last instruction is a instruction and nothing after it is.
+	for opcode := 0; opcode < 256; opcode++ {
+		length, _ := operandLength(uint8(opcode))
+		known := opcode != 0 && opcode <= int(InstructionSyscallR1)
+		if known && length == operandUnknown {
+			t.Fatalf("opcode 0x%02x has no operand length", opcode)
+		}
+		if !known && length != operandUnknown {
+			t.Fatalf("opcode 0x%02x should be unknown", opcode)
+		}
+	}
+}
+
+func TestVM_LoadProgram(t *testing.T) {
+	tests := []struct {
+		name     string
+		bytecode []byte
+		offset   uint64
+		err      error
+	}{
+		{
+			name:     "unknown instruction",
+			bytecode: []byte{InstructionMoveR1ToR2, 0x00},
+			offset:   1,
+			err:      UnknownInstruction,
+		},
+		{
+			name:     "truncated argument",
+			bytecode: []byte{InstructionMoveR1ToR2, InstructionUint32Load, 0x01, 0x02, 0x03},
+			offset:   1,
+			err:      InvalidInstructionArgument,
+		},
+		{
+			name:     "invalid varint",
+			bytecode: []byte{InstructionVarLoad, 0x80},
+			offset:   0,
+			err:      InvalidInstructionArgument,
+		},
+		{
+			name:     "jump out of bounds",
+			bytecode: []byte{InstructionMoveR1ToR2, InstructionJmp32, 0x06, 0x00, 0x00, 0x00},
+			offset:   1,
+			err:      InvalidMemoryLocation,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vm := NewVM(0, 0)
+			err := vm.LoadProgram(tt.bytecode)
+			if !errors.Is(err, tt.err) {
+				t.Fatal("expected", tt.err, "got:", err)
+			}
+			var vmErr *VMError
+			if !errors.As(err, &vmErr) || vmErr.Offset != tt.offset {
+				t.Fatal("wrong offset:", err)
+			}
+			if err := vm.Run(); !errors.Is(err, NoProgramLoaded) {
+				t.Fatal("expected no program loaded, got:", err)
+			}
+		})
+	}
+}
+
+func TestVM_Run(t *testing.T) {
+	// Running with no program loaded errors.
+	vm := NewVM(8, 0)
+	if err := vm.Run(); !errors.Is(err, NoProgramLoaded) {
+		t.Fatal("expected no program loaded, got:", err)
+	}
+
+	// Load a program which adds 2 to the uint64 at memory location 0.
+	program := []byte{
+		InstructionMemoryUint64Load, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint8LoadR2Direct, 0x02,
+		InstructionUnsignedAdd,
+		InstructionUint64Dump, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	if err := vm.LoadProgram(program); err != nil {
+		t.Fatal(err)
+	}
+
+	// Run it multiple times.
+	for i := uint64(1); i <= 3; i++ {
+		if err := vm.Run(); err != nil {
+			t.Fatal(err)
+		}
+		if x := getUint64(vm.Memory, 0); x != i*2 {
+			t.Fatal("not", i*2, "-", x)
+		}
+		if vm.PC != uint64(len(program)) {
+			t.Fatal("pc not at the end:", vm.PC)
+		}
+	}
+
+	// Run from the add instruction.
+	vm.Registers[0] = 10
+	if err := vm.RunFrom(11); err != nil {
+		t.Fatal(err)
+	}
+	if x := getUint64(vm.Memory, 0); x != 12 {
+		t.Fatal("not 12:", x)
+	}
+
+	// Running from past the end errors.
+	if err := vm.RunFrom(uint64(len(program)) + 1); !errors.Is(err, InvalidMemoryLocation) {
+		t.Fatal("expected invalid memory location, got:", err)
+	}
+}
@@ -0,0 +1,496 @@
+package gomachine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ReplayDiverged is returned by Replay when the bytecode makes different system calls to the ones recorded.
+var ReplayDiverged = errors.New("replay diverged from the recording")
+
+// InvalidRecording is returned by UnmarshalBinary when the data isn't a recording of a version which is supported.
+var InvalidRecording = errors.New("invalid recording")
+
+// RecordingUnsupported is returned by Record for paged virtual machines, which can't be recorded.
+var RecordingUnsupported = errors.New("recording is not supported for paged memory")
+
+// recordingMagic is used to start every serialized recording.
+const recordingMagic = "GMREC"
+
+// recordingVersion is the version of the serialized recording format.
+const recordingVersion = 1
+
+// MemoryDelta is used to represent a run of bytes a system call changed.
+type MemoryDelta struct {
+	// Address is the memory location of the first byte.
+	Address uint64
+
+	// Data is the bytes after the system call.
+	Data []byte
+}
+
+// RecordedSyscall is used to represent the effects of a system call made while recording.
+type RecordedSyscall struct {
+	// Number is the system call number.
+	Number uint64
+
+	// Offset is the bytecode index of the system call instruction.
+	Offset uint64
+
+	// Registers is the registers after the system call.
+	Registers [8]uint64
+
+	// MemorySize is the size of the memory after the system call.
+	MemorySize uint64
+
+	// Memory is the bytes the system call changed, including any memory it grew.
+	Memory []MemoryDelta
+
+	// Err is the error the system call returned, or nil if it returned nil.
+	Err *RecordedError
+}
+
+// RecordedErrorKind is used to tell which type of error a recorded system call returned.
+type RecordedErrorKind uint8
+
+const (
+	// RecordedFatal is used for a FatalSyscallError.
+	RecordedFatal RecordedErrorKind = iota + 1
+
+	// RecordedPanic is used for a SyscallPanicError.
+	RecordedPanic
+
+	// RecordedInvalidSyscall is used for a InvalidSyscallError.
+	RecordedInvalidSyscall
+
+	// RecordedDenied is used for a SyscallDeniedError.
+	RecordedDenied
+
+	// RecordedBudget is used for a SyscallBudgetError.
+	RecordedBudget
+)
+
+// RecordedError is used to represent the error a recorded system call returned, so Replay can rebuild a error which
+// errors.Is and errors.As match the same way.
+type RecordedError struct {
+	// Kind is the type of the error.
+	Kind RecordedErrorKind
+
+	// Message is the message of the error wrapped by a FatalSyscallError, or the panic value of a SyscallPanicError.
+	Message string
+
+	// Cause is the message of the error from this package which the error in Message wraps, such as OutOfGas, or a
+	// empty string if it doesn't wrap one.
+	Cause string
+
+	// Count and Global are the fields of a SyscallBudgetError.
+	Count  uint64
+	Global bool
+}
+
+// recordableErrors is the errors from this package which a recorded error can wrap. Errors which wrap others come
+// first so the most specific one is recorded.
+var recordableErrors = []error{
+	DeadlineExceeded, InvalidInstructionArgument, InvalidMemoryLocation, InvalidSyscall, InvalidRegister,
+	CPUTimeExhausted, DivideByZero, UnsupportedISAVersion, UnknownInstruction, FloatUnsupported,
+	InstructionBudgetExhausted, LoopBudgetExhausted, BreakpointHit, Stopped, Paused, Yielded, VMBusy, OutOfGas,
+	OutOfMemory, MemoryQuotaExceeded, MemoryWriteProtected, IORangeOverlap, IORangeStraddled, NotInInterrupt,
+	SyscallBudgetExhausted, SyscallDenied, ReplayDiverged, InvalidRecording, TooManyArguments,
+}
+
+// recordCause is used to get the message of the error from this package which the error wraps.
+func recordCause(Err error) string {
+	for _, cause := range recordableErrors {
+		if errors.Is(Err, cause) {
+			return cause.Error()
+		}
+	}
+	return ""
+}
+
+// recordError is used to turn the error returned for a system call into a RecordedError.
+func recordError(Err error) *RecordedError {
+	switch err := Err.(type) {
+	case nil:
+		return nil
+	case *SyscallPanicError:
+		e := &RecordedError{Kind: RecordedPanic, Message: fmt.Sprint(err.Value)}
+		if value, ok := err.Value.(error); ok {
+			e.Cause = recordCause(value)
+		}
+		return e
+	case *InvalidSyscallError:
+		return &RecordedError{Kind: RecordedInvalidSyscall}
+	case *SyscallDeniedError:
+		return &RecordedError{Kind: RecordedDenied}
+	case *SyscallBudgetError:
+		return &RecordedError{Kind: RecordedBudget, Count: err.Count, Global: err.Global}
+	case *FatalSyscallError:
+		return &RecordedError{Kind: RecordedFatal, Message: err.Err.Error(), Cause: recordCause(err.Err)}
+	default:
+		return &RecordedError{Kind: RecordedFatal, Message: err.Error(), Cause: recordCause(err)}
+	}
+}
+
+// replayedError is used to rebuild a recorded error which isn't from this package. It has the recorded message and
+// wraps the error from this package the original wrapped.
+type replayedError struct {
+	message string
+	cause   error
+}
+
+// Error implements the error interface.
+func (e *replayedError) Error() string {
+	return e.message
+}
+
+// Unwrap is used to get the error from this package the original error wrapped.
+func (e *replayedError) Unwrap() error {
+	return e.cause
+}
+
+// message is used to rebuild the error in Message, which is the error from this package it wrapped if the message is
+// the same.
+func (e *RecordedError) message() error {
+	for _, cause := range recordableErrors {
+		if cause.Error() == e.Cause {
+			if e.Message == e.Cause {
+				return cause
+			}
+			return &replayedError{message: e.Message, cause: cause}
+		}
+	}
+	return &replayedError{message: e.Message}
+}
+
+// rebuild is used to get the error for the system call specified back from the recorded error.
+func (e *RecordedError) rebuild(Number, PC uint64) error {
+	switch e.Kind {
+	case RecordedPanic:
+		var value interface{} = e.Message
+		if e.Cause != "" {
+			value = e.message()
+		}
+		return &SyscallPanicError{Number: Number, Value: value}
+	case RecordedInvalidSyscall:
+		return &InvalidSyscallError{Number: Number, PC: PC}
+	case RecordedDenied:
+		return &SyscallDeniedError{Number: Number, PC: PC}
+	case RecordedBudget:
+		return &SyscallBudgetError{Number: Number, Count: e.Count, Global: e.Global}
+	default:
+		return &FatalSyscallError{Err: e.message()}
+	}
+}
+
+// Recording is used to represent a execution which can be replayed without the system calls it made.
+type Recording struct {
+	// Mode is the mode of the virtual machine.
+	Mode Mode
+
+	// Bytecode is the bytecode which was executed.
+	Bytecode []byte
+
+	// Registers is the registers when execution started.
+	Registers [8]uint64
+
+	// RandomState is the state of the InstructionRand generator when execution started.
+	RandomState uint64
+
+	// Memory is the memory when execution started.
+	Memory []byte
+
+	// Syscalls is the system calls made in the order they were made.
+	Syscalls []RecordedSyscall
+}
+
+// Record is used to execute the bytecode like Execute while recording the starting state and the effects of every
+// system call on the registers and memory, so the execution can be replayed later with Replay. System calls which fail
+// are recorded too, including ones which don't exist or are refused by a filter or budget. Memory is compared before
+// and after each system call, so recording is slow for large memories.
+func (v *VM) Record(Bytecode []byte) (*Recording, error) {
+	if v.paged != nil {
+		return nil, RecordingUnsupported
+	}
+	r := &Recording{
+		Mode:        v.mode,
+		Bytecode:    append([]byte(nil), Bytecode...),
+		Registers:   v.Registers,
+		RandomState: v.RandomState,
+		Memory:      append([]byte(nil), v.Memory...),
+	}
+	record := func(Number, Offset uint64, Before []byte, Err error) {
+		r.Syscalls = append(r.Syscalls, RecordedSyscall{
+			Number:     Number,
+			Offset:     Offset,
+			Registers:  v.Registers,
+			MemorySize: uint64(len(v.Memory)),
+			Memory:     memoryDeltas(Before, v.Memory),
+			Err:        recordError(Err),
+		})
+	}
+	v.syscallHook = func(Number uint64, Call func(*VM) error) error {
+		offset := v.PC
+		before := append([]byte(nil), v.Memory...)
+		if Call == nil {
+			err := &InvalidSyscallError{Number: Number, PC: offset}
+			record(Number, offset, before, err)
+			return err
+		}
+
+		// Record a system call which panics before the panic is handled, so the replay fails the same way.
+		defer func() {
+			if r := recover(); r != nil {
+				record(Number, offset, before, &SyscallPanicError{Number: Number, Value: r})
+				panic(r)
+			}
+		}()
+		err := v.syscallResult(Call(v))
+		record(Number, offset, before, err)
+		return err
+	}
+	defer func() {
+		v.syscallHook = nil
+	}()
+	err := v.Execute(Bytecode)
+
+	// System calls refused by a filter or budget don't reach the hook, so they are recorded when execution fails.
+	var vmErr *VMError
+	if errors.As(err, &vmErr) {
+		var deniedErr *SyscallDeniedError
+		var budgetErr *SyscallBudgetError
+		refused := errors.As(vmErr.Err, &deniedErr) || errors.As(vmErr.Err, &budgetErr)
+		if n := len(r.Syscalls); refused && (n == 0 || r.Syscalls[n-1].Err == nil) {
+			record(vmErr.Operand, vmErr.Offset, v.Memory, vmErr.Err)
+		}
+	}
+	return r, err
+}
+
+// memoryDeltas is used to get the runs of bytes which are different in the memory after.
+func memoryDeltas(Before, After []byte) []MemoryDelta {
+	var deltas []MemoryDelta
+	for i := 0; i < len(After); {
+		if i < len(Before) && Before[i] == After[i] {
+			i++
+			continue
+		}
+		start := i
+		for i < len(After) && (i >= len(Before) || Before[i] != After[i]) {
+			i++
+		}
+		deltas = append(deltas, MemoryDelta{Address: uint64(start), Data: append([]byte(nil), After[start:i]...)})
+	}
+	return deltas
+}
+
+// Replay is used to set the registers and memory to how they were when the recording started and execute the
+// recorded bytecode again. Instead of calling the system calls, the recorded changes to the registers and memory are
+// applied and the recorded error is returned. If the bytecode makes a different system call or makes them from a
+// different place, or stops before making every recorded system call, ReplayDiverged is returned. A system call which
+// would make the memory larger than Grow allows returns MemoryQuotaExceeded. Only the registers and memory are
+// replayed, so system calls which stop, pause or raise interrupts don't do so again.
+func (v *VM) Replay(Recording *Recording) error {
+	if v.paged != nil {
+		return RecordingUnsupported
+	}
+	v.mode = Recording.Mode
+	v.Registers = Recording.Registers
+	v.RandomState = Recording.RandomState
+	v.Memory = append([]byte(nil), Recording.Memory...)
+	next := 0
+	v.syscallHook = func(Number uint64, _ func(*VM) error) error {
+		if next == len(Recording.Syscalls) {
+			return &FatalSyscallError{Err: fmt.Errorf("%w: system call %d at offset %d was not recorded", ReplayDiverged, Number, v.PC)}
+		}
+		call := &Recording.Syscalls[next]
+		if call.Number != Number || call.Offset != v.PC {
+			return &FatalSyscallError{Err: fmt.Errorf("%w: expected system call %d at offset %d, got system call %d at offset %d",
+				ReplayDiverged, call.Number, call.Offset, Number, v.PC)}
+		}
+		next++
+
+		// The recording might not be trusted, so the memory size is limited like Grow and the changes have to fit.
+		if !v.withinMemoryLimit(call.MemorySize) {
+			return &FatalSyscallError{Err: fmt.Errorf("%w: system call %d at offset %d sets the memory size to %d",
+				MemoryQuotaExceeded, call.Number, call.Offset, call.MemorySize)}
+		}
+		for _, delta := range call.Memory {
+			if delta.Address > call.MemorySize || uint64(len(delta.Data)) > call.MemorySize-delta.Address {
+				return &FatalSyscallError{Err: fmt.Errorf("%w: system call %d at offset %d changes memory past its size",
+					InvalidRecording, call.Number, call.Offset)}
+			}
+		}
+		v.Registers = call.Registers
+		if call.MemorySize <= uint64(len(v.Memory)) {
+			v.Memory = v.Memory[:call.MemorySize]
+		} else {
+			v.Memory = append(v.Memory, make([]byte, call.MemorySize-uint64(len(v.Memory)))...)
+		}
+		for _, delta := range call.Memory {
+			copy(v.Memory[delta.Address:], delta.Data)
+		}
+		if call.Err != nil {
+			return call.Err.rebuild(call.Number, call.Offset)
+		}
+		return nil
+	}
+	defer func() {
+		v.syscallHook = nil
+	}()
+	err := v.Execute(Recording.Bytecode)
+	if err == nil && next != len(Recording.Syscalls) {
+		return fmt.Errorf("%w: %d recorded system calls were not made", ReplayDiverged, len(Recording.Syscalls)-next)
+	}
+	return err
+}
+
+// appendUvarint is used to append a unsigned varint to the buffer.
+func appendUvarint(b []byte, x uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], x)]...)
+}
+
+// appendBytes is used to append a length prefixed byte slice to the buffer.
+func appendBytes(b, Data []byte) []byte {
+	return append(appendUvarint(b, uint64(len(Data))), Data...)
+}
+
+// MarshalBinary is used to serialize the recording into a versioned binary format.
+func (r *Recording) MarshalBinary() ([]byte, error) {
+	b := append([]byte(recordingMagic), recordingVersion, byte(r.Mode))
+	b = appendBytes(b, r.Bytecode)
+	for _, x := range r.Registers {
+		b = appendUvarint(b, x)
+	}
+	b = appendUvarint(b, r.RandomState)
+	b = appendBytes(b, r.Memory)
+	b = appendUvarint(b, uint64(len(r.Syscalls)))
+	for _, call := range r.Syscalls {
+		b = appendUvarint(b, call.Number)
+		b = appendUvarint(b, call.Offset)
+		for _, x := range call.Registers {
+			b = appendUvarint(b, x)
+		}
+		b = appendUvarint(b, call.MemorySize)
+		b = appendUvarint(b, uint64(len(call.Memory)))
+		for _, delta := range call.Memory {
+			b = appendUvarint(b, delta.Address)
+			b = appendBytes(b, delta.Data)
+		}
+		if call.Err == nil {
+			b = appendUvarint(b, 0)
+			continue
+		}
+		b = appendUvarint(b, uint64(call.Err.Kind))
+		b = appendBytes(b, []byte(call.Err.Message))
+		b = appendBytes(b, []byte(call.Err.Cause))
+		b = appendUvarint(b, call.Err.Count)
+		global := uint64(0)
+		if call.Err.Global {
+			global = 1
+		}
+		b = appendUvarint(b, global)
+	}
+	return b, nil
+}
+
+// recordingReader is used to read the fields of a serialized recording. The first error is kept and every read after
+// it returns zero values.
+type recordingReader struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (d *recordingReader) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	x, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		d.err = InvalidRecording
+	}
+	return x
+}
+
+func (d *recordingReader) bytes() []byte {
+	n := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	if n > uint64(d.r.Len()) {
+		d.err = InvalidRecording
+		return nil
+	}
+	b := make([]byte, n)
+	_, _ = d.r.Read(b)
+	return b
+}
+
+// count is used to read the length of a list, which can't be longer than the bytes left.
+func (d *recordingReader) count() int {
+	n := d.uvarint()
+	if n > uint64(d.r.Len()) {
+		d.err = InvalidRecording
+		return 0
+	}
+	return int(n)
+}
+
+// UnmarshalBinary is used to load a recording serialized by MarshalBinary. InvalidRecording is returned if the data is
+// corrupt or from a version which isn't supported.
+func (r *Recording) UnmarshalBinary(Data []byte) error {
+	if len(Data) < len(recordingMagic)+2 || string(Data[:len(recordingMagic)]) != recordingMagic {
+		return InvalidRecording
+	}
+	if version := Data[len(recordingMagic)]; version != recordingVersion {
+		return fmt.Errorf("%w: version %d is not supported", InvalidRecording, version)
+	}
+	d := &recordingReader{r: bytes.NewReader(Data[len(recordingMagic)+2:])}
+	rec := Recording{Mode: Mode(Data[len(recordingMagic)+1])}
+	rec.Bytecode = d.bytes()
+	for i := range rec.Registers {
+		rec.Registers[i] = d.uvarint()
+	}
+	rec.RandomState = d.uvarint()
+	rec.Memory = d.bytes()
+	n := d.count()
+	for i := 0; i < n && d.err == nil; i++ {
+		call := RecordedSyscall{Number: d.uvarint(), Offset: d.uvarint()}
+		for j := range call.Registers {
+			call.Registers[j] = d.uvarint()
+		}
+		call.MemorySize = d.uvarint()
+		deltas := d.count()
+		for j := 0; j < deltas && d.err == nil; j++ {
+			delta := MemoryDelta{Address: d.uvarint(), Data: d.bytes()}
+			if delta.Address > call.MemorySize || uint64(len(delta.Data)) > call.MemorySize-delta.Address {
+				d.err = InvalidRecording
+			}
+			call.Memory = append(call.Memory, delta)
+		}
+		if kind := d.uvarint(); kind != 0 {
+			if kind > uint64(RecordedBudget) {
+				d.err = InvalidRecording
+			}
+			call.Err = &RecordedError{Kind: RecordedErrorKind(kind), Message: string(d.bytes()), Cause: string(d.bytes())}
+			call.Err.Count = d.uvarint()
+			global := d.uvarint()
+			if global > 1 {
+				d.err = InvalidRecording
+			}
+			call.Err.Global = global == 1
+		}
+		rec.Syscalls = append(rec.Syscalls, call)
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if d.r.Len() != 0 {
+		return InvalidRecording
+	}
+	*r = rec
+	return nil
+}
@@ -0,0 +1,246 @@
+package gomachine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestVM_Record_Replay(t *testing.T) {
+	// Get the time twice, saving the first into memory with a dump and the second from the syscall.
+	bytecode := []byte{
+		InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint64Dump, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint8Load, 0x08,
+		InstructionSyscall, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionRand,
+	}
+	syscalls := map[uint64]func(*VM) error{
+		// Puts the time in nanoseconds in R1.
+		1: func(vm *VM) error {
+			vm.Registers[0] = uint64(time.Now().UnixNano())
+			return nil
+		},
+
+		// Writes the time in nanoseconds to the memory location in R1 and grows the memory by 8 bytes.
+		2: func(vm *VM) error {
+			if _, err := vm.Grow(8); err != nil {
+				return err
+			}
+			return vm.WriteUint64At(vm.Registers[0], uint64(time.Now().UnixNano()))
+		},
+	}
+	vm := NewVM(16, 0)
+	vm.Syscalls = syscalls
+	vm.SeedRandom(42)
+	recording, err := vm.Record(bytecode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recording.Syscalls) != 2 || vm.syscallHook != nil {
+		t.Fatal("wrong recording:", recording.Syscalls)
+	}
+	registers, memory := vm.Registers, append([]byte(nil), vm.Memory...)
+	if len(memory) != 24 {
+		t.Fatal("memory didn't grow:", len(memory))
+	}
+
+	// Replaying the serialized recording later on a virtual machine without the syscalls gives the same result.
+	data, err := recording.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	var loaded Recording
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	replay := NewVM(0, 0)
+	if err := replay.Replay(&loaded); err != nil {
+		t.Fatal(err)
+	}
+	if replay.Registers != registers || !bytes.Equal(replay.Memory, memory) {
+		t.Fatal("replay is different:", replay.Registers, registers, replay.Memory, memory)
+	}
+
+	// Making a different syscall diverges.
+	loaded.Syscalls[1].Number = 3
+	if err := replay.Replay(&loaded); !errors.Is(err, ReplayDiverged) {
+		t.Fatal("expected replay diverged, got:", err)
+	}
+
+	// So does stopping before every syscall is made.
+	loaded.Bytecode = bytecode[:9]
+	if err := replay.Replay(&loaded); !errors.Is(err, ReplayDiverged) {
+		t.Fatal("expected replay diverged, got:", err)
+	}
+
+	// Recorded errors are returned again.
+	vm.Syscalls = map[uint64]func(*VM) error{
+		1: func(*VM) error {
+			return &FatalSyscallError{Err: errors.New("device missing")}
+		},
+	}
+	recording, err = vm.Record(bytecode[:9])
+	if err == nil || len(recording.Syscalls) != 1 {
+		t.Fatal("expected error, got:", err)
+	}
+	var vmErr *VMError
+	if err := replay.Replay(recording); !errors.As(err, &vmErr) || vmErr.Err.Error() != "device missing" {
+		t.Fatal("expected device missing, got:", err)
+	}
+}
+
+func TestVM_Record_Replay_Errors(t *testing.T) {
+	bytecode := []byte{InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	tests := []struct {
+		name  string
+		setup func(vm *VM)
+	}{
+		{"out of gas", func(vm *VM) {
+			vm.CostTable = CostTable{InstructionSyscall: 0}
+			vm.Syscalls[1] = func(vm *VM) error { return vm.ChargeGas(1) }
+		}},
+		{"wrapped", func(vm *VM) {
+			vm.Syscalls[1] = func(*VM) error {
+				return &FatalSyscallError{Err: fmt.Errorf("growing: %w", MemoryQuotaExceeded)}
+			}
+		}},
+		{"invalid", func(*VM) {}},
+		{"panic", func(vm *VM) {
+			vm.Syscalls[1] = func(vm *VM) error {
+				vm.Registers[0] = 7
+				panic("boom")
+			}
+		}},
+		{"panic error", func(vm *VM) {
+			vm.Syscalls[1] = func(*VM) error { panic(fmt.Errorf("boom: %w", OutOfMemory)) }
+		}},
+		{"budget", func(vm *VM) {
+			vm.Syscalls[1] = func(*VM) error { return nil }
+			vm.SetSyscallBudget(1, 0)
+		}},
+		{"denied", func(vm *VM) {
+			vm.Syscalls[1] = func(*VM) error { return nil }
+			vm.PushSyscallFilter(DenySyscalls(1))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vm := NewVM(0, 0)
+			tt.setup(vm)
+			recording, recorded := vm.Record(bytecode)
+			if recorded == nil {
+				t.Fatal("expected error")
+			}
+
+			// The serialized recording is replayed on a virtual machine without the syscalls or limits.
+			data, err := recording.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var loaded Recording
+			if err := loaded.UnmarshalBinary(data); err != nil {
+				t.Fatal(err)
+			}
+			replay := NewVM(0, 0)
+			replayed := replay.Replay(&loaded)
+			if replayed == nil || replayed.Error() != recorded.Error() {
+				t.Fatal("different error:", replayed, recorded)
+			}
+			if replay.Registers != vm.Registers {
+				t.Fatal("different registers:", replay.Registers, vm.Registers)
+			}
+			for _, target := range []error{
+				OutOfGas, MemoryQuotaExceeded, OutOfMemory, InvalidSyscall, SyscallBudgetExhausted, SyscallDenied,
+				ReplayDiverged,
+			} {
+				if errors.Is(replayed, target) != errors.Is(recorded, target) {
+					t.Fatal("errors.Is is different for", target)
+				}
+			}
+			var fatal, fatalReplayed *FatalSyscallError
+			var invalid, invalidReplayed *InvalidSyscallError
+			var budget, budgetReplayed *SyscallBudgetError
+			var denied, deniedReplayed *SyscallDeniedError
+			var panicked, panickedReplayed *SyscallPanicError
+			if errors.As(recorded, &fatal) != errors.As(replayed, &fatalReplayed) ||
+				errors.As(recorded, &invalid) != errors.As(replayed, &invalidReplayed) ||
+				errors.As(recorded, &budget) != errors.As(replayed, &budgetReplayed) ||
+				errors.As(recorded, &denied) != errors.As(replayed, &deniedReplayed) ||
+				errors.As(recorded, &panicked) != errors.As(replayed, &panickedReplayed) {
+				t.Fatal("errors.As is different:", recorded, replayed)
+			}
+			if budget != nil && *budget != *budgetReplayed {
+				t.Fatal("budget error is different:", *budget, *budgetReplayed)
+			}
+		})
+	}
+}
+
+func TestRecording_UnmarshalBinary(t *testing.T) {
+	recording := &Recording{
+		Bytecode: []byte{InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		Memory:   []byte{1, 2, 3},
+		Syscalls: []RecordedSyscall{{
+			Number: 1, MemorySize: 3, Memory: []MemoryDelta{{Address: 1, Data: []byte{9}}},
+			Err: &RecordedError{Kind: RecordedFatal, Message: "failed", Cause: OutOfGas.Error()},
+		}},
+	}
+	data, err := recording.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// It loads back the same.
+	var loaded Recording
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&loaded, recording) {
+		t.Fatal("different recording:", loaded)
+	}
+
+	// Every truncation is invalid.
+	for i := 0; i < len(data); i++ {
+		var r Recording
+		if err := r.UnmarshalBinary(data[:i]); !errors.Is(err, InvalidRecording) {
+			t.Fatal("expected invalid recording at", i, "got:", err)
+		}
+	}
+
+	// A recording which makes the memory too large is refused when it is replayed rather than allocating it.
+	huge := *recording
+	huge.Syscalls = []RecordedSyscall{{Number: 1, MemorySize: 1 << 50}}
+	hugeData, err := huge.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.UnmarshalBinary(hugeData); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewVM(0, 0).Replay(&loaded); !errors.Is(err, MemoryQuotaExceeded) {
+		t.Fatal("expected memory quota exceeded, got:", err)
+	}
+	vm := NewVM(0, 0)
+	vm.MaxMemory = 2
+	if err := vm.Replay(recording); !errors.Is(err, MemoryQuotaExceeded) {
+		t.Fatal("expected memory quota exceeded, got:", err)
+	}
+
+	// So is one built by hand which changes memory past its size.
+	huge.Syscalls = []RecordedSyscall{{Number: 1, MemorySize: 3, Memory: []MemoryDelta{{Address: 2, Data: []byte{1, 2}}}}}
+	if err := NewVM(0, 0).Replay(&huge); !errors.Is(err, InvalidRecording) {
+		t.Fatal("expected invalid recording, got:", err)
+	}
+
+	// So are other versions.
+	data[len(recordingMagic)] = recordingVersion + 1
+	var r Recording
+	if err := r.UnmarshalBinary(data); !errors.Is(err, InvalidRecording) {
+		t.Fatal("expected invalid recording, got:", err)
+	}
+}
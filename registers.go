@@ -0,0 +1,33 @@
+package gomachine
+
+// Register is used to name a register of the virtual CPU.
+type Register uint8
+
+// Defines the registers. R4 holds the flags set by instructions such as division.
+const (
+	R1 Register = iota
+	R2
+	R3
+	R4
+	R5
+	R6
+	R7
+	R8
+)
+
+// GetRegister is used to get the value of a register. InvalidRegister is returned if the register doesn't exist.
+func (v *VM) GetRegister(Register Register) (uint64, error) {
+	if int(Register) >= len(v.Registers) {
+		return 0, InvalidRegister
+	}
+	return v.Registers[Register], nil
+}
+
+// SetRegister is used to set the value of a register. InvalidRegister is returned if the register doesn't exist.
+func (v *VM) SetRegister(Register Register, Value uint64) error {
+	if int(Register) >= len(v.Registers) {
+		return InvalidRegister
+	}
+	v.Registers[Register] = Value
+	return nil
+}
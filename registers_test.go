@@ -0,0 +1,34 @@
+package gomachine
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVM_GetRegister_SetRegister(t *testing.T) {
+	vm := NewVM(0, 0)
+	registers := []Register{R1, R2, R3, R4, R5, R6, R7, R8}
+	for i, register := range registers {
+		if err := vm.SetRegister(register, uint64(i)+100); err != nil {
+			t.Fatal(err)
+		}
+		if vm.Registers[i] != uint64(i)+100 {
+			t.Fatalf("R%d is not register %d", i+1, i)
+		}
+		x, err := vm.GetRegister(register)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if x != uint64(i)+100 {
+			t.Fatal("unexpected value:", x)
+		}
+	}
+
+	// Registers past the end of the register file are invalid.
+	if _, err := vm.GetRegister(R8 + 1); !errors.Is(err, InvalidRegister) {
+		t.Fatal("expected invalid register, got:", err)
+	}
+	if err := vm.SetRegister(255, 1); !errors.Is(err, InvalidRegister) {
+		t.Fatal("expected invalid register, got:", err)
+	}
+}
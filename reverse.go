@@ -0,0 +1,125 @@
+package gomachine
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// NoHistory is returned by StepBack when there are no instructions in the reverse journal to undo.
+var NoHistory = errors.New("no instructions to step back over")
+
+// reverseEntry is the state before a instruction ran and the bytes of memory it wrote.
+type reverseEntry struct {
+	pc           uint64
+	registers    [8]uint64
+	randomState  uint64
+	gasRemaining uint64
+	memorySize   uint64
+	inInterrupt  bool
+	interruptPC  uint64
+	writes       []journalEntry
+}
+
+// reverseJournal is a ring buffer of the last instructions run, used by StepBack.
+type reverseJournal struct {
+	entries []reverseEntry
+	start   int
+	n       int
+}
+
+// WithReverseJournal is used to keep the state before each of the last instructions run, up to the depth specified,
+// so StepBack can undo them. Memory accesses take the slow path while the journal is on.
+func WithReverseJournal(Depth int) Option {
+	return func(v *VM) {
+		if Depth <= 0 {
+			v.reverse = nil
+			return
+		}
+		v.reverse = &reverseJournal{entries: make([]reverseEntry, Depth)}
+	}
+}
+
+// WithIrreversibleSyscalls is used to mark system calls which can't be undone, such as ones which do io. Running one
+// clears the reverse journal, so StepBack can't go back past it.
+func WithIrreversibleSyscalls(Syscalls ...uint64) Option {
+	return func(v *VM) {
+		if v.irreversibleSyscalls == nil {
+			v.irreversibleSyscalls = map[uint64]bool{}
+		}
+		for _, syscall := range Syscalls {
+			v.irreversibleSyscalls[syscall] = true
+		}
+	}
+}
+
+// push is used to add the state before the instruction at the PC specified runs, replacing the oldest entry when the
+// journal is full.
+func (j *reverseJournal) push(v *VM, PC uint64) {
+	i := (j.start + j.n) % len(j.entries)
+	if j.n == len(j.entries) {
+		j.start = (j.start + 1) % len(j.entries)
+	} else {
+		j.n++
+	}
+	e := &j.entries[i]
+	*e = reverseEntry{
+		pc:           PC,
+		registers:    v.Registers,
+		randomState:  v.RandomState,
+		gasRemaining: v.GasRemaining,
+		memorySize:   v.memorySize(),
+		inInterrupt:  v.inInterrupt,
+		interruptPC:  v.interruptPC,
+		writes:       e.writes[:0],
+	}
+}
+
+// record is used to add the bytes about to be written to the latest entry.
+func (j *reverseJournal) record(v *VM, Address, Width uint64) {
+	if j.n == 0 {
+		return
+	}
+	e := &j.entries[(j.start+j.n-1)%len(j.entries)]
+	for i := uint64(0); i < Width; i++ {
+		e.writes = append(e.writes, journalEntry{address: Address + i, old: v.currentByte(Address + i)})
+	}
+}
+
+// clear is used to remove every entry from the journal.
+func (j *reverseJournal) clear() {
+	j.start, j.n = 0, 0
+}
+
+// StepBack is used to undo the last instruction run while WithReverseJournal was on, restoring the registers, PC,
+// memory it wrote, random state and gas. It can be called repeatedly to go back as many instructions as the journal
+// holds, and NoHistory is returned once there are none left or the journal is off. Memory written by system calls is
+// only undone if they used the host write helpers, and irreversible system calls can't be stepped back over.
+func (v *VM) StepBack() error {
+	if atomic.LoadUint32(&v.running) != 0 {
+		return VMBusy
+	}
+	j := v.reverse
+	if j == nil || j.n == 0 {
+		return NoHistory
+	}
+	j.n--
+	e := &j.entries[(j.start+j.n)%len(j.entries)]
+	for i := len(e.writes) - 1; i >= 0; i-- {
+		v.restoreByte(e.writes[i])
+	}
+	if v.paged != nil {
+		v.paged.size = e.memorySize
+	} else if uint64(len(v.Memory)) > e.memorySize {
+		v.Memory = v.Memory[:e.memorySize]
+	}
+	v.PC = e.pc
+	v.Registers = e.registers
+	v.RandomState = e.randomState
+	v.GasRemaining = e.gasRemaining
+	v.inInterrupt = e.inInterrupt
+	v.interruptPC = e.interruptPC
+
+	// The instruction at the PC has to run next even if it is a breakpoint.
+	v.atBreakpoint = true
+	return nil
+}
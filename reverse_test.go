@@ -0,0 +1,111 @@
+package gomachine
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestVM_StepBack(t *testing.T) {
+	// Count R1 up to 5, dumping each value to memory, then save a random number and make a syscall which writes memory.
+	bytecode := []byte{
+		InstructionUint8LoadR3Direct, 0x05,
+		InstructionUint8LoadR2Direct, 0x01,
+		InstructionUnsignedAdd,
+		InstructionUint8Dump, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionJmpIfLt, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionRand,
+		InstructionUint64Dump, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	newVM := func(Options ...Option) *VM {
+		vm := NewVM(24, 0, Options...)
+		vm.SeedRandom(1)
+		vm.Syscalls = map[uint64]func(*VM) error{
+			1: func(vm *VM) error {
+				return vm.WriteUint8At(16, uint8(vm.Registers[0]))
+			},
+		}
+		return vm
+	}
+	straight := newVM()
+	if err := straight.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+
+	// Step forward ten instructions, back five and forward again to the end.
+	vm := newVM(WithReverseJournal(32))
+	for i := 0; i < 10; i++ {
+		if err := vm.Step(bytecode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	registers, memory, pc := vm.Registers, append([]byte(nil), vm.Memory...), vm.PC
+	for i := 0; i < 5; i++ {
+		if err := vm.StepBack(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if vm.Registers[0] != 1 || vm.Memory[0] != 1 || vm.PC != 4 {
+		t.Fatal("wrong state after stepping back:", vm.Registers[0], vm.Memory[0])
+	}
+	for i := 0; i < 5; i++ {
+		if err := vm.Step(bytecode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if vm.Registers != registers || !bytes.Equal(vm.Memory, memory) || vm.PC != pc {
+		t.Fatal("stepping forward again is different:", vm.Registers, registers, vm.PC, pc)
+	}
+	for {
+		err := vm.Step(bytecode)
+		if errors.Is(err, ProgramComplete) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if vm.Registers != straight.Registers || !bytes.Equal(vm.Memory, straight.Memory) ||
+		vm.RandomState != straight.RandomState {
+		t.Fatal("different to a straight run:", vm.Registers, straight.Registers, vm.Memory, straight.Memory)
+	}
+
+	// Stepping back over the syscall and the random number undoes them.
+	for i := 0; i < 3; i++ {
+		if err := vm.StepBack(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if vm.Memory[16] != 0 || getUint64(vm.Memory, 8) != 0 || vm.RandomState != 1 {
+		t.Fatal("syscall and random number weren't undone:", vm.Memory)
+	}
+
+	// The journal only goes back as far as its depth.
+	vm = newVM(WithReverseJournal(4))
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		if err := vm.StepBack(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := vm.StepBack(); !errors.Is(err, NoHistory) {
+		t.Fatal("expected no history, got:", err)
+	}
+
+	// Irreversible syscalls are a barrier.
+	vm = newVM(WithReverseJournal(32), WithIrreversibleSyscalls(1))
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.StepBack(); !errors.Is(err, NoHistory) {
+		t.Fatal("expected no history, got:", err)
+	}
+
+	// Without the journal there is nothing to step back over.
+	if err := straight.StepBack(); !errors.Is(err, NoHistory) {
+		t.Fatal("expected no history, got:", err)
+	}
+}
@@ -0,0 +1,176 @@
+package gomachine
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// SchedulerOption is used to configure a scheduler created by NewScheduler.
+type SchedulerOption func(*Scheduler)
+
+// WithSliceInstructions is used to set the number of instructions each virtual machine runs before the next one gets a
+// turn. The default is 10000.
+func WithSliceInstructions(Instructions uint64) SchedulerOption {
+	return func(s *Scheduler) {
+		s.sliceInstructions = Instructions
+	}
+}
+
+// WithSliceDuration is used to also pause each virtual machine after it has run for the duration specified, so bytecode
+// with slow system calls can't hold on to a worker for a whole instruction slice. 0 turns it off, which is the default.
+func WithSliceDuration(Duration time.Duration) SchedulerOption {
+	return func(s *Scheduler) {
+		s.sliceDuration = Duration
+	}
+}
+
+// WithWorkers is used to set the number of goroutines running virtual machines. The default is 1, which runs them on
+// the goroutine calling Run.
+func WithWorkers(Workers int) SchedulerOption {
+	return func(s *Scheduler) {
+		s.workers = Workers
+	}
+}
+
+// WithResultHandler is used to set the function called when a virtual machine finishes. The error is nil if the
+// bytecode ran to the end. Calls are never made at the same time, even with more than one worker.
+func WithResultHandler(Handler func(VM *VM, Err error)) SchedulerOption {
+	return func(s *Scheduler) {
+		s.onResult = Handler
+	}
+}
+
+// schedulerTask is a virtual machine added to a scheduler and the bytecode it runs.
+type schedulerTask struct {
+	vm       *VM
+	bytecode []byte
+}
+
+// Scheduler is used to run many virtual machines by giving each a slice of instructions in turn. Yields and pauses
+// give up the rest of the slice, and virtual machines are removed when they finish or return any other error.
+type Scheduler struct {
+	sliceInstructions uint64
+	sliceDuration     time.Duration
+	workers           int
+	onResult          func(VM *VM, Err error)
+
+	// mu protects tasks and calls to onResult.
+	mu    sync.Mutex
+	tasks []*schedulerTask
+}
+
+// NewScheduler is used to create a scheduler with the options specified.
+func NewScheduler(Options ...SchedulerOption) *Scheduler {
+	s := &Scheduler{sliceInstructions: 10000, workers: 1}
+	for _, option := range Options {
+		option(s)
+	}
+	if s.workers < 1 {
+		s.workers = 1
+	}
+	if s.sliceInstructions == 0 {
+		s.sliceInstructions = 10000
+	}
+	return s
+}
+
+// Add is used to add a virtual machine to the scheduler. The bytecode is run from the start on the next call to Run.
+// Each virtual machine must only be added once.
+func (s *Scheduler) Add(VM *VM, Bytecode []byte) {
+	VM.PC = 0
+	s.mu.Lock()
+	s.tasks = append(s.tasks, &schedulerTask{vm: VM, bytecode: Bytecode})
+	s.mu.Unlock()
+}
+
+// Len is used to get the number of virtual machines which haven't finished.
+func (s *Scheduler) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.tasks)
+}
+
+// Run is used to run the virtual machines until they have all finished or the context is done. If the context is done,
+// its error is returned and the virtual machines which haven't finished are kept, so calling Run again carries on
+// running them. Virtual machines must not be added while Run is running.
+func (s *Scheduler) Run(ctx context.Context) error {
+	s.mu.Lock()
+	tasks := s.tasks
+	s.tasks = nil
+	s.mu.Unlock()
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	// Every task is either in the queue or being run by a worker, so the queue never fills up.
+	queue := make(chan *schedulerTask, len(tasks))
+	for _, t := range tasks {
+		queue <- t
+	}
+	var wg sync.WaitGroup
+	remaining := len(tasks)
+	worker := func() {
+		defer wg.Done()
+		for ctx.Err() == nil {
+			var t *schedulerTask
+			select {
+			case <-ctx.Done():
+				return
+			case t = <-queue:
+			}
+			if t == nil {
+				// The queue was closed as every task finished.
+				return
+			}
+			if done, err := s.slice(t); done {
+				s.mu.Lock()
+				if s.onResult != nil {
+					s.onResult(t.vm, err)
+				}
+				remaining--
+				if remaining == 0 {
+					close(queue)
+				}
+				s.mu.Unlock()
+				continue
+			}
+			queue <- t
+		}
+	}
+	wg.Add(s.workers)
+	for i := 1; i < s.workers; i++ {
+		go worker()
+	}
+	worker()
+	wg.Wait()
+
+	// Keep the tasks which haven't finished for the next run.
+	if err := ctx.Err(); err != nil {
+		s.mu.Lock()
+		for len(queue) != 0 {
+			s.tasks = append(s.tasks, <-queue)
+		}
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// slice is used to run a slice of the task and get if the task has finished and the error it finished with.
+func (s *Scheduler) slice(t *schedulerTask) (bool, error) {
+	var timer *time.Timer
+	if s.sliceDuration != 0 {
+		timer = time.AfterFunc(s.sliceDuration, t.vm.Pause)
+	}
+	_, err := t.vm.ExecuteN(t.bytecode, s.sliceInstructions)
+	if timer != nil {
+		timer.Stop()
+	}
+	if err == nil || errors.Is(err, Yielded) || errors.Is(err, Paused) {
+		// The slice ran out or was given up. A yield can be the last instruction.
+		return t.vm.PC == uint64(len(t.bytecode)), nil
+	}
+	return true, err
+}
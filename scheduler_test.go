@@ -0,0 +1,117 @@
+package gomachine
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// countTo is used to build bytecode which counts R1 up to the number specified.
+func countTo(N uint32) []byte {
+	return []byte{
+		InstructionUint32Load, uint8(N), uint8(N >> 8), uint8(N >> 16), uint8(N >> 24),
+		InstructionMoveR1ToR3,
+		InstructionUint8Load, 0x00,
+		InstructionUint8LoadR2Direct, 0x01,
+		InstructionUnsignedAdd,
+		InstructionJmpIfLt, 0x0A, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+}
+
+func TestScheduler_Run(t *testing.T) {
+	for _, workers := range []int{1, 4} {
+		// Add the longest loops first so they finish in order of length only if they are interleaved.
+		var mu sync.Mutex
+		var finished []uint64
+		errored := 0
+		s := NewScheduler(WithSliceInstructions(100), WithWorkers(workers), WithResultHandler(func(vm *VM, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errored++
+				return
+			}
+			finished = append(finished, vm.Registers[0])
+		}))
+		lengths := []uint32{100000, 20000, 5000, 1000}
+		for _, n := range lengths {
+			s.Add(NewVM(0, 0), countTo(n))
+		}
+
+		// A vm which errors is removed.
+		s.Add(NewVM(0, 0), []byte{0x00})
+		if err := s.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if s.Len() != 0 || len(finished) != 4 || errored != 1 {
+			t.Fatal("not every vm finished:", s.Len(), finished, errored)
+		}
+		if workers == 1 {
+			expected := []uint64{1000, 5000, 20000, 100000}
+			for i, x := range expected {
+				if finished[i] != x {
+					t.Fatal("finished in the wrong order:", finished)
+				}
+			}
+		}
+	}
+}
+
+func TestScheduler_Run_Yield(t *testing.T) {
+	// Each vm yields straight away, so they take turns.
+	var order []int
+	s := NewScheduler()
+	for i := 0; i < 3; i++ {
+		i := i
+		vm := NewVM(0, 0)
+		vm.Syscalls = map[uint64]func(*VM) error{
+			1: func(*VM) error {
+				order = append(order, i)
+				return nil
+			},
+		}
+		s.Add(vm, []byte{
+			InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			InstructionYield,
+			InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			InstructionYield,
+		})
+	}
+	if err := s.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expected := []int{0, 1, 2, 0, 1, 2}
+	for i, x := range expected {
+		if order[i] != x {
+			t.Fatal("wrong order:", order)
+		}
+	}
+}
+
+func TestScheduler_Run_Cancel(t *testing.T) {
+	// Cancel from the first vm's syscall. The vms are kept and the next run finishes them.
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewScheduler(WithSliceInstructions(1))
+	vm := NewVM(0, 0)
+	vm.Syscalls = map[uint64]func(*VM) error{
+		1: func(*VM) error {
+			cancel()
+			return nil
+		},
+	}
+	s.Add(vm, []byte{InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, InstructionUint8Load, 0x01})
+	s.Add(NewVM(0, 0), countTo(10))
+	if err := s.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatal("expected canceled, got:", err)
+	}
+	if s.Len() != 2 {
+		t.Fatal("not 2:", s.Len())
+	}
+	if err := s.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 0 || vm.Registers[0] != 1 {
+		t.Fatal("wrong state:", s.Len(), vm.Registers[0])
+	}
+}
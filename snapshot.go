@@ -0,0 +1,28 @@
+package gomachine
+
+// RegisterSnapshot is used to represent a copy of the registers taken at a instruction boundary.
+type RegisterSnapshot struct {
+	// Registers is a copy of the CPU registers.
+	Registers [8]uint64
+
+	// PC is the bytecode index of the instruction which would run next.
+	PC uint64
+
+	// Instructions is the number of instructions the execution had run when the snapshot was taken.
+	Instructions uint64
+}
+
+// publish is used to store a snapshot of the registers for Snapshot. It must only be called by the goroutine executing
+// the virtual machine.
+func (v *VM) publish(PC, Instructions uint64) {
+	v.snapshot.Store(RegisterSnapshot{Registers: v.Registers, PC: PC, Instructions: Instructions})
+}
+
+// Snapshot is used to get a copy of the registers which is safe to take from any goroutine, including while the virtual
+// machine is executing. The copy is published when execution starts, every CheckInterval instructions, before every
+// system call and when execution returns, so while the virtual machine is running it is at most CheckInterval
+// instructions behind. If the virtual machine has never executed, the zero RegisterSnapshot is returned.
+func (v *VM) Snapshot() RegisterSnapshot {
+	snapshot, _ := v.snapshot.Load().(RegisterSnapshot)
+	return snapshot
+}
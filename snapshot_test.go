@@ -0,0 +1,51 @@
+package gomachine
+
+import (
+	"testing"
+)
+
+func TestVM_Snapshot(t *testing.T) {
+	// A vm which has never run has a empty snapshot.
+	vm := NewVM(0, 0)
+	if s := vm.Snapshot(); s != (RegisterSnapshot{}) {
+		t.Fatal("snapshot not empty:", s)
+	}
+
+	// Count R1 up to 1,000,000 while snapshotting from this goroutine.
+	bytecode := []byte{
+		InstructionUint64LoadR3Direct, 0x40, 0x42, 0x0F, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint8LoadR2Direct, 0x01,
+		InstructionUnsignedAdd,
+		InstructionJmpIfLt, 0x0B, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	done := make(chan error)
+	go func() { done <- vm.Execute(bytecode) }()
+	last := uint64(0)
+	snapshots := 0
+	for running := true; running; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			running = false
+		default:
+		}
+		s := vm.Snapshot()
+		if s.Registers[0] < last {
+			t.Fatal("counter went backwards:", last, "to", s.Registers[0])
+		}
+		last = s.Registers[0]
+		snapshots++
+	}
+
+	// The final snapshot has the final registers.
+	s := vm.Snapshot()
+	if s.Registers[0] != 1000000 || s.PC != uint64(len(bytecode)) {
+		t.Fatal("final snapshot wrong:", s)
+	}
+	if s.Instructions != 2+2*1000000 {
+		t.Fatal("wrong instruction count:", s.Instructions)
+	}
+	t.Log("took", snapshots, "snapshots")
+}
@@ -0,0 +1,63 @@
+package gomachine
+
+import "time"
+
+// ExecutionStats is used to represent counters collected during a execution when CollectStats is set.
+type ExecutionStats struct {
+	// Instructions is the number of instructions executed, including the instruction which failed if there was a error.
+	Instructions uint64
+
+	// WallTime is the wall clock time the execution took, including system calls.
+	WallTime time.Duration
+
+	// Syscalls is the number of system call instructions executed.
+	Syscalls uint64
+
+	// MemoryReads is the number of instructions executed which read memory.
+	MemoryReads uint64
+
+	// MemoryWrites is the number of instructions executed which write to memory.
+	MemoryWrites uint64
+
+	// JumpsTaken is the number of jumps which went to their target. Conditional jumps which fall through aren't
+	// counted.
+	JumpsTaken uint64
+}
+
+// memoryReadOpcodes is used to define the instructions which read memory.
+var memoryReadOpcodes = []uint8{
+	InstructionMemoryUint8Load, InstructionMemoryUint16Load, InstructionMemoryUint32Load, InstructionMemoryUint64Load,
+	InstructionMemoryFloat32Load, InstructionMemorySum64, InstructionMemoryCRC32,
+	InstructionMemoryUint8Load32, InstructionMemoryUint16Load32, InstructionMemoryUint32Load32,
+	InstructionMemoryUint64Load32, InstructionMemoryVarLoad64,
+}
+
+// memoryWriteOpcodes is used to define the instructions which write to memory.
+var memoryWriteOpcodes = []uint8{
+	InstructionUint8Dump, InstructionUint16Dump, InstructionUint32Dump, InstructionUint64Dump, InstructionFloat32Dump,
+	InstructionUint8Dump32, InstructionUint16Dump32, InstructionUint32Dump32, InstructionUint64Dump32,
+}
+
+// recordStats is used to set the stats returned by LastStats from the number of times each opcode was executed.
+func (v *VM) recordStats(OpcodeCounts *[256]uint64, JumpsTaken uint64, WallTime time.Duration) {
+	stats := ExecutionStats{
+		WallTime:   WallTime,
+		Syscalls:   OpcodeCounts[InstructionSyscall] + OpcodeCounts[InstructionSyscallR1],
+		JumpsTaken: JumpsTaken,
+	}
+	for _, count := range OpcodeCounts {
+		stats.Instructions += count
+	}
+	for _, opcode := range memoryReadOpcodes {
+		stats.MemoryReads += OpcodeCounts[opcode]
+	}
+	for _, opcode := range memoryWriteOpcodes {
+		stats.MemoryWrites += OpcodeCounts[opcode]
+	}
+	v.lastStats = stats
+}
+
+// LastStats is used to get the counters collected during the last execution which ran with CollectStats set.
+func (v *VM) LastStats() ExecutionStats {
+	return v.lastStats
+}
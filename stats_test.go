@@ -0,0 +1,53 @@
+package gomachine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVM_LastStats(t *testing.T) {
+	// Count R1 up to 3, saving it to memory, loading it back and making a syscall each time round.
+	bytecode := []byte{
+		InstructionUint8LoadR3Direct, 0x03,
+		InstructionUint8LoadR2Direct, 0x01,
+		InstructionUnsignedAdd,
+		InstructionUint8Dump, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionMemoryUint8Load, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionJmpIfLt, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	vm := NewVM(8, 0)
+	vm.Syscalls = map[uint64]func(*VM) error{
+		1: func(*VM) error {
+			time.Sleep(time.Millisecond)
+			return nil
+		},
+	}
+
+	// Nothing is collected by default.
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	if vm.LastStats() != (ExecutionStats{}) {
+		t.Fatal("stats were collected:", vm.LastStats())
+	}
+
+	// The loop runs 3 times and jumps back twice.
+	vm.ClearRegisters()
+	vm.CollectStats = true
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	stats := vm.LastStats()
+	if stats.WallTime < 3*time.Millisecond {
+		t.Fatal("wall time too short:", stats.WallTime)
+	}
+	stats.WallTime = 0
+	expected := ExecutionStats{Instructions: 17, Syscalls: 3, MemoryReads: 3, MemoryWrites: 3, JumpsTaken: 2}
+	if stats != expected {
+		t.Fatal("wrong stats:", stats)
+	}
+	if vm.InstructionsExecuted != stats.Instructions {
+		t.Fatal("instructions don't match:", vm.InstructionsExecuted)
+	}
+}
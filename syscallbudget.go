@@ -0,0 +1,55 @@
+package gomachine
+
+import "errors"
+
+// SyscallBudgetExhausted is returned when the bytecode makes more system calls than a budget set by SetSyscallBudget or
+// MaxSyscalls allows.
+var SyscallBudgetExhausted = errors.New("system call budget exhausted")
+
+// syscallBudget is used to count the calls made to a system call with a budget.
+type syscallBudget struct {
+	limit uint64
+	calls uint64
+}
+
+// SetSyscallBudget is used to limit the number of times the system call specified can be made per execution, or in
+// total if CumulativeSyscallBudgets is set. Making it again after that fails with a SyscallBudgetError. A limit of 0
+// means it can't be made at all. Setting the budget resets its count.
+func (v *VM) SetSyscallBudget(Number, MaxCalls uint64) {
+	if v.syscallBudgets == nil {
+		v.syscallBudgets = map[uint64]*syscallBudget{}
+	}
+	v.syscallBudgets[Number] = &syscallBudget{limit: MaxCalls}
+}
+
+// ClearSyscallBudget is used to remove the budget of the system call specified.
+func (v *VM) ClearSyscallBudget(Number uint64) {
+	delete(v.syscallBudgets, Number)
+	if len(v.syscallBudgets) == 0 {
+		v.syscallBudgets = nil
+	}
+}
+
+// ResetSyscallBudgets is used to set the count of every system call budget and MaxSyscalls back to 0. Counts are reset
+// when each execution starts unless CumulativeSyscallBudgets is set.
+func (v *VM) ResetSyscallBudgets() {
+	for _, budget := range v.syscallBudgets {
+		budget.calls = 0
+	}
+	v.syscallCalls = 0
+}
+
+// chargeSyscall is used to count a call to the system call specified against its budget and MaxSyscalls.
+func (v *VM) chargeSyscall(Number uint64) error {
+	if v.MaxSyscalls != 0 && v.syscallCalls == v.MaxSyscalls {
+		return &SyscallBudgetError{Number: Number, Count: v.syscallCalls, Global: true}
+	}
+	if budget := v.syscallBudgets[Number]; budget != nil {
+		if budget.calls == budget.limit {
+			return &SyscallBudgetError{Number: Number, Count: budget.calls}
+		}
+		budget.calls++
+	}
+	v.syscallCalls++
+	return nil
+}
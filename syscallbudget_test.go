@@ -0,0 +1,76 @@
+package gomachine
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVM_SetSyscallBudget(t *testing.T) {
+	// Make syscall 1 five times, then syscall 2.
+	var bytecode []byte
+	for i := 0; i < 5; i++ {
+		bytecode = append(bytecode, InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+	}
+	bytecode = append(bytecode, InstructionSyscall, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+	calls := 0
+	vm := NewVM(0, 0)
+	vm.Syscalls[1] = func(*VM) error {
+		calls++
+		return nil
+	}
+	vm.Syscalls[2] = vm.Syscalls[1]
+	exhausted := func(Number, Count, PC uint64, Global bool) {
+		t.Helper()
+		err := vm.Execute(bytecode)
+		var budgetErr *SyscallBudgetError
+		if !errors.Is(err, SyscallBudgetExhausted) || !errors.As(err, &budgetErr) {
+			t.Fatal("expected budget exhausted, got:", err)
+		}
+		if budgetErr.Number != Number || budgetErr.Count != Count || budgetErr.Global != Global || vm.PC != PC {
+			t.Fatal("wrong error:", budgetErr, vm.PC)
+		}
+	}
+
+	// The 4th call trips a budget of 3 and the budget is per execution.
+	vm.SetSyscallBudget(1, 3)
+	for i := 0; i < 2; i++ {
+		calls = 0
+		exhausted(1, 3, 27, false)
+		if calls != 3 {
+			t.Fatal("not 3:", calls)
+		}
+	}
+
+	// Other syscalls aren't counted against it.
+	vm.SetSyscallBudget(1, 5)
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	vm.SetSyscallBudget(1, 0)
+	exhausted(1, 0, 0, false)
+	vm.ClearSyscallBudget(1)
+
+	// The global cap counts every syscall.
+	vm.MaxSyscalls = 5
+	calls = 0
+	exhausted(2, 5, 45, true)
+	if calls != 5 {
+		t.Fatal("not 5:", calls)
+	}
+	vm.MaxSyscalls = 0
+
+	// Cumulative budgets carry on between executions until they are reset.
+	vm.CumulativeSyscallBudgets = true
+	vm.SetSyscallBudget(2, 2)
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	exhausted(2, 2, 45, false)
+	vm.ResetSyscallBudgets()
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+}
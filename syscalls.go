@@ -0,0 +1,304 @@
+package gomachine
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"strings"
+)
+
+// SyscallRegistry is used to define system calls with a name, so tools can show which system call a number is. Use
+// WithSyscallRegistry to give it to a virtual machine.
+type SyscallRegistry struct {
+	syscalls map[uint64]func(*VM) error
+	names    map[uint64]string
+
+	// version is increased every time a system call is registered, so virtual machines using the registry's map know
+	// to drop their system call tables.
+	version uint64
+}
+
+// NewSyscallRegistry is used to create a empty system call registry.
+func NewSyscallRegistry() *SyscallRegistry {
+	return &SyscallRegistry{syscalls: map[uint64]func(*VM) error{}, names: map[uint64]string{}}
+}
+
+// Register is used to add a system call with the number and name specified. A system call already registered with the
+// number is replaced.
+func (r *SyscallRegistry) Register(Number uint64, Name string, Syscall func(*VM) error) {
+	r.syscalls[Number] = Syscall
+	r.names[Number] = Name
+	r.version++
+}
+
+// Name is used to get the name of the system call with the number specified.
+func (r *SyscallRegistry) Name(Number uint64) (string, bool) {
+	name, ok := r.names[Number]
+	return name, ok
+}
+
+// Names is used to get a copy of the name of each registered system call by number.
+func (r *SyscallRegistry) Names() map[uint64]string {
+	names := make(map[uint64]string, len(r.names))
+	for number, name := range r.names {
+		names[number] = name
+	}
+	return names
+}
+
+// WithSyscallRegistry is used to make the system calls of the virtual machine the ones in the registry, replacing any
+// added before. The registry's map becomes Syscalls, so system calls registered afterwards can be made straight away.
+func WithSyscallRegistry(Registry *SyscallRegistry) Option {
+	return func(v *VM) {
+		v.Syscalls = Registry.syscalls
+		v.syscallRegistry = Registry
+	}
+}
+
+// attachedRegistry is used to get the registry set by WithSyscallRegistry if Syscalls is still its map.
+func (v *VM) attachedRegistry() *SyscallRegistry {
+	r := v.syscallRegistry
+	if r == nil || reflect.ValueOf(v.Syscalls).Pointer() != reflect.ValueOf(r.syscalls).Pointer() {
+		return nil
+	}
+	return r
+}
+
+// maxSyscallTableSize is the number of slots a system call table can have. System calls aren't put in a table if a
+// number is past it.
+const maxSyscallTableSize = 1024
+
+// syscallTableThreshold is the number of system calls an execution makes through the map before it puts them in a
+// table, so short executions don't pay to build it.
+const syscallTableThreshold = 64
+
+// buildSyscallTable is used to put Syscalls in a table indexed by number, which is faster to look up than the map. The
+// buffer from the last table is reused. nil is returned if a number is too large or a system call is nil.
+func (v *VM) buildSyscallTable() []func(*VM) error {
+	size := uint64(0)
+	for number, syscall := range v.Syscalls {
+		if number >= maxSyscallTableSize || syscall == nil {
+			return nil
+		}
+		if number >= size {
+			size = number + 1
+		}
+	}
+	if uint64(cap(v.syscallTableBuf)) < size {
+		v.syscallTableBuf = make([]func(*VM) error, size)
+	}
+	table := v.syscallTableBuf[:size]
+	for i := range table {
+		table[i] = nil
+	}
+	for number, syscall := range v.Syscalls {
+		table[number] = syscall
+	}
+	return table
+}
+
+// syscallTableKey is used to tell if Syscalls has changed since a system call table was built from it.
+type syscallTableKey struct {
+	mapID   uintptr
+	length  int
+	version uint64
+}
+
+// syscallTableKey is used to get the key of Syscalls. It changes when the map is replaced, the number of system calls
+// changes or a system call is set with SetSyscall, ClearSyscall, Mount or the attached registry.
+func (v *VM) syscallTableKey() syscallTableKey {
+	version := v.syscallVersion
+	if v.syscallRegistry != nil {
+		version += v.syscallRegistry.version
+	}
+	return syscallTableKey{mapID: reflect.ValueOf(v.Syscalls).Pointer(), length: len(v.Syscalls), version: version}
+}
+
+// SetSyscall is used to set the system call with the number specified. Unlike writing to Syscalls, it can be used from
+// a system call to replace a system call during the execution.
+func (v *VM) SetSyscall(Number uint64, Syscall func(*VM) error) {
+	if v.Syscalls == nil {
+		v.Syscalls = map[uint64]func(*VM) error{}
+	}
+	v.Syscalls[Number] = Syscall
+	v.syscallVersion++
+}
+
+// ClearSyscall is used to remove the system call with the number specified. Unlike deleting from Syscalls, it can be
+// used from a system call to remove a system call during the execution.
+func (v *VM) ClearSyscall(Number uint64) {
+	delete(v.Syscalls, Number)
+	v.syscallVersion++
+}
+
+// SyscallOverlap is returned when a module is mounted over system call numbers which are already used.
+var SyscallOverlap = errors.New("system call numbers overlap")
+
+// SyscallDenied is returned when the bytecode makes a system call which isn't allowed by the system call filters.
+var SyscallDenied = errors.New("system call is not allowed")
+
+// ModuleAlreadyMounted is returned when a module is mounted with the name of a module which is already mounted.
+var ModuleAlreadyMounted = errors.New("a module with this name is already mounted")
+
+// ModuleCall is used to define a system call in a SyscallModule.
+type ModuleCall struct {
+	// Name is the name of the call within the module.
+	Name string
+
+	// Syscall is the system call.
+	Syscall func(*VM) error
+}
+
+// SyscallModule is used to define a group of system calls which are mounted together at a base number, so modules
+// written separately don't need to agree on system call numbers.
+type SyscallModule struct {
+	// Name is the name of the module. Calls are resolved by the module name and call name joined with a dot, such as
+	// "console.print".
+	Name string
+
+	// Calls is the system calls of the module. Call i gets the base number plus i.
+	Calls []ModuleCall
+}
+
+// mountedModule is used to remember the range of system call numbers a module was mounted at.
+type mountedModule struct {
+	module *SyscallModule
+	base   uint64
+}
+
+// Mount is used to add the calls of the module as system calls numbered from the base specified. SyscallOverlap is
+// returned if any of the numbers is already used by a system call, and ModuleAlreadyMounted if a module with the same
+// name is mounted. If a registry is attached, the calls are registered with it.
+func (v *VM) Mount(Module *SyscallModule, Base uint64) error {
+	count := uint64(len(Module.Calls))
+	if count == 0 {
+		return nil
+	}
+	for _, m := range v.mounts {
+		if m.module.Name == Module.Name {
+			return fmt.Errorf("%w: %s", ModuleAlreadyMounted, Module.Name)
+		}
+	}
+	last := Base + count - 1
+	if last < Base {
+		return fmt.Errorf("%w: %d calls at %d go past the largest system call number", SyscallOverlap, count, Base)
+	}
+	for i := range Module.Calls {
+		if _, ok := v.Syscalls[Base+uint64(i)]; ok {
+			return fmt.Errorf("%w: %s.%s would be system call %d, which is already used", SyscallOverlap,
+				Module.Name, Module.Calls[i].Name, Base+uint64(i))
+		}
+	}
+
+	registry := v.attachedRegistry()
+	for i, call := range Module.Calls {
+		if registry != nil {
+			registry.Register(Base+uint64(i), Module.Name+"."+call.Name, call.Syscall)
+		} else {
+			v.SetSyscall(Base+uint64(i), call.Syscall)
+		}
+	}
+	v.mounts = append(v.mounts, mountedModule{module: Module, base: Base})
+	return nil
+}
+
+// ResolveSyscall is used to get the number a mounted module call was given from its name, such as "console.print".
+func (v *VM) ResolveSyscall(Name string) (uint64, bool) {
+	dot := strings.LastIndexByte(Name, '.')
+	if dot == -1 {
+		return 0, false
+	}
+	moduleName, callName := Name[:dot], Name[dot+1:]
+	for _, m := range v.mounts {
+		if m.module.Name != moduleName {
+			continue
+		}
+		for i, call := range m.module.Calls {
+			if call.Name == callName {
+				return m.base + uint64(i), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// SyscallSymbols is used to get the number of every mounted module call by name, for assemblers and tools.
+func (v *VM) SyscallSymbols() map[string]uint64 {
+	symbols := map[string]uint64{}
+	for _, m := range v.mounts {
+		for i, call := range m.module.Calls {
+			symbols[m.module.Name+"."+call.Name] = m.base + uint64(i)
+		}
+	}
+	return symbols
+}
+
+// callSyscall is used to make the system call, through the hook if there is one. Panics are returned as a
+// SyscallPanicError unless PropagateSyscallPanics is set.
+func (v *VM) callSyscall(Number uint64, Call func(*VM) error) (err error) {
+	if !v.PropagateSyscallPanics {
+		defer func() {
+			if r := recover(); r != nil {
+				err = &SyscallPanicError{Number: Number, Value: r, Stack: debug.Stack()}
+			}
+		}()
+	}
+	if v.syscallHook != nil {
+		return v.syscallHook(Number, Call)
+	}
+	return Call(v)
+}
+
+// SyscallFilter is used to decide if a system call can be made. It returns true if the system call number specified is
+// allowed.
+type SyscallFilter func(Number uint64) bool
+
+// AllowSyscalls is used to create a filter which only allows the system calls specified.
+func AllowSyscalls(Numbers ...uint64) SyscallFilter {
+	allowed := make(map[uint64]bool, len(Numbers))
+	for _, number := range Numbers {
+		allowed[number] = true
+	}
+	return func(Number uint64) bool {
+		return allowed[Number]
+	}
+}
+
+// DenySyscalls is used to create a filter which allows every system call apart from the ones specified.
+func DenySyscalls(Numbers ...uint64) SyscallFilter {
+	denied := make(map[uint64]bool, len(Numbers))
+	for _, number := range Numbers {
+		denied[number] = true
+	}
+	return func(Number uint64) bool {
+		return !denied[Number]
+	}
+}
+
+// PushSyscallFilter is used to restrict the system calls the bytecode can make until the filter is removed with
+// PopSyscallFilter. A system call is only allowed if every pushed filter allows it, otherwise execution fails with a
+// SyscallDeniedError before the system call is looked up.
+func (v *VM) PushSyscallFilter(Filter SyscallFilter) {
+	v.syscallFilters = append(v.syscallFilters, Filter)
+}
+
+// PopSyscallFilter is used to remove the last filter added by PushSyscallFilter. It does nothing if there are no
+// filters.
+func (v *VM) PopSyscallFilter() {
+	if len(v.syscallFilters) == 0 {
+		return
+	}
+	v.syscallFilters[len(v.syscallFilters)-1] = nil
+	v.syscallFilters = v.syscallFilters[:len(v.syscallFilters)-1]
+}
+
+// syscallAllowed is used to check the system call specified is allowed by every filter.
+func (v *VM) syscallAllowed(Number uint64) bool {
+	for _, filter := range v.syscallFilters {
+		if !filter(Number) {
+			return false
+		}
+	}
+	return true
+}
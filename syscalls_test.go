@@ -0,0 +1,418 @@
+package gomachine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestSyscallRegistry(t *testing.T) {
+	registry := NewSyscallRegistry()
+	registry.Register(1, "read", func(vm *VM) error {
+		vm.Registers[0] = 10
+		return nil
+	})
+	vm := NewVM(0, 0, WithSyscall(9, func(*VM) error { return nil }), WithSyscallRegistry(registry))
+
+	// Syscalls registered after the registry is attached can be made too.
+	registry.Register(2, "write", func(vm *VM) error {
+		vm.Registers[0]++
+		return nil
+	})
+	bytecode := []byte{
+		InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionSyscall, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 11 {
+		t.Fatal("not 11:", vm.Registers[0])
+	}
+
+	if name, ok := registry.Name(2); !ok || name != "write" {
+		t.Fatal("wrong name:", name, ok)
+	}
+	if _, ok := registry.Name(9); ok {
+		t.Fatal("syscall 9 has a name")
+	}
+	names := registry.Names()
+	if !reflect.DeepEqual(names, map[uint64]string{1: "read", 2: "write"}) {
+		t.Fatal("wrong names:", names)
+	}
+	names[3] = "close"
+	if _, ok := registry.Name(3); ok {
+		t.Fatal("names were not copied")
+	}
+
+	// The registry replaces the syscalls added before it.
+	err := vm.Execute([]byte{
+		InstructionUint8Load, 0x01,
+		InstructionSyscall, 0x09, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	})
+	var syscallErr *InvalidSyscallError
+	if !errors.Is(err, InvalidSyscall) || !errors.As(err, &syscallErr) {
+		t.Fatal("expected invalid syscall, got:", err)
+	}
+	if syscallErr.Number != 9 || syscallErr.PC != 2 {
+		t.Fatal("wrong error fields:", syscallErr.Number, syscallErr.PC)
+	}
+	if syscallErr.Error() != "syscall is invalid: system call 9 at pc 2" {
+		t.Fatal("wrong error string:", syscallErr.Error())
+	}
+}
+
+// syscallLoop is used to build a guest which makes syscall 1 the number of times specified and then makes syscall 2.
+func syscallLoop(Count uint64) []byte {
+	bytecode := []byte{InstructionUint64LoadR3Direct, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint64(bytecode[1:], Count)
+	return append(bytecode,
+		InstructionUint8LoadR2Direct, 0x01,
+		InstructionUint8Load, 0x00,
+		InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUnsignedAdd,
+		InstructionJmpIfNe, 0x0D, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionSyscall, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	)
+}
+
+func TestVM_Execute_SyscallTable(t *testing.T) {
+	// Enough syscalls are made for them to be put in a table.
+	bytecode := syscallLoop(syscallTableThreshold * 2)
+	calls, last := 0, ""
+	registry := NewSyscallRegistry()
+	registry.Register(1, "count", func(*VM) error {
+		calls++
+		return nil
+	})
+	registry.Register(2, "last", func(*VM) error {
+		last = "registered"
+		return nil
+	})
+	vm := NewVM(0, 0, WithSyscallRegistry(registry))
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	if calls != syscallTableThreshold*2 || last != "registered" {
+		t.Fatal("wrong calls:", calls, last)
+	}
+
+	// Replacing and deleting through Syscalls behaves like the map.
+	vm.Syscalls[2] = func(*VM) error {
+		last = "replaced"
+		return nil
+	}
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	if last != "replaced" {
+		t.Fatal("old syscall was called:", last)
+	}
+	invalid := func() {
+		t.Helper()
+		err := vm.Execute(bytecode)
+		var syscallErr *InvalidSyscallError
+		if !errors.As(err, &syscallErr) || syscallErr.Number != 2 || syscallErr.PC != 32 {
+			t.Fatal("expected invalid syscall, got:", err)
+		}
+	}
+	delete(vm.Syscalls, 2)
+	invalid()
+
+	// So does deleting from a syscall while the table is being used.
+	vm.Syscalls[2] = vm.Syscalls[1]
+	calls = 0
+	vm.Syscalls[1] = func(vm *VM) error {
+		if calls++; calls == syscallTableThreshold+10 {
+			delete(vm.Syscalls, 2)
+		}
+		return nil
+	}
+	invalid()
+
+	// Replacing a syscall in place from a syscall while the table is being used is seen straight away.
+	replaced := 0
+	vm.Syscalls[2] = func(*VM) error { return nil }
+	calls = 0
+	vm.Syscalls[1] = func(vm *VM) error {
+		if calls++; calls == syscallTableThreshold+10 {
+			vm.SetSyscall(1, func(*VM) error {
+				replaced++
+				return nil
+			})
+		}
+		return nil
+	}
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	if calls != syscallTableThreshold+10 || replaced != syscallTableThreshold-10 {
+		t.Fatal("wrong calls:", calls, replaced)
+	}
+
+	// So is removing one syscall and adding another, which keeps the number of syscalls the same.
+	vm.Syscalls[1] = func(vm *VM) error {
+		if calls++; calls == syscallTableThreshold+10 {
+			vm.ClearSyscall(2)
+			vm.SetSyscall(3, func(*VM) error { return nil })
+		}
+		return nil
+	}
+	calls = 0
+	invalid()
+
+	// And so is registering over a syscall in the attached registry.
+	registry = NewSyscallRegistry()
+	registry.Register(2, "last", func(*VM) error { return nil })
+	registry.Register(1, "count", func(vm *VM) error {
+		if calls++; calls == syscallTableThreshold+10 {
+			registry.Register(1, "count", func(*VM) error {
+				replaced++
+				return nil
+			})
+		}
+		return nil
+	})
+	vm = NewVM(0, 0, WithSyscallRegistry(registry))
+	calls, replaced = 0, 0
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	if calls != syscallTableThreshold+10 || replaced != syscallTableThreshold-10 {
+		t.Fatal("wrong registered calls:", calls, replaced)
+	}
+
+	// Nil syscalls and large numbers stay in the map.
+	for _, number := range []uint64{2, maxSyscallTableSize} {
+		vm = NewVM(0, 0)
+		vm.Syscalls[1] = func(*VM) error { return nil }
+		vm.Syscalls[number] = nil
+		if vm.buildSyscallTable() != nil {
+			t.Fatal("table was built")
+		}
+	}
+}
+
+func BenchmarkVM_Execute_Syscalls(b *testing.B) {
+	bytecode := syscallLoop(1000000)
+	run := func(b *testing.B, vm *VM) {
+		for i := uint64(0); i < 16; i++ {
+			vm.Syscalls[i] = func(*VM) error { return nil }
+		}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := vm.Execute(bytecode); err != nil {
+				b.Fatal(err)
+			}
+			if vm.Registers[0] != 1000000 {
+				b.Fatal("not 1000000:", vm.Registers[0])
+			}
+		}
+	}
+	b.Run("Table", func(b *testing.B) {
+		run(b, NewVM(0, 0))
+	})
+
+	// A syscall past the largest table size keeps every lookup in the map.
+	b.Run("Map", func(b *testing.B) {
+		vm := NewVM(0, 0)
+		vm.Syscalls[maxSyscallTableSize] = func(*VM) error { return nil }
+		run(b, vm)
+	})
+}
+
+func TestVM_Mount(t *testing.T) {
+	var output []string
+	console := &SyscallModule{Name: "console", Calls: []ModuleCall{
+		{"print", func(vm *VM) error {
+			output = append(output, "console.print")
+			return nil
+		}},
+		{"clear", func(vm *VM) error {
+			output = append(output, "console.clear")
+			return nil
+		}},
+	}}
+	fs := &SyscallModule{Name: "fs", Calls: []ModuleCall{
+		{"print", func(vm *VM) error {
+			output = append(output, "fs.print")
+			return nil
+		}},
+	}}
+	vm := NewVM(0, 0)
+	if err := vm.Mount(console, 0x10); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.Mount(fs, 0x20); err != nil {
+		t.Fatal(err)
+	}
+
+	// Calls with the same name in different modules get their own numbers.
+	symbols := vm.SyscallSymbols()
+	if !reflect.DeepEqual(symbols, map[string]uint64{"console.print": 0x10, "console.clear": 0x11, "fs.print": 0x20}) {
+		t.Fatal("wrong symbols:", symbols)
+	}
+	for name, expected := range symbols {
+		if number, ok := vm.ResolveSyscall(name); !ok || number != expected {
+			t.Fatal("wrong number for", name, number, ok)
+		}
+	}
+	for _, name := range []string{"console.write", "net.print", "print"} {
+		if _, ok := vm.ResolveSyscall(name); ok {
+			t.Fatal("resolved", name)
+		}
+	}
+	err := vm.Execute([]byte{
+		InstructionSyscall, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionSyscall, 0x11, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionSyscall, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(output, []string{"fs.print", "console.clear", "console.print"}) {
+		t.Fatal("wrong output:", output)
+	}
+
+	// Mounting over used numbers fails without adding anything.
+	crypto := &SyscallModule{Name: "crypto", Calls: []ModuleCall{{"hash", nil}, {"sign", nil}}}
+	if err := vm.Mount(crypto, 0x0F); !errors.Is(err, SyscallOverlap) {
+		t.Fatal("expected overlap, got:", err)
+	}
+	vm.Syscalls[0x30] = func(*VM) error { return nil }
+	if err := vm.Mount(crypto, 0x2F); !errors.Is(err, SyscallOverlap) {
+		t.Fatal("expected overlap, got:", err)
+	}
+	if err := vm.Mount(crypto, ^uint64(0)); !errors.Is(err, SyscallOverlap) {
+		t.Fatal("expected overlap, got:", err)
+	}
+	if _, ok := vm.Syscalls[0x2F]; ok {
+		t.Fatal("syscall was added")
+	}
+	if _, ok := vm.ResolveSyscall("crypto.hash"); ok {
+		t.Fatal("module was mounted")
+	}
+	if err := vm.Mount(console, 0x40); !errors.Is(err, ModuleAlreadyMounted) {
+		t.Fatal("expected already mounted, got:", err)
+	}
+
+	// Mounting with a registry attached names the calls.
+	registry := NewSyscallRegistry()
+	vm = NewVM(0, 0, WithSyscallRegistry(registry))
+	if err := vm.Mount(console, 0); err != nil {
+		t.Fatal(err)
+	}
+	if name, ok := registry.Name(1); !ok || name != "console.clear" {
+		t.Fatal("wrong name:", name, ok)
+	}
+}
+
+func TestVM_Execute_SyscallPanic(t *testing.T) {
+	vm := NewVM(0, 0)
+	var m map[string]int
+	vm.Syscalls[3] = func(vm *VM) error {
+		m["x"] = 1
+		return nil
+	}
+	bytecode := []byte{
+		InstructionUint8Load, 0x07,
+		InstructionSyscall, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	err := vm.Execute(bytecode)
+	var panicErr *SyscallPanicError
+	var vmErr *VMError
+	if !errors.As(err, &panicErr) || !errors.As(err, &vmErr) {
+		t.Fatal("expected syscall panic error, got:", err)
+	}
+	if panicErr.Number != 3 || !bytes.Contains(panicErr.Stack, []byte("TestVM_Execute_SyscallPanic")) {
+		t.Fatal("wrong error fields:", panicErr.Number, string(panicErr.Stack))
+	}
+	var runtimeErr runtime.Error
+	if !errors.As(err, &runtimeErr) {
+		t.Fatal("panic value is not unwrapped:", panicErr.Value)
+	}
+	if vmErr.Offset != 2 || vmErr.Operand != 3 || vm.PC != 2 || vm.Registers[0] != 7 {
+		t.Fatal("wrong state:", vmErr.Offset, vmErr.Operand, vm.PC, vm.Registers[0])
+	}
+
+	// The vm can be used again.
+	vm.Syscalls[3] = func(vm *VM) error {
+		vm.Registers[0]++
+		return nil
+	}
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 8 {
+		t.Fatal("not 8:", vm.Registers[0])
+	}
+
+	// Panics can be left to unwind.
+	vm = NewVM(0, 0, WithPropagateSyscallPanics())
+	vm.Syscalls[3] = func(vm *VM) error {
+		panic("boom")
+	}
+	defer func() {
+		if r := recover(); r != "boom" {
+			t.Fatal("expected the panic, got:", r)
+		}
+	}()
+	_ = vm.Execute(bytecode)
+	t.Fatal("the panic was recovered")
+}
+
+func TestVM_PushSyscallFilter(t *testing.T) {
+	registry := NewSyscallRegistry()
+	for i := uint64(1); i <= 3; i++ {
+		number := i
+		registry.Register(number, "add", func(vm *VM) error {
+			vm.Registers[0] += number
+			return nil
+		})
+	}
+	vm := NewVM(0, 0, WithSyscallRegistry(registry))
+	bytecode := []byte{
+		InstructionUint8Load, 0x00,
+		InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionSyscall, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionSyscall, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	denied := func(Number, PC uint64) {
+		t.Helper()
+		err := vm.Execute(bytecode)
+		var deniedErr *SyscallDeniedError
+		if !errors.Is(err, SyscallDenied) || errors.Is(err, InvalidSyscall) || !errors.As(err, &deniedErr) {
+			t.Fatal("expected syscall denied, got:", err)
+		}
+		if deniedErr.Number != Number || deniedErr.PC != PC || vm.PC != PC {
+			t.Fatal("wrong error fields:", deniedErr.Number, deniedErr.PC, vm.PC)
+		}
+	}
+
+	// Permissive filters allow everything.
+	vm.PushSyscallFilter(DenySyscalls(7))
+	if err := vm.Execute(bytecode); err != nil || vm.Registers[0] != 6 {
+		t.Fatal("wrong result:", err, vm.Registers[0])
+	}
+
+	// Restricted filters stop the first syscall they don't allow, and stack.
+	vm.PushSyscallFilter(AllowSyscalls(1, 2))
+	denied(3, 20)
+	if vm.Registers[0] != 3 {
+		t.Fatal("not 3:", vm.Registers[0])
+	}
+	vm.PushSyscallFilter(DenySyscalls(2))
+	denied(2, 11)
+
+	// Popping the filters lifts the restrictions.
+	vm.PopSyscallFilter()
+	vm.PopSyscallFilter()
+	vm.PopSyscallFilter()
+	vm.PopSyscallFilter()
+	if err := vm.Execute(bytecode); err != nil || vm.Registers[0] != 6 {
+		t.Fatal("wrong result:", err, vm.Registers[0])
+	}
+}
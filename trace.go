@@ -0,0 +1,172 @@
+package gomachine
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strconv"
+)
+
+// MemoryAccess is used to represent the memory a traced instruction loaded or dumped.
+type MemoryAccess struct {
+	// Address is the memory location of the access.
+	Address uint64
+
+	// Width is the number of bytes accessed.
+	Width int
+
+	// Value is the value loaded or dumped.
+	Value uint64
+
+	// Write is true for dumps and false for loads.
+	Write bool
+}
+
+// TraceEvent is used to represent a instruction which ran.
+type TraceEvent struct {
+	// Offset is the bytecode index of the instruction.
+	Offset uint64
+
+	// Opcode is the instruction.
+	Opcode uint8
+
+	// Operand is the argument of the instruction, or 0 if it doesn't have one.
+	Operand uint64
+
+	// Registers is a copy of the registers after the instruction ran.
+	Registers [8]uint64
+
+	// Memory is the memory the instruction accessed. It is nil for instructions which don't load or dump a value.
+	Memory *MemoryAccess
+}
+
+// TraceEncoder is used to write out every instruction run by a virtual machine set up with SetTraceEncoder. The event
+// is only valid until Encode returns. Flush is called when each execution returns.
+type TraceEncoder interface {
+	Encode(Event *TraceEvent) error
+	Flush() error
+}
+
+// SetTraceEncoder is used to trace every instruction the virtual machine runs to the encoder specified. If Encode or
+// Flush return a error, execution stops with it. nil turns tracing off.
+func (v *VM) SetTraceEncoder(Encoder TraceEncoder) {
+	v.traceEncoder = Encoder
+}
+
+// memoryAccessWidths is used to define the width of the instructions which load or dump a single value. Dumps are
+// negative.
+var memoryAccessWidths = map[uint8]int{
+	InstructionMemoryUint8Load: 1, InstructionMemoryUint16Load: 2, InstructionMemoryUint32Load: 4,
+	InstructionMemoryUint64Load: 8, InstructionMemoryFloat32Load: 4, InstructionMemoryUint8Load32: 1,
+	InstructionMemoryUint16Load32: 2, InstructionMemoryUint32Load32: 4, InstructionMemoryUint64Load32: 8,
+	InstructionMemoryVarLoad64: 8,
+	InstructionUint8Dump:       -1, InstructionUint16Dump: -2, InstructionUint32Dump: -4, InstructionUint64Dump: -8,
+	InstructionFloat32Dump: -4, InstructionUint8Dump32: -1, InstructionUint16Dump32: -2, InstructionUint32Dump32: -4,
+	InstructionUint64Dump32: -8,
+}
+
+// tracer is used to hold the instruction being traced during a execution. Each instruction is encoded when the next
+// one starts or execution returns, so the registers it changed can be included.
+type tracer struct {
+	encoder TraceEncoder
+	event   TraceEvent
+	memory  MemoryAccess
+	pending bool
+}
+
+// begin is used to encode the instruction before and start tracing the instruction at the index specified.
+func (t *tracer) begin(v *VM, Bytecode []byte, Index uint64) error {
+	if err := t.encode(v); err != nil {
+		return err
+	}
+	operand, _, _, _ := decodeInstruction(Bytecode, Index, v.mode)
+	t.event = TraceEvent{Offset: Index, Opcode: Bytecode[Index], Operand: operand}
+	t.pending = true
+	return nil
+}
+
+// encode is used to encode the instruction being traced, if there is one, with the registers as they are now.
+func (t *tracer) encode(v *VM) error {
+	if !t.pending {
+		return nil
+	}
+	t.pending = false
+	t.event.Registers = v.Registers
+	if width, ok := memoryAccessWidths[t.event.Opcode]; ok {
+		t.memory = MemoryAccess{Address: t.event.Operand, Width: width, Value: v.Registers[0]}
+		if width < 0 {
+			t.memory.Width = -width
+			t.memory.Write = true
+		}
+		if t.event.Opcode == InstructionMemoryFloat32Load || t.event.Opcode == InstructionFloat32Dump {
+			// R1 holds the float64 form of the float32 in memory.
+			t.memory.Value = uint64(math.Float32bits(float32(math.Float64frombits(t.memory.Value))))
+		} else if t.memory.Width < 8 {
+			t.memory.Value &= 1<<(8*t.memory.Width) - 1
+		}
+		t.event.Memory = &t.memory
+	}
+	return t.encoder.Encode(&t.event)
+}
+
+// end is used to encode the last instruction and flush the encoder when execution returns.
+func (t *tracer) end(v *VM) error {
+	if err := t.encode(v); err != nil {
+		return err
+	}
+	return t.encoder.Flush()
+}
+
+// JSONTraceEncoder is used to write each traced instruction as a line of JSON like
+// {"offset":0,"opcode":1,"mnemonic":"Uint8Load","operand":5,"registers":[5,0,0,0,0,0,0,0]}. Instructions which
+// load or dump a value also have a "memory" object with "address", "width", "value" and "write" fields. Writes are
+// buffered until Flush is called.
+type JSONTraceEncoder struct {
+	w   *bufio.Writer
+	buf []byte
+}
+
+// NewJSONTraceEncoder is used to create a JSON trace encoder which writes to the writer specified.
+func NewJSONTraceEncoder(W io.Writer) *JSONTraceEncoder {
+	return &JSONTraceEncoder{w: bufio.NewWriterSize(W, 64*1024)}
+}
+
+// Encode is used to write the event as a line of JSON.
+func (e *JSONTraceEncoder) Encode(Event *TraceEvent) error {
+	b := append(e.buf[:0], `{"offset":`...)
+	b = strconv.AppendUint(b, Event.Offset, 10)
+	b = append(b, `,"opcode":`...)
+	b = strconv.AppendUint(b, uint64(Event.Opcode), 10)
+	b = append(b, `,"mnemonic":"`...)
+	b = append(b, OpcodeName(Event.Opcode)...)
+	b = append(b, `","operand":`...)
+	b = strconv.AppendUint(b, Event.Operand, 10)
+	b = append(b, `,"registers":[`...)
+	for i, r := range Event.Registers {
+		if i != 0 {
+			b = append(b, ',')
+		}
+		b = strconv.AppendUint(b, r, 10)
+	}
+	b = append(b, ']')
+	if m := Event.Memory; m != nil {
+		b = append(b, `,"memory":{"address":`...)
+		b = strconv.AppendUint(b, m.Address, 10)
+		b = append(b, `,"width":`...)
+		b = strconv.AppendInt(b, int64(m.Width), 10)
+		b = append(b, `,"value":`...)
+		b = strconv.AppendUint(b, m.Value, 10)
+		b = append(b, `,"write":`...)
+		b = strconv.AppendBool(b, m.Write)
+		b = append(b, '}')
+	}
+	b = append(b, "}\n"...)
+	e.buf = b
+	_, err := e.w.Write(b)
+	return err
+}
+
+// Flush is used to write any buffered lines to the writer.
+func (e *JSONTraceEncoder) Flush() error {
+	return e.w.Flush()
+}
@@ -0,0 +1,156 @@
+package gomachine
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// countingWriter is a writer which counts the calls to Write.
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+// failingEncoder is a trace encoder which fails after a number of events.
+type failingEncoder struct {
+	left int
+}
+
+var errEncoderFull = errors.New("encoder full")
+
+func (e *failingEncoder) Encode(*TraceEvent) error {
+	if e.left == 0 {
+		return errEncoderFull
+	}
+	e.left--
+	return nil
+}
+
+func (e *failingEncoder) Flush() error {
+	return nil
+}
+
+func TestVM_SetTraceEncoder_JSON(t *testing.T) {
+	bytecode := []byte{
+		InstructionUint16Load, 0x05, 0x01,
+		InstructionUint16Dump, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionMemoryUint8Load, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	vm := NewVM(16, 0)
+	vm.Syscalls = map[uint64]func(*VM) error{
+		1: func(vm *VM) error {
+			vm.Registers[1] = 7
+			return nil
+		},
+	}
+	w := &countingWriter{}
+	vm.SetTraceEncoder(NewJSONTraceEncoder(w))
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every field has to be there and nothing else.
+	type memory struct {
+		Address *uint64 `json:"address"`
+		Width   *int    `json:"width"`
+		Value   *uint64 `json:"value"`
+		Write   *bool   `json:"write"`
+	}
+	type line struct {
+		Offset    *uint64  `json:"offset"`
+		Opcode    *uint8   `json:"opcode"`
+		Mnemonic  *string  `json:"mnemonic"`
+		Operand   *uint64  `json:"operand"`
+		Registers []uint64 `json:"registers"`
+		Memory    *memory  `json:"memory"`
+	}
+	dec := json.NewDecoder(&w.Buffer)
+	dec.DisallowUnknownFields()
+	var lines []line
+	for {
+		var l line
+		if err := dec.Decode(&l); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if l.Offset == nil || l.Opcode == nil || l.Mnemonic == nil || l.Operand == nil || len(l.Registers) != 8 {
+			t.Fatal("missing field:", l)
+		}
+		if l.Memory != nil && (l.Memory.Address == nil || l.Memory.Width == nil || l.Memory.Value == nil || l.Memory.Write == nil) {
+			t.Fatal("missing memory field:", *l.Memory)
+		}
+		lines = append(lines, l)
+	}
+	if len(lines) != 4 {
+		t.Fatal("wrong number of lines:", len(lines))
+	}
+
+	expected := []struct {
+		offset    uint64
+		mnemonic  string
+		operand   uint64
+		registers []uint64
+		memory    *MemoryAccess
+	}{
+		{0, "Uint16Load", 0x105, []uint64{0x105, 0, 0, 0, 0, 0, 0, 0}, nil},
+		{3, "Uint16Dump", 8, []uint64{0x105, 0, 0, 0, 0, 0, 0, 0}, &MemoryAccess{Address: 8, Width: 2, Value: 0x105, Write: true}},
+		{12, "MemoryUint8Load", 8, []uint64{5, 0, 0, 0, 0, 0, 0, 0}, &MemoryAccess{Address: 8, Width: 1, Value: 5}},
+		{21, "Syscall", 1, []uint64{5, 7, 0, 0, 0, 0, 0, 0}, nil},
+	}
+	for i, e := range expected {
+		l := lines[i]
+		if *l.Offset != e.offset || *l.Opcode != bytecode[e.offset] || *l.Mnemonic != e.mnemonic ||
+			*l.Operand != e.operand || !reflect.DeepEqual(l.Registers, e.registers) {
+			t.Fatal("wrong line", i, *l.Offset, *l.Mnemonic, *l.Operand, l.Registers)
+		}
+		if (l.Memory == nil) != (e.memory == nil) {
+			t.Fatal("wrong memory on line", i)
+		}
+		if l.Memory != nil {
+			m := MemoryAccess{Address: *l.Memory.Address, Width: *l.Memory.Width, Value: *l.Memory.Value, Write: *l.Memory.Write}
+			if m != *e.memory {
+				t.Fatal("wrong memory on line", i, m)
+			}
+		}
+	}
+
+	// A loop of thousands of instructions is written out in a few large writes.
+	w = &countingWriter{}
+	vm.SetTraceEncoder(NewJSONTraceEncoder(w))
+	vm.ClearRegisters()
+	err := vm.Execute([]byte{
+		InstructionUint64LoadR3Direct, 0xE8, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint8LoadR2Direct, 0x01,
+		InstructionUnsignedAdd,
+		InstructionJmpIfLt, 0x0B, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(w.Bytes(), []byte("\n")); n != 2+2*1000 {
+		t.Fatal("wrong number of lines:", n)
+	}
+	if w.writes > w.Len()/(32*1024) {
+		t.Fatal("too many writes:", w.writes, w.Len())
+	}
+
+	// A encoder error stops execution.
+	vm.SetTraceEncoder(&failingEncoder{left: 1})
+	if err := vm.Execute(bytecode); !errors.Is(err, errEncoderFull) {
+		t.Fatal("expected encoder full, got:", err)
+	}
+	if vm.PC != 12 || vm.InstructionsExecuted != 2 {
+		t.Fatal("wrong state:", vm.PC, vm.InstructionsExecuted)
+	}
+}
@@ -0,0 +1,126 @@
+package gomachine
+
+import "errors"
+
+// TransactionInProgress is returned by BeginTransaction when a transaction is already in progress.
+var TransactionInProgress = errors.New("transaction is already in progress")
+
+// NoTransaction is returned by Commit and Rollback when there is no transaction in progress.
+var NoTransaction = errors.New("no transaction is in progress")
+
+// TransactionOption is used to change what BeginTransaction records.
+type TransactionOption uint8
+
+const (
+	// SnapshotRegisters is used to make Rollback restore the registers and PC as well as the memory.
+	SnapshotRegisters TransactionOption = iota + 1
+)
+
+// journalEntry is a byte of memory and the value it had before it was first written in the transaction.
+type journalEntry struct {
+	address uint64
+	old     uint8
+}
+
+// transaction is the state recorded by BeginTransaction.
+type transaction struct {
+	journal    []journalEntry
+	memorySize uint64
+	registers  *[8]uint64
+	pc         uint64
+}
+
+// BeginTransaction is used to start recording every byte of memory written by dumps and the host write helpers so
+// Rollback can undo them. Memory mapped io writes are not recorded. Nested transactions are not supported and return
+// TransactionInProgress.
+func (v *VM) BeginTransaction(Options ...TransactionOption) error {
+	if v.transaction != nil {
+		return TransactionInProgress
+	}
+	v.transaction = &transaction{memorySize: v.memorySize()}
+	for _, option := range Options {
+		if option == SnapshotRegisters {
+			registers := v.Registers
+			v.transaction.registers = &registers
+			v.transaction.pc = v.PC
+		}
+	}
+	return nil
+}
+
+// Commit is used to end the transaction and keep everything written during it.
+func (v *VM) Commit() error {
+	if v.transaction == nil {
+		return NoTransaction
+	}
+	v.transaction = nil
+	return nil
+}
+
+// Rollback is used to end the transaction and restore every byte written during it in reverse order. Memory grown
+// during the transaction is removed, and if SnapshotRegisters was passed the registers and PC are restored.
+func (v *VM) Rollback() error {
+	t := v.transaction
+	if t == nil {
+		return NoTransaction
+	}
+	v.transaction = nil
+	for i := len(t.journal) - 1; i >= 0; i-- {
+		entry := t.journal[i]
+		if v.paged != nil {
+			page, err := v.paged.page(entry.address)
+			if err != nil {
+				return err
+			}
+			page[entry.address%v.paged.pageSize] = entry.old
+		} else if entry.address < uint64(len(v.Memory)) {
+			v.Memory[entry.address] = entry.old
+		} else {
+			continue
+		}
+		v.notifyWrite(entry.address, 1, uint64(entry.old))
+	}
+	if v.paged != nil {
+		v.paged.size = t.memorySize
+	} else if uint64(len(v.Memory)) > t.memorySize {
+		v.Memory = v.Memory[:t.memorySize]
+	}
+	if t.registers != nil {
+		v.Registers = *t.registers
+		v.PC = t.pc
+	}
+	return nil
+}
+
+// record is used to add the bytes about to be written to the journal.
+func (v *VM) record(Address, Width uint64) {
+	for i := uint64(0); i < Width; i++ {
+		address := Address + i
+		v.transaction.journal = append(v.transaction.journal, journalEntry{address: address, old: v.currentByte(address)})
+	}
+}
+
+// currentByte is used to get the byte at a address which is about to be written.
+func (v *VM) currentByte(Address uint64) uint8 {
+	if v.paged != nil {
+		// If the page can't be allocated the write fails straight after, so the value is harmless.
+		page, _ := v.paged.page(Address)
+		if page != nil {
+			return page[Address%v.paged.pageSize]
+		}
+		return 0
+	}
+	return v.Memory[Address]
+}
+
+// restoreByte is used to put back a byte recorded in a journal.
+func (v *VM) restoreByte(Entry journalEntry) {
+	if v.paged != nil {
+		page, err := v.paged.page(Entry.address)
+		if err == nil {
+			page[Entry.address%v.paged.pageSize] = Entry.old
+		}
+	} else if Entry.address < uint64(len(v.Memory)) {
+		v.Memory[Entry.address] = Entry.old
+	}
+}
@@ -0,0 +1,88 @@
+package gomachine
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestVM_Rollback(t *testing.T) {
+	vm := NewVM(32, 0)
+	vm.Syscalls[1] = SbrkSyscall
+	for i := range vm.Memory {
+		vm.Memory[i] = uint8(i)
+	}
+	preImage := append([]byte(nil), vm.Memory...)
+	vm.Registers[4] = 7
+	if err := vm.BeginTransaction(SnapshotRegisters); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.BeginTransaction(); !errors.Is(err, TransactionInProgress) {
+		t.Fatal("expected transaction in progress, got:", err)
+	}
+
+	// Scribble over memory, including the same place twice and memory grown during the transaction.
+	err := vm.Execute([]byte{
+		InstructionUint64Load, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
+		InstructionUint64Dump, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint32Dump, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint8Dump32, 0x1F, 0x00, 0x00, 0x00,
+		InstructionUint8LoadR2Direct, 0x08,
+		InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint8Dump32, 0x24, 0x00, 0x00, 0x00,
+		InstructionMoveR1ToR5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(vm.Memory[:32], preImage) || len(vm.Memory) != 40 {
+		t.Fatal("memory was not written to")
+	}
+
+	// Roll back and compare against the pre-image.
+	if err := vm.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(vm.Memory, preImage) {
+		t.Fatal("memory not restored:", vm.Memory)
+	}
+	if vm.Registers != [8]uint64{0, 0, 0, 0, 7} || vm.PC != 0 {
+		t.Fatal("registers not restored:", vm.Registers, vm.PC)
+	}
+	if err := vm.Rollback(); !errors.Is(err, NoTransaction) {
+		t.Fatal("expected no transaction, got:", err)
+	}
+}
+
+func TestVM_Commit(t *testing.T) {
+	vm := NewVM(8, 0)
+	if err := vm.Commit(); !errors.Is(err, NoTransaction) {
+		t.Fatal("expected no transaction, got:", err)
+	}
+	if err := vm.BeginTransaction(); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.Execute([]byte{InstructionUint8Load, 0x05, InstructionUint8Dump32, 0x01, 0x00, 0x00, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Memory[1] != 5 || vm.Registers[0] != 5 {
+		t.Fatal("changes not kept")
+	}
+
+	// Without SnapshotRegisters only the memory is restored.
+	if err := vm.BeginTransaction(); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.Execute([]byte{InstructionUint8Load, 0x06, InstructionUint8Dump32, 0x01, 0x00, 0x00, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Memory[1] != 5 || vm.Registers[0] != 6 {
+		t.Fatal("unexpected state:", vm.Memory[1], vm.Registers[0])
+	}
+}
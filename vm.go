@@ -1,203 +1,557 @@
 package gomachine
 
 import (
+	"context"
+	"encoding/binary"
 	"errors"
-	"runtime"
+	"fmt"
+	"hash/crc32"
+	"math"
 	"sync/atomic"
 	"time"
-	"unsafe"
 )
 
-// Defines the CPU instructions.
+// InvalidInstructionArgument is used when the instruction expects a argument but none is provided.
+var InvalidInstructionArgument = errors.New("no argument provided as the instruction expects one")
+
+// InvalidMemoryLocation is an error which is thrown when the memory location is outside of the memory length.
+var InvalidMemoryLocation = errors.New("memory location is outside of the maximum array size")
+
+// InvalidSyscall is an error which is thrown when the bytes reference a syscall which doesn't exist.
+var InvalidSyscall = errors.New("syscall is invalid")
+
+// InvalidRegister is returned when a register operand selects a register which doesn't exist.
+var InvalidRegister = errors.New("invalid register")
+
+// CPUTimeExhausted is returned when the amount of CPU time a user has was exhausted.
+var CPUTimeExhausted = errors.New("cpu time is exhausted")
+
+// DeadlineExceeded is returned when execution is still running at Deadline. It wraps CPUTimeExhausted, so errors.Is
+// matches both.
+var DeadlineExceeded = fmt.Errorf("deadline exceeded: %w", CPUTimeExhausted)
+
+// DivideByZero is used when a division or modulo by 0 is attempted and StrictArithmetic is set.
+var DivideByZero = errors.New("division by zero")
+
+// UnsupportedISAVersion is used when bytecode requires a newer ISA version than ISAVersion.
+var UnsupportedISAVersion = errors.New("bytecode requires a newer isa version")
+
+// UnknownInstruction is used when the CPU instruction is unknown.
+var UnknownInstruction = errors.New("unknown cpu instruction")
+
+// FloatUnsupported is used when a float instruction is used in Mode32.
+var FloatUnsupported = errors.New("float instructions are not supported in 32-bit mode")
+
+// InstructionBudgetExhausted is returned when the number of instructions set by MaxInstructions have been executed.
+var InstructionBudgetExhausted = errors.New("instruction budget is exhausted")
+
+// LoopBudgetExhausted is returned when the number of backward jumps set by MaxBackwardJumps have been made.
+var LoopBudgetExhausted = errors.New("loop budget is exhausted")
+
+// BreakpointHit is returned when execution reaches a bytecode index with a breakpoint set by SetBreakpoint.
+var BreakpointHit = errors.New("breakpoint hit")
+
+// Stopped is returned when execution is stopped by Stop.
+var Stopped = errors.New("execution was stopped")
+
+// Paused is returned when execution is paused by Pause.
+var Paused = errors.New("execution was paused")
+
+// NotPaused is returned by Resume when the last execution wasn't paused.
+var NotPaused = errors.New("execution is not paused")
+
+// Defines the reasons stored in the stop flag.
 const (
-	// InstructionUint8Load is used to load a uint8 argument into R1.
-	InstructionUint8Load = uint8(iota + 1)
+	stopRequested = 1 + iota
+	stopPaused
+)
+
+// defaultCheckInterval is the CheckInterval used when it is 0.
+const defaultCheckInterval = 1024
+
+// Yielded is returned when the bytecode runs InstructionYield.
+var Yielded = errors.New("execution yielded")
+
+// ProgramComplete is returned by Step when the PC is at the end of the bytecode.
+var ProgramComplete = errors.New("program is complete")
+
+// VMBusy is returned when the virtual machine is asked to execute while it is already executing.
+var VMBusy = errors.New("virtual machine is already executing")
+
+// VM is used to represent the virtual machine. A VM is single-threaded: it can only execute one piece of bytecode at a
+// time, and executing it while it is already executing (from another goroutine or from inside a system call) returns
+// VMBusy. Use Clone to run the same state in parallel.
+type VM struct {
+	// Memory is used to represent the memory of the virtual machine.
+	Memory []byte
 
-	// InstructionUint16Load is used to load a uint16 argument into R1.
-	InstructionUint16Load
+	// MaxCPUTime is used to say how much CPU time a VM can use. 0 means unlimited. Only time spent running instructions
+	// counts: the clock is stopped while system calls run. It is checked every CheckInterval instructions, so a
+	// execution can go over by the time those instructions take.
+	MaxCPUTime time.Duration
 
-	// InstructionUint32Load is used to load a uint32 argument into R1.
-	InstructionUint32Load
+	// CumulativeCPUBudget is used to limit the CPU time used across every execution instead of each one. Once
+	// CPUTimeUsed reaches it, executions return CPUTimeExhausted, straight away if it has already been used up, until
+	// ResetCPUBudget is called. 0 turns it off.
+	CumulativeCPUBudget time.Duration
+
+	// Deadline is used to stop execution with DeadlineExceeded once the wall clock reaches it. Unlike MaxCPUTime, time
+	// spent in system calls counts. It is checked every CheckInterval instructions. The zero time means there is no
+	// deadline.
+	Deadline time.Time
+
+	// SoftCPUTime is used to set a warning threshold below MaxCPUTime. When a execution has used this much CPU time,
+	// OnSoftLimit is called once between instructions and execution carries on. 0 turns it off.
+	SoftCPUTime time.Duration
+
+	// OnSoftLimit is called when SoftCPUTime is reached. It can call Stop to end execution or ExtendCPUTime to move the
+	// MaxCPUTime limit for this execution. The time it takes isn't counted as CPU time.
+	OnSoftLimit func(*VM)
+
+	// OnCPUExhausted is called when MaxCPUTime or CumulativeCPUBudget runs out, with the clock stopped and the PC
+	// pointing at the instruction which would have run next. If it returns GrantGrace, execution jumps to CleanupOffset
+	// and the bytecode can save its state before the grace runs out. Grace is only granted once an execution, and when
+	// it runs out the CPU time error from when the limit was hit is returned.
+	OnCPUExhausted func(*VM) GraceDecision
+
+	// CleanupOffset is the bytecode index execution jumps to when OnCPUExhausted grants grace.
+	CleanupOffset uint64
+
+	// CheckInterval is the number of instructions between checks of the CPU time, deadline, Stop, Pause and raised
+	// interrupts, which are also made straight after every system call. The registers are published for Snapshot at the
+	// same time. Lower values make those react faster at the cost of speed. 0 means 1024.
+	CheckInterval uint64
+
+	// MaxInstructions is used to say how many instructions each execution can run before InstructionBudgetExhausted is
+	// returned. Unlike MaxCPUTime, the same bytecode always stops at the same instruction. The PC points at the
+	// instruction which would have run next, so Continue runs the next batch. 0 means unlimited.
+	MaxInstructions uint64
+
+	// MaxBackwardJumps is used to say how many jumps to the same or an earlier bytecode index each execution can make
+	// before LoopBudgetExhausted is returned. Bytecode can only loop by jumping backwards, so this bounds execution like
+	// MaxInstructions while only costing anything on jumps. 0 means unlimited.
+	MaxBackwardJumps uint64
+
+	// CostTable is used to turn on gas metering. Each instruction costs the gas its opcode costs in the table, which is
+	// taken from GasRemaining before the instruction runs. If there isn't enough gas left, OutOfGas is returned with the
+	// PC pointing at the instruction and GasRemaining left as it was. nil turns gas metering off.
+	CostTable CostTable
+
+	// GasRemaining is the gas left for instructions and ChargeGas when CostTable is set.
+	GasRemaining uint64
+
+	// CollectStats is used to count the instructions, system calls, memory accesses and jumps of each execution for
+	// LastStats. It is off by default since counting slows execution down.
+	CollectStats bool
+
+	// ProfileOpcodes is used to count the number of times each opcode is executed for OpcodeProfile. The counts add up
+	// across executions until ResetOpcodeProfile is called. It is off by default.
+	ProfileOpcodes bool
+
+	// OnMemoryWrite is called after every successful write to memory with the address, the width in bytes and the
+	// value written. Dumps, the host write helpers such as WriteBytes and Rollback all call it, but writes to memory
+	// mapped io don't. Dumps call it on the goroutine executing the bytecode while the instruction runs, so it must be
+	// fast. Memory accesses take the slow path while it is set, and it has to be set before execution starts.
+	OnMemoryWrite func(Address uint64, Width int, Value uint64)
+
+	// CollectCoverage is used to record the offset of every instruction executed for Coverage. It is off by default.
+	CollectCoverage bool
+
+	// OnJump is called by every jump instruction with the bytecode index of the jump, the target and if the jump was
+	// taken. Conditional jumps which fall through report their target with taken set to false. Jumps into interrupt
+	// handlers and back out of them are reported as taken, with the bytecode index of the instruction which was about to
+	// run as where they came from. It runs on the goroutine executing the bytecode, so it must be fast.
+	OnJump func(From, To uint64, Taken bool)
+
+	// MemoryProfileBucketSize is used to turn on the memory heat map returned by MemoryProfile. Loads and dumps are
+	// counted in buckets of this many bytes, such as 64 for cache lines. Memory accesses take the slow path while it is
+	// set. 0 turns it off, which is the default.
+	MemoryProfileBucketSize uint64
+
+	// InstructionsExecuted is the number of instructions the last execution ran, including the instruction which
+	// failed if it returned a error.
+	InstructionsExecuted uint64
 
-	// InstructionUint64Load is used to load a uint64 argument into R1.
-	InstructionUint64Load
+	// Syscalls is used to define system calls the virtual machine can do.
+	// An error being returned here is given to the bytecode in R3 and R4 unless it is a FatalSyscallError, which errors
+	// the execution of the VM. So do the errors the VM returns for system calls, such as a InvalidSyscallError. Panics
+	// are returned as a SyscallPanicError unless PropagateSyscallPanics is set. Time spent in system calls doesn't
+	// count towards MaxCPUTime. System calls which replace or remove other system calls while executing should use
+	// SetSyscall and ClearSyscall, since a entry replaced by writing to the map might not be seen until the next
+	// execution.
+	Syscalls map[uint64]func(*VM) error
 
-	// InstructionUint8Load is used to load a uint8 argument into R1 from the memory location specified.
-	InstructionMemoryUint8Load
+	// Defines the CPU registers.
+	Registers [8]uint64
 
-	// InstructionUint16Load is used to load a uint16 argument into R1 from the memory location specified.
-	InstructionMemoryUint16Load
+	// PC is the bytecode index execution is at. It is only updated when Execute returns and before system calls. After a
+	// error it points at the instruction which failed, and after a successful run it is the length of the bytecode.
+	PC uint64
 
-	// InstructionUint32Load is used to load a uint32 argument into R1 from the memory location specified.
-	InstructionMemoryUint32Load
+	// RandomState is the state of the splitmix64 generator used by InstructionRand. Use SeedRandom to seed it.
+	RandomState uint64
 
-	// InstructionUint64Load is used to load a uint64 argument into R1 from the memory location specified.
-	InstructionMemoryUint64Load
+	// StrictArithmetic is used to make division and modulo by 0 return DivideByZero instead of setting R4 to 1.
+	StrictArithmetic bool
 
-	// InstructionMoveR1ToR2 is used to move R1 into R2.
-	InstructionMoveR1ToR2
+	// MaxSyscalls is the number of system calls which can be made per execution, or in total if
+	// CumulativeSyscallBudgets is set. Making another fails with a SyscallBudgetError. 0 means there is no limit.
+	MaxSyscalls uint64
 
-	// InstructionMoveR1ToR3 is used to move R1 into R3.
-	InstructionMoveR1ToR3
+	// CumulativeSyscallBudgets is used to stop the system call counts of MaxSyscalls and SetSyscallBudget being reset
+	// when each execution starts. Use ResetSyscallBudgets to reset them.
+	CumulativeSyscallBudgets bool
 
-	// InstructionMoveR2ToR1 is used to move R2 into R1.
-	InstructionMoveR2ToR1
+	// PropagateSyscallPanics is used to let panics from system calls unwind through Execute instead of being returned as
+	// a SyscallPanicError.
+	PropagateSyscallPanics bool
 
-	// InstructionMoveR2ToR3 is used to move R2 into R3.
-	InstructionMoveR2ToR3
+	// PreserveFlags is used to stop instructions clobbering R4. By default every instruction apart from the jumps sets
+	// R4 to 0 unless it produces a flag. When this is set, only the division and modulo instructions (which set R4 to 1
+	// on division by 0 and 0 otherwise) and system calls (which may set it themselves) write to R4, so it can hold a
+	// flag or value across other instructions.
+	PreserveFlags bool
 
-	// InstructionFlipR1R2 is used to flip the values of R1 and R2.
-	InstructionFlipR1R2
+	// MaxMemory is the size Grow and SbrkSyscall can't grow the memory past. 0 means DefaultMaxMemory, or no limit for
+	// paged memory. It does not shrink memory which is already bigger. Use WithMaxMemory to set it when creating the
+	// virtual machine.
+	MaxMemory uint64
 
-	// InstructionMoveR3ToR1 is used to move R3 into R1.
-	InstructionMoveR3ToR1
+	// GuardSize is used to trap accesses to low memory. Any memory access starting below it returns a NullPageFault, so
+	// dereferencing "null" or a small offset from it fails instead of corrupting the start of memory. 0 disables it.
+	GuardSize uint64
 
-	// InstructionMoveR3ToR2 is used to move R3 into R2.
-	InstructionMoveR3ToR2
+	// WrapAddresses is used to make memory locations wrap around modulo the memory size like 8-bit machines instead of
+	// returning InvalidMemoryLocation. Multi-byte accesses which run off the end of the memory wrap a byte at a time.
+	WrapAddresses bool
 
-	// InstructionFlipR1R3 is used to flip the values of R1 and R3.
-	InstructionFlipR1R3
+	// RequireAlignment is used to make uint16, uint32 and uint64 loads and dumps return UnalignedAccess when the memory
+	// location is not a multiple of the width. By default unaligned accesses are allowed.
+	RequireAlignment bool
 
-	// InstructionMoveR4ToR1 is used to move R4 into R1.
-	InstructionMoveR4ToR1
+	// TrackInitialization is a debug mode used to catch reads of memory which was never written. Dumps and
+	// MarkInitialized mark bytes as initialized, and loads covering a byte which isn't return UninitializedRead. This
+	// makes every memory access take the slow path, so it should only be used for debugging.
+	TrackInitialization bool
 
-	// InstructionMoveR4ToR2 is used to move R4 into R2.
-	InstructionMoveR4ToR2
+	// UninitializedReadHandler is used to handle reads of uninitialized memory when TrackInitialization is set instead
+	// of returning UninitializedRead. The address and width are of the whole access. If it returns nil, the read
+	// carries on.
+	UninitializedReadHandler func(Address, Width uint64) error
 
-	// InstructionMoveR4ToR3 is used to move R4 into R3.
-	InstructionMoveR4ToR3
+	// MaskShiftCounts is used to make the shift instructions use R2 & 63 as the shift count like most hardware does.
+	// By default shifting by 64 or more follows Go and gives 0.
+	MaskShiftCounts bool
 
-	// InstructionUint8Dump is used to dump a uint8 argument from R1 into the memory location specified.
-	InstructionUint8Dump
+	// program is the bytecode stored by LoadProgram.
+	program []byte
 
-	// InstructionUint16Dump is used to dump a uint16 argument from R1 into the memory location specified.
-	InstructionUint16Dump
+	// mode is the mode set when the virtual machine was created.
+	mode Mode
 
-	// InstructionUint32Dump is used to dump a uint32 argument from R1 into the memory location specified.
-	InstructionUint32Dump
+	// io is the memory mapped io ranges added by MapIO.
+	io []ioRange
 
-	// InstructionUint64Dump is used to dump a uint64 argument from R1 into the memory location specified.
-	InstructionUint64Dump
+	// rom and romBase are the read-only data and address added by AttachROM.
+	rom     []byte
+	romBase uint64
 
-	// InstructionUnsignedAdd is used to add R2 to R1 and treat them as unsigned integers. The result is stored in R1.
-	InstructionUnsignedAdd
+	// shadow is the bitmap of initialized bytes used by TrackInitialization. Each entry holds the bits for 64 bytes.
+	shadow map[uint64]uint64
 
-	// InstructionSignedAdd is used to add R2 to R1 and treat them as signed integers. The result is stored in R1.
-	InstructionSignedAdd
+	// transaction is the transaction started by BeginTransaction.
+	transaction *transaction
 
-	// InstructionUnsignedSub is used to subtract R2 from R1 and treat them as unsigned integers. The result is stored in R1.
-	InstructionUnsignedSub
+	// paged is the sparse memory used instead of Memory by virtual machines created with NewPagedVM.
+	paged *pagedMemory
 
-	// InstructionSignedSub is used to subtract R2 from R1 and treat them as signed integers. The result is stored in R1.
-	InstructionSignedSub
+	// running is set to 1 while the virtual machine is executing. It is only accessed atomically.
+	running uint32
 
-	// InstructionUnsignedDiv is used to divide R1 against R2 and treat them as unsigned integers. The result is stored in R1, and if you try and divide by 0 it returns 1 in R4.
-	InstructionUnsignedDiv
+	// snapshot is the RegisterSnapshot last published by the executing goroutine.
+	snapshot atomic.Value
 
-	// InstructionSignedDiv is used to divide R1 against R2 and treat them as signed integers. The result is stored in R1, and if you try and divide by 0 it returns 1 in R4.
-	InstructionSignedDiv
+	// stop is set by Stop and Pause to the reason execution should stop. It is only accessed atomically.
+	stop uintptr
 
-	// InstructionUnsignedMul is used to multiply R1 with R2 and treat them as unsigned integers. The result is stored in R1.
-	InstructionUnsignedMul
+	// cpuTimeUsed is the CPU time used by executions since the last ResetCPUBudget.
+	cpuTimeUsed time.Duration
 
-	// InstructionSignedMul is used to multiply R1 with R2 and treat them as signed integers. The result is stored in R1.
-	InstructionSignedMul
+	// cpuTimeExtension is the CPU time added by ExtendCPUTime which the current execution hasn't applied yet.
+	cpuTimeExtension time.Duration
 
-	// InstructionUnsignedMod is used to mod R1 against R2 and treat them as unsigned integers. The result is stored in R1, and if you try and divide by 0 it returns 1 in R4.
-	InstructionUnsignedMod
+	// paused is set when the last execution returned Paused, and pausedCPUTime is the CPU time it had left.
+	paused        bool
+	pausedCPUTime time.Duration
 
-	// InstructionSignedMod is used to mod R1 against R2 and treat them as signed integers. The result is stored in R1, and if you try and divide by 0 it returns 1 in R4.
-	InstructionSignedMod
+	// interruptHandlers is the bytecode indexes of the handlers set by SetInterruptHandler.
+	interruptHandlers map[uint8]uint64
 
-	// InstructionBitwiseAnd is used to perform bitwise and on R1 with R2. The result is stored in R1.
-	InstructionBitwiseAnd
+	// interruptsPending is the bitmap of raised interrupt vectors and interruptRaised is set to 1 when one is raised.
+	// They are only accessed atomically.
+	interruptsPending [8]uint32
+	interruptRaised   uint32
 
-	// InstructionBitwiseOr is used to perform bitwise or on R1 with R2. The result is stored in R1.
-	InstructionBitwiseOr
+	// inInterrupt is set while a interrupt handler is running and interruptPC is the bytecode index it returns to.
+	inInterrupt bool
+	interruptPC uint64
 
-	// InstructionBitwiseXor is used to perform bitwise xor on R1 with R2. The result is stored in R1.
-	InstructionBitwiseXor
+	// breakpoints is the bytecode indexes set by SetBreakpoint and SetConditionalBreakpoint and their conditions. The
+	// condition is nil for breakpoints which always stop. It is nil when there are none.
+	breakpoints map[uint64]func(*VM) bool
 
-	// InstructionBitwiseLeftShift is used to shift R1 left the number of bits specified in R2. The result is stored in R1.
-	InstructionBitwiseLeftShift
+	// atBreakpoint is set when the last execution returned BreakpointHit, so execution resumed from the breakpoint
+	// doesn't stop on it again straight away.
+	atBreakpoint bool
 
-	// InstructionBitwiseRightShift is used to shift R1 right the number of bits specified in R2. The result is stored in R1.
-	InstructionBitwiseRightShift
+	// lastStats is the stats returned by LastStats.
+	lastStats ExecutionStats
 
-	// InstructionJmp is used to jump to another place in the bytecode.
-	InstructionJmp
+	// syscallHook is used by Record and Replay to run every system call instead of calling it directly. The call is nil
+	// if the system call isn't defined.
+	syscallHook func(Number uint64, Call func(*VM) error) error
 
-	// InstructionJmpIfZero is used to jump if R3 is not equal to R1.
-	InstructionJmpIfZero
+	// syscallRegistry is the registry set by WithSyscallRegistry. Mount registers calls with it while Syscalls is still
+	// its map.
+	syscallRegistry *SyscallRegistry
 
-	// InstructionJmpIfEq is used to jump if R3 is equal to R1.
-	InstructionJmpIfEq
+	// syscallTableBuf is the buffer reused by buildSyscallTable.
+	syscallTableBuf []func(*VM) error
 
-	// InstructionJmpIfNe is used to jump if R3 is not equal to R1.
-	InstructionJmpIfNe
+	// syscallVersion is increased by SetSyscall and ClearSyscall so system call tables are dropped.
+	syscallVersion uint64
 
-	// InstructionJmpIfGt is used to jump if R1 is greater than R3.
-	InstructionJmpIfGt
+	// syscallBudgets is the budgets set by SetSyscallBudget.
+	syscallBudgets map[uint64]*syscallBudget
 
-	// InstructionJmpIfLt is used to jump if R1 is less than R3.
-	InstructionJmpIfLt
+	// syscallCalls is the number of system calls counted against MaxSyscalls.
+	syscallCalls uint64
 
-	// InstructionJmpIfGtOrEqual is used to jump if R1 is greater than or equal to R3.
-	InstructionJmpIfGtOrEqual
-	
-	// InstructionJmpIfLtOrEqual is used to jump if R1 is less than or equal to R3.
-	InstructionJmpIfLtOrEqual
+	// syscallFilters is the filters added by PushSyscallFilter.
+	syscallFilters []SyscallFilter
 
-	// InstructionSyscall is used to make a system call with the instruction in R1. System calls are expected to throw errors in R3.
-	InstructionSyscall
-)
+	// mounts is the modules added by Mount.
+	mounts []mountedModule
 
-// InvalidInstructionArgument is used when the instruction expects a argument but none is provided.
-var InvalidInstructionArgument = errors.New("no argument provided as the instruction expects one")
+	// reverse is the journal used by StepBack. It is nil unless WithReverseJournal is used.
+	reverse *reverseJournal
 
-// InvalidMemoryLocation is an error which is thrown when the memory location is outside of the memory length.
-var InvalidMemoryLocation = errors.New("memory location is outside of the maximum array size")
+	// irreversibleSyscalls is the system calls set by WithIrreversibleSyscalls.
+	irreversibleSyscalls map[uint64]bool
 
-// InvalidSyscall is an error which is thrown when the bytes reference a syscall which doesn't exist.
-var InvalidSyscall = errors.New("syscall is invalid")
+	// ctx is the context returned by Context. It is set by ExecuteWithLabels.
+	ctx context.Context
 
-// CPUTimeExhausted is returned when the amount of CPU time a user has was exhausted.
-var CPUTimeExhausted = errors.New("cpu time is exhausted")
+	// coverage is the bitset returned by Coverage.
+	coverage Coverage
 
-// UnknownInstruction is used when the CPU instruction is unknown.
-var UnknownInstruction = errors.New("unknown cpu instruction")
+	// traceEncoder is the encoder set by SetTraceEncoder.
+	traceEncoder TraceEncoder
 
-// VM is used to represent the virtual machine.
-type VM struct {
-	// Memory is used to represent the memory of the virtual machine.
-	Memory []byte
+	// opcodeProfile is the counts returned by OpcodeProfile. It is nil until a execution runs with ProfileOpcodes set.
+	opcodeProfile *[256]uint64
 
-	// MaxCPUTime is used to say how much CPU time a VM can use. 0 means unlimited.
-	MaxCPUTime time.Duration
+	// memoryProfile is the counts returned by MemoryProfile. It is nil until memory is accessed with
+	// MemoryProfileBucketSize set.
+	memoryProfile *memoryProfile
+}
 
-	// Syscalls is used to define system calls the virtual machine can do.
-	// An error being returned here will error the execution of the VM.
-	Syscalls map[uint64]func(*VM) error
+// Mode is used to define the width of the virtual machine.
+type Mode uint8
 
-	// Defines the CPU registers.
-	Registers [4]uint64
+const (
+	// Mode64 is the default mode. Registers are 64-bit and memory location and jump target arguments are uint64s.
+	Mode64 Mode = iota
+
+	// Mode32 is used for small programs. Registers are masked to 32 bits after every instruction, memory location and
+	// jump target arguments are uint32s, and signed instructions treat the registers as int32s. Loads of immediate
+	// values keep their argument widths but the value is masked. Float instructions work on float64 bit patterns which
+	// don't fit in 32 bits, so they fail with FloatUnsupported and the float features aren't reported.
+	Mode32
+)
+
+// Mode is used to get the mode of the virtual machine.
+func (v *VM) Mode() Mode {
+	return v.mode
 }
 
-// Execute is used to execute bytecode on the virtual machine.
+// Execute is used to execute bytecode on the virtual machine. The bytecode doesn't have to be trusted: any input either
+// runs or returns a error, and the interpreter never panics or touches host memory outside of the bytecode and the
+// memory of the virtual machine. Panics from system calls are returned as a SyscallPanicError, but panics from memory
+// mapped io handlers are not recovered. Set MaxCPUTime to stop bytecode which loops forever.
 func (v *VM) Execute(Bytecode []byte) error {
-	// Get the bytecode location and length.
+	return v.run(Bytecode, 0, 0, false)
+}
+
+// Continue is used to continue executing bytecode from the PC. Registers and memory are kept as they are in the VM, so
+// after Execute returns CPUTimeExhausted, calling Continue with the same bytecode gives the guest another time slice
+// and picks up at the instruction which would have run next.
+func (v *VM) Continue(Bytecode []byte) error {
+	if v.PC > uint64(len(Bytecode)) {
+		return fmt.Errorf("%w: pc %d is past the end of the bytecode", InvalidMemoryLocation, v.PC)
+	}
+	return v.run(Bytecode, v.PC, 0, false)
+}
+
+// Stop is used to make the current execution return Stopped within CheckInterval instructions, or before the next
+// instruction if it is called from a system call. It can be called from any goroutine. If nothing is executing, it does
+// nothing.
+func (v *VM) Stop() {
+	atomic.CompareAndSwapUintptr(&v.stop, 0, stopRequested)
+}
+
+// CPUTimeUsed is used to get the CPU time used by executions since the virtual machine was created or ResetCPUBudget
+// was last called. Only executions with a CPU time limit, deadline or cumulative budget set are measured.
+func (v *VM) CPUTimeUsed() time.Duration {
+	return v.cpuTimeUsed
+}
+
+// ResetCPUBudget is used to set CPUTimeUsed back to 0, which replenishes CumulativeCPUBudget.
+func (v *VM) ResetCPUBudget() {
+	v.cpuTimeUsed = 0
+}
+
+// ExtendCPUTime is used to give the current execution more CPU time. It is meant to be called from OnSoftLimit or a
+// system call, and only lasts for the current execution. It does nothing if MaxCPUTime is 0.
+func (v *VM) ExtendCPUTime(Duration time.Duration) {
+	v.cpuTimeExtension += Duration
+}
+
+// extendCPUTime is used to apply the CPU time added by ExtendCPUTime to the CPU time of the current execution.
+func (v *VM) extendCPUTime(CPUTime time.Duration) time.Duration {
+	if CPUTime != 0 {
+		CPUTime += v.cpuTimeExtension
+	}
+	v.cpuTimeExtension = 0
+	return CPUTime
+}
+
+// Pause is used to make the current execution return Paused within CheckInterval instructions, or before the next
+// instruction if it is called from a system call. It can be called from any goroutine. The PC, registers and the CPU
+// time left are kept, and Resume carries on from where it stopped without the CPU time going down while it is paused.
+// If nothing is executing, it does nothing.
+func (v *VM) Pause() {
+	atomic.CompareAndSwapUintptr(&v.stop, 0, stopPaused)
+}
+
+// Resume is used to carry on executing the bytecode after Pause with the CPU time which was left. NotPaused is returned
+// if the last execution wasn't paused. Continue, ExecuteN and Step from the PC also resume with the CPU time left.
+func (v *VM) Resume(Bytecode []byte) error {
+	if !v.paused {
+		return NotPaused
+	}
+	return v.Continue(Bytecode)
+}
+
+// Step is used to execute the instruction at the PC and move the PC to the next instruction. The same errors as Execute
+// are returned, and ProgramComplete is returned once the PC is at the end of the bytecode.
+func (v *VM) Step(Bytecode []byte) error {
+	if v.PC > uint64(len(Bytecode)) {
+		return fmt.Errorf("%w: pc %d is past the end of the bytecode", InvalidMemoryLocation, v.PC)
+	}
+	if err := v.run(Bytecode, v.PC, 1, false); err != nil {
+		return err
+	}
+	if v.PC == uint64(len(Bytecode)) {
+		return ProgramComplete
+	}
+	return nil
+}
+
+// ExecuteN is used to execute at most N instructions of the bytecode from the PC and return the number executed.
+// Running out of instructions isn't a error: nil is returned with the PC pointing at the next instruction, so the next
+// call carries on from there. The bytecode has finished when the PC is the length of the bytecode.
+func (v *VM) ExecuteN(Bytecode []byte, N uint64) (uint64, error) {
+	if v.PC > uint64(len(Bytecode)) {
+		return 0, fmt.Errorf("%w: pc %d is past the end of the bytecode", InvalidMemoryLocation, v.PC)
+	}
+	if N == 0 {
+		return 0, nil
+	}
+	err := v.run(Bytecode, v.PC, N, false)
+	if errors.Is(err, VMBusy) {
+		return 0, err
+	}
+	return v.InstructionsExecuted, err
+}
+
+// ExecuteFromMemory is used to execute the memory of the virtual machine as bytecode from the entry point specified.
+// Code and data share the memory, so jump targets and arguments are checked against the memory length and dumps over
+// the bytecode are seen by the next instruction fetched. Execution ends when it runs off the end of the memory.
+func (v *VM) ExecuteFromMemory(Entry uint64) error {
+	if Entry > uint64(len(v.Memory)) {
+		return fmt.Errorf("%w: entry point %d is past the end of the memory", InvalidMemoryLocation, Entry)
+	}
+	return v.run(v.Memory, Entry, 0, true)
+}
+
+// run is used to execute the bytecode starting at the bytecode index specified. If Limit isn't 0, run returns nil with
+// the PC pointing at the next instruction after Limit instructions. If CodeInMemory is set, the bytecode is the memory
+// and follows it if a system call replaces it. The PC is only set once the virtual machine is known not to be executing
+// already, so a concurrent call returning VMBusy doesn't touch the running one.
+func (v *VM) run(Bytecode []byte, Start, Limit uint64, CodeInMemory bool) (err error) {
+	// Mark the virtual machine as running. The deferred store also runs if a system call panics, so the virtual machine
+	// can be used again after the panic is recovered.
+	if !atomic.CompareAndSwapUint32(&v.running, 0, 1) {
+		return VMBusy
+	}
+	defer atomic.StoreUint32(&v.running, 0)
+
+	// Execution resumed from the breakpoint it stopped at doesn't stop on it again straight away.
+	resumeIndex := ^uint64(0)
+	if v.atBreakpoint && Start == v.PC {
+		resumeIndex = Start
+	}
+	v.atBreakpoint = false
+
+	// Execution resumed from where it was paused gets the CPU time it had left instead of MaxCPUTime.
+	cpuTime := v.MaxCPUTime
+	if v.paused && Start == v.PC && cpuTime != 0 {
+		cpuTime = v.pausedCPUTime
+	}
+	v.paused = false
+	v.PC = Start
+
+	// Get the bytecode length.
 	bytecodeLen := uint64(len(Bytecode))
-	if bytecodeLen == 0 {
+	if v.PC == bytecodeLen {
 		// Return no errors. No bytecode was executed.
 		return nil
 	}
-	bytecodePtr := (unsafe.Pointer)(&Bytecode[0])
 
-	// Get the virtual memory location and length.
-	virtualMemoryLen := uint64(len(v.Memory))
-	var virtualMemory uintptr
-	if virtualMemoryLen != 0 {
-		virtualMemory = (uintptr)((unsafe.Pointer)(&v.Memory[0]))
+	// Get the virtual memory and its length. All accesses go through the slice so the garbage collector always sees
+	// both the bytecode and the memory as being in use.
+	memory := v.Memory
+	virtualMemoryLen := v.memorySize()
+
+	// Defines if memory accesses have to go through the slow path which applies the memory options.
+	slowMemory := v.slowMemory()
+
+	// Defines if division by 0 is an error.
+	strictArithmetic := v.StrictArithmetic
+
+	// Defines the mask instructions which don't produce a flag apply to R4. This clears R4 unless flags are preserved.
+	r4Mask := uint64(0)
+	if v.PreserveFlags {
+		r4Mask = ^uint64(0)
+	}
+
+	// Defines the mask applied to shift counts. Go semantics (shifting by 64 or more gives 0) are used unless shift counts
+	// are masked.
+	shiftMask := ^uint64(0)
+	if v.MaskShiftCounts {
+		shiftMask = 63
+	}
+
+	// Defines the width of memory location and jump target arguments and the shift used to sign extend registers. In
+	// 32-bit mode the registers are masked to 32 bits, so they are sign extended from bit 31 for signed operations.
+	addressWidth := uint64(8)
+	signShift := uint(0)
+	mode32 := v.mode == Mode32
+	if mode32 {
+		addressWidth = 4
+		signShift = 32
+		v.maskRegisters()
 	}
 
 	// A pointer to the registers array.
@@ -205,240 +559,736 @@ func (v *VM) Execute(Bytecode []byte) error {
 	r2 := &v.Registers[1]
 	r3 := &v.Registers[2]
 	r4 := &v.Registers[3]
-
-	// Defines if we should stop.
-	shouldStop := uintptr(0)
-
-	// Defines if we should do time checks and handle them if so.
-	doTimeChecks := v.MaxCPUTime != 0
-	var timer *time.Timer
+	r5 := &v.Registers[4]
+	r6 := &v.Registers[5]
+	r7 := &v.Registers[6]
+	r8 := &v.Registers[7]
+
+	// Clear the stop flag so a Stop or Pause call while nothing was running can't stop this execution.
+	atomic.StoreUintptr(&v.stop, 0)
+
+	// Defines the number of instructions between checks and the number left until the next one. The first check is
+	// made before the first instruction.
+	checkInterval := v.CheckInterval
+	if checkInterval == 0 {
+		checkInterval = defaultCheckInterval
+	}
+	untilCheck := uint64(1)
+
+	// Defines if we should do time checks. The CPU time used is the time spent before the last system call plus the
+	// time since the last system call returned. softLimit is set until OnSoftLimit has been called.
+	softLimit := v.SoftCPUTime != 0 && v.OnSoftLimit != nil
+	deadline := v.Deadline
+	cumulativeBudget := v.CumulativeCPUBudget
+	doTimeChecks := cpuTime != 0 || softLimit || !deadline.IsZero() || cumulativeBudget != 0
+	v.cpuTimeExtension = 0
+	var elapsed time.Duration
+	var resumed time.Time
 	if doTimeChecks {
-		timer = time.AfterFunc(v.MaxCPUTime, func() {
-			atomic.StoreUintptr(&shouldStop, 1)
-		})
+		resumed = time.Now()
+		defer func() {
+			v.cpuTimeUsed += elapsed + time.Since(resumed)
+		}()
 	}
+
+	// Defines the number of instructions executed. The registers are published when execution returns so Snapshot sees
+	// the final values.
+	instructionCount := uint64(0)
 	defer func() {
-		if timer != nil {
-			timer.Stop()
-		}
+		v.InstructionsExecuted = instructionCount
+		v.publish(v.PC, instructionCount)
 	}()
 
-	// Go through the bytecode.
-	bytecodeIndex := uint64(0)
+	// Defines the counters for LastStats and OpcodeProfile if they are being collected. Only the number of times each
+	// opcode runs and the jumps taken are counted while executing, and the rest of the stats are worked out from them.
+	// Without stats, the opcodes are counted straight into the profile.
+	var opcodeCounts *[256]uint64
+	var jumpsTaken uint64
+	if v.ProfileOpcodes && v.opcodeProfile == nil {
+		v.opcodeProfile = new([256]uint64)
+	}
+	if v.CollectStats {
+		opcodeCounts = new([256]uint64)
+		start := time.Now()
+		defer func() {
+			v.recordStats(opcodeCounts, jumpsTaken, time.Since(start))
+			if v.ProfileOpcodes {
+				for opcode, count := range opcodeCounts {
+					v.opcodeProfile[opcode] += count
+				}
+			}
+		}()
+	} else if v.ProfileOpcodes {
+		opcodeCounts = v.opcodeProfile
+	}
+
+	// Defines the number of instructions which can be executed. This is the lower of the budget and the limit so only
+	// one check is needed for each instruction.
+	maxInstructions := v.MaxInstructions
+	if maxInstructions == 0 {
+		maxInstructions = ^uint64(0)
+	}
+	if Limit != 0 && Limit < maxInstructions {
+		maxInstructions = Limit
+	}
+
+	// Defines the CPU time error being held back while the cleanup code runs and the CPU time used and instruction count
+	// the grace ends at. 0 means there is no limit of that kind.
+	var graceErr *CPUTimeError
+	var graceTime time.Duration
+	var graceInstructions uint64
+
+	// Defines the gas cost of each opcode if gas is metered.
+	var costs *[256]uint64
+	if v.CostTable != nil {
+		costs = v.CostTable.costs()
+	}
+
+	// Start the system call budgets again unless they are cumulative.
+	if !v.CumulativeSyscallBudgets {
+		v.ResetSyscallBudgets()
+	}
+
+	// Defines the table system calls are looked up in instead of the map once enough have been made. It is taken from
+	// Syscalls, and is dropped if a system call changes Syscalls.
+	var syscallTable []func(*VM) error
+	var syscallKey syscallTableKey
+	syscallMapLookups := 0
+
+	// Defines the breakpoints and the jump hook.
+	breakpoints := v.breakpoints
+	onJump := v.OnJump
+
+	// Defines the coverage bitset if coverage is being collected. It has a bit for every offset in the bytecode.
+	var coverage Coverage
+	if v.CollectCoverage {
+		coverage = v.growCoverage(bytecodeLen)
+	}
+
+	// Defines the tracer if a trace encoder is set. The last instruction is encoded when execution returns, and errors
+	// from the encoder are returned unless execution already failed.
+	var trace *tracer
+	if v.traceEncoder != nil {
+		trace = &tracer{encoder: v.traceEncoder}
+		defer func() {
+			if traceErr := trace.end(v); traceErr != nil && err == nil {
+				err = traceErr
+			}
+		}()
+	}
+
+	// Defines the number of backward jumps which can be made and where the jump being made goes.
+	maxBackwardJumps := v.MaxBackwardJumps
+	if maxBackwardJumps == 0 {
+		maxBackwardJumps = ^uint64(0)
+	}
+	var backwardJumps, jumpTarget uint64
+
+	// Go through the bytecode from the PC.
+	bytecodeIndex := v.PC
 	for bytecodeIndex != bytecodeLen {
 	s:
-		// Do a time check.
-		if doTimeChecks {
-			if atomic.LoadUintptr(&shouldStop) == 1 {
-				return CPUTimeExhausted
+		// Publish the registers, check the CPU time, check if execution was stopped and check for interrupts every
+		// checkInterval instructions. The CPU time is checked first so a Stop call from OnSoftLimit stops execution
+		// straight away.
+		untilCheck--
+		if untilCheck == 0 {
+			untilCheck = checkInterval
+			v.publish(bytecodeIndex, instructionCount)
+			if doTimeChecks {
+				used := elapsed + time.Since(resumed)
+				if softLimit && used >= v.SoftCPUTime {
+					// Call the handler with the clock stopped and apply any extension it makes.
+					softLimit = false
+					elapsed = used
+					v.PC = bytecodeIndex
+					v.OnSoftLimit(v)
+					cpuTime = v.extendCPUTime(cpuTime)
+					resumed = time.Now()
+
+					// The handler may have replaced or grown the memory or changed the memory options, so get the
+					// memory, its length and if the slow path is needed again.
+					memory = v.Memory
+					virtualMemoryLen = v.memorySize()
+					slowMemory = v.slowMemory()
+					if CodeInMemory {
+						// The bytecode is the memory, so it must follow it.
+						Bytecode = memory
+						bytecodeLen = uint64(len(memory))
+						if coverage != nil {
+							coverage = v.growCoverage(bytecodeLen)
+						}
+						if bytecodeIndex >= bytecodeLen {
+							return &VMError{Offset: bytecodeIndex, Err: InvalidMemoryLocation}
+						}
+					}
+				}
+				if graceErr == nil {
+					var err *CPUTimeError
+					if cpuTime != 0 && used >= cpuTime {
+						err = &CPUTimeError{
+							Limit: cpuTime, Elapsed: used, Instructions: instructionCount, PC: bytecodeIndex,
+						}
+					} else if cumulativeBudget != 0 && v.cpuTimeUsed+used >= cumulativeBudget {
+						err = &CPUTimeError{
+							Limit: cumulativeBudget, Elapsed: v.cpuTimeUsed + used, Instructions: instructionCount, PC: bytecodeIndex,
+						}
+					}
+					if err != nil {
+						if v.OnCPUExhausted == nil {
+							return v.fail(Bytecode, bytecodeIndex, 0, err)
+						}
+
+						// Call the handler with the clock stopped and jump to the cleanup code if it grants grace.
+						elapsed = used
+						v.PC = bytecodeIndex
+						decision := v.OnCPUExhausted(v)
+						resumed = time.Now()
+						if decision == Kill {
+							return v.fail(Bytecode, bytecodeIndex, 0, err)
+						}
+
+						// The handler may have replaced or grown the memory or changed the memory options, so get the
+						// memory, its length and if the slow path is needed again.
+						memory = v.Memory
+						virtualMemoryLen = v.memorySize()
+						slowMemory = v.slowMemory()
+						if CodeInMemory {
+							// The bytecode is the memory, so it must follow it.
+							Bytecode = memory
+							bytecodeLen = uint64(len(memory))
+							if coverage != nil {
+								coverage = v.growCoverage(bytecodeLen)
+							}
+						}
+						if v.CleanupOffset >= bytecodeLen {
+							return v.fail(Bytecode, bytecodeIndex, v.CleanupOffset, InvalidMemoryLocation)
+						}
+						graceErr = err
+						if decision.duration != 0 {
+							graceTime = used + decision.duration
+						}
+						if decision.instructions != 0 {
+							graceInstructions = instructionCount + decision.instructions
+							if graceInstructions < maxInstructions {
+								maxInstructions = graceInstructions
+							}
+						}
+						bytecodeIndex = v.CleanupOffset
+					}
+				} else if graceTime != 0 && used >= graceTime {
+					return v.fail(Bytecode, bytecodeIndex, 0, graceErr)
+				}
+				if !deadline.IsZero() && !time.Now().Before(deadline) {
+					return v.fail(Bytecode, bytecodeIndex, 0, DeadlineExceeded)
+				}
+			}
+
+			// Check if Stop or Pause has stopped execution.
+			if reason := atomic.LoadUintptr(&v.stop); reason != 0 {
+				if reason == stopPaused {
+					// Keep the CPU time which is left. At least a nanosecond is kept since 0 would mean no limit.
+					v.paused = true
+					if cpuTime != 0 {
+						v.pausedCPUTime = cpuTime - elapsed - time.Since(resumed)
+						if v.pausedCPUTime <= 0 {
+							v.pausedCPUTime = 1
+						}
+					}
+					return v.fail(Bytecode, bytecodeIndex, 0, Paused)
+				}
+				return v.fail(Bytecode, bytecodeIndex, 0, Stopped)
+			}
+
+			// Jump to the handler of a pending interrupt unless a handler is already running.
+			if atomic.LoadUint32(&v.interruptRaised) != 0 && !v.inInterrupt {
+				if offset, ok := v.takeInterrupt(); ok {
+					if offset >= bytecodeLen {
+						return v.fail(Bytecode, bytecodeIndex, offset, InvalidMemoryLocation)
+					}
+					v.inInterrupt = true
+					v.interruptPC = bytecodeIndex
+					if onJump != nil {
+						onJump(bytecodeIndex, offset, true)
+					}
+					bytecodeIndex = offset
+				}
+			}
+		}
+
+		// Check the instruction budget and limit.
+		if instructionCount == maxInstructions {
+			if graceErr != nil && instructionCount == graceInstructions {
+				return v.fail(Bytecode, bytecodeIndex, 0, graceErr)
+			}
+			if instructionCount == Limit {
+				v.PC = bytecodeIndex
+				return nil
+			}
+			return v.fail(Bytecode, bytecodeIndex, 0, InstructionBudgetExhausted)
+		}
+
+		// Stop at breakpoints.
+		if breakpoints != nil {
+			if cond, ok := breakpoints[bytecodeIndex]; ok && bytecodeIndex != resumeIndex {
+				// The condition sees the registers and the PC as they are before the instruction runs.
+				v.PC = bytecodeIndex
+				if cond == nil || cond(v) {
+					v.atBreakpoint = true
+					return v.fail(Bytecode, bytecodeIndex, 0, BreakpointHit)
+				}
+
+				// The condition may have replaced or grown the memory or changed the memory options, so get the
+				// memory, its length and if the slow path is needed again.
+				memory = v.Memory
+				virtualMemoryLen = v.memorySize()
+				slowMemory = v.slowMemory()
+				if CodeInMemory {
+					// The bytecode is the memory, so it must follow it.
+					Bytecode = memory
+					bytecodeLen = uint64(len(memory))
+					if coverage != nil {
+						coverage = v.growCoverage(bytecodeLen)
+					}
+					if bytecodeIndex >= bytecodeLen {
+						return &VMError{Offset: bytecodeIndex, Err: InvalidMemoryLocation}
+					}
+				}
+			}
+			resumeIndex = ^uint64(0)
+		}
+
+		// Keep the state before the instruction for StepBack.
+		if v.reverse != nil {
+			v.reverse.push(v, bytecodeIndex)
+		}
+
+		// Charge the gas for the instruction.
+		if costs != nil {
+			cost := costs[Bytecode[bytecodeIndex]]
+			if cost > v.GasRemaining {
+				return v.fail(Bytecode, bytecodeIndex, 0, OutOfGas)
 			}
+			v.GasRemaining -= cost
+		}
+
+		// Trace the instruction, which encodes the one before.
+		if trace != nil {
+			if err := trace.begin(v, Bytecode, bytecodeIndex); err != nil {
+				return v.fail(Bytecode, bytecodeIndex, 0, err)
+			}
+		}
+
+		// Mark the instruction as covered.
+		if coverage != nil {
+			coverage[bytecodeIndex/64] |= 1 << (bytecodeIndex % 64)
+		}
+
+		// Count this instruction and remember where it starts for errors.
+		instructionCount++
+		instructionIndex := bytecodeIndex
+		if opcodeCounts != nil {
+			opcodeCounts[Bytecode[bytecodeIndex]]++
 		}
 
 		// Run a switch on this byte to get the instruction.
-		switch *(*uint8)(bytecodePtr) {
+		switch Bytecode[bytecodeIndex] {
 		// Load from bytecode instructions.
 		case InstructionUint8Load:
 			bytecodeIndex++
 			if bytecodeIndex == bytecodeLen {
-				return InvalidInstructionArgument
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
 			}
-			bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 1)
-			*r1 = uint64(*(*uint8)(bytecodePtr))
-			*r4 = 0
+			*r1 = uint64(Bytecode[bytecodeIndex])
+			*r4 &= r4Mask
 		case InstructionUint16Load:
 			bytecodeIndex += 2
 			if bytecodeIndex >= bytecodeLen {
-				return InvalidInstructionArgument
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
 			}
-			bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 1)
-			*r1 = uint64(*(*uint16)(bytecodePtr))
-			*r4 = 0
-			bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 1)
+			*r1 = uint64(getUint16(Bytecode, bytecodeIndex-1))
+			*r4 &= r4Mask
 		case InstructionUint32Load:
 			bytecodeIndex += 4
 			if bytecodeIndex >= bytecodeLen {
-				return InvalidInstructionArgument
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
 			}
-			bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 1)
-			*r1 = uint64(*(*uint32)(bytecodePtr))
-			*r4 = 0
-			bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 3)
+			*r1 = uint64(getUint32(Bytecode, bytecodeIndex-3))
+			*r4 &= r4Mask
 		case InstructionUint64Load:
 			bytecodeIndex += 8
 			if bytecodeIndex >= bytecodeLen {
-				return InvalidInstructionArgument
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			*r1 = getUint64(Bytecode, bytecodeIndex-7)
+			*r4 &= r4Mask
+
+		// Direct load from bytecode instructions.
+		case InstructionUint8LoadR2Direct:
+			bytecodeIndex++
+			if bytecodeIndex == bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			*r2 = uint64(Bytecode[bytecodeIndex])
+			*r4 &= r4Mask
+		case InstructionUint8LoadR3Direct:
+			bytecodeIndex++
+			if bytecodeIndex == bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			*r3 = uint64(Bytecode[bytecodeIndex])
+			*r4 &= r4Mask
+		case InstructionUint64LoadR2Direct:
+			bytecodeIndex += 8
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			*r2 = getUint64(Bytecode, bytecodeIndex-7)
+			*r4 &= r4Mask
+		case InstructionUint64LoadR3Direct:
+			bytecodeIndex += 8
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			*r3 = getUint64(Bytecode, bytecodeIndex-7)
+			*r4 &= r4Mask
+		case InstructionUint64LoadR5Direct:
+			bytecodeIndex += 8
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			*r5 = getUint64(Bytecode, bytecodeIndex-7)
+			*r4 &= r4Mask
+		case InstructionUint64LoadR6Direct:
+			bytecodeIndex += 8
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			*r6 = getUint64(Bytecode, bytecodeIndex-7)
+			*r4 &= r4Mask
+		case InstructionUint64LoadR7Direct:
+			bytecodeIndex += 8
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			*r7 = getUint64(Bytecode, bytecodeIndex-7)
+			*r4 &= r4Mask
+		case InstructionUint64LoadR8Direct:
+			bytecodeIndex += 8
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
 			}
-			bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 1)
-			*r1 = *(*uint64)(bytecodePtr)
-			*r4 = 0
-			bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 7)
+			*r8 = getUint64(Bytecode, bytecodeIndex-7)
+			*r4 &= r4Mask
 
 		// Load from virtual memory instructions.
 		case InstructionMemoryUint8Load:
-			bytecodeIndex += 8
+			bytecodeIndex += addressWidth
 			if bytecodeIndex >= bytecodeLen {
-				return InvalidInstructionArgument
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
 			}
-			bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 1)
-			memoryLocation := *(*uint64)(bytecodePtr)
-			bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 7)
-			if memoryLocation >= virtualMemoryLen {
-				return InvalidMemoryLocation
+			memoryLocation := getAddress(Bytecode, bytecodeIndex, addressWidth)
+			if slowMemory || memoryLocation >= virtualMemoryLen {
+				x, err := v.load(memoryLocation, 1)
+				if err != nil {
+					return v.fail(Bytecode, instructionIndex, memoryLocation, err)
+				}
+				*r1 = x
+			} else {
+				*r1 = uint64(memory[memoryLocation])
 			}
-			*r1 = uint64(*(*uint8)(unsafe.Pointer(virtualMemory + uintptr(memoryLocation))))
-			*r4 = 0
+			*r4 &= r4Mask
 		case InstructionMemoryUint16Load:
-			bytecodeIndex += 8
+			bytecodeIndex += addressWidth
 			if bytecodeIndex >= bytecodeLen {
-				return InvalidInstructionArgument
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
 			}
-			bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 1)
-			memoryLocation := *(*uint64)(bytecodePtr)
-			bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 7)
-			if memoryLocation+1 >= virtualMemoryLen {
-				return InvalidMemoryLocation
+			memoryLocation := getAddress(Bytecode, bytecodeIndex, addressWidth)
+			if slowMemory || virtualMemoryLen < 2 || memoryLocation > virtualMemoryLen-2 {
+				x, err := v.load(memoryLocation, 2)
+				if err != nil {
+					return v.fail(Bytecode, instructionIndex, memoryLocation, err)
+				}
+				*r1 = x
+			} else {
+				*r1 = uint64(getUint16(memory, memoryLocation))
 			}
-			*r1 = uint64(*(*uint16)(unsafe.Pointer(virtualMemory + uintptr(memoryLocation))))
-			*r4 = 0
+			*r4 &= r4Mask
 		case InstructionMemoryUint32Load:
-			bytecodeIndex += 8
+			bytecodeIndex += addressWidth
 			if bytecodeIndex >= bytecodeLen {
-				return InvalidInstructionArgument
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
 			}
-			bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 1)
-			memoryLocation := *(*uint64)(bytecodePtr)
-			bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 7)
-			if memoryLocation+3 >= virtualMemoryLen {
-				return InvalidMemoryLocation
+			memoryLocation := getAddress(Bytecode, bytecodeIndex, addressWidth)
+			if slowMemory || virtualMemoryLen < 4 || memoryLocation > virtualMemoryLen-4 {
+				x, err := v.load(memoryLocation, 4)
+				if err != nil {
+					return v.fail(Bytecode, instructionIndex, memoryLocation, err)
+				}
+				*r1 = x
+			} else {
+				*r1 = uint64(getUint32(memory, memoryLocation))
 			}
-			*r1 = uint64(*(*uint32)(unsafe.Pointer(virtualMemory + uintptr(memoryLocation))))
-			*r4 = 0
+			*r4 &= r4Mask
 		case InstructionMemoryUint64Load:
-			bytecodeIndex += 8
+			bytecodeIndex += addressWidth
 			if bytecodeIndex >= bytecodeLen {
-				return InvalidInstructionArgument
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
 			}
-			bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 1)
-			memoryLocation := *(*uint64)(bytecodePtr)
-			bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 7)
-			if memoryLocation+7 >= virtualMemoryLen {
-				return InvalidMemoryLocation
+			memoryLocation := getAddress(Bytecode, bytecodeIndex, addressWidth)
+			if slowMemory || virtualMemoryLen < 8 || memoryLocation > virtualMemoryLen-8 {
+				x, err := v.load(memoryLocation, 8)
+				if err != nil {
+					return v.fail(Bytecode, instructionIndex, memoryLocation, err)
+				}
+				*r1 = x
+			} else {
+				*r1 = getUint64(memory, memoryLocation)
 			}
-			*r1 = *(*uint64)(unsafe.Pointer(virtualMemory + uintptr(memoryLocation)))
-			*r4 = 0
+			*r4 &= r4Mask
 
 		// Register move instructions.
 		case InstructionMoveR1ToR2:
 			*r2 = *r1
-			*r4 = 0
+			*r4 &= r4Mask
 		case InstructionMoveR1ToR3:
 			*r3 = *r1
-			*r4 = 0
+			*r4 &= r4Mask
 		case InstructionMoveR2ToR1:
 			*r1 = *r2
-			*r4 = 0
+			*r4 &= r4Mask
 		case InstructionMoveR2ToR3:
 			*r3 = *r2
-			*r4 = 0
+			*r4 &= r4Mask
 		case InstructionFlipR1R2:
 			x := *r1
 			*r1 = *r2
 			*r2 = x
-			*r4 = 0
+			*r4 &= r4Mask
 		case InstructionMoveR3ToR1:
 			*r1 = *r3
-			*r4 = 0
+			*r4 &= r4Mask
 		case InstructionMoveR3ToR2:
 			*r2 = *r3
-			*r4 = 0
+			*r4 &= r4Mask
 		case InstructionFlipR1R3:
 			x := *r1
 			*r1 = *r3
 			*r3 = x
-			*r4 = 0
+			*r4 &= r4Mask
 		case InstructionMoveR4ToR1:
 			*r1 = *r4
-			*r4 = 0
+			*r4 &= r4Mask
 		case InstructionMoveR4ToR2:
 			*r2 = *r4
-			*r4 = 0
+			*r4 &= r4Mask
 		case InstructionMoveR4ToR3:
 			*r3 = *r4
-			*r4 = 0
+			*r4 &= r4Mask
+
+		// Extended register move instructions.
+		case InstructionMoveR1ToR5:
+			*r5 = *r1
+			*r4 &= r4Mask
+		case InstructionMoveR1ToR6:
+			*r6 = *r1
+			*r4 &= r4Mask
+		case InstructionMoveR1ToR7:
+			*r7 = *r1
+			*r4 &= r4Mask
+		case InstructionMoveR1ToR8:
+			*r8 = *r1
+			*r4 &= r4Mask
+		case InstructionMoveR2ToR5:
+			*r5 = *r2
+			*r4 &= r4Mask
+		case InstructionMoveR2ToR6:
+			*r6 = *r2
+			*r4 &= r4Mask
+		case InstructionMoveR2ToR7:
+			*r7 = *r2
+			*r4 &= r4Mask
+		case InstructionMoveR2ToR8:
+			*r8 = *r2
+			*r4 &= r4Mask
+		case InstructionMoveR3ToR5:
+			*r5 = *r3
+			*r4 &= r4Mask
+		case InstructionMoveR3ToR6:
+			*r6 = *r3
+			*r4 &= r4Mask
+		case InstructionMoveR3ToR7:
+			*r7 = *r3
+			*r4 &= r4Mask
+		case InstructionMoveR3ToR8:
+			*r8 = *r3
+			*r4 &= r4Mask
+		case InstructionMoveR5ToR1:
+			*r1 = *r5
+			*r4 &= r4Mask
+		case InstructionMoveR5ToR2:
+			*r2 = *r5
+			*r4 &= r4Mask
+		case InstructionMoveR5ToR3:
+			*r3 = *r5
+			*r4 &= r4Mask
+		case InstructionMoveR6ToR1:
+			*r1 = *r6
+			*r4 &= r4Mask
+		case InstructionMoveR6ToR2:
+			*r2 = *r6
+			*r4 &= r4Mask
+		case InstructionMoveR6ToR3:
+			*r3 = *r6
+			*r4 &= r4Mask
+		case InstructionMoveR7ToR1:
+			*r1 = *r7
+			*r4 &= r4Mask
+		case InstructionMoveR7ToR2:
+			*r2 = *r7
+			*r4 &= r4Mask
+		case InstructionMoveR7ToR3:
+			*r3 = *r7
+			*r4 &= r4Mask
+		case InstructionMoveR8ToR1:
+			*r1 = *r8
+			*r4 &= r4Mask
+		case InstructionMoveR8ToR2:
+			*r2 = *r8
+			*r4 &= r4Mask
+		case InstructionMoveR8ToR3:
+			*r3 = *r8
+			*r4 &= r4Mask
+
+		// Conditional move instructions.
+		case InstructionMoveR2ToR1IfEq:
+			if *r1 == *r3 {
+				*r1 = *r2
+			}
+			*r4 &= r4Mask
+		case InstructionMoveR2ToR1IfNe:
+			if *r1 != *r3 {
+				*r1 = *r2
+			}
+			*r4 &= r4Mask
+
+		// Set on compare instructions.
+		case InstructionSetIfEq:
+			if *r1 == *r3 {
+				*r1 = 1
+			} else {
+				*r1 = 0
+			}
+			*r4 &= r4Mask
+		case InstructionSetIfLt:
+			if *r1 < *r3 {
+				*r1 = 1
+			} else {
+				*r1 = 0
+			}
+			*r4 &= r4Mask
+		case InstructionSetIfGt:
+			if *r1 > *r3 {
+				*r1 = 1
+			} else {
+				*r1 = 0
+			}
+			*r4 &= r4Mask
+		case InstructionSetIfLtSigned:
+			if signExtend(*r1, signShift) < signExtend(*r3, signShift) {
+				*r1 = 1
+			} else {
+				*r1 = 0
+			}
+			*r4 &= r4Mask
+		case InstructionSetIfGtSigned:
+			if signExtend(*r1, signShift) > signExtend(*r3, signShift) {
+				*r1 = 1
+			} else {
+				*r1 = 0
+			}
+			*r4 &= r4Mask
 
 		// Memory dump instructions.
 		case InstructionUint8Dump:
-			bytecodeIndex += 8
+			bytecodeIndex += addressWidth
 			if bytecodeIndex >= bytecodeLen {
-				return InvalidInstructionArgument
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
 			}
-			bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 1)
-			memoryLocation := *(*uint64)(bytecodePtr)
-			bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 7)
-			if memoryLocation >= virtualMemoryLen {
-				return InvalidMemoryLocation
+			memoryLocation := getAddress(Bytecode, bytecodeIndex, addressWidth)
+			if slowMemory || memoryLocation >= virtualMemoryLen {
+				if err := v.store(memoryLocation, 1, *r1); err != nil {
+					return v.fail(Bytecode, instructionIndex, memoryLocation, err)
+				}
+			} else {
+				memory[memoryLocation] = uint8(*r1)
 			}
-			*(*uint8)(unsafe.Pointer(virtualMemory + uintptr(memoryLocation))) = uint8(*r1)
-			*r4 = 0
+			*r4 &= r4Mask
 		case InstructionUint16Dump:
-			bytecodeIndex += 8
+			bytecodeIndex += addressWidth
 			if bytecodeIndex >= bytecodeLen {
-				return InvalidInstructionArgument
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
 			}
-			bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 1)
-			memoryLocation := *(*uint64)(bytecodePtr)
-			bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 7)
-			if memoryLocation+1 >= virtualMemoryLen {
-				return InvalidMemoryLocation
+			memoryLocation := getAddress(Bytecode, bytecodeIndex, addressWidth)
+			if slowMemory || virtualMemoryLen < 2 || memoryLocation > virtualMemoryLen-2 {
+				if err := v.store(memoryLocation, 2, *r1); err != nil {
+					return v.fail(Bytecode, instructionIndex, memoryLocation, err)
+				}
+			} else {
+				putUint16(memory, memoryLocation, uint16(*r1))
 			}
-			*(*uint16)(unsafe.Pointer(virtualMemory + uintptr(memoryLocation))) = uint16(*r1)
-			*r4 = 0
+			*r4 &= r4Mask
 		case InstructionUint32Dump:
-			bytecodeIndex += 8
+			bytecodeIndex += addressWidth
 			if bytecodeIndex >= bytecodeLen {
-				return InvalidInstructionArgument
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
 			}
-			bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 1)
-			memoryLocation := *(*uint64)(bytecodePtr)
-			bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 7)
-			if memoryLocation+3 >= virtualMemoryLen {
-				return InvalidMemoryLocation
+			memoryLocation := getAddress(Bytecode, bytecodeIndex, addressWidth)
+			if slowMemory || virtualMemoryLen < 4 || memoryLocation > virtualMemoryLen-4 {
+				if err := v.store(memoryLocation, 4, *r1); err != nil {
+					return v.fail(Bytecode, instructionIndex, memoryLocation, err)
+				}
+			} else {
+				putUint32(memory, memoryLocation, uint32(*r1))
 			}
-			*(*uint32)(unsafe.Pointer(virtualMemory + uintptr(memoryLocation))) = uint32(*r1)
-			*r4 = 0
+			*r4 &= r4Mask
 		case InstructionUint64Dump:
-			bytecodeIndex += 8
+			bytecodeIndex += addressWidth
 			if bytecodeIndex >= bytecodeLen {
-				return InvalidInstructionArgument
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
 			}
-			bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 1)
-			memoryLocation := *(*uint64)(bytecodePtr)
-			bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 7)
-			if memoryLocation+7 >= virtualMemoryLen {
-				return InvalidMemoryLocation
+			memoryLocation := getAddress(Bytecode, bytecodeIndex, addressWidth)
+			if slowMemory || virtualMemoryLen < 8 || memoryLocation > virtualMemoryLen-8 {
+				if err := v.store(memoryLocation, 8, *r1); err != nil {
+					return v.fail(Bytecode, instructionIndex, memoryLocation, err)
+				}
+			} else {
+				putUint64(memory, memoryLocation, *r1)
 			}
-			*(*uint64)(unsafe.Pointer(virtualMemory + uintptr(memoryLocation))) = *r1
-			*r4 = 0
+			*r4 &= r4Mask
 
 		// Addition instructions.
 		case InstructionUnsignedAdd:
 			*r1 += *r2
-			*r4 = 0
+			*r4 &= r4Mask
 		case InstructionSignedAdd:
-			*(*int64)(unsafe.Pointer(r1)) += *(*int64)(unsafe.Pointer(r2))
-			*r4 = 0
+			*r1 = uint64(int64(*r1) + int64(*r2))
+			*r4 &= r4Mask
 
 		// Subtraction instructions.
 		case InstructionUnsignedSub:
 			*r1 -= *r2
-			*r4 = 0
+			*r4 &= r4Mask
 		case InstructionSignedSub:
-			*(*int64)(unsafe.Pointer(r1)) -= *(*int64)(unsafe.Pointer(r2))
-			*r4 = 0
+			*r1 = uint64(int64(*r1) - int64(*r2))
+			*r4 &= r4Mask
 
 		// Division instructions.
 		case InstructionUnsignedDiv:
 			if *r2 == 0 {
+				if strictArithmetic {
+					return v.fail(Bytecode, instructionIndex, 0, DivideByZero)
+				}
 				*r4 = 1
 			} else {
 				*r1 /= *r2
@@ -446,23 +1296,29 @@ func (v *VM) Execute(Bytecode []byte) error {
 			}
 		case InstructionSignedDiv:
 			if *r2 == 0 {
+				if strictArithmetic {
+					return v.fail(Bytecode, instructionIndex, 0, DivideByZero)
+				}
 				*r4 = 1
 			} else {
-				*(*int64)(unsafe.Pointer(r1)) /= *(*int64)(unsafe.Pointer(r2))
+				*r1 = uint64(signExtend(*r1, signShift) / signExtend(*r2, signShift))
 				*r4 = 0
 			}
 
 		// Multiply instructions.
 		case InstructionUnsignedMul:
 			*r1 *= *r2
-			*r4 = 0
+			*r4 &= r4Mask
 		case InstructionSignedMul:
-			*(*int64)(unsafe.Pointer(r1)) *= *(*int64)(unsafe.Pointer(r2))
-			*r4 = 0
+			*r1 = uint64(int64(*r1) * int64(*r2))
+			*r4 &= r4Mask
 
 		// Modulo instructions.
 		case InstructionUnsignedMod:
 			if *r2 == 0 {
+				if strictArithmetic {
+					return v.fail(Bytecode, instructionIndex, 0, DivideByZero)
+				}
 				*r4 = 1
 			} else {
 				*r1 %= *r2
@@ -470,224 +1326,1176 @@ func (v *VM) Execute(Bytecode []byte) error {
 			}
 		case InstructionSignedMod:
 			if *r2 == 0 {
+				if strictArithmetic {
+					return v.fail(Bytecode, instructionIndex, 0, DivideByZero)
+				}
 				*r4 = 1
 			} else {
-				*(*int64)(unsafe.Pointer(r1)) %= *(*int64)(unsafe.Pointer(r2))
+				*r1 = uint64(signExtend(*r1, signShift) % signExtend(*r2, signShift))
 				*r4 = 0
 			}
 
-		// Bitwise instructions.
-		case InstructionBitwiseAnd:
-			*r1 &= *r2
-			*r4 = 0
-		case InstructionBitwiseOr:
-			*r1 |= *r2
-			*r4 = 0
-		case InstructionBitwiseXor:
-			*r1 ^= *r2
-			*r4 = 0
-		case InstructionBitwiseLeftShift:
-			*r1 <<= *r2
-			*r4 = 0
-		case InstructionBitwiseRightShift:
-			*r1 >>= *r2
-			*r4 = 0
-
-		// Jump instruction.
-		case InstructionJmp:
+		// Float64 instructions.
+		case InstructionFloat64Load:
+			if mode32 {
+				return v.fail(Bytecode, instructionIndex, 0, FloatUnsupported)
+			}
 			bytecodeIndex += 8
 			if bytecodeIndex >= bytecodeLen {
-				return InvalidInstructionArgument
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
 			}
-			bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 1)
-			location := *(*uint64)(bytecodePtr)
-			if location >= bytecodeLen {
-				return InvalidMemoryLocation
+			*r1 = getUint64(Bytecode, bytecodeIndex-7)
+			*r4 &= r4Mask
+		case InstructionFloat64Add:
+			if mode32 {
+				return v.fail(Bytecode, instructionIndex, 0, FloatUnsupported)
 			}
-			bytecodePtr = (unsafe.Pointer)(&Bytecode[location])
-			bytecodeIndex = location
-			goto s
-		case InstructionJmpIfZero:
-			bytecodeIndex += 8
-			if bytecodeIndex >= bytecodeLen {
-				return InvalidInstructionArgument
+			*r1 = math.Float64bits(math.Float64frombits(*r1) + math.Float64frombits(*r2))
+			*r4 &= r4Mask
+		case InstructionFloat64Sub:
+			if mode32 {
+				return v.fail(Bytecode, instructionIndex, 0, FloatUnsupported)
+			}
+			*r1 = math.Float64bits(math.Float64frombits(*r1) - math.Float64frombits(*r2))
+			*r4 &= r4Mask
+		case InstructionFloat64Mul:
+			if mode32 {
+				return v.fail(Bytecode, instructionIndex, 0, FloatUnsupported)
+			}
+			*r1 = math.Float64bits(math.Float64frombits(*r1) * math.Float64frombits(*r2))
+			*r4 &= r4Mask
+		case InstructionFloat64Div:
+			if mode32 {
+				return v.fail(Bytecode, instructionIndex, 0, FloatUnsupported)
+			}
+			*r1 = math.Float64bits(math.Float64frombits(*r1) / math.Float64frombits(*r2))
+			*r4 &= r4Mask
+		case InstructionMemoryFloat32Load:
+			if mode32 {
+				return v.fail(Bytecode, instructionIndex, 0, FloatUnsupported)
+			}
+			bytecodeIndex += addressWidth
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			memoryLocation := getAddress(Bytecode, bytecodeIndex, addressWidth)
+			if slowMemory || virtualMemoryLen < 4 || memoryLocation > virtualMemoryLen-4 {
+				x, err := v.load(memoryLocation, 4)
+				if err != nil {
+					return v.fail(Bytecode, instructionIndex, memoryLocation, err)
+				}
+				*r1 = math.Float64bits(float64(math.Float32frombits(uint32(x))))
+			} else {
+				*r1 = math.Float64bits(float64(math.Float32frombits(getUint32(memory, memoryLocation))))
+			}
+			*r4 &= r4Mask
+		case InstructionFloat32Dump:
+			if mode32 {
+				return v.fail(Bytecode, instructionIndex, 0, FloatUnsupported)
+			}
+			bytecodeIndex += addressWidth
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			memoryLocation := getAddress(Bytecode, bytecodeIndex, addressWidth)
+			if slowMemory || virtualMemoryLen < 4 || memoryLocation > virtualMemoryLen-4 {
+				if err := v.store(memoryLocation, 4, uint64(math.Float32bits(float32(math.Float64frombits(*r1))))); err != nil {
+					return v.fail(Bytecode, instructionIndex, memoryLocation, err)
+				}
+			} else {
+				putUint32(memory, memoryLocation, math.Float32bits(float32(math.Float64frombits(*r1))))
+			}
+			*r4 &= r4Mask
+		case InstructionFloat64ToFloat32Bits:
+			if mode32 {
+				return v.fail(Bytecode, instructionIndex, 0, FloatUnsupported)
+			}
+			*r1 = uint64(math.Float32bits(float32(math.Float64frombits(*r1))))
+			*r4 &= r4Mask
+
+		// Bitwise instructions.
+		case InstructionBitwiseAnd:
+			*r1 &= *r2
+			*r4 &= r4Mask
+		case InstructionBitwiseOr:
+			*r1 |= *r2
+			*r4 &= r4Mask
+		case InstructionBitwiseXor:
+			*r1 ^= *r2
+			*r4 &= r4Mask
+		case InstructionBitwiseLeftShift:
+			*r1 <<= *r2 & shiftMask
+			*r4 &= r4Mask
+		case InstructionBitwiseRightShift:
+			*r1 >>= *r2 & shiftMask
+			*r4 &= r4Mask
+
+		// Register operand instructions.
+		case InstructionMove2, InstructionAdd2, InstructionSub2, InstructionMul2, InstructionAnd2, InstructionOr2, InstructionXor2, InstructionLeftShift2, InstructionRightShift2:
+			bytecodeIndex++
+			if bytecodeIndex == bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			operand := Bytecode[bytecodeIndex]
+			dst, src := operand>>4, operand&0x0F
+			if dst >= uint8(len(v.Registers)) || src >= uint8(len(v.Registers)) {
+				return v.fail(Bytecode, instructionIndex, uint64(operand), InvalidRegister)
+			}
+			x, y := v.Registers[dst], v.Registers[src]
+			switch Bytecode[instructionIndex] {
+			case InstructionMove2:
+				x = y
+			case InstructionAdd2:
+				x += y
+			case InstructionSub2:
+				x -= y
+			case InstructionMul2:
+				x *= y
+			case InstructionAnd2:
+				x &= y
+			case InstructionOr2:
+				x |= y
+			case InstructionXor2:
+				x ^= y
+			case InstructionLeftShift2:
+				x <<= y & shiftMask
+			case InstructionRightShift2:
+				x >>= y & shiftMask
+			}
+
+			// Clear R4 first so it can be the destination.
+			*r4 &= r4Mask
+			v.Registers[dst] = x
+
+		// Interrupt return instruction.
+		case InstructionInterruptReturn:
+			if !v.inInterrupt {
+				return v.fail(Bytecode, instructionIndex, 0, NotInInterrupt)
+			}
+			if v.interruptPC >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, v.interruptPC, InvalidMemoryLocation)
+			}
+			v.inInterrupt = false
+			if onJump != nil {
+				onJump(instructionIndex, v.interruptPC, true)
+			}
+			bytecodeIndex = v.interruptPC
+
+			// Check for interrupts which were raised while the handler ran before the next instruction.
+			untilCheck = 1
+			goto s
+
+		// Yield instruction.
+		case InstructionYield:
+			// The PC points after the yield so execution carries on from there.
+			*r4 &= r4Mask
+			v.PC = bytecodeIndex + 1
+			return &VMError{Offset: instructionIndex, Opcode: InstructionYield, Operand: *r1, Err: Yielded}
+
+		// Jump instruction.
+		case InstructionJmp:
+			bytecodeIndex += addressWidth
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			location := getAddress(Bytecode, bytecodeIndex, addressWidth)
+			if location >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, location, InvalidMemoryLocation)
+			}
+			jumpTarget = location
+			goto jump
+		case InstructionJmpIfZero:
+			bytecodeIndex += addressWidth
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
 			}
 			if *r1 == 0 {
-				bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 1)
-				location := *(*uint64)(bytecodePtr)
+				location := getAddress(Bytecode, bytecodeIndex, addressWidth)
 				if location >= bytecodeLen {
-					return InvalidMemoryLocation
+					return v.fail(Bytecode, instructionIndex, location, InvalidMemoryLocation)
 				}
-				bytecodePtr = (unsafe.Pointer)(&Bytecode[location])
-				bytecodeIndex = location
-				goto s
-			} else {
-				bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 8)
+				jumpTarget = location
+				goto jump
+			} else if onJump != nil {
+				onJump(instructionIndex, getAddress(Bytecode, bytecodeIndex, addressWidth), false)
 			}
 		case InstructionJmpIfEq:
-			bytecodeIndex += 8
+			bytecodeIndex += addressWidth
 			if bytecodeIndex >= bytecodeLen {
-				return InvalidInstructionArgument
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
 			}
 			if *r1 == *r3 {
-				bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 1)
-				location := *(*uint64)(bytecodePtr)
+				location := getAddress(Bytecode, bytecodeIndex, addressWidth)
 				if location >= bytecodeLen {
-					return InvalidMemoryLocation
+					return v.fail(Bytecode, instructionIndex, location, InvalidMemoryLocation)
 				}
-				bytecodePtr = (unsafe.Pointer)(&Bytecode[location])
-				bytecodeIndex = location
-				goto s
-			} else {
-				bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 8)
+				jumpTarget = location
+				goto jump
+			} else if onJump != nil {
+				onJump(instructionIndex, getAddress(Bytecode, bytecodeIndex, addressWidth), false)
 			}
 		case InstructionJmpIfNe:
-			bytecodeIndex += 8
+			bytecodeIndex += addressWidth
 			if bytecodeIndex >= bytecodeLen {
-				return InvalidInstructionArgument
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
 			}
 			if *r1 != *r3 {
-				bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 1)
-				location := *(*uint64)(bytecodePtr)
+				location := getAddress(Bytecode, bytecodeIndex, addressWidth)
 				if location >= bytecodeLen {
-					return InvalidMemoryLocation
+					return v.fail(Bytecode, instructionIndex, location, InvalidMemoryLocation)
 				}
-				bytecodePtr = (unsafe.Pointer)(&Bytecode[location])
-				bytecodeIndex = location
-				goto s
-			} else {
-				bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 8)
+				jumpTarget = location
+				goto jump
+			} else if onJump != nil {
+				onJump(instructionIndex, getAddress(Bytecode, bytecodeIndex, addressWidth), false)
 			}
 		case InstructionJmpIfGt:
-			bytecodeIndex += 8
+			bytecodeIndex += addressWidth
 			if bytecodeIndex >= bytecodeLen {
-				return InvalidInstructionArgument
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
 			}
 			if *r1 > *r3 {
-				bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 1)
-				location := *(*uint64)(bytecodePtr)
+				location := getAddress(Bytecode, bytecodeIndex, addressWidth)
 				if location >= bytecodeLen {
-					return InvalidMemoryLocation
+					return v.fail(Bytecode, instructionIndex, location, InvalidMemoryLocation)
 				}
-				bytecodePtr = (unsafe.Pointer)(&Bytecode[location])
-				bytecodeIndex = location
-				goto s
-			} else {
-				bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 8)
+				jumpTarget = location
+				goto jump
+			} else if onJump != nil {
+				onJump(instructionIndex, getAddress(Bytecode, bytecodeIndex, addressWidth), false)
 			}
 		case InstructionJmpIfLt:
-			bytecodeIndex += 8
+			bytecodeIndex += addressWidth
 			if bytecodeIndex >= bytecodeLen {
-				return InvalidInstructionArgument
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
 			}
 			if *r1 < *r3 {
-				bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 1)
-				location := *(*uint64)(bytecodePtr)
+				location := getAddress(Bytecode, bytecodeIndex, addressWidth)
 				if location >= bytecodeLen {
-					return InvalidMemoryLocation
+					return v.fail(Bytecode, instructionIndex, location, InvalidMemoryLocation)
 				}
-				bytecodePtr = (unsafe.Pointer)(&Bytecode[location])
-				bytecodeIndex = location
-				goto s
-			} else {
-				bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 8)
+				jumpTarget = location
+				goto jump
+			} else if onJump != nil {
+				onJump(instructionIndex, getAddress(Bytecode, bytecodeIndex, addressWidth), false)
 			}
 		case InstructionJmpIfGtOrEqual:
-			bytecodeIndex += 8
+			bytecodeIndex += addressWidth
 			if bytecodeIndex >= bytecodeLen {
-				return InvalidInstructionArgument
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
 			}
 			if *r1 >= *r3 {
-				bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 1)
-				location := *(*uint64)(bytecodePtr)
+				location := getAddress(Bytecode, bytecodeIndex, addressWidth)
 				if location >= bytecodeLen {
-					return InvalidMemoryLocation
+					return v.fail(Bytecode, instructionIndex, location, InvalidMemoryLocation)
 				}
-				bytecodePtr = (unsafe.Pointer)(&Bytecode[location])
-				bytecodeIndex = location
-				goto s
-			} else {
-				bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 8)
+				jumpTarget = location
+				goto jump
+			} else if onJump != nil {
+				onJump(instructionIndex, getAddress(Bytecode, bytecodeIndex, addressWidth), false)
 			}
 		case InstructionJmpIfLtOrEqual:
-			bytecodeIndex += 8
+			bytecodeIndex += addressWidth
 			if bytecodeIndex >= bytecodeLen {
-				return InvalidInstructionArgument
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
 			}
 			if *r1 <= *r3 {
-				bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 1)
-				location := *(*uint64)(bytecodePtr)
+				location := getAddress(Bytecode, bytecodeIndex, addressWidth)
 				if location >= bytecodeLen {
-					return InvalidMemoryLocation
+					return v.fail(Bytecode, instructionIndex, location, InvalidMemoryLocation)
 				}
-				bytecodePtr = (unsafe.Pointer)(&Bytecode[location])
-				bytecodeIndex = location
-				goto s
-			} else {
-				bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 8)
+				jumpTarget = location
+				goto jump
+			} else if onJump != nil {
+				onJump(instructionIndex, getAddress(Bytecode, bytecodeIndex, addressWidth), false)
 			}
 
 		// System call instruction.
-		case InstructionSyscall:
-			bytecodeIndex += 8
-			if bytecodeIndex >= bytecodeLen {
-				return InvalidInstructionArgument
+		case InstructionSyscall, InstructionSyscallR1:
+			// The system call number is either the argument or R1.
+			opcode := Bytecode[instructionIndex]
+			syscall := *r1
+			if opcode == InstructionSyscall {
+				bytecodeIndex += 8
+				if bytecodeIndex >= bytecodeLen {
+					return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+				}
+				syscall = getUint64(Bytecode, bytecodeIndex-7)
+			}
+			if len(v.syscallFilters) != 0 && !v.syscallAllowed(syscall) {
+				return v.fail(Bytecode, instructionIndex, syscall, &SyscallDeniedError{Number: syscall, PC: instructionIndex})
 			}
-			bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 1)
-			syscall := *(*uint64)(bytecodePtr)
-			bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 7)
-			call, ok := v.Syscalls[syscall]
-			*r4 = 0
-			if ok {
-				// Attempt the system call.
-				if err := call(v); err != nil {
-					return err
+			var call func(*VM) error
+			ok := false
+			if syscallTable != nil {
+				if syscall < uint64(len(syscallTable)) {
+					call = syscallTable[syscall]
+					ok = call != nil
+				}
+			} else {
+				call, ok = v.Syscalls[syscall]
+				if syscallMapLookups++; syscallMapLookups == syscallTableThreshold {
+					syscallTable = v.buildSyscallTable()
+					syscallKey = v.syscallTableKey()
+				}
+			}
+			*r4 &= r4Mask
+			if ok || v.syscallHook != nil {
+				if v.MaxSyscalls != 0 || v.syscallBudgets != nil {
+					if err := v.chargeSyscall(syscall); err != nil {
+						return v.fail(Bytecode, instructionIndex, syscall, err)
+					}
+				}
+
+				// Attempt the system call. The PC is updated first so the system call can see where it was made from.
+				v.PC = instructionIndex
+				v.publish(instructionIndex, instructionCount-1)
+
+				// Stop the clock while the system call runs.
+				if doTimeChecks {
+					elapsed += time.Since(resumed)
+				}
+				err := v.callSyscall(syscall, call)
+				if doTimeChecks {
+					cpuTime = v.extendCPUTime(cpuTime)
+					resumed = time.Now()
+				}
+
+				// Do the checks before the next instruction so Stop, Pause and interrupts from the system call are seen
+				// straight away.
+				untilCheck = 1
+				if v.reverse != nil && v.irreversibleSyscalls[syscall] {
+					v.reverse.clear()
+				}
+				if err = v.syscallResult(err); err != nil {
+					return v.fail(Bytecode, instructionIndex, syscall, err)
+				}
+
+				// The system call may have set or removed system calls or replaced the system call map.
+				if syscallTable != nil && v.syscallTableKey() != syscallKey {
+					syscallTable = nil
+					syscallMapLookups = 0
+				}
+
+				// The system call may have replaced or grown the memory or changed the memory options, so get the memory, its
+				// length and if the slow path is needed again.
+				memory = v.Memory
+				virtualMemoryLen = v.memorySize()
+				slowMemory = v.slowMemory()
+				if CodeInMemory {
+					// The bytecode is the memory, so it must follow it.
+					Bytecode = memory
+					bytecodeLen = uint64(len(memory))
+					if coverage != nil {
+						coverage = v.growCoverage(bytecodeLen)
+					}
+					if bytecodeIndex >= bytecodeLen {
+						v.PC = instructionIndex
+						return &VMError{Offset: instructionIndex, Opcode: opcode, Operand: syscall, Err: InvalidMemoryLocation}
+					}
 				}
 			} else {
 				// Invalid system call.
-				return InvalidSyscall
+				return v.fail(Bytecode, instructionIndex, syscall, &InvalidSyscallError{Number: syscall, PC: instructionIndex})
+			}
+
+		// Error flag jump instructions.
+		case InstructionJmpIfR4Set:
+			bytecodeIndex += addressWidth
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			if *r4 != 0 {
+				location := getAddress(Bytecode, bytecodeIndex, addressWidth)
+				if location >= bytecodeLen {
+					return v.fail(Bytecode, instructionIndex, location, InvalidMemoryLocation)
+				}
+				jumpTarget = location
+				goto jump
+			} else if onJump != nil {
+				onJump(instructionIndex, getAddress(Bytecode, bytecodeIndex, addressWidth), false)
+			}
+		case InstructionJmpIfR4Clear:
+			bytecodeIndex += addressWidth
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			if *r4 == 0 {
+				location := getAddress(Bytecode, bytecodeIndex, addressWidth)
+				if location >= bytecodeLen {
+					return v.fail(Bytecode, instructionIndex, location, InvalidMemoryLocation)
+				}
+				jumpTarget = location
+				goto jump
+			} else if onJump != nil {
+				onJump(instructionIndex, getAddress(Bytecode, bytecodeIndex, addressWidth), false)
+			}
+
+		// Signed jump instructions.
+		case InstructionJmpIfGtSigned:
+			bytecodeIndex += addressWidth
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			if signExtend(*r1, signShift) > signExtend(*r3, signShift) {
+				location := getAddress(Bytecode, bytecodeIndex, addressWidth)
+				if location >= bytecodeLen {
+					return v.fail(Bytecode, instructionIndex, location, InvalidMemoryLocation)
+				}
+				jumpTarget = location
+				goto jump
+			} else if onJump != nil {
+				onJump(instructionIndex, getAddress(Bytecode, bytecodeIndex, addressWidth), false)
+			}
+		case InstructionJmpIfLtSigned:
+			bytecodeIndex += addressWidth
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			if signExtend(*r1, signShift) < signExtend(*r3, signShift) {
+				location := getAddress(Bytecode, bytecodeIndex, addressWidth)
+				if location >= bytecodeLen {
+					return v.fail(Bytecode, instructionIndex, location, InvalidMemoryLocation)
+				}
+				jumpTarget = location
+				goto jump
+			} else if onJump != nil {
+				onJump(instructionIndex, getAddress(Bytecode, bytecodeIndex, addressWidth), false)
+			}
+		case InstructionJmpIfGtOrEqualSigned:
+			bytecodeIndex += addressWidth
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			if signExtend(*r1, signShift) >= signExtend(*r3, signShift) {
+				location := getAddress(Bytecode, bytecodeIndex, addressWidth)
+				if location >= bytecodeLen {
+					return v.fail(Bytecode, instructionIndex, location, InvalidMemoryLocation)
+				}
+				jumpTarget = location
+				goto jump
+			} else if onJump != nil {
+				onJump(instructionIndex, getAddress(Bytecode, bytecodeIndex, addressWidth), false)
+			}
+		case InstructionJmpIfLtOrEqualSigned:
+			bytecodeIndex += addressWidth
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			if signExtend(*r1, signShift) <= signExtend(*r3, signShift) {
+				location := getAddress(Bytecode, bytecodeIndex, addressWidth)
+				if location >= bytecodeLen {
+					return v.fail(Bytecode, instructionIndex, location, InvalidMemoryLocation)
+				}
+				jumpTarget = location
+				goto jump
+			} else if onJump != nil {
+				onJump(instructionIndex, getAddress(Bytecode, bytecodeIndex, addressWidth), false)
+			}
+
+		// Feature query instruction.
+		case InstructionFeatures:
+			*r1 = SupportedFeatures
+			if mode32 {
+				*r1 &^= FeatureFloat64 | FeatureFloat32
+			}
+			*r4 &= r4Mask
+
+		// Introspection instructions.
+		case InstructionMemorySize:
+			*r1 = virtualMemoryLen
+			*r4 &= r4Mask
+		case InstructionLoadPC:
+			*r1 = instructionIndex
+			*r4 &= r4Mask
+		case InstructionReadCycleCounter:
+			*r1 = instructionCount
+			*r4 &= r4Mask
+
+		// Bulk memory instructions.
+		case InstructionMemorySum64:
+			var region []byte
+			if slowMemory || *r3 > virtualMemoryLen/8 || *r2 > virtualMemoryLen-*r3*8 {
+				if *r3 > virtualMemoryLen/8 {
+					// The length in bytes can overflow, so check the count first.
+					return v.fail(Bytecode, instructionIndex, *r2, &MemoryFault{Address: *r2, Width: *r3 * 8, Size: virtualMemoryLen})
+				}
+				var err error
+				region, err = v.loadRange(*r2, *r3*8)
+				if err != nil {
+					return v.fail(Bytecode, instructionIndex, *r2, err)
+				}
+			} else {
+				region = memory[*r2 : *r2+*r3*8]
+			}
+			sum := *r1
+			for i := 0; i < len(region); i += 8 {
+				sum += binary.LittleEndian.Uint64(region[i:])
+			}
+			*r1 = sum
+			*r4 &= r4Mask
+
+		case InstructionMemoryCRC32:
+			var region []byte
+			if slowMemory || *r3 > virtualMemoryLen || *r2 > virtualMemoryLen-*r3 {
+				var err error
+				region, err = v.loadRange(*r2, *r3)
+				if err != nil {
+					return v.fail(Bytecode, instructionIndex, *r2, err)
+				}
+			} else {
+				region = memory[*r2 : *r2+*r3]
+			}
+			*r1 = uint64(crc32.ChecksumIEEE(region))
+			*r4 &= r4Mask
+
+		// Random number instruction.
+		case InstructionRand:
+			*r1 = v.nextRandom()
+			*r4 &= r4Mask
+
+		// Float64 jump instructions.
+		case InstructionJmpIfFloatGt:
+			if mode32 {
+				return v.fail(Bytecode, instructionIndex, 0, FloatUnsupported)
+			}
+			bytecodeIndex += addressWidth
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			if math.Float64frombits(*r1) > math.Float64frombits(*r3) {
+				location := getAddress(Bytecode, bytecodeIndex, addressWidth)
+				if location >= bytecodeLen {
+					return v.fail(Bytecode, instructionIndex, location, InvalidMemoryLocation)
+				}
+				jumpTarget = location
+				goto jump
+			} else if onJump != nil {
+				onJump(instructionIndex, getAddress(Bytecode, bytecodeIndex, addressWidth), false)
+			}
+		case InstructionJmpIfFloatLt:
+			if mode32 {
+				return v.fail(Bytecode, instructionIndex, 0, FloatUnsupported)
+			}
+			bytecodeIndex += addressWidth
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			if math.Float64frombits(*r1) < math.Float64frombits(*r3) {
+				location := getAddress(Bytecode, bytecodeIndex, addressWidth)
+				if location >= bytecodeLen {
+					return v.fail(Bytecode, instructionIndex, location, InvalidMemoryLocation)
+				}
+				jumpTarget = location
+				goto jump
+			} else if onJump != nil {
+				onJump(instructionIndex, getAddress(Bytecode, bytecodeIndex, addressWidth), false)
+			}
+		case InstructionJmpIfFloatEq:
+			if mode32 {
+				return v.fail(Bytecode, instructionIndex, 0, FloatUnsupported)
+			}
+			bytecodeIndex += addressWidth
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			if math.Float64frombits(*r1) == math.Float64frombits(*r3) {
+				location := getAddress(Bytecode, bytecodeIndex, addressWidth)
+				if location >= bytecodeLen {
+					return v.fail(Bytecode, instructionIndex, location, InvalidMemoryLocation)
+				}
+				jumpTarget = location
+				goto jump
+			} else if onJump != nil {
+				onJump(instructionIndex, getAddress(Bytecode, bytecodeIndex, addressWidth), false)
+			}
+		case InstructionJmpIfFloatUnordered:
+			if mode32 {
+				return v.fail(Bytecode, instructionIndex, 0, FloatUnsupported)
+			}
+			bytecodeIndex += addressWidth
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			if math.IsNaN(math.Float64frombits(*r1)) || math.IsNaN(math.Float64frombits(*r3)) {
+				location := getAddress(Bytecode, bytecodeIndex, addressWidth)
+				if location >= bytecodeLen {
+					return v.fail(Bytecode, instructionIndex, location, InvalidMemoryLocation)
+				}
+				jumpTarget = location
+				goto jump
+			} else if onJump != nil {
+				onJump(instructionIndex, getAddress(Bytecode, bytecodeIndex, addressWidth), false)
+			}
+
+		// Compact jump instructions.
+		case InstructionJmp32:
+			bytecodeIndex += 4
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			location := uint64(getUint32(Bytecode, bytecodeIndex-3))
+			if location >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, location, InvalidMemoryLocation)
+			}
+			jumpTarget = location
+			goto jump
+		case InstructionJmpIfZero32:
+			bytecodeIndex += 4
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			if *r1 == 0 {
+				location := uint64(getUint32(Bytecode, bytecodeIndex-3))
+				if location >= bytecodeLen {
+					return v.fail(Bytecode, instructionIndex, location, InvalidMemoryLocation)
+				}
+				jumpTarget = location
+				goto jump
+			} else if onJump != nil {
+				onJump(instructionIndex, uint64(getUint32(Bytecode, bytecodeIndex-3)), false)
+			}
+		case InstructionJmpIfEq32:
+			bytecodeIndex += 4
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			if *r1 == *r3 {
+				location := uint64(getUint32(Bytecode, bytecodeIndex-3))
+				if location >= bytecodeLen {
+					return v.fail(Bytecode, instructionIndex, location, InvalidMemoryLocation)
+				}
+				jumpTarget = location
+				goto jump
+			} else if onJump != nil {
+				onJump(instructionIndex, uint64(getUint32(Bytecode, bytecodeIndex-3)), false)
+			}
+		case InstructionJmpIfNe32:
+			bytecodeIndex += 4
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			if *r1 != *r3 {
+				location := uint64(getUint32(Bytecode, bytecodeIndex-3))
+				if location >= bytecodeLen {
+					return v.fail(Bytecode, instructionIndex, location, InvalidMemoryLocation)
+				}
+				jumpTarget = location
+				goto jump
+			} else if onJump != nil {
+				onJump(instructionIndex, uint64(getUint32(Bytecode, bytecodeIndex-3)), false)
+			}
+		case InstructionJmpIfGt32:
+			bytecodeIndex += 4
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			if *r1 > *r3 {
+				location := uint64(getUint32(Bytecode, bytecodeIndex-3))
+				if location >= bytecodeLen {
+					return v.fail(Bytecode, instructionIndex, location, InvalidMemoryLocation)
+				}
+				jumpTarget = location
+				goto jump
+			} else if onJump != nil {
+				onJump(instructionIndex, uint64(getUint32(Bytecode, bytecodeIndex-3)), false)
+			}
+		case InstructionJmpIfLt32:
+			bytecodeIndex += 4
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			if *r1 < *r3 {
+				location := uint64(getUint32(Bytecode, bytecodeIndex-3))
+				if location >= bytecodeLen {
+					return v.fail(Bytecode, instructionIndex, location, InvalidMemoryLocation)
+				}
+				jumpTarget = location
+				goto jump
+			} else if onJump != nil {
+				onJump(instructionIndex, uint64(getUint32(Bytecode, bytecodeIndex-3)), false)
+			}
+		case InstructionJmpIfGtOrEqual32:
+			bytecodeIndex += 4
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			if *r1 >= *r3 {
+				location := uint64(getUint32(Bytecode, bytecodeIndex-3))
+				if location >= bytecodeLen {
+					return v.fail(Bytecode, instructionIndex, location, InvalidMemoryLocation)
+				}
+				jumpTarget = location
+				goto jump
+			} else if onJump != nil {
+				onJump(instructionIndex, uint64(getUint32(Bytecode, bytecodeIndex-3)), false)
+			}
+		case InstructionJmpIfLtOrEqual32:
+			bytecodeIndex += 4
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			if *r1 <= *r3 {
+				location := uint64(getUint32(Bytecode, bytecodeIndex-3))
+				if location >= bytecodeLen {
+					return v.fail(Bytecode, instructionIndex, location, InvalidMemoryLocation)
+				}
+				jumpTarget = location
+				goto jump
+			} else if onJump != nil {
+				onJump(instructionIndex, uint64(getUint32(Bytecode, bytecodeIndex-3)), false)
+			}
+
+		// Compact memory instructions.
+		case InstructionMemoryUint8Load32:
+			bytecodeIndex += 4
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			memoryLocation := uint64(getUint32(Bytecode, bytecodeIndex-3))
+			if slowMemory || memoryLocation >= virtualMemoryLen {
+				x, err := v.load(memoryLocation, 1)
+				if err != nil {
+					return v.fail(Bytecode, instructionIndex, memoryLocation, err)
+				}
+				*r1 = x
+			} else {
+				*r1 = uint64(memory[memoryLocation])
+			}
+			*r4 &= r4Mask
+		case InstructionMemoryUint16Load32:
+			bytecodeIndex += 4
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			memoryLocation := uint64(getUint32(Bytecode, bytecodeIndex-3))
+			if slowMemory || virtualMemoryLen < 2 || memoryLocation > virtualMemoryLen-2 {
+				x, err := v.load(memoryLocation, 2)
+				if err != nil {
+					return v.fail(Bytecode, instructionIndex, memoryLocation, err)
+				}
+				*r1 = x
+			} else {
+				*r1 = uint64(getUint16(memory, memoryLocation))
+			}
+			*r4 &= r4Mask
+		case InstructionMemoryUint32Load32:
+			bytecodeIndex += 4
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			memoryLocation := uint64(getUint32(Bytecode, bytecodeIndex-3))
+			if slowMemory || virtualMemoryLen < 4 || memoryLocation > virtualMemoryLen-4 {
+				x, err := v.load(memoryLocation, 4)
+				if err != nil {
+					return v.fail(Bytecode, instructionIndex, memoryLocation, err)
+				}
+				*r1 = x
+			} else {
+				*r1 = uint64(getUint32(memory, memoryLocation))
+			}
+			*r4 &= r4Mask
+		case InstructionMemoryUint64Load32:
+			bytecodeIndex += 4
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			memoryLocation := uint64(getUint32(Bytecode, bytecodeIndex-3))
+			if slowMemory || virtualMemoryLen < 8 || memoryLocation > virtualMemoryLen-8 {
+				x, err := v.load(memoryLocation, 8)
+				if err != nil {
+					return v.fail(Bytecode, instructionIndex, memoryLocation, err)
+				}
+				*r1 = x
+			} else {
+				*r1 = getUint64(memory, memoryLocation)
+			}
+			*r4 &= r4Mask
+		case InstructionUint8Dump32:
+			bytecodeIndex += 4
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			memoryLocation := uint64(getUint32(Bytecode, bytecodeIndex-3))
+			if slowMemory || memoryLocation >= virtualMemoryLen {
+				if err := v.store(memoryLocation, 1, *r1); err != nil {
+					return v.fail(Bytecode, instructionIndex, memoryLocation, err)
+				}
+			} else {
+				memory[memoryLocation] = uint8(*r1)
+			}
+			*r4 &= r4Mask
+		case InstructionUint16Dump32:
+			bytecodeIndex += 4
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			memoryLocation := uint64(getUint32(Bytecode, bytecodeIndex-3))
+			if slowMemory || virtualMemoryLen < 2 || memoryLocation > virtualMemoryLen-2 {
+				if err := v.store(memoryLocation, 2, *r1); err != nil {
+					return v.fail(Bytecode, instructionIndex, memoryLocation, err)
+				}
+			} else {
+				putUint16(memory, memoryLocation, uint16(*r1))
+			}
+			*r4 &= r4Mask
+		case InstructionUint32Dump32:
+			bytecodeIndex += 4
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			memoryLocation := uint64(getUint32(Bytecode, bytecodeIndex-3))
+			if slowMemory || virtualMemoryLen < 4 || memoryLocation > virtualMemoryLen-4 {
+				if err := v.store(memoryLocation, 4, *r1); err != nil {
+					return v.fail(Bytecode, instructionIndex, memoryLocation, err)
+				}
+			} else {
+				putUint32(memory, memoryLocation, uint32(*r1))
+			}
+			*r4 &= r4Mask
+		case InstructionUint64Dump32:
+			bytecodeIndex += 4
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			memoryLocation := uint64(getUint32(Bytecode, bytecodeIndex-3))
+			if slowMemory || virtualMemoryLen < 8 || memoryLocation > virtualMemoryLen-8 {
+				if err := v.store(memoryLocation, 8, *r1); err != nil {
+					return v.fail(Bytecode, instructionIndex, memoryLocation, err)
+				}
+			} else {
+				putUint64(memory, memoryLocation, *r1)
+			}
+			*r4 &= r4Mask
+
+		// Variable length instructions.
+		case InstructionVarLoad:
+			value, n := decodeVarint(Bytecode[bytecodeIndex+1:])
+			if n == 0 {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			bytecodeIndex += uint64(n)
+			*r1 = value
+			*r4 &= r4Mask
+		case InstructionVarJmp:
+			location, n := decodeVarint(Bytecode[bytecodeIndex+1:])
+			if n == 0 {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			if location >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, location, InvalidMemoryLocation)
+			}
+			jumpTarget = location
+			goto jump
+		case InstructionMemoryVarLoad64:
+			memoryLocation, n := decodeVarint(Bytecode[bytecodeIndex+1:])
+			if n == 0 {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			bytecodeIndex += uint64(n)
+			if slowMemory || virtualMemoryLen < 8 || memoryLocation > virtualMemoryLen-8 {
+				x, err := v.load(memoryLocation, 8)
+				if err != nil {
+					return v.fail(Bytecode, instructionIndex, memoryLocation, err)
+				}
+				*r1 = x
+			} else {
+				*r1 = getUint64(memory, memoryLocation)
+			}
+			*r4 &= r4Mask
+
+		// ISA version instruction.
+		case InstructionRequireISAVersion:
+			bytecodeIndex += 2
+			if bytecodeIndex >= bytecodeLen {
+				return v.fail(Bytecode, instructionIndex, 0, InvalidInstructionArgument)
+			}
+			version := getUint16(Bytecode, bytecodeIndex-1)
+			if err := v.CheckVersion(version); err != nil {
+				return v.fail(Bytecode, instructionIndex, uint64(version), err)
 			}
 
 		// Handle unknown instruction.
 		default:
-			return UnknownInstruction
+			return v.fail(Bytecode, instructionIndex, 0, UnknownInstruction)
+		}
+
+		// Mask the registers to 32 bits in 32-bit mode.
+		if mode32 {
+			v.maskRegisters()
 		}
 
 		// Add 1 to the pointer and bytecode index.
 		bytecodeIndex++
-		bytecodePtr = (unsafe.Pointer)((uintptr)(bytecodePtr) + 1)
+		continue
+
+	jump:
+		// Count jumps backwards since they are the only way to loop.
+		if jumpTarget <= instructionIndex {
+			backwardJumps++
+			if backwardJumps > maxBackwardJumps {
+				return v.fail(Bytecode, instructionIndex, jumpTarget, LoopBudgetExhausted)
+			}
+		}
+		if opcodeCounts != nil {
+			jumpsTaken++
+		}
+		if onJump != nil {
+			onJump(instructionIndex, jumpTarget, true)
+		}
+		bytecodeIndex = jumpTarget
+		goto s
 	}
 
-	// Keep the bytecode alive.
-	runtime.KeepAlive(Bytecode)
+	// Set the PC to the end of the bytecode and return no errors.
+	v.PC = bytecodeLen
+	return nil
+}
+
+// getAddress is used to get the memory location or jump target argument of the width specified which ends at the index.
+func getAddress(b []byte, i, width uint64) uint64 {
+	if width == 4 {
+		return uint64(getUint32(b, i-3))
+	}
+	return getUint64(b, i-7)
+}
 
-	// Return no errors.
+// signExtend is used to get a register as a signed integer after sign extending it from the bit shift places from the
+// top. A shift of 0 uses all 64 bits.
+func signExtend(x uint64, shift uint) int64 {
+	return int64(x<<shift) >> shift
+}
+
+// maskRegisters is used to mask the registers to 32 bits.
+func (v *VM) maskRegisters() {
+	for i := range v.Registers {
+		v.Registers[i] &= 0xFFFFFFFF
+	}
+}
+
+// decodeVarint is used to decode a unsigned LEB128 value from the start of the bytes. The number of bytes used is 0 if
+// the value is truncated, longer than 10 bytes, overflows a uint64 or is not minimally encoded.
+func decodeVarint(b []byte) (uint64, int) {
+	value := uint64(0)
+	for i := 0; i < len(b) && i < 10; i++ {
+		x := b[i]
+		if i == 9 && x > 1 {
+			// This would overflow a uint64.
+			return 0, 0
+		}
+		value |= uint64(x&0x7F) << (7 * uint(i))
+		if x < 0x80 {
+			if x == 0 && i != 0 {
+				// This is not minimally encoded.
+				return 0, 0
+			}
+			return value, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// CheckVersion is used to check if the virtual machine supports bytecode built against the ISA version specified.
+func (v *VM) CheckVersion(RequiredVersion uint16) error {
+	if RequiredVersion > ISAVersion {
+		return fmt.Errorf("%w: requires %d but %d is supported", UnsupportedISAVersion, RequiredVersion, ISAVersion)
+	}
 	return nil
 }
 
-// ClearRegisters is used to clear the registers of the virtual CPU.
+// SeedRandom is used to seed the pseudo-random number generator used by InstructionRand.
+func (v *VM) SeedRandom(Seed uint64) {
+	v.RandomState = Seed
+}
+
+// nextRandom is used to advance the pseudo-random number generator. This is splitmix64 so the sequence is the same on
+// every platform.
+func (v *VM) nextRandom() uint64 {
+	v.RandomState += 0x9E3779B97F4A7C15
+	z := v.RandomState
+	z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+	z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+	return z ^ (z >> 31)
+}
+
+// ClearRegisters is used to set every register of the virtual CPU, including the R4 flags register, and the PC to 0. It
+// doesn't execute any bytecode, so it can't fail whatever MaxCPUTime is set to.
 func (v *VM) ClearRegisters() {
-	_ = v.Execute([]byte{
-		InstructionUint8Load,  // Insert uint8 into R1.
-		0x00,                  // uint8 for 0.
-		InstructionMoveR1ToR2, // Move R2 <- [R1].
-		InstructionMoveR1ToR3, // Move R3 <- [R1].
-	})
+	v.Registers = [8]uint64{}
+	v.PC = 0
 }
 
 // ClearMemory is used to clear the memory of a virtual machine.
 func (v *VM) ClearMemory() {
+	v.shadow = nil
+	if v.paged != nil {
+		v.paged.pages = map[uint64][]byte{}
+	}
 	for i := range v.Memory {
 		v.Memory[i] = 0
 	}
 }
 
-// NewVM is used to create a new virtual machine.
-func NewVM(MemoryLength uint64, MaxCPUTime time.Duration) *VM {
-	return &VM{
+// ResetOption is used to change what Reset clears.
+type ResetOption uint8
+
+const (
+	// KeepMemory is used to make Reset leave the memory as it is.
+	KeepMemory ResetOption = iota + 1
+)
+
+// Reset is used to put the virtual machine back into the state NewVM created it in. The registers, PC, random state and
+// memory are cleared, along with the state left behind by earlier executions. The memory length, system calls, loaded
+// program and execution options are kept.
+func (v *VM) Reset(Options ...ResetOption) {
+	clearMemory := true
+	for _, option := range Options {
+		if option == KeepMemory {
+			clearMemory = false
+		}
+	}
+	v.ClearRegisters()
+	v.RandomState = 0
+	v.snapshot.Store(RegisterSnapshot{})
+	v.InstructionsExecuted = 0
+	v.atBreakpoint = false
+	v.paused = false
+	v.pausedCPUTime = 0
+	v.inInterrupt = false
+	v.interruptPC = 0
+	v.clearInterrupts()
+	v.cpuTimeExtension = 0
+	v.ResetCPUBudget()
+	v.lastStats = ExecutionStats{}
+	v.ResetOpcodeProfile()
+	v.ResetMemoryProfile()
+	if v.reverse != nil {
+		v.reverse.clear()
+	}
+	v.ResetCoverage()
+	v.ResetSyscallBudgets()
+	if clearMemory {
+		v.ClearMemory()
+	}
+}
+
+// CloneOption is used to change what Clone copies.
+type CloneOption uint8
+
+const (
+	// CopySyscalls is used to make Clone give the clone its own copy of the system call map.
+	CopySyscalls CloneOption = iota + 1
+)
+
+// Clone is used to create a deep copy of the virtual machine. The memory and registers are copied along with the rest
+// of the state and options. The system call map is shared with the original unless CopySyscalls is passed, so adding
+// a system call to one adds it to both. The loaded program and memory mapped io handlers are shared, but mapping a new
+// range only affects the virtual machine it is mapped on.
+func (v *VM) Clone(Options ...CloneOption) *VM {
+	clone := *v
+	clone.running = 0
+	clone.snapshot = atomic.Value{}
+	clone.stop = 0
+	clone.syscallHook = nil
+	clone.syscallTableBuf = nil
+	clone.ctx = nil
+	clone.mounts = append([]mountedModule(nil), v.mounts...)
+	clone.syscallFilters = append([]SyscallFilter(nil), v.syscallFilters...)
+	if v.syscallBudgets != nil {
+		clone.syscallBudgets = make(map[uint64]*syscallBudget, len(v.syscallBudgets))
+		for k, budget := range v.syscallBudgets {
+			b := *budget
+			clone.syscallBudgets[k] = &b
+		}
+	}
+	clone.Memory = make([]byte, len(v.Memory))
+	copy(clone.Memory, v.Memory)
+	clone.io = append([]ioRange(nil), v.io...)
+	if v.paged != nil {
+		clone.paged = v.paged.clone()
+	}
+	if v.transaction != nil {
+		t := *v.transaction
+		t.journal = append([]journalEntry(nil), t.journal...)
+		clone.transaction = &t
+	}
+	if v.interruptHandlers != nil {
+		clone.interruptHandlers = make(map[uint8]uint64, len(v.interruptHandlers))
+		for vector, offset := range v.interruptHandlers {
+			clone.interruptHandlers[vector] = offset
+		}
+	}
+	if v.breakpoints != nil {
+		clone.breakpoints = make(map[uint64]func(*VM) bool, len(v.breakpoints))
+		for k, cond := range v.breakpoints {
+			clone.breakpoints[k] = cond
+		}
+	}
+	if v.opcodeProfile != nil {
+		profile := *v.opcodeProfile
+		clone.opcodeProfile = &profile
+	}
+	if v.reverse != nil {
+		clone.reverse = &reverseJournal{entries: make([]reverseEntry, len(v.reverse.entries))}
+	}
+	if v.irreversibleSyscalls != nil {
+		clone.irreversibleSyscalls = make(map[uint64]bool, len(v.irreversibleSyscalls))
+		for k := range v.irreversibleSyscalls {
+			clone.irreversibleSyscalls[k] = true
+		}
+	}
+	clone.coverage = append(Coverage(nil), v.coverage...)
+	if v.memoryProfile != nil {
+		clone.memoryProfile = &memoryProfile{
+			bucketSize: v.memoryProfile.bucketSize,
+			reads:      append([]uint64(nil), v.memoryProfile.reads...),
+			writes:     append([]uint64(nil), v.memoryProfile.writes...),
+		}
+	}
+	if v.shadow != nil {
+		clone.shadow = make(map[uint64]uint64, len(v.shadow))
+		for k, bits := range v.shadow {
+			clone.shadow[k] = bits
+		}
+	}
+	for _, option := range Options {
+		if option == CopySyscalls {
+			clone.Syscalls = make(map[uint64]func(*VM) error, len(v.Syscalls))
+			for k, f := range v.Syscalls {
+				clone.Syscalls[k] = f
+			}
+		}
+	}
+	return &clone
+}
+
+// NewVM is used to create a new virtual machine. The options are applied after the memory and maximum CPU time are set
+// but are not checked for conflicts, use NewVMWithOptions for that.
+func NewVM(MemoryLength uint64, MaxCPUTime time.Duration, Options ...Option) *VM {
+	v := &VM{
 		Memory:     make([]byte, MemoryLength),
 		MaxCPUTime: MaxCPUTime,
 		Syscalls:   map[uint64]func(*VM) error{},
-		Registers:  [4]uint64{},
+		Registers:  [8]uint64{},
 	}
+	for _, option := range Options {
+		option(v)
+	}
+	return v
+}
+
+// NewVMWithMemory is used to create a new virtual machine whose memory starts with a copy of the initial bytes followed
+// by the number of extra zeroed bytes specified.
+func NewVMWithMemory(Initial []byte, Extra uint64, MaxCPUTime time.Duration, Options ...Option) *VM {
+	v := NewVM(uint64(len(Initial))+Extra, MaxCPUTime, Options...)
+	copy(v.Memory, Initial)
+	return v
+}
+
+// NewVMFromMemory is used to create a new virtual machine which adopts the slice as its memory without copying it. The
+// host and the virtual machine share the bytes, so writes from either side are seen by the other.
+func NewVMFromMemory(Memory []byte, MaxCPUTime time.Duration, Options ...Option) *VM {
+	v := NewVM(0, MaxCPUTime, Options...)
+	v.Memory = Memory
+	return v
 }
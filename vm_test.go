@@ -2,40 +2,2547 @@ package gomachine
 
 import (
 	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math"
+	"reflect"
+	"runtime"
 	"testing"
 	"time"
 )
 
+// uint64Operand is used to encode a uint64 operand for bytecode.
+func uint64Operand(x uint64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, x)
+	return b
+}
+
 func TestVM_Execute_Blank(t *testing.T) {
 	vm := NewVM(0, 0)
-	if err := vm.Execute([]byte{}); err != nil {
+	if err := vm.Execute([]byte{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVM_CPUTimeExhaustion(t *testing.T) {
+	vm := NewVM(0, time.Millisecond)
+	err := vm.Execute([]byte{InstructionJmp, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	if !errors.Is(err, CPUTimeExhausted) {
+		t.Fatal("expected cpu time exhausted error, got:", err)
+	}
+}
+
+func TestVM_Execute_StoreLoadRAM(t *testing.T) {
+	vm := NewVM(2, 0)
+	if err := vm.Execute([]byte{
+		InstructionUint8Load, 0x0A, InstructionUint8Dump,
+		0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint8Load, 0x00, InstructionMemoryUint8Load,
+		0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 0x0A {
+		t.Fatal("register not 0x0A:", vm.Registers[0])
+	}
+	if vm.Memory[1] != 0x0A {
+		t.Fatal("RAM not 0x0A:", vm.Memory[1])
+	}
+}
+
+func TestVM_Execute_JmpIfR4Set(t *testing.T) {
+	vm := NewVM(0, 0)
+	if err := vm.Execute([]byte{
+		InstructionUint8Load, 0x00, InstructionMoveR1ToR2,
+		InstructionUint8Load, 0x0A, InstructionUnsignedDiv,
+		InstructionJmpIfR4Set, 0x11, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint8Load, 0xFF,
+		InstructionMoveR4ToR3,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 0x0A {
+		t.Fatal("quotient register not preserved:", vm.Registers[0])
+	}
+	if vm.Registers[2] != 1 {
+		t.Fatal("error path not taken:", vm.Registers[2])
+	}
+}
+
+func TestVM_Execute_JmpIfR4Clear(t *testing.T) {
+	vm := NewVM(0, 0)
+	if err := vm.Execute([]byte{
+		InstructionUint8Load, 0x02, InstructionMoveR1ToR2,
+		InstructionUint8Load, 0x0A, InstructionUnsignedDiv,
+		InstructionJmpIfR4Clear, 0x11, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint8Load, 0xFF,
+		InstructionMoveR4ToR3,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 5 {
+		t.Fatal("not 5:", vm.Registers[0])
+	}
+
+	// Make sure a jump which is not taken leaves R4 alone.
+	vm = NewVM(0, 0)
+	if err := vm.Execute([]byte{
+		InstructionUint8Load, 0x00, InstructionMoveR1ToR2,
+		InstructionUint8Load, 0x0A, InstructionUnsignedDiv,
+		InstructionJmpIfR4Clear, 0x0F, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionMoveR4ToR3,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[2] != 1 {
+		t.Fatal("R4 was modified by the jump:", vm.Registers[2])
+	}
+}
+
+func TestVM_Execute_SignedJumps(t *testing.T) {
+	tests := []struct {
+		name        string
+		instruction uint8
+		r1          int64
+		r3          int64
+		taken       bool
+	}{
+		{"gt negative vs positive", InstructionJmpIfGtSigned, -1, 5, false},
+		{"gt positive vs negative", InstructionJmpIfGtSigned, 5, -1, true},
+		{"lt negative vs positive", InstructionJmpIfLtSigned, -1, 5, true},
+		{"lt positive vs negative", InstructionJmpIfLtSigned, 5, -1, false},
+		{"gte equal negatives", InstructionJmpIfGtOrEqualSigned, -3, -3, true},
+		{"gte negative vs positive", InstructionJmpIfGtOrEqualSigned, -3, 2, false},
+		{"gte positive vs negative", InstructionJmpIfGtOrEqualSigned, 2, -3, true},
+		{"lte equal negatives", InstructionJmpIfLtOrEqualSigned, -3, -3, true},
+		{"lte negative vs positive", InstructionJmpIfLtOrEqualSigned, -3, 2, true},
+		{"lte positive vs negative", InstructionJmpIfLtOrEqualSigned, 2, -3, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vm := NewVM(0, 0)
+			vm.Registers[0] = uint64(tt.r1)
+			vm.Registers[2] = uint64(tt.r3)
+			if err := vm.Execute([]byte{
+				tt.instruction, 0x0B, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				InstructionUint8Load, 0xFF,
+				InstructionMoveR1ToR2,
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if taken := vm.Registers[0] != 0xFF; taken != tt.taken {
+				t.Fatal("expected taken to be", tt.taken)
+			}
+		})
+	}
+}
+
+func TestVM_Execute_ConditionalMoves(t *testing.T) {
+	tests := []struct {
+		name        string
+		instruction uint8
+		r1          uint64
+		expected    uint64
+	}{
+		{"eq taken", InstructionMoveR2ToR1IfEq, 3, 7},
+		{"eq not taken", InstructionMoveR2ToR1IfEq, 4, 4},
+		{"ne taken", InstructionMoveR2ToR1IfNe, 4, 7},
+		{"ne not taken", InstructionMoveR2ToR1IfNe, 3, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vm := NewVM(0, 0)
+			vm.Registers = [8]uint64{tt.r1, 7, 3, 1}
+
+			// The instruction after the move marks R3 so we know bytecode advanced by exactly one byte.
+			if err := vm.Execute([]byte{tt.instruction, InstructionMoveR2ToR3}); err != nil {
+				t.Fatal(err)
+			}
+			if vm.Registers[0] != tt.expected {
+				t.Fatal("unexpected R1:", vm.Registers[0])
+			}
+			if vm.Registers[2] != 7 {
+				t.Fatal("bytecode did not advance to the next instruction")
+			}
+
+			vm.Registers[3] = 1
+			if err := vm.Execute([]byte{tt.instruction}); err != nil {
+				t.Fatal(err)
+			}
+			if vm.Registers[3] != 0 {
+				t.Fatal("R4 not cleared:", vm.Registers[3])
+			}
+		})
+	}
+}
+
+func TestVM_Execute_SetIfRangeCheck(t *testing.T) {
+	// Computes (x > 10) & (x < 20) where x is stored at memory location 0.
+	program := []byte{
+		InstructionUint8Load, 0x0A, InstructionMoveR1ToR3,
+		InstructionMemoryUint8Load, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionSetIfGt, InstructionMoveR1ToR2,
+		InstructionUint8Load, 0x14, InstructionMoveR1ToR3,
+		InstructionMemoryUint8Load, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionSetIfLt, InstructionBitwiseAnd,
+	}
+	for x, expected := range map[uint8]uint64{5: 0, 10: 0, 11: 1, 15: 1, 19: 1, 20: 0, 25: 0} {
+		vm := NewVM(1, 0)
+		vm.Memory[0] = x
+		if err := vm.Execute(program); err != nil {
+			t.Fatal(err)
+		}
+		if vm.Registers[0] != expected {
+			t.Fatal("unexpected result for", x, "got", vm.Registers[0])
+		}
+	}
+}
+
+func TestVM_Execute_SetIfSigned(t *testing.T) {
+	tests := []struct {
+		instruction uint8
+		r1          int64
+		r3          int64
+		expected    uint64
+	}{
+		{InstructionSetIfEq, -1, -1, 1},
+		{InstructionSetIfEq, -1, 1, 0},
+		{InstructionSetIfLt, -1, 5, 0},
+		{InstructionSetIfLtSigned, -1, 5, 1},
+		{InstructionSetIfGt, -1, 5, 1},
+		{InstructionSetIfGtSigned, -1, 5, 0},
+		{InstructionSetIfGtSigned, 5, -1, 1},
+		{InstructionSetIfLtSigned, 5, -1, 0},
+	}
+	for _, tt := range tests {
+		vm := NewVM(0, 0)
+		vm.Registers[0] = uint64(tt.r1)
+		vm.Registers[2] = uint64(tt.r3)
+		if err := vm.Execute([]byte{tt.instruction}); err != nil {
+			t.Fatal(err)
+		}
+		if vm.Registers[0] != tt.expected {
+			t.Fatal("unexpected result for instruction", tt.instruction, "with", tt.r1, tt.r3, "got", vm.Registers[0])
+		}
+	}
+}
+
+func TestVM_Execute_Float64RoundTrip(t *testing.T) {
+	for _, f := range []float64{0, math.Copysign(0, -1), 1.5, -2.25, math.MaxFloat64, math.SmallestNonzeroFloat64, math.Inf(1)} {
+		vm := NewVM(0, 0)
+		if err := vm.Execute(append([]byte{InstructionFloat64Load}, uint64Operand(math.Float64bits(f))...)); err != nil {
+			t.Fatal(err)
+		}
+		if vm.Registers[0] != math.Float64bits(f) {
+			t.Fatal("bit pattern not preserved for", f)
+		}
+	}
+}
+
+func TestVM_Execute_Float64Arithmetic(t *testing.T) {
+	tests := []struct {
+		instruction uint8
+		r1          float64
+		r2          float64
+		expected    float64
+	}{
+		{InstructionFloat64Add, 1.5, 2.25, 3.75},
+		{InstructionFloat64Sub, 1.5, 2.25, -0.75},
+		{InstructionFloat64Mul, 1.5, -2, -3},
+		{InstructionFloat64Div, 1, 4, 0.25},
+		{InstructionFloat64Div, 1, 0, math.Inf(1)},
+		{InstructionFloat64Div, -1, 0, math.Inf(-1)},
+	}
+	for _, tt := range tests {
+		vm := NewVM(0, 0)
+		vm.Registers = [8]uint64{math.Float64bits(tt.r1), math.Float64bits(tt.r2), 0, 1}
+		if err := vm.Execute([]byte{tt.instruction}); err != nil {
+			t.Fatal(err)
+		}
+		if result := math.Float64frombits(vm.Registers[0]); result != tt.expected {
+			t.Fatal("unexpected result for instruction", tt.instruction, "got", result)
+		}
+		if vm.Registers[3] != 0 {
+			t.Fatal("R4 not cleared:", vm.Registers[3])
+		}
+	}
+}
+
+func TestVM_Execute_Float64NaN(t *testing.T) {
+	for _, instruction := range []uint8{InstructionFloat64Add, InstructionFloat64Sub, InstructionFloat64Mul, InstructionFloat64Div} {
+		vm := NewVM(0, 0)
+		vm.Registers = [8]uint64{math.Float64bits(math.NaN()), math.Float64bits(2), 0, 0}
+		if err := vm.Execute([]byte{instruction}); err != nil {
+			t.Fatal(err)
+		}
+		if !math.IsNaN(math.Float64frombits(vm.Registers[0])) {
+			t.Fatal("NaN not propagated by instruction", instruction)
+		}
+	}
+
+	// 0 / 0 should produce NaN rather than setting R4.
+	vm := NewVM(0, 0)
+	if err := vm.Execute([]byte{InstructionFloat64Div}); err != nil {
+		t.Fatal(err)
+	}
+	if !math.IsNaN(math.Float64frombits(vm.Registers[0])) || vm.Registers[3] != 0 {
+		t.Fatal("expected NaN without R4 set")
+	}
+}
+
+func TestVM_Execute_Float64Average(t *testing.T) {
+	values := []float64{1.5, 2.5, 3.25, 4.75}
+	vm := NewVM(uint64(len(values)*8), 0)
+	for i, f := range values {
+		binary.LittleEndian.PutUint64(vm.Memory[i*8:], math.Float64bits(f))
+	}
+
+	// Sum the values into R3 and then divide by the count.
+	program := append([]byte{InstructionMemoryUint64Load}, uint64Operand(0)...)
+	program = append(program, InstructionMoveR1ToR3)
+	for i := 1; i < len(values); i++ {
+		program = append(program, InstructionMemoryUint64Load)
+		program = append(program, uint64Operand(uint64(i*8))...)
+		program = append(program, InstructionMoveR1ToR2, InstructionMoveR3ToR1, InstructionFloat64Add, InstructionMoveR1ToR3)
+	}
+	program = append(program, InstructionFloat64Load)
+	program = append(program, uint64Operand(math.Float64bits(float64(len(values))))...)
+	program = append(program, InstructionMoveR1ToR2, InstructionMoveR3ToR1, InstructionFloat64Div)
+	if err := vm.Execute(program); err != nil {
+		t.Fatal(err)
+	}
+	if result := math.Float64frombits(vm.Registers[0]); result != 3 {
+		t.Fatal("not 3:", result)
+	}
+}
+
+func TestVM_Execute_Float32(t *testing.T) {
+	for _, f := range []float64{
+		0.1,                              // Loses precision when narrowed.
+		1 << 30,                          // Exact in both.
+		-3.14159265358979,                // Loses precision when narrowed.
+		float64(math.Float32frombits(1)), // Smallest float32 subnormal.
+		float64(math.Float32frombits(0x007FFFFF)), // Largest float32 subnormal.
+		math.SmallestNonzeroFloat64,               // Underflows to 0 as a float32.
+		math.MaxFloat64,                           // Overflows to Inf as a float32.
+	} {
+		narrowed := float32(f)
+
+		// Dump to memory and check the stored bits.
+		vm := NewVM(5, 0)
+		vm.Registers[0] = math.Float64bits(f)
+		program := append([]byte{InstructionFloat32Dump}, uint64Operand(1)...)
+		if err := vm.Execute(program); err != nil {
+			t.Fatal(err)
+		}
+		if binary.LittleEndian.Uint32(vm.Memory[1:]) != math.Float32bits(narrowed) {
+			t.Fatal("unexpected float32 bits in memory for", f)
+		}
+
+		// Load back from memory and check it widened the narrowed value.
+		program = append([]byte{InstructionMemoryFloat32Load}, uint64Operand(1)...)
+		if err := vm.Execute(program); err != nil {
+			t.Fatal(err)
+		}
+		if vm.Registers[0] != math.Float64bits(float64(narrowed)) {
+			t.Fatal("unexpected float64 bits in R1 for", f)
+		}
+
+		// Explicitly narrow the register.
+		vm.Registers[0] = math.Float64bits(f)
+		if err := vm.Execute([]byte{InstructionFloat64ToFloat32Bits}); err != nil {
+			t.Fatal(err)
+		}
+		if vm.Registers[0] != uint64(math.Float32bits(narrowed)) {
+			t.Fatal("unexpected float32 bits in R1 for", f)
+		}
+	}
+
+	// Make sure the memory bounds are checked.
+	vm := NewVM(4, 0)
+	if err := vm.Execute(append([]byte{InstructionMemoryFloat32Load}, uint64Operand(1)...)); !errors.Is(err, InvalidMemoryLocation) {
+		t.Fatal("expected invalid memory location, got:", err)
+	}
+	if err := vm.Execute(append([]byte{InstructionFloat32Dump}, uint64Operand(1)...)); !errors.Is(err, InvalidMemoryLocation) {
+		t.Fatal("expected invalid memory location, got:", err)
+	}
+}
+
+func TestVM_Execute_FloatJumps(t *testing.T) {
+	nan := math.NaN()
+	negZero := math.Copysign(0, -1)
+	tests := []struct {
+		name        string
+		instruction uint8
+		r1          float64
+		r3          float64
+		taken       bool
+	}{
+		{"gt negative vs positive", InstructionJmpIfFloatGt, -1, 5, false},
+		{"gt positive vs negative", InstructionJmpIfFloatGt, 5, -1, true},
+		{"gt nan left", InstructionJmpIfFloatGt, nan, 1, false},
+		{"gt nan right", InstructionJmpIfFloatGt, 1, nan, false},
+		{"lt negative vs positive", InstructionJmpIfFloatLt, -1, 5, true},
+		{"lt nan left", InstructionJmpIfFloatLt, nan, 1, false},
+		{"lt nan right", InstructionJmpIfFloatLt, 1, nan, false},
+		{"lt negative zero vs zero", InstructionJmpIfFloatLt, negZero, 0, false},
+		{"eq equal", InstructionJmpIfFloatEq, 2.5, 2.5, true},
+		{"eq negative zero vs zero", InstructionJmpIfFloatEq, negZero, 0, true},
+		{"eq nan", InstructionJmpIfFloatEq, nan, nan, false},
+		{"unordered nan left", InstructionJmpIfFloatUnordered, nan, 1, true},
+		{"unordered nan right", InstructionJmpIfFloatUnordered, 1, nan, true},
+		{"unordered ordered", InstructionJmpIfFloatUnordered, 1, 2, false},
+		{"unordered negative zero vs zero", InstructionJmpIfFloatUnordered, negZero, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vm := NewVM(0, 0)
+			vm.Registers[0] = math.Float64bits(tt.r1)
+			vm.Registers[2] = math.Float64bits(tt.r3)
+			if err := vm.Execute([]byte{
+				tt.instruction, 0x0B, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				InstructionUint8Load, 0xFF,
+				InstructionMoveR1ToR2,
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if taken := vm.Registers[0] != 0xFF; taken != tt.taken {
+				t.Fatal("expected taken to be", tt.taken)
+			}
+		})
+	}
+}
+
+func TestVM_Execute_Features(t *testing.T) {
+	vm := NewVM(0, 0)
+	if err := vm.Execute([]byte{InstructionFeatures}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != SupportedFeatures {
+		t.Fatal("unexpected features:", vm.Registers[0])
+	}
+
+	// Make sure every group in the mask has its instructions compiled in.
+	groups := map[uint64][]uint8{
+		FeatureBase:              {InstructionUint8Load, InstructionUnsignedAdd, InstructionJmp, InstructionSyscall, InstructionJmpIfR4Set, InstructionFeatures},
+		FeatureSignedCompare:     {InstructionJmpIfGtSigned, InstructionJmpIfLtOrEqualSigned, InstructionSetIfEq, InstructionSetIfGtSigned},
+		FeatureConditionalMove:   {InstructionMoveR2ToR1IfEq, InstructionMoveR2ToR1IfNe},
+		FeatureFloat64:           {InstructionFloat64Load, InstructionFloat64Div, InstructionJmpIfFloatUnordered},
+		FeatureFloat32:           {InstructionMemoryFloat32Load, InstructionFloat32Dump, InstructionFloat64ToFloat32Bits},
+		FeatureIntrospection:     {InstructionMemorySize, InstructionLoadPC, InstructionReadCycleCounter},
+		FeatureRandom:            {InstructionRand},
+		FeatureBulkMemory:        {InstructionMemorySum64, InstructionMemoryCRC32},
+		FeatureCompactOperands:   {InstructionJmp32, InstructionJmpIfLtOrEqual32, InstructionMemoryUint8Load32, InstructionUint64Dump32},
+		FeatureVariableOperands:  {InstructionVarLoad, InstructionVarJmp, InstructionMemoryVarLoad64},
+		FeatureDirectLoads:       {InstructionUint8LoadR2Direct, InstructionUint64LoadR3Direct},
+		FeatureExtendedRegisters: {InstructionMoveR1ToR5, InstructionMoveR8ToR3, InstructionUint64LoadR8Direct},
+		FeatureRegisterOperands:  {InstructionMove2, InstructionAdd2, InstructionRightShift2},
+		FeatureYield:             {InstructionYield},
+		FeatureInterrupts:        {InstructionInterruptReturn},
+		FeatureSyscallR1:         {InstructionSyscallR1},
+	}
+	mask := uint64(0)
+	for feature, instructions := range groups {
+		mask |= feature
+		for _, instruction := range instructions {
+			vm := NewVM(8, 0)
+			program := []byte{instruction}
+			for i := 0; i < 8; i++ {
+				// Pad with instructions so any operand is present and one byte instructions are followed by valid bytecode.
+				program = append(program, InstructionMoveR1ToR2)
+			}
+			if err := vm.Execute(program); errors.Is(err, UnknownInstruction) {
+				t.Fatal("instruction not compiled in:", instruction)
+			}
+		}
+	}
+	if mask != SupportedFeatures {
+		t.Fatal("feature mask does not match the instruction groups:", mask)
+	}
+}
+
+func TestVM_Execute_MemorySize(t *testing.T) {
+	for _, size := range []uint64{0, 1, 255, 4096} {
+		vm := NewVM(size, 0)
+		vm.Registers[0] = 0xFFFF
+		vm.Registers[3] = 1
+		if err := vm.Execute([]byte{InstructionMemorySize}); err != nil {
+			t.Fatal(err)
+		}
+		if vm.Registers[0] != size {
+			t.Fatal("expected", size, "got", vm.Registers[0])
+		}
+		if vm.Registers[3] != 0 {
+			t.Fatal("R4 not cleared:", vm.Registers[3])
+		}
+	}
+}
+
+func TestVM_Execute_LoadPC(t *testing.T) {
+	vm := NewVM(0, 0)
+	if err := vm.Execute([]byte{InstructionLoadPC}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 0 {
+		t.Fatal("not 0:", vm.Registers[0])
+	}
+
+	// Load the PC at offsets 2, 14 and 16 into R2, R3 and R1, jumping over a move along the way.
+	vm = NewVM(0, 0)
+	if err := vm.Execute([]byte{
+		InstructionUint8Load, 0xFF,
+		InstructionLoadPC, InstructionMoveR1ToR2,
+		InstructionJmp, 0x0E, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionMoveR1ToR2,
+		InstructionLoadPC, InstructionMoveR1ToR3,
+		InstructionLoadPC,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[1] != 2 || vm.Registers[2] != 14 || vm.Registers[0] != 16 {
+		t.Fatal("unexpected PC values:", vm.Registers)
+	}
+}
+
+func TestVM_Execute_ReadCycleCounter(t *testing.T) {
+	vm := NewVM(8, 0)
+	if err := vm.Execute([]byte{
+		InstructionReadCycleCounter,
+		InstructionUint64Dump, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+
+		// Count from 0 to 5 in R1.
+		InstructionUint8Load, 0x05, InstructionMoveR1ToR3,
+		InstructionUint8Load, 0x01, InstructionMoveR1ToR2,
+		InstructionUint8Load, 0x00,
+		InstructionUnsignedAdd,
+		InstructionJmpIfNe, 0x12, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+
+		InstructionReadCycleCounter,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	start := binary.LittleEndian.Uint64(vm.Memory)
+	if start != 1 {
+		t.Fatal("expected the first read to be 1:", start)
+	}
+
+	// 1 dump, 5 setup instructions, 5 iterations of 2 instructions and the read itself.
+	if delta := vm.Registers[0] - start; delta != 17 {
+		t.Fatal("expected a delta of 17:", delta)
+	}
+}
+
+func TestVM_Execute_Rand(t *testing.T) {
+	vm := NewVM(0, 0)
+	vm.SeedRandom(1234567)
+	for _, expected := range []uint64{0x599ED017FB08FC85, 0x2C73F08458540FA5, 0x883EBCE5A3F27C77, 0x3FBEF740E9177B3F} {
+		if err := vm.Execute([]byte{InstructionRand}); err != nil {
+			t.Fatal(err)
+		}
+		if vm.Registers[0] != expected {
+			t.Fatalf("expected %x, got %x", expected, vm.Registers[0])
+		}
+	}
+}
+
+func TestVM_Execute_MemorySum64(t *testing.T) {
+	vm := NewVM(40, 0)
+	for i, x := range []uint64{1, 2, 3, 0xFFFFFFFFFFFFFFFF, 10} {
+		binary.LittleEndian.PutUint64(vm.Memory[i*8:], x)
+	}
+
+	// Sum the middle three values onto 100. The overflow wraps.
+	vm.Registers = [8]uint64{100, 8, 3, 1}
+	if err := vm.Execute([]byte{InstructionMemorySum64}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 104 {
+		t.Fatal("not 104:", vm.Registers[0])
+	}
+	if vm.Registers[3] != 0 {
+		t.Fatal("R4 not cleared:", vm.Registers[3])
+	}
+
+	// Sum the whole of memory and nothing.
+	for _, tt := range []struct{ r2, r3, expected uint64 }{{0, 5, 15}, {40, 0, 0}, {0, 0, 0}} {
+		vm.Registers = [8]uint64{0, tt.r2, tt.r3, 0}
+		if err := vm.Execute([]byte{InstructionMemorySum64}); err != nil {
+			t.Fatal(err)
+		}
+		if vm.Registers[0] != tt.expected {
+			t.Fatal("expected", tt.expected, "got", vm.Registers[0])
+		}
+	}
+
+	// Make sure out of range and overflowing ranges are rejected.
+	for _, tt := range []struct{ r2, r3 uint64 }{{8, 5}, {41, 0}, {0, 6}, {0xFFFFFFFFFFFFFFF8, 2}, {8, 0x2000000000000000}} {
+		vm.Registers = [8]uint64{0, tt.r2, tt.r3, 0}
+		if err := vm.Execute([]byte{InstructionMemorySum64}); !errors.Is(err, InvalidMemoryLocation) {
+			t.Fatal("expected invalid memory location for", tt.r2, tt.r3, "got:", err)
+		}
+	}
+}
+
+func TestVM_Execute_MemoryCRC32(t *testing.T) {
+	vm := NewVM(64, 0)
+	copy(vm.Memory, "The quick brown fox jumps over the lazy dog")
+	for _, tt := range []struct{ r2, r3 uint64 }{{0, 43}, {4, 5}, {0, 64}, {10, 0}, {64, 0}} {
+		vm.Registers = [8]uint64{0, tt.r2, tt.r3, 1}
+		if err := vm.Execute([]byte{InstructionMemoryCRC32}); err != nil {
+			t.Fatal(err)
+		}
+		if expected := uint64(crc32.ChecksumIEEE(vm.Memory[tt.r2 : tt.r2+tt.r3])); vm.Registers[0] != expected {
+			t.Fatal("expected", expected, "got", vm.Registers[0])
+		}
+		if vm.Registers[3] != 0 {
+			t.Fatal("R4 not cleared:", vm.Registers[3])
+		}
+	}
+
+	// Make sure out of range and overflowing ranges are rejected.
+	for _, tt := range []struct{ r2, r3 uint64 }{{0, 65}, {65, 0}, {60, 5}, {0xFFFFFFFFFFFFFFFF, 2}} {
+		vm.Registers = [8]uint64{0, tt.r2, tt.r3, 0}
+		if err := vm.Execute([]byte{InstructionMemoryCRC32}); !errors.Is(err, InvalidMemoryLocation) {
+			t.Fatal("expected invalid memory location for", tt.r2, tt.r3, "got:", err)
+		}
+	}
+}
+
+func TestVM_Execute_CompactJumps(t *testing.T) {
+	tests := []struct {
+		instruction uint8
+		r1          uint64
+		r3          uint64
+		taken       bool
+	}{
+		{InstructionJmp32, 0, 0, true},
+		{InstructionJmpIfZero32, 0, 1, true},
+		{InstructionJmpIfZero32, 1, 1, false},
+		{InstructionJmpIfEq32, 2, 2, true},
+		{InstructionJmpIfEq32, 2, 3, false},
+		{InstructionJmpIfNe32, 2, 3, true},
+		{InstructionJmpIfNe32, 2, 2, false},
+		{InstructionJmpIfGt32, 3, 2, true},
+		{InstructionJmpIfGt32, 2, 2, false},
+		{InstructionJmpIfLt32, 2, 3, true},
+		{InstructionJmpIfLt32, 2, 2, false},
+		{InstructionJmpIfGtOrEqual32, 2, 2, true},
+		{InstructionJmpIfGtOrEqual32, 1, 2, false},
+		{InstructionJmpIfLtOrEqual32, 2, 2, true},
+		{InstructionJmpIfLtOrEqual32, 3, 2, false},
+	}
+	for _, tt := range tests {
+		vm := NewVM(0, 0)
+		vm.Registers[0] = tt.r1
+		vm.Registers[2] = tt.r3
+		if err := vm.Execute([]byte{
+			tt.instruction, 0x07, 0x00, 0x00, 0x00,
+			InstructionUint8Load, 0xFF,
+			InstructionMoveR1ToR2,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if taken := vm.Registers[0] != 0xFF; taken != tt.taken {
+			t.Fatal("expected taken to be", tt.taken, "for instruction", tt.instruction)
+		}
+	}
+
+	// Make sure the largest target is validated against the bytecode length and truncated arguments are rejected.
+	vm := NewVM(0, 0)
+	if err := vm.Execute([]byte{InstructionJmp32, 0xFF, 0xFF, 0xFF, 0xFF}); !errors.Is(err, InvalidMemoryLocation) {
+		t.Fatal("expected invalid memory location, got:", err)
+	}
+	if err := vm.Execute([]byte{InstructionJmpIfZero32, 0xFF, 0xFF, 0xFF, 0xFF}); !errors.Is(err, InvalidMemoryLocation) {
+		t.Fatal("expected invalid memory location, got:", err)
+	}
+	if err := vm.Execute([]byte{InstructionJmp32, 0x00, 0x00, 0x00}); !errors.Is(err, InvalidInstructionArgument) {
+		t.Fatal("expected invalid instruction argument, got:", err)
+	}
+}
+
+func TestVM_Execute_CompactMemory(t *testing.T) {
+	tests := []struct {
+		load  uint8
+		dump  uint8
+		width uint32
+	}{
+		{InstructionMemoryUint8Load32, InstructionUint8Dump32, 1},
+		{InstructionMemoryUint16Load32, InstructionUint16Dump32, 2},
+		{InstructionMemoryUint32Load32, InstructionUint32Dump32, 4},
+		{InstructionMemoryUint64Load32, InstructionUint64Dump32, 8},
+	}
+	for _, tt := range tests {
+		for _, location := range []uint32{0, 16 - tt.width} {
+			b := make([]byte, 4)
+			binary.LittleEndian.PutUint32(b, location)
+
+			// Dump R1 then clear R1 and load it back.
+			vm := NewVM(16, 0)
+			vm.Registers[0] = 0x0102030405060708
+			program := append([]byte{tt.dump}, b...)
+			program = append(program, InstructionUint8Load, 0x00, tt.load)
+			program = append(program, b...)
+			if err := vm.Execute(program); err != nil {
+				t.Fatal(err)
+			}
+			expected := uint64(0x0102030405060708)
+			if tt.width != 8 {
+				expected &= 1<<(tt.width*8) - 1
+			}
+			if vm.Registers[0] != expected {
+				t.Fatalf("expected %x at %d, got %x", expected, location, vm.Registers[0])
+			}
+		}
+
+		// Make sure out of range locations are rejected.
+		for _, location := range []uint32{16 - tt.width + 1, 16, 0xFFFFFFFF} {
+			b := make([]byte, 4)
+			binary.LittleEndian.PutUint32(b, location)
+			vm := NewVM(16, 0)
+			if err := vm.Execute(append([]byte{tt.load}, b...)); !errors.Is(err, InvalidMemoryLocation) {
+				t.Fatal("expected invalid memory location, got:", err)
+			}
+			if err := vm.Execute(append([]byte{tt.dump}, b...)); !errors.Is(err, InvalidMemoryLocation) {
+				t.Fatal("expected invalid memory location, got:", err)
+			}
+		}
+	}
+}
+
+func TestVM_Execute_VarLoad(t *testing.T) {
+	tests := []struct {
+		operand  []byte
+		expected uint64
+	}{
+		{[]byte{0x00}, 0},
+		{[]byte{0x7F}, 0x7F},
+		{[]byte{0x80, 0x01}, 0x80},
+		{[]byte{0xE5, 0x8E, 0x26}, 624485},
+		{[]byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x01}, 0xFFFFFFFFFFFFFFFF},
+	}
+	for _, tt := range tests {
+		vm := NewVM(0, 0)
+		program := append([]byte{InstructionVarLoad}, tt.operand...)
+		program = append(program, InstructionMoveR1ToR2)
+		if err := vm.Execute(program); err != nil {
+			t.Fatal(err)
+		}
+		if vm.Registers[1] != tt.expected {
+			t.Fatal("expected", tt.expected, "got", vm.Registers[1])
+		}
+	}
+
+	// Make sure malformed arguments are rejected.
+	for _, operand := range [][]byte{
+		{},                 // Missing.
+		{0x80},             // Truncated.
+		{0xFF, 0xFF, 0xFF}, // Truncated.
+		{0x80, 0x00},       // Not minimally encoded.
+		{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x02},       // Overflows.
+		{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x81, 0x00}, // Longer than 10 bytes.
+	} {
+		vm := NewVM(0, 0)
+		if err := vm.Execute(append([]byte{InstructionVarLoad}, operand...)); !errors.Is(err, InvalidInstructionArgument) {
+			t.Fatal("expected invalid instruction argument for", operand, "got:", err)
+		}
+	}
+}
+
+func TestVM_Execute_VarJmpAndMemoryLoad(t *testing.T) {
+	vm := NewVM(0x90, 0)
+	binary.LittleEndian.PutUint64(vm.Memory[0x88:], 0xDEADBEEF)
+	if err := vm.Execute([]byte{
+		InstructionVarJmp, 0x04,
+		InstructionUint8Load, 0xFF,
+		InstructionMemoryVarLoad64, 0x88, 0x01,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 0xDEADBEEF {
+		t.Fatal("not 0xDEADBEEF:", vm.Registers[0])
+	}
+
+	// Make sure targets and locations are validated.
+	if err := vm.Execute([]byte{InstructionVarJmp, 0x02}); !errors.Is(err, InvalidMemoryLocation) {
+		t.Fatal("expected invalid memory location, got:", err)
+	}
+	if err := vm.Execute([]byte{InstructionMemoryVarLoad64, 0x89, 0x01}); !errors.Is(err, InvalidMemoryLocation) {
+		t.Fatal("expected invalid memory location, got:", err)
+	}
+	if err := vm.Execute([]byte{InstructionMemoryVarLoad64, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x01}); !errors.Is(err, InvalidMemoryLocation) {
+		t.Fatal("expected invalid memory location, got:", err)
+	}
+	if err := vm.Execute([]byte{InstructionVarJmp, 0x80}); !errors.Is(err, InvalidInstructionArgument) {
+		t.Fatal("expected invalid instruction argument, got:", err)
+	}
+}
+
+func TestVM_Execute_DirectLoads(t *testing.T) {
+	vm := NewVM(0, 0)
+	vm.Registers[0] = 0xAA
+	program := []byte{InstructionUint8LoadR2Direct, 0x01, InstructionUint64LoadR3Direct}
+	program = append(program, uint64Operand(0x0102030405060708)...)
+	if err := vm.Execute(program); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers != [8]uint64{0xAA, 0x01, 0x0102030405060708, 0} {
+		t.Fatal("unexpected registers:", vm.Registers)
+	}
+
+	program = []byte{InstructionUint8LoadR3Direct, 0x02, InstructionUint64LoadR2Direct}
+	program = append(program, uint64Operand(0xFFFFFFFFFFFFFFFF)...)
+	if err := vm.Execute(program); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers != [8]uint64{0xAA, 0xFFFFFFFFFFFFFFFF, 0x02, 0} {
+		t.Fatal("unexpected registers:", vm.Registers)
+	}
+
+	// Make sure truncated arguments are rejected.
+	for _, program := range [][]byte{
+		{InstructionUint8LoadR2Direct}, {InstructionUint8LoadR3Direct},
+		{InstructionUint64LoadR2Direct, 0x00, 0x00}, {InstructionUint64LoadR3Direct, 0x00},
+	} {
+		if err := vm.Execute(program); !errors.Is(err, InvalidInstructionArgument) {
+			t.Fatal("expected invalid instruction argument, got:", err)
+		}
+	}
+}
+
+func TestVM_Execute_GCStress(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				runtime.GC()
+			}
+		}
+	}()
+
+	for i := 0; i < 10000; i++ {
+		// Freshly allocate everything so nothing but Execute references it, and make every other run fail part way.
+		vm := NewVM(16, 0)
+		program := []byte{InstructionUint8Load, byte(i), InstructionUint8Dump, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+		if i%2 == 1 {
+			program = append(program, InstructionMemoryUint64Load, 0xFF, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+		}
+		err := vm.Execute(program)
+		if i%2 == 1 && !errors.Is(err, InvalidMemoryLocation) {
+			t.Fatal("expected invalid memory location, got:", err)
+		} else if i%2 == 0 && err != nil {
+			t.Fatal(err)
+		}
+		if vm.Memory[1] != byte(i) {
+			t.Fatal("unexpected memory:", vm.Memory[1])
+		}
+	}
+}
+
+func TestVM_Execute_SyscallReplacesMemory(t *testing.T) {
+	vm := NewVM(4, 0)
+	old := vm.Memory
+	vm.Syscalls[1] = func(vm *VM) error {
+		vm.Memory = make([]byte, 32)
+		return nil
+	}
+	if err := vm.Execute([]byte{
+		InstructionUint8Load, 0x0A,
+		InstructionUint8Dump, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint8Load, 0x0B,
+		InstructionUint8Dump, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint64Dump, 0x18, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionMemorySize,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if old[1] != 0x0A {
+		t.Fatal("old memory was modified after the syscall:", old[1])
+	}
+	if vm.Memory[1] != 0x0B || vm.Memory[0x18] != 0x0B {
+		t.Fatal("dumps did not land in the new memory")
+	}
+	if vm.Registers[0] != 32 {
+		t.Fatal("not 32:", vm.Registers[0])
+	}
+
+	// Make sure the old bounds apply again when the memory shrinks.
+	vm.Syscalls[1] = func(vm *VM) error {
+		vm.Memory = make([]byte, 2)
+		return nil
+	}
+	if err := vm.Execute([]byte{
+		InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint8Dump, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}); !errors.Is(err, InvalidMemoryLocation) {
+		t.Fatal("expected invalid memory location, got:", err)
+	}
+}
+
+func TestVM_Execute_SyscallErrors(t *testing.T) {
+	// R1 is 3 if the syscall failed and 2 if it didn't.
+	bytecode := []byte{
+		InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionJmpIfR4Set, 0x1D, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint8Load, 0x02,
+		InstructionJmp, 0x1F, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint8Load, 0x03,
+		InstructionMoveR1ToR2,
+	}
+	notFound := errors.New("not found")
+	var syscallErr error
+	vm := NewVM(0, 0)
+	vm.Syscalls[1] = func(*VM) error {
+		return syscallErr
+	}
+	for _, tt := range []struct {
+		name   string
+		err    error
+		result uint64
+		code   uint64
+	}{
+		{"success", nil, 2, 0},
+		{"error", notFound, 3, DefaultSyscallErrorCode},
+		{"errno", ErrnoError(11, notFound), 3, 11},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			vm.ClearRegisters()
+			syscallErr = tt.err
+			if err := vm.Execute(bytecode); err != nil {
+				t.Fatal(err)
+			}
+			if vm.Registers[0] != tt.result || vm.Registers[2] != tt.code {
+				t.Fatal("wrong result:", vm.Registers[0], vm.Registers[2])
+			}
+		})
+	}
+
+	// Fatal errors stop execution at the syscall.
+	vm.ClearRegisters()
+	syscallErr = &FatalSyscallError{Err: notFound}
+	err := vm.Execute(bytecode)
+	var vmErr *VMError
+	if !errors.As(err, &vmErr) || !errors.Is(err, notFound) || vmErr.Offset != 0 || vmErr.Operand != 1 {
+		t.Fatal("expected fatal error, got:", err)
+	}
+	if vm.PC != 0 || vm.Registers[0] != 0 || vm.Registers[3] != 0 {
+		t.Fatal("wrong state:", vm.PC, vm.Registers[0], vm.Registers[3])
+	}
+	if err.Error() != "not found (opcode 0x2f at offset 0, operand 0x1)" {
+		t.Fatal("wrong error string:", err.Error())
+	}
+}
+
+func TestVM_Execute_SyscallR1(t *testing.T) {
+	// Make the syscalls at memory locations 0 and 1 from R1.
+	bytecode := []byte{
+		InstructionMemoryUint8Load, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionSyscallR1,
+		InstructionMemoryUint8Load, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionSyscallR1,
+	}
+	var calls []string
+	vm := NewVM(2, 0)
+	vm.Syscalls[5] = func(vm *VM) error {
+		calls = append(calls, "read")
+		return nil
+	}
+	vm.Syscalls[9] = func(vm *VM) error {
+		calls = append(calls, "write")
+		return nil
+	}
+	vm.Memory[0], vm.Memory[1] = 9, 5
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(calls, []string{"write", "read"}) {
+		t.Fatal("wrong calls:", calls)
+	}
+
+	// Unknown numbers are invalid syscalls like with InstructionSyscall.
+	vm.Memory[1] = 7
+	err := vm.Execute(bytecode)
+	var vmErr *VMError
+	if !errors.Is(err, InvalidSyscall) || !errors.As(err, &vmErr) || vmErr.Offset != 19 || vmErr.Opcode != InstructionSyscallR1 || vmErr.Operand != 7 {
+		t.Fatal("expected invalid syscall, got:", err)
+	}
+}
+
+func TestVM_Execute_LittleEndianOperands(t *testing.T) {
+	// Arguments are always little endian regardless of the host.
+	vm := NewVM(0x0110, 0)
+	for _, tt := range []struct {
+		program  []byte
+		expected uint64
+	}{
+		{[]byte{InstructionUint16Load, 0x34, 0x12}, 0x1234},
+		{[]byte{InstructionUint32Load, 0x78, 0x56, 0x34, 0x12}, 0x12345678},
+		{[]byte{InstructionUint64Load, 0xF0, 0xDE, 0xBC, 0x9A, 0x78, 0x56, 0x34, 0x12}, 0x123456789ABCDEF0},
+		{[]byte{InstructionFloat64Load, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xF0, 0x3F}, math.Float64bits(1)},
+	} {
+		if err := vm.Execute(tt.program); err != nil {
+			t.Fatal(err)
+		}
+		if vm.Registers[0] != tt.expected {
+			t.Fatalf("expected %x, got %x", tt.expected, vm.Registers[0])
+		}
+	}
+
+	// Memory locations and memory contents are little endian too.
+	if err := vm.Execute([]byte{
+		InstructionUint32Load, 0x78, 0x56, 0x34, 0x12,
+		InstructionUint32Dump, 0x01, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionMemoryUint16Load, 0x02, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Memory[0x0101] != 0x78 || vm.Memory[0x0102] != 0x56 || vm.Memory[0x0103] != 0x34 || vm.Memory[0x0104] != 0x12 {
+		t.Fatal("unexpected memory:", vm.Memory[0x0101:0x0105])
+	}
+	if vm.Registers[0] != 0x3456 {
+		t.Fatalf("expected 3456, got %x", vm.Registers[0])
+	}
+
+	// Jump targets are little endian.
+	program := make([]byte, 0x0102)
+	program[0] = InstructionJmp
+	program[1], program[2] = 0x01, 0x01
+	program[0x0101] = InstructionLoadPC
+	if err := vm.Execute(program); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 0x0101 {
+		t.Fatalf("expected 101, got %x", vm.Registers[0])
+	}
+}
+
+func TestVM_Execute_UnalignedAccess(t *testing.T) {
+	tests := []struct {
+		load  uint8
+		dump  uint8
+		width uint64
+	}{
+		{InstructionMemoryUint16Load, InstructionUint16Dump, 2},
+		{InstructionMemoryUint32Load, InstructionUint32Dump, 4},
+		{InstructionMemoryUint64Load, InstructionUint64Dump, 8},
+	}
+	for _, tt := range tests {
+		for _, location := range []uint64{1, 3, 5, 7} {
+			// Start with a one byte instruction so every argument after it is at a odd offset.
+			vm := NewVM(16, 0)
+			program := []byte{InstructionMoveR2ToR3, InstructionUint64Load}
+			program = append(program, uint64Operand(0x0102030405060708)...)
+			program = append(program, tt.dump)
+			program = append(program, uint64Operand(location)...)
+			program = append(program, InstructionUint8LoadR2Direct, 0x00, InstructionMoveR2ToR1, tt.load)
+			program = append(program, uint64Operand(location)...)
+			if err := vm.Execute(program); err != nil {
+				t.Fatal(err)
+			}
+			expected := uint64(0x0102030405060708)
+			if tt.width != 8 {
+				expected &= 1<<(tt.width*8) - 1
+			}
+			if vm.Registers[0] != expected {
+				t.Fatalf("expected %x at %d, got %x", expected, location, vm.Registers[0])
+			}
+			for i := uint64(0); i < tt.width; i++ {
+				if vm.Memory[location+i] != byte(expected>>(i*8)) {
+					t.Fatal("unexpected memory at", location+i, "got", vm.Memory)
+				}
+			}
+		}
+	}
+
+	// Jump targets and compact arguments at odd offsets.
+	vm := NewVM(16, 0)
+	vm.Memory[5] = 0xAB
+	if err := vm.Execute([]byte{
+		InstructionMoveR2ToR3,
+		InstructionJmpIfZero, 0x0A, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionMemoryUint16Load32, 0x05, 0x00, 0x00, 0x00,
+		InstructionUint32Load, 0x01, 0x02, 0x03, 0x04,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 0x04030201 {
+		t.Fatalf("expected 4030201, got %x", vm.Registers[0])
+	}
+}
+
+func TestVM_Execute_StrictArithmetic(t *testing.T) {
+	for _, instruction := range []uint8{InstructionUnsignedDiv, InstructionSignedDiv, InstructionUnsignedMod, InstructionSignedMod} {
+		// The default sets R4 and leaves R1 alone.
+		vm := NewVM(0, 0)
+		vm.Registers = [8]uint64{10, 0, 0, 0}
+		if err := vm.Execute([]byte{instruction}); err != nil {
+			t.Fatal(err)
+		}
+		if vm.Registers[0] != 10 || vm.Registers[3] != 1 {
+			t.Fatal("unexpected registers:", vm.Registers)
+		}
+
+		// Strict mode returns a error pointing at the instruction.
+		vm = NewVM(0, 0)
+		vm.StrictArithmetic = true
+		vm.Registers = [8]uint64{10, 0, 0, 0}
+		err := vm.Execute([]byte{InstructionMoveR1ToR3, instruction})
+		if !errors.Is(err, DivideByZero) {
+			t.Fatal("expected division by zero, got:", err)
+		}
+		var vmErr *VMError
+		if !errors.As(err, &vmErr) || vmErr.Offset != 1 || vmErr.Opcode != instruction {
+			t.Fatal("unexpected error:", err)
+		}
+		if vm.Registers[0] != 10 || vm.Registers[3] != 0 {
+			t.Fatal("unexpected registers:", vm.Registers)
+		}
+
+		// Strict mode still divides normally.
+		vm.Registers = [8]uint64{10, 4, 0, 0}
+		if err := vm.Execute([]byte{instruction}); err != nil {
+			t.Fatal(err)
+		}
+		if vm.Registers[0] != 2 {
+			t.Fatal("not 2:", vm.Registers[0])
+		}
+	}
+}
+
+func TestVM_Execute_PreserveFlags(t *testing.T) {
+	// Divide by 0, do some moves and loads, then check R4.
+	program := []byte{
+		InstructionUint8Load, 0x0A, InstructionUnsignedDiv,
+		InstructionMoveR1ToR3, InstructionUint8Load, 0x05, InstructionMoveR4ToR2, InstructionMemorySize,
+		InstructionJmpIfR4Set, 0x14, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint8Load, 0xFF, InstructionMoveR1ToR3,
+		InstructionMoveR2ToR1,
+	}
+	for _, tt := range []struct {
+		preserve bool
+		r1       uint64
+		r3       uint64
+		r4       uint64
+	}{
+		// By default R4 is cleared by the move right after the division, so the jump is not taken and R2 gets 0.
+		{false, 0, 0xFF, 0},
+		// With flags preserved the flag survives, the jump is taken and R2 gets the flag.
+		{true, 1, 0x0A, 1},
+	} {
+		vm := NewVM(0, 0)
+		vm.PreserveFlags = tt.preserve
+		if err := vm.Execute(program); err != nil {
+			t.Fatal(err)
+		}
+		if vm.Registers[0] != tt.r1 || vm.Registers[2] != tt.r3 || vm.Registers[3] != tt.r4 {
+			t.Fatal("unexpected registers with preserve flags", tt.preserve, "got", vm.Registers)
+		}
+	}
+
+	// A successful division always clears the flag.
+	vm := NewVM(0, 0)
+	vm.PreserveFlags = true
+	vm.Registers = [8]uint64{10, 2, 0, 1}
+	if err := vm.Execute([]byte{InstructionUnsignedDiv}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[3] != 0 {
+		t.Fatal("R4 not cleared:", vm.Registers[3])
+	}
+
+	// R4 can hold a value across other instructions.
+	vm.Registers = [8]uint64{0, 0, 0, 0x1234}
+	if err := vm.Execute([]byte{InstructionUint8Load, 0x01, InstructionMoveR1ToR2, InstructionUnsignedAdd, InstructionFloat64Add, InstructionMoveR4ToR3}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[2] != 0x1234 || vm.Registers[3] != 0x1234 {
+		t.Fatal("R4 not preserved:", vm.Registers)
+	}
+}
+
+func TestVM_Execute_MaskShiftCounts(t *testing.T) {
+	tests := []struct {
+		instruction uint8
+		count       uint64
+		mask        bool
+		expected    uint64
+	}{
+		{InstructionBitwiseLeftShift, 63, false, 0x8000000000000000},
+		{InstructionBitwiseLeftShift, 64, false, 0},
+		{InstructionBitwiseLeftShift, 65, false, 0},
+		{InstructionBitwiseLeftShift, 128, false, 0},
+		{InstructionBitwiseLeftShift, 63, true, 0x8000000000000000},
+		{InstructionBitwiseLeftShift, 64, true, 0x3},
+		{InstructionBitwiseLeftShift, 65, true, 0x6},
+		{InstructionBitwiseLeftShift, 128, true, 0x3},
+		{InstructionBitwiseRightShift, 63, false, 0},
+		{InstructionBitwiseRightShift, 64, false, 0},
+		{InstructionBitwiseRightShift, 65, false, 0},
+		{InstructionBitwiseRightShift, 128, false, 0},
+		{InstructionBitwiseRightShift, 63, true, 0},
+		{InstructionBitwiseRightShift, 64, true, 0x3},
+		{InstructionBitwiseRightShift, 65, true, 0x1},
+		{InstructionBitwiseRightShift, 128, true, 0x3},
+	}
+	for _, tt := range tests {
+		vm := NewVM(0, 0)
+		vm.MaskShiftCounts = tt.mask
+		vm.Registers = [8]uint64{3, tt.count, 0, 0}
+		if err := vm.Execute([]byte{tt.instruction}); err != nil {
+			t.Fatal(err)
+		}
+		if vm.Registers[0] != tt.expected {
+			t.Fatalf("expected %x for shift %d by %d with masking %v, got %x", tt.expected, tt.instruction, tt.count, tt.mask, vm.Registers[0])
+		}
+	}
+}
+
+func TestVM_Execute_PC(t *testing.T) {
+	// The PC is the length of the bytecode after a successful run.
+	vm := NewVM(4, 0)
+	if err := vm.Execute([]byte{InstructionUint8Load, 0x01, InstructionMoveR1ToR2}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.PC != 3 {
+		t.Fatal("not 3:", vm.PC)
+	}
+
+	// The PC points at the instruction which failed.
+	err := vm.Execute([]byte{
+		InstructionUint8Load, 0x01, InstructionMoveR1ToR2,
+		InstructionMemoryUint8Load, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	})
+	if !errors.Is(err, InvalidMemoryLocation) {
+		t.Fatal("expected invalid memory location, got:", err)
+	}
+	if vm.PC != 3 {
+		t.Fatal("not 3:", vm.PC)
+	}
+
+	// The PC is visible to system calls.
+	var syscallPC uint64
+	vm.Syscalls[1] = func(vm *VM) error {
+		syscallPC = vm.PC
+		return nil
+	}
+	if err := vm.Execute([]byte{InstructionMoveR1ToR2, InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	if syscallPC != 1 {
+		t.Fatal("not 1:", syscallPC)
+	}
+
+	// The PC points at the instruction which would have run next when the CPU time is exhausted.
+	vm = NewVM(0, time.Millisecond)
+	err = vm.Execute([]byte{InstructionMoveR1ToR2, InstructionJmp, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	if !errors.Is(err, CPUTimeExhausted) {
+		t.Fatal("expected cpu time exhausted error, got:", err)
+	}
+	if vm.PC != 1 {
+		t.Fatal("not 1:", vm.PC)
+	}
+
+	// Clearing the registers resets the PC.
+	vm.ClearRegisters()
+	if vm.PC != 0 {
+		t.Fatal("not 0:", vm.PC)
+	}
+}
+
+func TestVM_ClearRegisters(t *testing.T) {
+	// Exhaust a tiny amount of CPU time so the VM has no time left, then set every register.
+	vm := NewVM(0, time.Nanosecond)
+	err := vm.Execute([]byte{InstructionMoveR1ToR2, InstructionJmp, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	if !errors.Is(err, CPUTimeExhausted) {
+		t.Fatal("expected cpu time exhausted error, got:", err)
+	}
+	for i := range vm.Registers {
+		vm.Registers[i] = uint64(i) + 1
+	}
+	vm.PC = 5
+
+	// Every register and the PC are cleared.
+	vm.ClearRegisters()
+	if vm.Registers != [8]uint64{} {
+		t.Fatal("registers not cleared:", vm.Registers)
+	}
+	if vm.PC != 0 {
+		t.Fatal("not 0:", vm.PC)
+	}
+}
+
+func TestVM_Execute_MaxInstructions(t *testing.T) {
+	// Count R1 up to 100.
+	bytecode := []byte{
+		InstructionUint8LoadR3Direct, 0x64,
+		InstructionUint8LoadR2Direct, 0x01,
+		InstructionUnsignedAdd,
+		InstructionJmpIfLt, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+
+	// The 2 loads and 4 times round the loop fit in 10 instructions.
+	vm := NewVM(0, 0, WithMaxInstructions(10))
+	err := vm.Execute(bytecode)
+	if !errors.Is(err, InstructionBudgetExhausted) {
+		t.Fatal("expected instruction budget exhausted, got:", err)
+	}
+	if vm.Registers[0] != 4 || vm.PC != 4 || vm.InstructionsExecuted != 10 {
+		t.Fatal("wrong state:", vm.Registers[0], vm.PC, vm.InstructionsExecuted)
+	}
+
+	// Continuing gets another 10 instructions.
+	if err := vm.Continue(bytecode); !errors.Is(err, InstructionBudgetExhausted) {
+		t.Fatal("expected instruction budget exhausted, got:", err)
+	}
+	if vm.Registers[0] != 9 || vm.PC != 4 || vm.InstructionsExecuted != 10 {
+		t.Fatal("wrong state:", vm.Registers[0], vm.PC, vm.InstructionsExecuted)
+	}
+
+	// With no limit it runs to the end.
+	vm.MaxInstructions = 0
+	if err := vm.Continue(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 100 || vm.InstructionsExecuted != 2*91 {
+		t.Fatal("wrong state:", vm.Registers[0], vm.InstructionsExecuted)
+	}
+
+	// A budget of exactly the instructions needed is enough.
+	vm = NewVM(0, 0, WithMaxInstructions(2+2*100))
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVM_Execute_MaxBackwardJumps(t *testing.T) {
+	// Straight-line code of any length is unaffected.
+	bytecode := make([]byte, 0, 30000)
+	for i := 0; i < 10000; i++ {
+		bytecode = append(bytecode, InstructionUint8LoadR2Direct, 0x01, InstructionUnsignedAdd)
+	}
+	vm := NewVM(0, 0, WithMaxBackwardJumps(1))
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+
+	// Forward jumps are not counted.
+	bytecode = []byte{
+		InstructionJmp, 0x09, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionJmp, 0x14, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint8Load, 0x05,
+		InstructionUint8Load, 0x01,
+	}
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 1 {
+		t.Fatal("wrong result:", vm.Registers[0])
+	}
+
+	// Count R1 up to 100. The loop dies after exactly 5 back-edges, on the 6th time round.
+	bytecode = []byte{
+		InstructionUint8LoadR3Direct, 0x64,
+		InstructionUint8LoadR2Direct, 0x01,
+		InstructionUnsignedAdd,
+		InstructionJmpIfLt, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	vm.ClearRegisters()
+	vm.MaxBackwardJumps = 5
+	err := vm.Execute(bytecode)
+	if !errors.Is(err, LoopBudgetExhausted) {
+		t.Fatal("expected loop budget exhausted, got:", err)
+	}
+	var vmErr *VMError
+	if !errors.As(err, &vmErr) || vmErr.Offset != 5 || vmErr.Operand != 4 {
+		t.Fatal("wrong error:", err)
+	}
+	if vm.Registers[0] != 6 || vm.InstructionsExecuted != 2+6*2 {
+		t.Fatal("wrong state:", vm.Registers[0], vm.InstructionsExecuted)
+	}
+
+	// A budget of exactly the back-edges needed is enough.
+	vm.ClearRegisters()
+	vm.MaxBackwardJumps = 99
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 100 {
+		t.Fatal("wrong result:", vm.Registers[0])
+	}
+
+	// Jumping to itself counts as a back-edge.
+	vm.MaxBackwardJumps = 3
+	err = vm.Execute([]byte{InstructionJmp, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	if !errors.Is(err, LoopBudgetExhausted) || vm.InstructionsExecuted != 4 {
+		t.Fatal("expected loop budget exhausted after 4 instructions, got:", err, vm.InstructionsExecuted)
+	}
+}
+
+func TestVM_Execute_OnJump(t *testing.T) {
+	// Count R1 up to 3, skip a branch back to the start and jump over a load.
+	bytecode := []byte{
+		InstructionUint8LoadR3Direct, 0x03,
+		InstructionUint8LoadR2Direct, 0x01,
+		InstructionUnsignedAdd,
+		InstructionJmpIfLt, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionJmpIfZero, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionJmp, 0x22, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint8Load, 0x05,
+		InstructionUint8LoadR2Direct, 0x00,
+	}
+	type edge struct {
+		from, to uint64
+		taken    bool
+	}
+	var edges []edge
+	vm := NewVM(0, 0)
+	vm.OnJump = func(From, To uint64, Taken bool) {
+		edges = append(edges, edge{From, To, Taken})
+	}
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	expected := []edge{
+		{5, 4, true},
+		{5, 4, true},
+		{5, 4, false},
+		{14, 0, false},
+		{23, 34, true},
+	}
+	if !reflect.DeepEqual(edges, expected) {
+		t.Fatal("wrong edges:", edges)
+	}
+	if vm.Registers[0] != 3 {
+		t.Fatal("wrong result:", vm.Registers[0])
+	}
+}
+
+func TestVM_Step(t *testing.T) {
+	// A ten instruction program.
+	bytecode := []byte{
+		InstructionUint8Load, 0x05,
+		InstructionMoveR1ToR2,
+		InstructionUnsignedAdd,
+		InstructionMoveR1ToR5,
+		InstructionUint16Load, 0x00, 0x01,
+		InstructionMoveR1ToR3,
+		InstructionUnsignedMul,
+		InstructionMoveR5ToR2,
+		InstructionBitwiseXor,
+		InstructionUint64Dump, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+
+	// Step it and check the state after each step is the same as running that many instructions.
+	vm := NewVM(8, 0)
+	for i := uint64(1); i <= 10; i++ {
+		err := vm.Step(bytecode)
+		if i == 10 {
+			if err != ProgramComplete {
+				t.Fatal("expected program complete, got:", err)
+			}
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		batch := NewVM(8, 0, WithMaxInstructions(i))
+		if err := batch.Execute(bytecode); err != nil && !errors.Is(err, InstructionBudgetExhausted) {
+			t.Fatal(err)
+		}
+		if vm.Registers != batch.Registers || vm.PC != batch.PC {
+			t.Fatal("step", i, "differs:", vm.Registers, vm.PC, batch.Registers, batch.PC)
+		}
+	}
+	if x := getUint64(vm.Memory, 0); x != 256*5^10 {
+		t.Fatal("wrong result:", x)
+	}
+
+	// Stepping at the end does nothing.
+	if err := vm.Step(bytecode); err != ProgramComplete {
+		t.Fatal("expected program complete, got:", err)
+	}
+
+	// Errors are returned with the PC at the failing instruction.
+	vm.PC = 0
+	if err := vm.Step([]byte{0x00}); !errors.Is(err, UnknownInstruction) || vm.PC != 0 {
+		t.Fatal("expected unknown instruction, got:", err)
+	}
+
+	// The loaded program can be stepped.
+	vm = NewVM(8, 0)
+	if err := vm.StepProgram(); !errors.Is(err, NoProgramLoaded) {
+		t.Fatal("expected no program loaded, got:", err)
+	}
+	if err := vm.LoadProgram(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.StepProgram(); err != nil || vm.PC != 2 || vm.Registers[0] != 5 {
+		t.Fatal("wrong state:", err, vm.PC, vm.Registers[0])
+	}
+}
+
+func TestVM_ExecuteN(t *testing.T) {
+	// Sum 1 to N into R5 where N is the byte at memory location 0.
+	bytecode := []byte{
+		InstructionMemoryUint8Load, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionMoveR1ToR3,
+		InstructionUint8Load, 0x00,
+		InstructionUint8LoadR2Direct, 0x01,
+		InstructionUnsignedAdd,
+		InstructionMoveR1ToR6,
+		InstructionMoveR5ToR1,
+		InstructionMoveR6ToR2,
+		InstructionUnsignedAdd,
+		InstructionMoveR1ToR5,
+		InstructionMoveR6ToR1,
+		InstructionUint8LoadR2Direct, 0x01,
+		InstructionJmpIfLt, 0x0e, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	newVM := func(n uint8) *VM {
+		vm := NewVM(1, 0)
+		vm.Memory[0] = n
+		return vm
+	}
+
+	// Run each to completion.
+	expected := make([]uint64, 2)
+	for i, n := range []uint8{100, 37} {
+		vm := newVM(n)
+		if err := vm.Execute(bytecode); err != nil {
+			t.Fatal(err)
+		}
+		expected[i] = vm.Registers[4]
+	}
+	if expected[0] != 5050 || expected[1] != 703 {
+		t.Fatal("wrong sums:", expected)
+	}
+
+	// Interleave them 7 instructions at a time.
+	vms := []*VM{newVM(100), newVM(37)}
+	for done := 0; done != len(vms); {
+		done = 0
+		for _, vm := range vms {
+			if vm.PC == uint64(len(bytecode)) {
+				done++
+				continue
+			}
+			executed, err := vm.ExecuteN(bytecode, 7)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if executed != 7 && vm.PC != uint64(len(bytecode)) {
+				t.Fatal("paused after", executed, "instructions")
+			}
+		}
+	}
+	for i, vm := range vms {
+		if vm.Registers[4] != expected[i] {
+			t.Fatal("not", expected[i], "-", vm.Registers[4])
+		}
+	}
+
+	// Executing 0 instructions does nothing.
+	vm := newVM(1)
+	if executed, err := vm.ExecuteN(bytecode, 0); executed != 0 || err != nil || vm.PC != 0 {
+		t.Fatal("wrong state:", executed, err, vm.PC)
+	}
+}
+
+func TestVM_Stop(t *testing.T) {
+	// Stopping when nothing is running does nothing.
+	vm := NewVM(0, 0)
+	vm.Stop()
+	if err := vm.Execute([]byte{InstructionUint8Load, 0x01}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Spin forever after a syscall says the loop has started.
+	started := make(chan struct{})
+	vm.Syscalls = map[uint64]func(*VM) error{
+		1: func(vm *VM) error {
+			close(started)
+			return nil
+		},
+	}
+	bytecode := []byte{
+		InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionJmp, 0x09, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	done := make(chan error)
+	go func() { done <- vm.Execute(bytecode) }()
+	<-started
+	vm.Stop()
+	select {
+	case err := <-done:
+		if !errors.Is(err, Stopped) || errors.Is(err, CPUTimeExhausted) {
+			t.Fatal("expected stopped, got:", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("vm didn't stop")
+	}
+	if vm.PC != 9 {
+		t.Fatal("not 9:", vm.PC)
+	}
+}
+
+func TestVM_Pause(t *testing.T) {
+	// Resuming when nothing was paused errors.
+	vm := NewVM(0, 250*time.Millisecond)
+	if err := vm.Resume(nil); !errors.Is(err, NotPaused) {
+		t.Fatal("expected not paused, got:", err)
+	}
+
+	// Count R1 up to 10,000 calling a syscall each time round the loop. The 1000th call waits until the vm is paused.
+	started := make(chan struct{})
+	release := make(chan struct{})
+	calls := 0
+	vm.Syscalls = map[uint64]func(*VM) error{
+		1: func(vm *VM) error {
+			calls++
+			if calls == 1000 {
+				close(started)
+				<-release
+			}
+			return nil
+		},
+	}
+	bytecode := []byte{
+		InstructionUint64LoadR3Direct, 0x10, 0x27, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint8LoadR2Direct, 0x01,
+		InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUnsignedAdd,
+		InstructionJmpIfLt, 0x0B, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	done := make(chan error)
+	go func() { done <- vm.Execute(bytecode) }()
+	<-started
+	vm.Pause()
+	close(release)
+	if err := <-done; !errors.Is(err, Paused) {
+		t.Fatal("expected paused, got:", err)
+	}
+	if vm.PC != 20 || vm.Registers[0] != 999 {
+		t.Fatal("wrong state:", vm.PC, vm.Registers[0])
+	}
+	if vm.pausedCPUTime <= 0 || vm.pausedCPUTime > 250*time.Millisecond {
+		t.Fatal("wrong cpu time left:", vm.pausedCPUTime)
+	}
+
+	// No progress is made while paused, and the time paused doesn't count towards the CPU time.
+	before := vm.Snapshot()
+	time.Sleep(300 * time.Millisecond)
+	if vm.Snapshot() != before {
+		t.Fatal("progress made while paused")
+	}
+	if err := vm.Resume(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 10000 {
+		t.Fatal("not 10000:", vm.Registers[0])
+	}
+}
+
+func TestVM_Execute_Yield(t *testing.T) {
+	// A generator which yields the squares of 1, 2 and 3 in R1.
+	bytecode := []byte{
+		InstructionUint8LoadR3Direct, 0x03,
+		InstructionUint8Load, 0x00,
+		InstructionMoveR1ToR5,
+
+		// Loop: R5 += 1, then yield R5 * R5.
+		InstructionMoveR5ToR1,
+		InstructionUint8LoadR2Direct, 0x01,
+		InstructionUnsignedAdd,
+		InstructionMoveR1ToR5,
+		InstructionMoveR1ToR2,
+		InstructionUnsignedMul,
+		InstructionYield,
+		InstructionMoveR5ToR1,
+		InstructionJmpIfLt, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	vm := NewVM(0, 0)
+	var values []uint64
+	err := vm.Execute(bytecode)
+	for errors.Is(err, Yielded) {
+		var vmErr *VMError
+		if !errors.As(err, &vmErr) || vmErr.Offset != 12 || vmErr.Operand != vm.Registers[0] {
+			t.Fatal("wrong error:", err)
+		}
+		if vm.PC != 13 {
+			t.Fatal("not 13:", vm.PC)
+		}
+		values = append(values, vm.Registers[0])
+		err = vm.Continue(bytecode)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 3 || values[0] != 1 || values[1] != 4 || values[2] != 9 {
+		t.Fatal("wrong values:", values)
+	}
+}
+
+func TestVM_Execute_CPUTimeExcludesSyscalls(t *testing.T) {
+	// A syscall which sleeps for longer than the CPU time doesn't use it up.
+	vm := NewVM(0, 20*time.Millisecond)
+	vm.Syscalls = map[uint64]func(*VM) error{
+		1: func(*VM) error {
+			time.Sleep(50 * time.Millisecond)
+			return nil
+		},
+	}
+	bytecode := []byte{
+		InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint8Load, 0x01,
+	}
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 1 {
+		t.Fatal("not 1:", vm.Registers[0])
+	}
+
+	// A loop which never calls a syscall still runs out.
+	err := vm.Execute([]byte{InstructionJmp, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	if !errors.Is(err, CPUTimeExhausted) {
+		t.Fatal("expected cpu time exhausted error, got:", err)
+	}
+}
+
+func TestVM_Execute_CPUTimeExcludesRepeatedSyscalls(t *testing.T) {
+	// 4 syscalls each sleeping for half the CPU time add up to twice the CPU time, which isn't charged.
+	vm := NewVM(0, 20*time.Millisecond)
+	vm.Syscalls = map[uint64]func(*VM) error{
+		1: func(*VM) error {
+			time.Sleep(10 * time.Millisecond)
+			return nil
+		},
+	}
+	bytecode := []byte{
+		InstructionUint8LoadR3Direct, 0x04,
+		InstructionUint8LoadR2Direct, 0x01,
+		InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUnsignedAdd,
+		InstructionJmpIfLt, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	start := time.Now()
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 40*time.Millisecond || vm.Registers[0] != 4 {
+		t.Fatal("syscalls didn't all run:", vm.Registers[0])
+	}
+
+	// Spinning without syscalls is killed.
+	err := vm.Execute([]byte{InstructionJmp, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	if !errors.Is(err, CPUTimeExhausted) {
+		t.Fatal("expected cpu time exhausted error, got:", err)
+	}
+}
+
+func TestVM_Execute_SoftCPUTime(t *testing.T) {
+	// Spin calling a syscall until 60ms have passed. The syscall sets R1 to 1 when the time is up.
+	bytecode := []byte{
+		InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionJmpIfZero, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	run := func(onSoftLimit func(*VM)) (time.Duration, error) {
+		vm := NewVM(0, 30*time.Millisecond)
+		vm.SoftCPUTime = 10 * time.Millisecond
+		var start time.Time
+		var firedAt time.Duration
+		vm.OnSoftLimit = func(vm *VM) {
+			firedAt = time.Since(start)
+			onSoftLimit(vm)
+		}
+		vm.Syscalls = map[uint64]func(*VM) error{
+			1: func(vm *VM) error {
+				if time.Since(start) >= 60*time.Millisecond {
+					vm.Registers[0] = 1
+				}
+				return nil
+			},
+		}
+		start = time.Now()
+		err := vm.Execute(bytecode)
+		return firedAt, err
+	}
+
+	// Without an extension it dies at the hard limit after the callback fires.
+	firedAt, err := run(func(*VM) {})
+	if !errors.Is(err, CPUTimeExhausted) {
+		t.Fatal("expected cpu time exhausted error, got:", err)
+	}
+	if firedAt < 10*time.Millisecond {
+		t.Fatal("fired too early:", firedAt)
+	}
+
+	// Extending the hard limit lets it finish.
+	if _, err := run(func(vm *VM) { vm.ExtendCPUTime(time.Second) }); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stopping from the callback ends it straight away.
+	if _, err := run(func(vm *VM) { vm.Stop() }); !errors.Is(err, Stopped) {
+		t.Fatal("expected stopped, got:", err)
+	}
+
+	// Memory grown by the callback is used straight away. The guest spins until address 8 is set and then writes 5 to
+	// address 0, and the callback grows the memory and sets address 8 in the new memory.
+	vm := NewVM(16, time.Second)
+	vm.SoftCPUTime = 5 * time.Millisecond
+	vm.OnSoftLimit = func(vm *VM) {
+		if _, err := vm.Grow(1 << 20); err != nil {
+			t.Fatal(err)
+		}
+		vm.Memory[8] = 1
+	}
+	if err := vm.Execute([]byte{
+		InstructionMemoryUint8Load, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionJmpIfZero, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint8Load, 0x05,
+		InstructionUint8Dump, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(vm.Memory) != 16+1<<20 || vm.Memory[0] != 5 {
+		t.Fatal("write was lost:", len(vm.Memory), vm.Memory[0])
+	}
+}
+
+func TestVM_Execute_Deadline(t *testing.T) {
+	// A deadline in the past returns before anything runs.
+	vm := NewVM(0, 0)
+	vm.Deadline = time.Now().Add(-time.Second)
+	err := vm.Execute([]byte{InstructionUint8Load, 0x01})
+	if !errors.Is(err, DeadlineExceeded) || !errors.Is(err, CPUTimeExhausted) {
+		t.Fatal("expected deadline exceeded, got:", err)
+	}
+	if vm.Registers[0] != 0 || vm.PC != 0 {
+		t.Fatal("instruction ran:", vm.Registers[0], vm.PC)
+	}
+
+	// A deadline in the near future stops a loop.
+	vm.Deadline = time.Now().Add(20 * time.Millisecond)
+	err = vm.Execute([]byte{InstructionJmp, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	if !errors.Is(err, DeadlineExceeded) {
+		t.Fatal("expected deadline exceeded, got:", err)
+	}
+	if time.Now().Before(vm.Deadline) {
+		t.Fatal("stopped before the deadline")
+	}
+
+	// The CPU time running out isn't a deadline being exceeded.
+	vm = NewVM(0, time.Millisecond)
+	err = vm.Execute([]byte{InstructionJmp, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	if !errors.Is(err, CPUTimeExhausted) || errors.Is(err, DeadlineExceeded) {
+		t.Fatal("expected cpu time exhausted, got:", err)
+	}
+}
+
+func TestVM_Execute_CumulativeCPUBudget(t *testing.T) {
+	// Spin forever with a 30ms budget across every call.
+	vm := NewVM(0, 0)
+	vm.CumulativeCPUBudget = 30 * time.Millisecond
+	bytecode := []byte{InstructionJmp, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+
+	// Calls stopped by a 5ms per-call limit add up until the budget is spent.
+	vm.MaxCPUTime = 5 * time.Millisecond
+	calls := 0
+	for {
+		err := vm.Execute(bytecode)
+		if !errors.Is(err, CPUTimeExhausted) {
+			t.Fatal("expected cpu time exhausted error, got:", err)
+		}
+		if vm.CPUTimeUsed() >= 30*time.Millisecond {
+			break
+		}
+		calls++
+		if calls > 100 {
+			t.Fatal("budget never ran out:", vm.CPUTimeUsed())
+		}
+	}
+	if calls < 3 {
+		t.Fatal("budget ran out after", calls, "calls")
+	}
+
+	// A new call with the budget spent returns before running anything.
+	vm.MaxCPUTime = 0
+	err := vm.Execute([]byte{InstructionUint8Load, 0x01})
+	if !errors.Is(err, CPUTimeExhausted) || vm.Registers[0] != 0 {
+		t.Fatal("expected cpu time exhausted error, got:", err)
+	}
+
+	// Resetting the budget replenishes it.
+	vm.ResetCPUBudget()
+	if vm.CPUTimeUsed() != 0 {
+		t.Fatal("not 0:", vm.CPUTimeUsed())
+	}
+	if err := vm.Execute([]byte{InstructionUint8Load, 0x01}); err != nil {
 		t.Fatal(err)
 	}
 }
 
-func TestVM_CPUTimeExhaustion(t *testing.T) {
+func TestVM_Execute_CheckInterval(t *testing.T) {
+	// The CPU time is checked every 16 instructions, so the loop stops on a multiple of 16 instructions.
 	vm := NewVM(0, time.Millisecond)
+	vm.CheckInterval = 16
 	err := vm.Execute([]byte{InstructionJmp, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
-	if err != CPUTimeExhausted {
+	var cpuErr *CPUTimeError
+	if !errors.As(err, &cpuErr) {
+		t.Fatal("expected cpu time error, got:", err)
+	}
+	if cpuErr.Instructions == 0 || cpuErr.Instructions%16 != 0 {
+		t.Fatal("not a multiple of 16:", cpuErr.Instructions)
+	}
+
+	// A stop from another goroutine is seen within the interval too.
+	vm = NewVM(0, 0)
+	vm.CheckInterval = 16
+	vm.Syscalls = map[uint64]func(*VM) error{
+		1: func(vm *VM) error {
+			go vm.Stop()
+			return nil
+		},
+	}
+	err = vm.Execute([]byte{
+		InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionJmp, 0x09, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	})
+	if !errors.Is(err, Stopped) {
+		t.Fatal("expected stopped, got:", err)
+	}
+	if (vm.InstructionsExecuted-1)%16 != 0 {
+		t.Fatal("not stopped on a check:", vm.InstructionsExecuted)
+	}
+}
+
+func TestVM_Continue(t *testing.T) {
+	x := make([]byte, 4)
+	binary.LittleEndian.PutUint32(x, 50000000)
+	instructions := []byte{
+		InstructionUint32Load,
+		x[0], x[1], x[2], x[3],
+		InstructionMoveR1ToR3,
+		InstructionUint8Load,
+		0x01,
+		InstructionMoveR1ToR2,
+		InstructionUint8Load,
+		0x00,
+		InstructionUnsignedAdd,
+		InstructionJmpIfNe,
+		0x0B, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+
+	// Do one uninterrupted run.
+	uninterrupted := NewVM(0, 0)
+	if err := uninterrupted.Execute(instructions); err != nil {
+		t.Fatal(err)
+	}
+
+	// Run the loop in three slices.
+	vm := NewVM(0, time.Millisecond)
+	if err := vm.Execute(instructions); !errors.Is(err, CPUTimeExhausted) {
+		t.Fatal("expected cpu time exhausted error, got:", err)
+	}
+	if err := vm.Continue(instructions); !errors.Is(err, CPUTimeExhausted) {
 		t.Fatal("expected cpu time exhausted error, got:", err)
 	}
+	vm.MaxCPUTime = 0
+	if err := vm.Continue(instructions); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers != uninterrupted.Registers {
+		t.Fatal("registers differ:", vm.Registers, uninterrupted.Registers)
+	}
+	if vm.PC != uint64(len(instructions)) {
+		t.Fatal("pc not at the end:", vm.PC)
+	}
+
+	// Continuing at the end does nothing and continuing past it errors.
+	if err := vm.Continue(instructions); err != nil {
+		t.Fatal(err)
+	}
+	vm.PC++
+	if err := vm.Continue(instructions); !errors.Is(err, InvalidMemoryLocation) {
+		t.Fatal("expected invalid memory location, got:", err)
+	}
 }
 
-func TestVM_Execute_StoreLoadRAM(t *testing.T) {
-	vm := NewVM(2, 0)
+func TestVM_ExecuteFromMemory(t *testing.T) {
+	// Load a program which overwrites the InstructionMoveR1ToR3 at the end with a InstructionMoveR1ToR2.
+	vm := NewVM(16, 0)
+	copy(vm.Memory[4:], []byte{
+		InstructionUint8Load, InstructionMoveR1ToR2,
+		InstructionUint8Dump, 0x0F, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionMoveR1ToR3,
+	})
+	if err := vm.ExecuteFromMemory(4); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[1] != uint64(InstructionMoveR1ToR2) {
+		t.Fatal("the patched instruction was not run:", vm.Registers)
+	}
+	if vm.Registers[2] != 0 {
+		t.Fatal("the original instruction was run:", vm.Registers)
+	}
+	if vm.PC != 16 {
+		t.Fatal("not 16:", vm.PC)
+	}
+
+	// Jump targets are checked against the memory length.
+	vm = NewVM(8, 0)
+	copy(vm.Memory, []byte{InstructionJmp32, 0x08, 0x00, 0x00, 0x00})
+	if err := vm.ExecuteFromMemory(0); !errors.Is(err, InvalidMemoryLocation) {
+		t.Fatal("expected invalid memory location, got:", err)
+	}
+
+	// The code follows the memory if a system call replaces it.
+	vm = NewVM(9, 0)
+	copy(vm.Memory, []byte{InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	vm.Syscalls[1] = func(vm *VM) error {
+		vm.Memory = append(vm.Memory, InstructionUint8Load, 0x05)
+		return nil
+	}
+	if err := vm.ExecuteFromMemory(0); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 5 {
+		t.Fatal("not 5:", vm.Registers[0])
+	}
+
+	// Starting past the end of the memory errors.
+	if err := vm.ExecuteFromMemory(12); !errors.Is(err, InvalidMemoryLocation) {
+		t.Fatal("expected invalid memory location, got:", err)
+	}
+}
+
+func TestVM_Reset(t *testing.T) {
+	dirty := func() *VM {
+		vm := NewVM(16, time.Second, WithReverseJournal(4))
+		vm.Syscalls[1] = func(vm *VM) error { return nil }
+		vm.PreserveFlags = true
+		vm.SeedRandom(1)
+		if err := vm.LoadProgram([]byte{InstructionUint8Load, 0x01}); err != nil {
+			t.Fatal(err)
+		}
+		if err := vm.Run(); err != nil {
+			t.Fatal(err)
+		}
+		for i := range vm.Memory {
+			vm.Memory[i] = 0xFF
+		}
+		vm.Registers = [8]uint64{1, 2, 3, 4}
+		vm.PC = 2
+		vm.publish(2, 3)
+		vm.InstructionsExecuted = 7
+		vm.atBreakpoint = true
+		vm.paused = true
+		vm.pausedCPUTime = time.Millisecond
+		vm.RaiseInterrupt(3)
+		vm.inInterrupt = true
+		vm.interruptPC = 1
+		vm.ExtendCPUTime(time.Second)
+		vm.cpuTimeUsed = time.Second
+		vm.lastStats.Instructions = 5
+		vm.opcodeProfile = &[256]uint64{1}
+		vm.memoryProfile = &memoryProfile{bucketSize: 8, reads: []uint64{1}}
+		vm.coverage = Coverage{1}
+		vm.SetSyscallBudget(1, 5)
+		vm.syscallBudgets[1].calls = 3
+		vm.syscallCalls = 2
+		return vm
+	}
+
+	// checkState is used to check the state left by executions is back to how NewVM creates it.
+	checkState := func(vm *VM) {
+		t.Helper()
+		if vm.Registers != [8]uint64{} {
+			t.Fatal("registers not cleared:", vm.Registers)
+		}
+		if vm.PC != 0 || vm.RandomState != 0 {
+			t.Fatal("state not cleared:", vm.PC, vm.RandomState)
+		}
+		if vm.Snapshot() != (RegisterSnapshot{}) {
+			t.Fatal("snapshot not cleared:", vm.Snapshot())
+		}
+		if vm.InstructionsExecuted != 0 {
+			t.Fatal("instruction count not cleared:", vm.InstructionsExecuted)
+		}
+		if vm.atBreakpoint {
+			t.Fatal("breakpoint state not cleared")
+		}
+		if vm.pausedCPUTime != 0 {
+			t.Fatal("paused CPU time not cleared:", vm.pausedCPUTime)
+		}
+		if err := vm.Resume(nil); err != NotPaused {
+			t.Fatal("pause state not cleared:", err)
+		}
+		if vm.inInterrupt || vm.interruptPC != 0 || vm.interruptsPending != [8]uint32{} || vm.interruptRaised != 0 {
+			t.Fatal("interrupt state not cleared")
+		}
+		if vm.cpuTimeExtension != 0 {
+			t.Fatal("CPU time extension not cleared:", vm.cpuTimeExtension)
+		}
+		if vm.CPUTimeUsed() != 0 {
+			t.Fatal("CPU time used not cleared:", vm.CPUTimeUsed())
+		}
+		if vm.LastStats() != (ExecutionStats{}) {
+			t.Fatal("stats not cleared:", vm.LastStats())
+		}
+		if len(vm.OpcodeProfile()) != 0 {
+			t.Fatal("opcode profile not cleared:", vm.OpcodeProfile())
+		}
+		if vm.MemoryProfile() != nil {
+			t.Fatal("memory profile not cleared:", vm.MemoryProfile())
+		}
+		if err := vm.StepBack(); err != NoHistory {
+			t.Fatal("reverse journal not cleared:", err)
+		}
+		if vm.Coverage() != nil {
+			t.Fatal("coverage not cleared:", vm.Coverage())
+		}
+		if vm.syscallCalls != 0 || (vm.syscallBudgets[1] != nil && vm.syscallBudgets[1].calls != 0) {
+			t.Fatal("system call counts not cleared")
+		}
+	}
+
+	// Reset everything.
+	vm := dirty()
+	vm.Reset()
+	checkState(vm)
+	for i, x := range vm.Memory {
+		if x != 0 {
+			t.Fatal("memory not cleared at", i)
+		}
+	}
+	if len(vm.Memory) != 16 || vm.MaxCPUTime != time.Second || !vm.PreserveFlags || vm.Syscalls[1] == nil {
+		t.Fatal("configuration was not kept")
+	}
+	if vm.syscallBudgets[1] == nil || vm.syscallBudgets[1].limit != 5 {
+		t.Fatal("configuration was not kept")
+	}
+	if err := vm.Run(); err != nil || vm.Registers[0] != 1 {
+		t.Fatal("program was not kept:", err, vm.Registers[0])
+	}
+
+	// Reset everything but the memory.
+	vm = dirty()
+	vm.Reset(KeepMemory)
+	checkState(vm)
+	for i, x := range vm.Memory {
+		if x != 0xFF {
+			t.Fatal("memory cleared at", i)
+		}
+	}
+}
+
+func TestVM_Clone(t *testing.T) {
+	vm := NewVM(8, time.Second)
+	vm.Memory[0] = 1
+	vm.Registers[0] = 2
+	calls := 0
+	vm.Syscalls[1] = func(vm *VM) error {
+		calls++
+		return nil
+	}
+
+	// Check the clone is independent.
+	clone := vm.Clone()
+	clone.Memory[0] = 3
+	clone.Registers[0] = 4
+	if vm.Memory[0] != 1 || vm.Registers[0] != 2 {
+		t.Fatal("original was changed:", vm.Memory[0], vm.Registers[0])
+	}
+	if clone.MaxCPUTime != time.Second {
+		t.Fatal("max cpu time not copied:", clone.MaxCPUTime)
+	}
+
+	// Check system calls fire on the clone.
+	if err := clone.Execute([]byte{InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Fatal("system call not made:", calls)
+	}
+
+	// Check the system call map is shared unless it is copied.
+	clone.Syscalls[2] = func(vm *VM) error { return nil }
+	if vm.Syscalls[2] == nil {
+		t.Fatal("system calls not shared")
+	}
+	clone = vm.Clone(CopySyscalls)
+	clone.Syscalls[3] = func(vm *VM) error { return nil }
+	if vm.Syscalls[3] != nil || clone.Syscalls[1] == nil {
+		t.Fatal("system calls not copied")
+	}
+}
+
+func TestVM_Execute_ExtendedRegisters(t *testing.T) {
+	// Check every move between R1 to R3 and R5 to R8.
+	moves := []struct {
+		instruction uint8
+		src, dst    int
+	}{
+		{InstructionMoveR1ToR5, 0, 4}, {InstructionMoveR1ToR6, 0, 5}, {InstructionMoveR1ToR7, 0, 6}, {InstructionMoveR1ToR8, 0, 7},
+		{InstructionMoveR2ToR5, 1, 4}, {InstructionMoveR2ToR6, 1, 5}, {InstructionMoveR2ToR7, 1, 6}, {InstructionMoveR2ToR8, 1, 7},
+		{InstructionMoveR3ToR5, 2, 4}, {InstructionMoveR3ToR6, 2, 5}, {InstructionMoveR3ToR7, 2, 6}, {InstructionMoveR3ToR8, 2, 7},
+		{InstructionMoveR5ToR1, 4, 0}, {InstructionMoveR5ToR2, 4, 1}, {InstructionMoveR5ToR3, 4, 2},
+		{InstructionMoveR6ToR1, 5, 0}, {InstructionMoveR6ToR2, 5, 1}, {InstructionMoveR6ToR3, 5, 2},
+		{InstructionMoveR7ToR1, 6, 0}, {InstructionMoveR7ToR2, 6, 1}, {InstructionMoveR7ToR3, 6, 2},
+		{InstructionMoveR8ToR1, 7, 0}, {InstructionMoveR8ToR2, 7, 1}, {InstructionMoveR8ToR3, 7, 2},
+	}
+	for _, tt := range moves {
+		vm := NewVM(0, 0)
+		for i := range vm.Registers {
+			vm.Registers[i] = uint64(i + 1)
+		}
+		if err := vm.Execute([]byte{tt.instruction}); err != nil {
+			t.Fatal(err)
+		}
+		expected := [8]uint64{1, 2, 3, 0, 5, 6, 7, 8}
+		expected[tt.dst] = uint64(tt.src + 1)
+		if vm.Registers != expected {
+			t.Fatalf("instruction 0x%02x: expected %v, got %v", tt.instruction, expected, vm.Registers)
+		}
+	}
+
+	// Check the loads into R5 to R8.
+	vm := NewVM(0, 0)
+	var program []byte
+	for i, instruction := range []uint8{
+		InstructionUint64LoadR5Direct, InstructionUint64LoadR6Direct,
+		InstructionUint64LoadR7Direct, InstructionUint64LoadR8Direct,
+	} {
+		program = append(program, instruction)
+		program = append(program, uint64Operand(0x0102030405060700+uint64(i))...)
+	}
+	if err := vm.Execute(program); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers != [8]uint64{0, 0, 0, 0, 0x0102030405060700, 0x0102030405060701, 0x0102030405060702, 0x0102030405060703} {
+		t.Fatal("unexpected registers:", vm.Registers)
+	}
+
+	// Old programs leave R5 to R8 untouched.
+	vm.Registers[4] = 0xFF
+	if err := vm.Execute([]byte{InstructionUint8Load, 0x05, InstructionMoveR1ToR2, InstructionUnsignedAdd, InstructionMoveR1ToR3}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers != [8]uint64{10, 5, 10, 0, 0xFF, 0x0102030405060701, 0x0102030405060702, 0x0102030405060703} {
+		t.Fatal("unexpected registers:", vm.Registers)
+	}
+}
+
+func TestVM_Execute_RegisterOperands(t *testing.T) {
+	// Check every register pair with InstructionMove2 and InstructionAdd2.
+	for dst := uint8(0); dst < 8; dst++ {
+		for src := uint8(0); src < 8; src++ {
+			vm := NewVM(0, 0)
+			vm.PreserveFlags = true
+			for i := range vm.Registers {
+				vm.Registers[i] = uint64(i+1) * 10
+			}
+			if err := vm.Execute([]byte{InstructionMove2, RegisterOperand(dst, src)}); err != nil {
+				t.Fatal(err)
+			}
+			if vm.Registers[dst] != uint64(src+1)*10 {
+				t.Fatalf("move R%d to R%d: got %d", src+1, dst+1, vm.Registers[dst])
+			}
+
+			for i := range vm.Registers {
+				vm.Registers[i] = uint64(i+1) * 10
+			}
+			if err := vm.Execute([]byte{InstructionAdd2, RegisterOperand(dst, src)}); err != nil {
+				t.Fatal(err)
+			}
+			if vm.Registers[dst] != uint64(dst+1)*10+uint64(src+1)*10 {
+				t.Fatalf("add R%d to R%d: got %d", src+1, dst+1, vm.Registers[dst])
+			}
+		}
+	}
+
+	// Check the rest of the operations.
+	tests := []struct {
+		instruction uint8
+		expected    uint64
+	}{
+		{InstructionSub2, 12},
+		{InstructionMul2, 45},
+		{InstructionAnd2, 3},
+		{InstructionOr2, 15},
+		{InstructionXor2, 12},
+		{InstructionLeftShift2, 15 << 3},
+		{InstructionRightShift2, 1},
+	}
+	for _, tt := range tests {
+		vm := NewVM(0, 0)
+		vm.Registers[5] = 15
+		vm.Registers[6] = 3
+		vm.Registers[3] = 1
+		if err := vm.Execute([]byte{tt.instruction, RegisterOperand(5, 6)}); err != nil {
+			t.Fatal(err)
+		}
+		if vm.Registers[5] != tt.expected {
+			t.Fatalf("instruction 0x%02x: expected %d, got %d", tt.instruction, tt.expected, vm.Registers[5])
+		}
+		if vm.Registers[3] != 0 {
+			t.Fatal("R4 not cleared:", vm.Registers[3])
+		}
+	}
+
+	// Check R4 can be the destination even when flags are not preserved.
+	vm := NewVM(0, 0)
+	vm.Registers[0] = 9
+	if err := vm.Execute([]byte{InstructionMove2, RegisterOperand(3, 0)}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[3] != 9 {
+		t.Fatal("not 9:", vm.Registers[3])
+	}
+
+	// Check invalid registers.
+	for _, operand := range []uint8{RegisterOperand(8, 0), RegisterOperand(0, 8), 0xFF} {
+		err := vm.Execute([]byte{InstructionMove2, operand})
+		if !errors.Is(err, InvalidRegister) {
+			t.Fatal("expected invalid register, got:", err)
+		}
+	}
+	if err := vm.Execute([]byte{InstructionMove2}); !errors.Is(err, InvalidInstructionArgument) {
+		t.Fatal("expected invalid instruction argument, got:", err)
+	}
+}
+
+func TestVM_Execute_Mode32(t *testing.T) {
+	vm := NewVM(8, 0, WithMode(Mode32))
+	if vm.Mode() != Mode32 || NewVM(0, 0).Mode() != Mode64 {
+		t.Fatal("wrong mode")
+	}
+
+	// Check addition wraps around at 2^32.
 	if err := vm.Execute([]byte{
-		InstructionUint8Load, 0x0A, InstructionUint8Dump,
-		0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-		InstructionUint8Load, 0x00, InstructionMemoryUint8Load,
-		0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		InstructionUint32Load, 0xFF, 0xFF, 0xFF, 0xFF,
+		InstructionUint8LoadR2Direct, 0x02,
+		InstructionUnsignedAdd,
 	}); err != nil {
 		t.Fatal(err)
 	}
-	if vm.Registers[0] != 0x0A {
-		t.Fatal("register not 0x0A:", vm.Registers[0])
+	if vm.Registers[0] != 1 {
+		t.Fatal("not 1:", vm.Registers[0])
 	}
-	if vm.Memory[1] != 0x0A {
-		t.Fatal("RAM not 0x0A:", vm.Memory[1])
+
+	// Check immediate loads are masked.
+	if err := vm.Execute(append([]byte{InstructionUint64Load}, uint64Operand(0x1122334455667788)...)); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 0x55667788 {
+		t.Fatalf("not 0x55667788: 0x%x", vm.Registers[0])
+	}
+
+	// Check signed division treats the registers as int32s.
+	vm.Registers = [8]uint64{uint64(uint32(0xFFFFFFF9)), 2}
+	if err := vm.Execute([]byte{InstructionSignedDiv}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 0xFFFFFFFD {
+		t.Fatalf("not -3: 0x%x", vm.Registers[0])
+	}
+	vm.Registers = [8]uint64{0x80000000, 0xFFFFFFFF}
+	if err := vm.Execute([]byte{InstructionSignedDiv}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 0x80000000 {
+		t.Fatalf("not -2^31: 0x%x", vm.Registers[0])
+	}
+
+	// Check signed compares treat the registers as int32s.
+	vm.Registers = [8]uint64{0xFFFFFFFF, 0, 1}
+	if err := vm.Execute([]byte{InstructionSetIfLtSigned}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 1 {
+		t.Fatal("-1 is not less than 1")
+	}
+
+	// Check jump targets and memory locations are uint32s.
+	vm.Registers = [8]uint64{}
+	if err := vm.Execute([]byte{
+		InstructionUint8Load, 0x03,
+		InstructionUint8Dump, 0x04, 0x00, 0x00, 0x00,
+		InstructionMoveR1ToR3,
+		InstructionUint8Load, 0x00,
+		InstructionUint8LoadR2Direct, 0x01,
+		InstructionUnsignedAdd,
+		InstructionJmpIfNe, 0x0C, 0x00, 0x00, 0x00,
+		InstructionMemoryUint8Load, 0x04, 0x00, 0x00, 0x00,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 3 || vm.Memory[4] != 3 {
+		t.Fatal("unexpected state:", vm.Registers[0], vm.Memory[4])
+	}
+
+	// Check programs are validated with uint32 arguments.
+	if err := vm.LoadProgram([]byte{InstructionJmp, 0x00, 0x00, 0x00, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewVM(0, 0).LoadProgram([]byte{InstructionJmp, 0x00, 0x00, 0x00, 0x00}); !errors.Is(err, InvalidInstructionArgument) {
+		t.Fatal("expected invalid instruction argument, got:", err)
+	}
+
+	// Check float instructions are rejected rather than having their results truncated.
+	for opcode := InstructionFloat64Load; opcode <= InstructionJmpIfFloatUnordered; opcode++ {
+		var b []byte
+		switch length, _ := operandLength(opcode); length {
+		case operandAddress:
+			b = append([]byte{opcode}, 0x00, 0x00, 0x00, 0x00)
+		default:
+			b = append([]byte{opcode}, make([]byte, length)...)
+		}
+		if err := vm.Execute(b); !errors.Is(err, FloatUnsupported) {
+			t.Fatalf("expected float unsupported for %s, got: %v", opcodeNames[opcode], err)
+		}
+		if err := vm.LoadProgram(b); !errors.Is(err, FloatUnsupported) {
+			t.Fatalf("expected float unsupported loading %s, got: %v", opcodeNames[opcode], err)
+		}
+	}
+	if err := vm.Execute([]byte{InstructionFeatures}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0]&(FeatureFloat64|FeatureFloat32) != 0 {
+		t.Fatalf("float features reported: 0x%x", vm.Registers[0])
+	}
+}
+
+func TestVM_Execute_GuardSize(t *testing.T) {
+	tests := []struct {
+		instruction uint8
+		compact     bool
+		width       uint64
+		write       bool
+	}{
+		{InstructionMemoryUint8Load, false, 1, false},
+		{InstructionMemoryUint16Load, false, 2, false},
+		{InstructionMemoryUint32Load, false, 4, false},
+		{InstructionMemoryUint64Load, false, 8, false},
+		{InstructionMemoryFloat32Load, false, 4, false},
+		{InstructionUint8Dump, false, 1, true},
+		{InstructionUint16Dump, false, 2, true},
+		{InstructionUint32Dump, false, 4, true},
+		{InstructionUint64Dump, false, 8, true},
+		{InstructionFloat32Dump, false, 4, true},
+		{InstructionMemoryUint8Load32, true, 1, false},
+		{InstructionMemoryUint64Load32, true, 8, false},
+		{InstructionUint8Dump32, true, 1, true},
+		{InstructionUint64Dump32, true, 8, true},
+	}
+	for _, tt := range tests {
+		for _, address := range []uint64{15, 16} {
+			vm := NewVM(64, 0)
+			vm.GuardSize = 16
+			program := []byte{tt.instruction}
+			if tt.compact {
+				program = append(program, byte(address), 0x00, 0x00, 0x00)
+			} else {
+				program = append(program, uint64Operand(address)...)
+			}
+			err := vm.Execute(program)
+			if address == 16 {
+				if err != nil {
+					t.Fatalf("instruction 0x%02x at the boundary: %v", tt.instruction, err)
+				}
+				continue
+			}
+			var fault *NullPageFault
+			if !errors.As(err, &fault) || !errors.Is(err, InvalidMemoryLocation) {
+				t.Fatalf("instruction 0x%02x below the boundary: expected null page fault, got %v", tt.instruction, err)
+			}
+			if fault.Address != 15 || fault.Width != tt.width || fault.Write != tt.write {
+				t.Fatalf("instruction 0x%02x: unexpected fault %+v", tt.instruction, fault)
+			}
+		}
+	}
+
+	// Check variable length memory locations and bulk memory instructions.
+	vm := NewVM(64, 0)
+	vm.GuardSize = 16
+	if err := vm.Execute([]byte{InstructionMemoryVarLoad64, 0x0F}); !errors.Is(err, InvalidMemoryLocation) {
+		t.Fatal("expected invalid memory location, got:", err)
+	}
+	vm.Registers = [8]uint64{0, 8, 2}
+	if err := vm.Execute([]byte{InstructionMemorySum64}); !errors.Is(err, InvalidMemoryLocation) {
+		t.Fatal("expected invalid memory location, got:", err)
+	}
+	vm.Registers = [8]uint64{0, 16, 2}
+	if err := vm.Execute([]byte{InstructionMemoryCRC32}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Check the memory length is still enforced.
+	if err := vm.Execute(append([]byte{InstructionMemoryUint64Load}, uint64Operand(57)...)); !errors.Is(err, InvalidMemoryLocation) {
+		t.Fatal("expected invalid memory location, got:", err)
+	}
+}
+
+func TestVM_Execute_Busy(t *testing.T) {
+	// The syscall blocks until the second execute has been attempted.
+	started := make(chan struct{})
+	release := make(chan struct{})
+	vm := NewVM(0, 0)
+	vm.Syscalls = map[uint64]func(*VM) error{
+		1: func(vm *VM) error {
+			close(started)
+			<-release
+			return nil
+		},
+	}
+	bytecode := []byte{InstructionSyscall, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	done := make(chan error)
+	go func() { done <- vm.Execute(bytecode) }()
+	<-started
+	if err := vm.Execute([]byte{InstructionUint8Load, 0x01}); !errors.Is(err, VMBusy) {
+		t.Fatal("expected vm busy, got:", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// Executing from inside a syscall is busy too.
+	var nestedErr error
+	vm.Syscalls[1] = func(vm *VM) error {
+		nestedErr = vm.Execute([]byte{InstructionUint8Load, 0x01})
+		return nil
+	}
+	if err := vm.Execute(bytecode); err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(nestedErr, VMBusy) {
+		t.Fatal("expected vm busy, got:", nestedErr)
+	}
+
+	// The vm can be used again after a syscall panic unwinds through it.
+	vm.PropagateSyscallPanics = true
+	vm.Syscalls[1] = func(vm *VM) error {
+		panic("syscall panicked")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("panic was recovered")
+			}
+		}()
+		_ = vm.Execute(bytecode)
+	}()
+	if err := vm.Execute([]byte{InstructionUint8Load, 0x01}); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Registers[0] != 1 {
+		t.Fatal("not 1:", vm.Registers[0])
 	}
 }
 
@@ -92,3 +2599,90 @@ func BenchmarkVM_Execute_Add10000000Numbers_CPUTimeCheck(b *testing.B) {
 		b.Fatal("not 10000000:", vm.Registers[0])
 	}
 }
+
+func BenchmarkVM_Execute_Add10000000Numbers_DirectLoads(b *testing.B) {
+	x := make([]byte, 8)
+	binary.LittleEndian.PutUint64(x, 10000000)
+	instructions := []byte{
+		InstructionUint64LoadR3Direct,
+		x[0], x[1], x[2], x[3], x[4], x[5], x[6], x[7],
+		InstructionUint8LoadR2Direct,
+		0x01,
+		InstructionUint8Load,
+		0x00,
+		InstructionUnsignedAdd,
+		InstructionJmpIfNe,
+		0x0D, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	vm := NewVM(0, 0)
+	b.ResetTimer()
+	b.ReportAllocs()
+	if err := vm.Execute(instructions); err != nil {
+		b.Fatal(err)
+	}
+	if vm.Registers[0] != 10000000 {
+		b.Fatal("not 10000000:", vm.Registers[0])
+	}
+}
+
+func BenchmarkVM_Execute_MemorySum64(b *testing.B) {
+	vm := NewVM(4096*8, 0)
+	for i := 0; i < 4096; i++ {
+		binary.LittleEndian.PutUint64(vm.Memory[i*8:], uint64(i))
+	}
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		vm.Registers = [8]uint64{0, 0, 4096, 0}
+		if err := vm.Execute([]byte{InstructionMemorySum64}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if vm.Registers[0] != 4096*4095/2 {
+		b.Fatal("unexpected sum:", vm.Registers[0])
+	}
+}
+
+func BenchmarkVM_Execute_MemorySum64_Bytecode(b *testing.B) {
+	vm := NewVM(4096*8, 0)
+	for i := 0; i < 4096; i++ {
+		binary.LittleEndian.PutUint64(vm.Memory[i*8:], uint64(i))
+	}
+
+	// There is no indexed addressing so the equivalent bytecode is unrolled with the sum kept in R3.
+	instructions := []byte{InstructionUint8Load, 0x00, InstructionMoveR1ToR3}
+	for i := 0; i < 4096; i++ {
+		instructions = append(instructions, InstructionMemoryUint64Load)
+		instructions = append(instructions, uint64Operand(uint64(i*8))...)
+		instructions = append(instructions, InstructionMoveR1ToR2, InstructionMoveR3ToR1, InstructionUnsignedAdd, InstructionMoveR1ToR3)
+	}
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := vm.Execute(instructions); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if vm.Registers[0] != 4096*4095/2 {
+		b.Fatal("unexpected sum:", vm.Registers[0])
+	}
+}
+
+func BenchmarkVM_Execute_MemoryLoadDump(b *testing.B) {
+	// Copy 1024 uint64 values at odd locations so both the arguments and memory accesses are unaligned.
+	instructions := []byte{}
+	for i := 0; i < 1024; i++ {
+		instructions = append(instructions, InstructionMemoryUint64Load)
+		instructions = append(instructions, uint64Operand(uint64(i*8+1))...)
+		instructions = append(instructions, InstructionUint64Dump)
+		instructions = append(instructions, uint64Operand(uint64(i*8+8193))...)
+	}
+	vm := NewVM(16400, 0)
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := vm.Execute(instructions); err != nil {
+			b.Fatal(err)
+		}
+	}
+}